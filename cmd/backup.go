@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tdevsin/keyforge/internal/backup"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"google.golang.org/grpc"
+)
+
+// backupCmd writes a backup of a running node
+var backupCmd = &cobra.Command{
+	Use:   "backup <path>",
+	Short: "Writes a backup of a node into a directory or a tar archive",
+	Long: `Writes a consistent backup of the data and metadata of a running node into a new
+directory, or into a tar archive if the path ends with .tar, or .tar.gz or .tgz
+for a compressed one.
+
+The node creates Pebble checkpoints of its databases and streams their files
+along with a manifest containing the cluster state and the SHA-256 checksum of
+every file. The checksums are verified before the command succeeds, and the
+manifest is stored as MANIFEST.json in the backup.
+
+Only the first node passed with --server is backed up, as every node stores
+different keys. Back up every node to back up a cluster. If nodes serve cluster
+traffic on a separate internal address, pass that address. Nodes are only backed
+up on clusters that authenticate nodes, by callers sending the cluster secret or
+a node certificate.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputText, outputJSON)
+		if err != nil {
+			return err
+		}
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		var manifest *proto.BackupManifest
+		err = c.callServer(cmd.Context(), c.server(), func(ctx context.Context, conn *grpc.ClientConn) error {
+			stream, err := proto.NewClusterServiceClient(conn).Backup(ctx, &proto.BackupRequest{})
+			if err != nil {
+				return err
+			}
+			manifest, err = backup.Receive(args[0], stream.Recv)
+			return err
+		})
+		if err != nil {
+			return clientError(err)
+		}
+		if format == outputJSON {
+			return printJSON(cmd, manifest)
+		}
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "Backed up node %s (%s) into %s: %s\n", manifest.NodeId, manifest.Address, args[0], backupSize(manifest))
+		return err
+	},
+}
+
+// restoreCmd rebuilds the data directory of a node from a backup
+var restoreCmd = &cobra.Command{
+	Use:   "restore <backup>",
+	Short: "Rebuilds the data directory of a node from a backup",
+	Long: `Replaces the data and metadata of a node with a backup written by keyforge backup,
+either a directory or a tar archive.
+
+Every file is verified against the checksum in the manifest of the backup before
+anything is replaced. The node must be stopped, and a data directory that already
+contains data is only replaced with --force. The data directory is read from the
+config file like keyforge start does, unless --data-dir is passed.
+
+The restored node keeps the ID of the backed up node. Once started, it rejoins
+the cluster and gossip brings its cluster state up to date. Keys written to the
+node after the backup was taken are lost.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		settings, err := loadSettings(cmd)
+		if err != nil {
+			return err
+		}
+		if cmd.Flags().Changed("data-dir") {
+			settings.DataDir, _ = cmd.Flags().GetString("data-dir")
+		}
+		if _, err := os.Stat(args[0]); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(settings.DataDir, 0o755); err != nil {
+			return err
+		}
+		dataDir, metadataDir, lockFile := config.Paths(settings.DataDir)
+		// Holding the lock of the root directory ensures the node is not running
+		lock, err := storage.LockFile(lockFile)
+		if err != nil {
+			return err
+		}
+		defer lock.Close()
+
+		if force, _ := cmd.Flags().GetBool("force"); !force {
+			for _, dir := range []string{dataDir, metadataDir} {
+				if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+					return fmt.Errorf("%s already contains data of a node, pass --force to replace it", settings.DataDir)
+				} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+		}
+
+		manifest, err := backup.Restore(args[0], dataDir, metadataDir)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "Restored node %s into %s from the backup of %s: %s\n",
+			manifest.NodeId, settings.DataDir, manifest.GetCreatedAt().AsTime().Local().Format(time.RFC3339), backupSize(manifest))
+		return err
+	},
+}
+
+// backupSize describes the number of files of a backup and their total size
+func backupSize(manifest *proto.BackupManifest) string {
+	var size int64
+	for _, file := range manifest.Files {
+		size += file.Size
+	}
+	return fmt.Sprintf("%d files, %d bytes", len(manifest.Files), size)
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd, restoreCmd)
+
+	addClientFlags(backupCmd)
+	addClusterSecretFlag(backupCmd)
+	addOutputFlag(backupCmd, outputText, outputJSON)
+	setDefaultTimeout(backupCmd, time.Hour, "Timeout of the backup")
+
+	restoreCmd.Flags().StringP("data-dir", "d", config.DefaultSettings().DataDir, "Directory containing all files of the restored node, like the one passed to keyforge start")
+	restoreCmd.Flags().Bool("force", false, "Replaces the data of a node already stored in the data directory")
+	addConfigFlag(restoreCmd)
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/signal"
+	"slices"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tdevsin/keyforge/internal/bench"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// benchCmd sends load to a cluster and reports throughput and latency
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Sends load to a cluster and reports throughput and latency",
+	Long: `Sends a mix of reads, writes and deletes to a running cluster and reports the
+throughput and latency percentiles of every operation.
+
+Workers send requests one after another on keys picked from a key space of
+--keys keys: uniformly, with a zipfian distribution where few keys get most
+requests, or sequentially. Workers are spread over the nodes passed with
+--server. Reads and deletes of missing keys are counted as misses, so pass
+--preload to write every key before the run.
+
+The run stops after --duration, after --requests requests if set, or on Ctrl-C.
+The command fails if every request failed.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputText, outputJSON)
+		if err != nil {
+			return err
+		}
+		opts, err := benchOptions(cmd)
+		if err != nil {
+			return err
+		}
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+		request, err := benchRequest(c)
+		if err != nil {
+			return err
+		}
+
+		// Ctrl-C stops the run early, and the requests sent so far are reported
+		ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		if preload, _ := cmd.Flags().GetBool("preload"); preload {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Writing %d keys\n", opts.Keys)
+			if err := bench.Preload(ctx, opts, request); err != nil {
+				return err
+			}
+		}
+		report := bench.Run(ctx, opts, request)
+
+		if format == outputJSON {
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(report)
+		} else {
+			err = printBenchReport(cmd, opts, len(c.servers), report)
+		}
+		if err != nil {
+			return err
+		}
+		if report.Requests > 0 && report.Errors == report.Requests {
+			return errors.New("every request failed")
+		}
+		return nil
+	},
+}
+
+// benchOptions reads the load test flags
+func benchOptions(cmd *cobra.Command) (bench.Options, error) {
+	flags := cmd.Flags()
+	var opts bench.Options
+	opts.Concurrency, _ = flags.GetInt("concurrency")
+	opts.Duration, _ = flags.GetDuration("duration")
+	opts.Requests, _ = flags.GetInt("requests")
+	opts.Keys, _ = flags.GetInt("keys")
+	opts.KeyPrefix, _ = flags.GetString("key-prefix")
+	opts.Distribution, _ = flags.GetString("distribution")
+	opts.ZipfExponent, _ = flags.GetFloat64("zipf-exponent")
+
+	mix, _ := flags.GetString("mix")
+	var err error
+	if opts.Mix, err = bench.ParseMix(mix); err != nil {
+		return opts, err
+	}
+	valueSize, _ := flags.GetString("value-size")
+	if opts.MinValueSize, opts.MaxValueSize, err = bench.ParseValueSize(valueSize); err != nil {
+		return opts, err
+	}
+	return opts, opts.Validate()
+}
+
+// benchRequest returns a request sending every operation to the node of its worker. Connections are
+// opened up front, as workers share them concurrently.
+func benchRequest(c *client) (bench.Request, error) {
+	for _, server := range c.servers {
+		if _, err := c.conn(server); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(ctx context.Context, worker int, op bench.Op, key string, value []byte) error {
+		err := c.callServer(ctx, c.servers[worker%len(c.servers)], func(ctx context.Context, conn *grpc.ClientConn) error {
+			client := proto.NewKeyServiceClient(conn)
+			switch op {
+			case bench.Write:
+				_, err := client.SetKey(ctx, &proto.SetKeyRequest{Key: key, Value: value})
+				return err
+			case bench.Delete:
+				resp, err := client.DeleteKey(ctx, &proto.DeleteKeyRequest{Key: key})
+				if err == nil && !resp.Found {
+					return bench.ErrMiss
+				}
+				return err
+			default:
+				_, err := client.GetKey(ctx, &proto.GetKeyRequest{Key: key})
+				return err
+			}
+		})
+		switch {
+		case status.Code(err) == codes.NotFound:
+			return bench.ErrMiss
+		case err != nil:
+			return clientError(err)
+		}
+		return nil
+	}, nil
+}
+
+// printBenchReport writes a summary of the run and a table of the latencies of every operation
+func printBenchReport(cmd *cobra.Command, opts bench.Options, servers int, report *bench.Report) error {
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Sent %d requests (%s) on %d %s keys with %d workers to %d node(s) in %.2fs\n",
+		report.Requests, opts.Mix, opts.Keys, opts.Distribution, opts.Concurrency, servers, report.Seconds)
+	fmt.Fprintf(out, "Throughput: %.1f requests/s, %d errors, %d misses\n\n", report.Throughput, report.Errors, report.Misses)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tREQUESTS\tERRORS\tMISSES\tREQ/S\tMEAN\tP50\tP90\tP95\tP99\tP99.9\tMAX")
+	row := func(name string, r bench.OpReport) {
+		l := r.Latency
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f\t%.3fms\t%.3fms\t%.3fms\t%.3fms\t%.3fms\t%.3fms\t%.3fms\n",
+			name, r.Requests, r.Errors, r.Misses, r.Throughput, l.Mean, l.P50, l.P90, l.P95, l.P99, l.P999, l.Max)
+	}
+	for _, op := range bench.Ops {
+		if r, ok := report.Operations[op]; ok {
+			row(string(op), *r)
+		}
+	}
+	row("total", bench.OpReport{
+		Requests:   report.Requests,
+		Errors:     report.Errors,
+		Misses:     report.Misses,
+		Throughput: report.Throughput,
+		Latency:    report.Latency,
+	})
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(report.ErrorMessages) > 0 {
+		messages := make([]string, 0, len(report.ErrorMessages))
+		for message := range report.ErrorMessages {
+			messages = append(messages, message)
+		}
+		slices.Sort(messages)
+		fmt.Fprintln(out, "\nErrors:")
+		for _, message := range messages {
+			fmt.Fprintf(out, "- %s (%d)\n", message, report.ErrorMessages[message])
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+
+	addClientFlags(benchCmd)
+	addOutputFlag(benchCmd, outputText, outputJSON)
+
+	flags := benchCmd.Flags()
+	flags.IntP("concurrency", "c", 16, "Number of workers sending requests one after another")
+	flags.Duration("duration", 10*time.Second, "Duration of the run, unless --requests is passed")
+	flags.IntP("requests", "n", 0, "Total number of requests to send instead of running for --duration")
+	flags.String("mix", "read=90,write=10", "Weights of the operations, like read=80,write=15,delete=5")
+	flags.Int("keys", 10000, "Number of keys in the key space")
+	flags.String("key-prefix", "bench/", "Prefix of the keys")
+	flags.String("distribution", bench.Uniform, "Distribution of the keys of requests. Accepted values: uniform, zipfian, sequential")
+	flags.Float64("zipf-exponent", 1.1, "Skew of the zipfian distribution, larger than 1. Larger values send more requests to fewer keys")
+	flags.String("value-size", "100", "Size of written values in bytes, or a range like 100-1000 of which sizes are picked uniformly")
+	flags.Bool("preload", false, "Writes every key of the key space before the run")
+}
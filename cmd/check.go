@@ -1,40 +1,261 @@
-/*
-Copyright © 2024 NAME HERE <EMAIL ADDRESS>
-
-*/
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
-// checkCmd represents the check command
+// checkCmd checks that the nodes of a cluster agree on its state and are healthy
 var checkCmd = &cobra.Command{
 	Use:   "check",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("check called")
+	Short: "Checks that all nodes of a cluster agree on its state and are healthy",
+	Long: `Checks the health of a cluster.
+
+The cluster state is fetched from the first available node passed with --server,
+then from every member it lists. The check fails if the nodes disagree on the
+cluster version or on the members and their status, if a member is failed or
+unreachable, or if its health check fails. The share of keys every node owns on
+the hash ring is reported as well.
+
+If nodes serve cluster traffic on a separate internal address, pass that address,
+as the cluster state is only served there.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputText, outputJSON)
+		if err != nil {
+			return err
+		}
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		report, err := checkCluster(cmd.Context(), c)
+		if err != nil {
+			return clientError(err)
+		}
+		if format == outputJSON {
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(report)
+		} else {
+			err = report.print(cmd)
+		}
+		if err != nil {
+			return err
+		}
+		if len(report.Problems) > 0 {
+			return fmt.Errorf("cluster check found %d problem(s)", len(report.Problems))
+		}
+		return nil
 	},
 }
 
-func init() {
-	rootCmd.AddCommand(checkCmd)
+// checkReport is the result of a cluster check
+type checkReport struct {
+	Version  int64       `json:"version"`  // Version is the cluster state version of the first node
+	Nodes    []nodeCheck `json:"nodes"`    // Nodes are the members known to any node, ordered by ID
+	Problems []string    `json:"problems"` // Problems fail the check
+}
+
+// nodeCheck is the result of checking a single member
+type nodeCheck struct {
+	ID           string  `json:"id"`
+	Address      string  `json:"address"`
+	Status       string  `json:"status"`                  // Status is the status of the node in the view of the first node
+	StateVersion int64   `json:"state_version,omitempty"` // StateVersion is the cluster state version the node reported
+	Members      int     `json:"members,omitempty"`       // Members is the number of nodes in the view of the node
+	Healthy      bool    `json:"healthy"`                 // Healthy is set if the node answered and its health check passed
+	Error        string  `json:"error,omitempty"`
+	Ownership    float64 `json:"ownership"` // Ownership is the share of the key space the node owns
+}
+
+// checkCluster fetches the cluster state of every member and compares the views
+func checkCluster(ctx context.Context, c *client) (*checkReport, error) {
+	var first *proto.ClusterState
+	err := c.call(ctx, func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+		first, err = proto.NewClusterServiceClient(conn).GetClusterState(ctx, &emptypb.Empty{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &checkReport{Version: first.Version, Problems: []string{}}
+	firstView := membershipView(first)
+	members := map[string]*proto.Node{}
+	for _, node := range first.Nodes {
+		members[node.Id] = node
+	}
+	// Nodes missing from the first view are checked too, as other nodes may know them
+	queue := slices.Clone(first.Nodes)
+	seen := map[string]bool{}
+	for _, node := range queue {
+		seen[node.Id] = true
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		check := nodeCheck{ID: node.Id, Address: node.Address, Status: "UNKNOWN"}
+		if known, ok := members[node.Id]; ok {
+			check.Status = known.GetHealth().GetStatus().String()
+		}
+		// Removed nodes are expected to be gone
+		if node.GetHealth().GetStatus() == proto.Status_REMOVED {
+			report.Nodes = append(report.Nodes, check)
+			continue
+		}
+
+		state, err := fetchState(ctx, c, peerAddress(node))
+		if err == nil {
+			err = checkHealth(ctx, c, peerAddress(node))
+			check.StateVersion = state.Version
+			check.Members = len(state.Nodes)
+			for _, other := range state.Nodes {
+				if !seen[other.Id] {
+					seen[other.Id] = true
+					queue = append(queue, other)
+				}
+			}
+			if state.Version != first.Version {
+				report.Problems = append(report.Problems, fmt.Sprintf("Node %s has cluster version %d instead of %d", node.Id, state.Version, first.Version))
+			}
+			if view := membershipView(state); view != firstView {
+				report.Problems = append(report.Problems, fmt.Sprintf("Node %s sees the members %s instead of %s", node.Id, view, firstView))
+			}
+		}
+		switch status := node.GetHealth().GetStatus(); {
+		case check.Status == "UNKNOWN":
+			report.Problems = append(report.Problems, fmt.Sprintf("Node %s is not a member in the view of the first node", node.Id))
+		case status == proto.Status_FAILED || status == proto.Status_SUSPECTED_FAILED:
+			report.Problems = append(report.Problems, fmt.Sprintf("Node %s is %s", node.Id, status))
+		}
+		if err != nil {
+			check.Error = clientError(err).Error()
+			report.Problems = append(report.Problems, fmt.Sprintf("Node %s at %s is not healthy: %s", node.Id, peerAddress(node), check.Error))
+		} else {
+			check.Healthy = true
+		}
+		report.Nodes = append(report.Nodes, check)
+	}
+	slices.SortFunc(report.Nodes, func(a, b nodeCheck) int { return strings.Compare(a.ID, b.ID) })
+
+	// Nodes that are leaving no longer own keys
+	ring := cluster.NewHashRing()
+	for _, node := range first.Nodes {
+		if status := node.GetHealth().GetStatus(); status != proto.Status_LEAVING && status != proto.Status_REMOVED {
+			ring.AddNode(cluster.MapProtoToNode(node))
+		}
+	}
+	ownership := ring.Ownership()
+	for i := range report.Nodes {
+		report.Nodes[i].Ownership = ownership[report.Nodes[i].ID]
+	}
+	return report, nil
+}
 
-	// Here you will define your flags and configuration settings.
+func fetchState(ctx context.Context, c *client, address string) (state *proto.ClusterState, err error) {
+	err = c.callServer(ctx, address, func(ctx context.Context, conn *grpc.ClientConn) error {
+		state, err = proto.NewClusterServiceClient(conn).GetClusterState(ctx, &emptypb.Empty{})
+		return err
+	})
+	return state, err
+}
+
+func checkHealth(ctx context.Context, c *client, address string) error {
+	return c.callServer(ctx, address, func(ctx context.Context, conn *grpc.ClientConn) error {
+		_, err := proto.NewHealthServiceClient(conn).CheckHealth(ctx, &emptypb.Empty{})
+		return err
+	})
+}
+
+// peerAddress returns the address serving the cluster state of a node
+func peerAddress(node *proto.Node) string {
+	if node.InternalAddress != "" {
+		return node.InternalAddress
+	}
+	return node.Address
+}
+
+// membershipView describes the members of a cluster state and their status, ordered by ID
+func membershipView(state *proto.ClusterState) string {
+	members := make([]string, 0, len(state.Nodes))
+	for _, node := range state.Nodes {
+		members = append(members, node.Id+"="+node.GetHealth().GetStatus().String())
+	}
+	slices.Sort(members)
+	return "[" + strings.Join(members, " ") + "]"
+}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// checkCmd.PersistentFlags().String("foo", "", "A help for foo")
+// print writes the report as tables of the nodes and the problems found
+func (r *checkReport) print(cmd *cobra.Command) error {
+	out := cmd.OutOrStdout()
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tSTATUS\tVERSION\tMEMBERS\tHEALTH\tOWNERSHIP")
+	var largest float64
+	for _, node := range r.Nodes {
+		health := "OK"
+		switch {
+		case node.Status == proto.Status_REMOVED.String():
+			health = "-"
+		case !node.Healthy:
+			health = "FAILED"
+		}
+		// Nodes that did not answer have no view
+		version, members := "-", "-"
+		if node.Members > 0 {
+			version, members = strconv.FormatInt(node.StateVersion, 10), strconv.Itoa(node.Members)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%.1f%%\n", node.ID, node.Address, node.Status, version, members, health, node.Ownership*100)
+		largest = max(largest, node.Ownership)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if owners := countOwners(r.Nodes); owners > 1 {
+		ideal := 1 / float64(owners)
+		fmt.Fprintf(out, "\nRing balance: the largest share is %.1fx the ideal share of %.1f%%\n", largest/ideal, ideal*100)
+	}
+	if len(r.Problems) == 0 {
+		_, err := fmt.Fprintf(out, "\nAll %d nodes agree on cluster version %d and are healthy\n", len(r.Nodes), r.Version)
+		return err
+	}
+	fmt.Fprintln(out, "\nProblems:")
+	for _, problem := range r.Problems {
+		fmt.Fprintln(out, "- "+problem)
+	}
+	return nil
+}
+
+// countOwners returns the number of nodes owning a share of the ring
+func countOwners(nodes []nodeCheck) int {
+	owners := 0
+	for _, node := range nodes {
+		if node.Ownership > 0 {
+			owners++
+		}
+	}
+	return owners
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// checkCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	addClientFlags(checkCmd)
+	addClusterSecretFlag(checkCmd)
+	addOutputFlag(checkCmd, outputText, outputJSON)
 }
@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tdevsin/keyforge/internal/auth"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// defaultServer is the node clients connect to if neither --server nor KEYFORGE_SERVER is set
+const defaultServer = "localhost:8080"
+
+// Output formats of client commands
+const (
+	outputText = "text"
+	outputRaw  = "raw"
+	outputJSON = "json"
+	outputHex  = "hex"
+)
+
+// jsonMarshaler writes field names as in the proto files, like the HTTP gateway
+var jsonMarshaler = protojson.MarshalOptions{UseProtoNames: true}
+
+// addClientFlags adds the flags of commands talking to a cluster
+func addClientFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.StringSliceP("server", "s", nil, "Addresses of nodes to connect to, tried in order until one is available. Defaults to the KEYFORGE_SERVER environment variable or "+defaultServer)
+	flags.Duration("timeout", 5*time.Second, "Timeout of every request")
+	flags.String("token", "", "Bearer token sent with every request. Defaults to the KEYFORGE_TOKEN environment variable")
+	flags.Bool("tls", false, "Connects over TLS, verifying the server with the system CAs unless --tls-ca is passed")
+	flags.String("tls-ca", "", "PEM encoded CA verifying the server. Implies --tls")
+	flags.String("tls-cert", "", "PEM encoded client certificate for mutual TLS. Implies --tls")
+	flags.String("tls-key", "", "PEM encoded private key of the client certificate")
+	flags.String("tls-server-name", "", "Name the server certificate is verified against. Defaults to the host of the address")
+}
+
+// addClusterSecretFlag adds the flag of commands calling ClusterService on clusters authenticating
+// nodes with a shared secret
+func addClusterSecretFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("cluster-secret-file", "", "File containing the secret nodes authenticate each other with, for clusters with internal.auth set to secret")
+	cmd.PersistentFlags().Bool("cluster-secret-plaintext", false, "Sends the cluster secret without TLS, for clusters with internal.plaintext_secret set")
+}
+
+// setDefaultTimeout changes the default of --timeout, for commands sending a single long request
+// like a stream of all keys of a node
+func setDefaultTimeout(cmd *cobra.Command, timeout time.Duration, usage string) {
+	flag := cmd.PersistentFlags().Lookup("timeout")
+	flag.Value.Set(timeout.String())
+	flag.DefValue = flag.Value.String()
+	flag.Usage = usage
+}
+
+// addOutputFlag adds the --output flag choosing between the given formats, the first one being the default
+func addOutputFlag(cmd *cobra.Command, formats ...string) {
+	cmd.PersistentFlags().StringP("output", "o", formats[0], "Output format. Accepted values: "+strings.Join(formats, ", "))
+}
+
+// outputFormat returns the format passed with --output, or an error if it is not one of formats
+func outputFormat(cmd *cobra.Command, formats ...string) (string, error) {
+	format, _ := cmd.Flags().GetString("output")
+	for _, f := range formats {
+		if format == f {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("invalid output format %q, accepted values: %s", format, strings.Join(formats, ", "))
+}
+
+// client sends requests to the nodes passed with --server. Requests fail over to the next node if a
+// node is unavailable, and the node that answered is used for the following requests.
+type client struct {
+	servers     []string
+	timeout     time.Duration
+	token       string
+	dialOptions []grpc.DialOption
+	conns       map[string]*grpc.ClientConn
+	current     int
+}
+
+// newClient reads the client flags of a command
+func newClient(cmd *cobra.Command) (*client, error) {
+	flags := cmd.Flags()
+	servers, _ := flags.GetStringSlice("server")
+	if len(servers) == 0 {
+		servers = []string{defaultServer}
+		if env := os.Getenv("KEYFORGE_SERVER"); env != "" {
+			servers = strings.Split(env, ",")
+		}
+	}
+	timeout, _ := flags.GetDuration("timeout")
+	token, _ := flags.GetString("token")
+	if token == "" {
+		token = os.Getenv("KEYFORGE_TOKEN")
+	}
+	creds, err := clientCredentials(cmd)
+	if err != nil {
+		return nil, err
+	}
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if secretFile, _ := flags.GetString("cluster-secret-file"); secretFile != "" {
+		secret, err := auth.LoadSharedSecret(secretFile)
+		if err != nil {
+			return nil, err
+		}
+		if plaintext, _ := flags.GetBool("cluster-secret-plaintext"); plaintext {
+			secret = secret.AllowPlaintext()
+		}
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(secret))
+	}
+	return &client{
+		servers:     servers,
+		timeout:     timeout,
+		token:       token,
+		dialOptions: dialOptions,
+		conns:       map[string]*grpc.ClientConn{},
+	}, nil
+}
+
+// clientCredentials returns the transport credentials configured by the TLS flags
+func clientCredentials(cmd *cobra.Command) (credentials.TransportCredentials, error) {
+	flags := cmd.Flags()
+	enabled, _ := flags.GetBool("tls")
+	caFile, _ := flags.GetString("tls-ca")
+	certFile, _ := flags.GetString("tls-cert")
+	keyFile, _ := flags.GetString("tls-key")
+	serverName, _ := flags.GetString("tls-server-name")
+	if !enabled && caFile == "" && certFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+	if caFile != "" {
+		pool, err := security.LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// server returns the address of the node requests are currently sent to
+func (c *client) server() string {
+	return c.servers[c.current]
+}
+
+// conn returns the connection to a node, creating it on first use
+func (c *client) conn(server string) (*grpc.ClientConn, error) {
+	if conn, ok := c.conns[server]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(server, c.dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[server] = conn
+	return conn, nil
+}
+
+// call runs a request with the timeout and token of the client. If the node is unavailable, the
+// request is retried on the next node until every node was tried once. The last node tried is kept
+// for the following requests, rather than going back to the first unavailable one.
+func (c *client) call(ctx context.Context, request func(ctx context.Context, conn *grpc.ClientConn) error) error {
+	var err error
+	for i := range c.servers {
+		err = c.callServer(ctx, c.server(), request)
+		if !c.unavailable(c.server(), err) || i == len(c.servers)-1 {
+			return err
+		}
+		c.current = (c.current + 1) % len(c.servers)
+	}
+	return err
+}
+
+// unavailable reports whether a request failed because the node can not serve requests. Nodes
+// return Unavailable as well if the node owning a key is unreachable, which another node could
+// not change, so such errors only count if the connection to the node itself is down.
+func (c *client) unavailable(server string, err error) bool {
+	if status.Code(err) != codes.Unavailable {
+		return false
+	}
+	if status.Convert(err).Message() == status.Convert(constants.StatusErrNotReady).Message() {
+		return true
+	}
+	conn, ok := c.conns[server]
+	return !ok || conn.GetState() != connectivity.Ready
+}
+
+// callServer runs a request on the given node
+func (c *client) callServer(ctx context.Context, server string, request func(ctx context.Context, conn *grpc.ClientConn) error) error {
+	conn, err := c.conn(server)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	return request(ctx, conn)
+}
+
+// requestContext returns a context with the timeout and token of the client, for requests sent
+// without callServer, like streams to several nodes at once
+func (c *client) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	if c.token != "" {
+		ctx = auth.WithToken(ctx, c.token)
+	}
+	return ctx, cancel
+}
+
+// close closes the connections to all nodes
+func (c *client) close() {
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+// clientError returns the message of a failed request without the gRPC prefix, like
+// "NotFound: Key not found"
+func clientError(err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	return errors.New(s.Code().String() + ": " + s.Message())
+}
+
+// printJSON writes a proto message as a line of JSON
+func printJSON(cmd *cobra.Command, m protobuf.Message) error {
+	data, err := jsonMarshaler.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(cmd.OutOrStdout(), string(data))
+	return err
+}
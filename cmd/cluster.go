@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// clusterCmd groups the commands administering the membership of a cluster
+var clusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Shows and changes the membership of a cluster",
+	Long: `Shows and changes the membership of a cluster through ClusterService.
+
+Commands are sent to the first available node passed with --server. If nodes
+serve cluster traffic on a separate internal address, pass that address, as
+ClusterService is only served there. Clusters authenticating nodes accept
+these commands only with the node credentials: the secret passed with
+--cluster-secret-file, or a node certificate passed with --tls-cert.`,
+}
+
+var clusterStatusCmd = &cobra.Command{
+	Use:          "status",
+	Short:        "Prints a summary of the cluster state",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputText, outputJSON)
+		if err != nil {
+			return err
+		}
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		var state *proto.ClusterState
+		var ring *proto.Ring
+		err = c.call(cmd.Context(), func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+			client := proto.NewClusterServiceClient(conn)
+			if state, err = client.GetClusterState(ctx, &emptypb.Empty{}); err != nil {
+				return err
+			}
+			ring, err = client.GetRing(ctx, &emptypb.Empty{})
+			return err
+		})
+		if err != nil {
+			return clientError(err)
+		}
+
+		summary := summarizeCluster(c.server(), state, ring)
+		if format == outputJSON {
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(summary)
+		}
+		return summary.print(cmd)
+	},
+}
+
+// clusterSummary is the output of cluster status
+type clusterSummary struct {
+	Server      string         `json:"server"`  // Server is the node the state was fetched from
+	Version     int64          `json:"version"` // Version is the cluster state version
+	LastUpdated time.Time      `json:"last_updated"`
+	Members     int            `json:"members"`
+	Statuses    map[string]int `json:"statuses"` // Statuses counts the members by status
+	Owners      int            `json:"owners"`   // Owners is the number of nodes owning a range of the ring
+	Largest     float64        `json:"largest_share"`
+}
+
+// summarizeCluster counts the members of a cluster state by status and the owners of a ring
+func summarizeCluster(server string, state *proto.ClusterState, ring *proto.Ring) *clusterSummary {
+	summary := &clusterSummary{
+		Server:      server,
+		Version:     state.Version,
+		LastUpdated: state.GetLastUpdated().AsTime(),
+		Members:     len(state.Nodes),
+		Statuses:    map[string]int{},
+		Owners:      len(ring.Ranges),
+	}
+	for _, node := range state.Nodes {
+		summary.Statuses[node.GetHealth().GetStatus().String()]++
+	}
+	for _, r := range ring.Ranges {
+		summary.Largest = max(summary.Largest, r.Ownership)
+	}
+	return summary
+}
+
+// print writes the summary as a list of fields
+func (s *clusterSummary) print(cmd *cobra.Command) error {
+	statuses := make([]string, 0, len(s.Statuses))
+	for status, count := range s.Statuses {
+		statuses = append(statuses, fmt.Sprintf("%d %s", count, status))
+	}
+	slices.Sort(statuses)
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Server:\t%s\n", s.Server)
+	fmt.Fprintf(w, "Version:\t%d\n", s.Version)
+	fmt.Fprintf(w, "Last updated:\t%s\n", s.LastUpdated.Local().Format(time.RFC3339))
+	fmt.Fprintf(w, "Members:\t%d (%s)\n", s.Members, strings.Join(statuses, ", "))
+	fmt.Fprintf(w, "Ring owners:\t%d\n", s.Owners)
+	if s.Owners > 0 {
+		fmt.Fprintf(w, "Largest share:\t%.1f%% (ideal %.1f%%)\n", s.Largest*100, 100/float64(s.Owners))
+	}
+	return w.Flush()
+}
+
+var clusterMembersCmd = &cobra.Command{
+	Use:          "members",
+	Short:        "Lists the members of the cluster and their status",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputText, outputJSON)
+		if err != nil {
+			return err
+		}
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		var state *proto.ClusterState
+		err = c.call(cmd.Context(), func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+			state, err = proto.NewClusterServiceClient(conn).GetClusterState(ctx, &emptypb.Empty{})
+			return err
+		})
+		if err != nil {
+			return clientError(err)
+		}
+		slices.SortFunc(state.Nodes, func(a, b *proto.Node) int { return strings.Compare(a.Id, b.Id) })
+		if format == outputJSON {
+			return printJSON(cmd, state)
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tADDRESS\tINTERNAL ADDRESS\tSTATUS\tVERSION\tLAST UPDATED")
+		for _, node := range state.Nodes {
+			internal := node.InternalAddress
+			if internal == "" {
+				internal = "-"
+			}
+			updated := node.GetHealth().GetLastUpdated().AsTime().Local().Format(time.RFC3339)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", node.Id, node.Address, internal, node.GetHealth().GetStatus(), node.Version, updated)
+		}
+		return w.Flush()
+	},
+}
+
+var clusterRingCmd = &cobra.Command{
+	Use:   "ring",
+	Short: "Prints the ranges of the hash ring and the nodes owning them",
+	Long: `Prints the hash ring of the node the command is sent to. A node owns the
+positions after the previous node up to and including its own, so every row is
+the range (START, END] of a node. The first range wraps around the end of the ring.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputText, outputJSON)
+		if err != nil {
+			return err
+		}
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		var ring *proto.Ring
+		err = c.call(cmd.Context(), func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+			ring, err = proto.NewClusterServiceClient(conn).GetRing(ctx, &emptypb.Empty{})
+			return err
+		})
+		if err != nil {
+			return clientError(err)
+		}
+		if format == outputJSON {
+			return printJSON(cmd, ring)
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NODE\tADDRESS\tSTART\tEND\tOWNERSHIP")
+		for _, r := range ring.Ranges {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.1f%%\n", r.NodeId, r.Address, r.Start, r.End, r.Ownership*100)
+		}
+		return w.Flush()
+	},
+}
+
+var clusterRemoveCmd = &cobra.Command{
+	Use:   "remove <node-id>...",
+	Short: "Removes failed nodes from the cluster",
+	Long: `Removes nodes that are failed or suspected to be failed from the cluster, so that
+their keys are owned by the following nodes on the ring. Healthy nodes can not be
+removed, decommission them instead. The removal reaches all nodes via gossip; a
+removed node that is started again rejoins the cluster.
+
+Keys stored on a removed node are not copied to the new owners.`,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputText, outputJSON)
+		if err != nil {
+			return err
+		}
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		for _, id := range args {
+			var node *proto.Node
+			err = c.call(cmd.Context(), func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+				node, err = proto.NewClusterServiceClient(conn).RemoveNode(ctx, &proto.RemoveNodeRequest{Id: id})
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("%s: %w", id, clientError(err))
+			}
+			if err := printNode(cmd, format, node); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var clusterDecommissionCmd = &cobra.Command{
+	Use:   "decommission [node-id]",
+	Short: "Makes a node leave the cluster",
+	Long: `Makes a node leave the cluster, or the node the command is sent to if no ID is
+given. The node announces itself as leaving, like it does when it shuts down, and
+forwards the requests it receives from then on to the new owners of their keys.
+The node keeps running and can be stopped once clients no longer use it.
+
+Keys stored on the node are not copied to the new owners.`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputText, outputJSON)
+		if err != nil {
+			return err
+		}
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		req := &proto.DecommissionNodeRequest{}
+		if len(args) > 0 {
+			req.Id = args[0]
+		}
+		var node *proto.Node
+		err = c.call(cmd.Context(), func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+			node, err = proto.NewClusterServiceClient(conn).DecommissionNode(ctx, req)
+			return err
+		})
+		if err != nil {
+			return clientError(err)
+		}
+		return printNode(cmd, format, node)
+	},
+}
+
+// printNode writes the ID and status of a node, or the node as JSON
+func printNode(cmd *cobra.Command, format string, node *proto.Node) error {
+	if format == outputJSON {
+		return printJSON(cmd, node)
+	}
+	_, err := fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", node.Id, node.GetHealth().GetStatus())
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(clusterCmd)
+	clusterCmd.AddCommand(clusterStatusCmd, clusterMembersCmd, clusterRingCmd, clusterRemoveCmd, clusterDecommissionCmd)
+
+	addClientFlags(clusterCmd)
+	addClusterSecretFlag(clusterCmd)
+	addOutputFlag(clusterCmd, outputText, outputJSON)
+}
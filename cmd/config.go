@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tdevsin/keyforge/internal/config"
+)
+
+// configCmd groups the commands working with the node configuration
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the node configuration",
+}
+
+// configValidateCmd validates the config file together with the KEYFORGE_* environment variables
+var configValidateCmd = &cobra.Command{
+	Use:          "validate",
+	Short:        "Validates the config file and KEYFORGE_* environment variables without starting a node",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		settings, err := loadSettings(cmd)
+		if err != nil {
+			return err
+		}
+		if err := settings.Validate(); err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Configuration is valid")
+		return nil
+	},
+}
+
+// addConfigFlag adds the --config flag to a command
+func addConfigFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP("config", "c", "", "Path to a YAML or TOML config file. Defaults to the KEYFORGE_CONFIG environment variable")
+}
+
+// loadSettings reads the settings from the config file and the KEYFORGE_* environment variables
+func loadSettings(cmd *cobra.Command) (*config.Settings, error) {
+	configFile, _ := cmd.Flags().GetString("config")
+	if configFile == "" {
+		configFile = os.Getenv("KEYFORGE_CONFIG")
+	}
+	return config.LoadSettings(configFile)
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+
+	addConfigFlag(configValidateCmd)
+}
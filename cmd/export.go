@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/dump"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// importBatchSize is the approximate size of the keys and values sent to a node in one message
+const importBatchSize = 1 << 20
+
+// exportCmd writes every key of a cluster into a file
+var exportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Writes every key of a cluster into a file",
+	Long: `Writes every key of a cluster, with its value, expiry and flags, into a file that
+keyforge import loads into another cluster. Pass - to write to stdout.
+
+Every node owning a range of the ring streams its keys from a snapshot of its
+database. The streams of all nodes are opened before any key is read, so the
+snapshots are taken at about the same time, but not atomically across nodes.
+
+The file contains a JSON object per line, or size prefixed protobuf KeyEntry
+messages with --format proto, which files ending with .pb or .bin default to.
+
+Nodes only export keys on clusters that authenticate nodes, to callers sending
+the cluster secret or a node certificate.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		format, err := dumpFormat(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		prefix, _ := cmd.Flags().GetString("prefix")
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		ring, err := fetchRing(cmd.Context(), c)
+		if err != nil {
+			return err
+		}
+		owners := ring.GetNodes()
+
+		ctx, cancel := c.requestContext(cmd.Context())
+		defer cancel()
+		streams := make([]grpc.ServerStreamingClient[proto.KeyEntryBatch], len(owners))
+		for i, node := range owners {
+			conn, err := c.conn(node.PeerAddress())
+			if err != nil {
+				return err
+			}
+			streams[i], err = proto.NewClusterServiceClient(conn).ExportKeys(ctx, &proto.ExportKeysRequest{Prefix: prefix})
+			if err != nil {
+				return fmt.Errorf("failed to export the keys of %s: %w", node.ID, clientError(err))
+			}
+		}
+
+		out := cmd.OutOrStdout()
+		if args[0] != "-" {
+			f, err := os.Create(args[0])
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if closeErr := f.Close(); err == nil {
+					err = closeErr
+				}
+				if err != nil {
+					os.Remove(args[0])
+				}
+			}()
+			out = f
+		}
+		w, err := dump.NewWriter(out, format)
+		if err != nil {
+			return err
+		}
+		exported := 0
+		for i, stream := range streams {
+			for {
+				batch, err := stream.Recv()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return fmt.Errorf("failed to export the keys of %s: %w", owners[i].ID, clientError(err))
+				}
+				for _, entry := range batch.Entries {
+					if err := w.Write(entry); err != nil {
+						return err
+					}
+				}
+				exported += len(batch.Entries)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "Exported %d keys from %d nodes\n", exported, len(owners))
+		return nil
+	},
+}
+
+// importCmd writes the keys of a file into a cluster
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Writes the keys of an exported file into a cluster",
+	Long: `Writes the keys of a file written by keyforge export into a cluster, keeping their
+expiry and flags. Pass - to read from stdin. Keys that already exist are
+overwritten, and keys that expired since the export are skipped.
+
+Every key is routed by the ring of the target cluster and sent to the node owning
+it, in batches written with a single sync. Nodes forward keys they no longer own
+if the ring changes during the import.
+
+Nodes only import keys on clusters that authenticate nodes, from callers sending
+the cluster secret or a node certificate.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		r, closeDump, err := openDump(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		defer closeDump()
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		ring, err := fetchRing(cmd.Context(), c)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := c.requestContext(cmd.Context())
+		defer cancel()
+		imports := map[string]*nodeImport{}
+		for {
+			entry, err := r.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			owner, ok := ring.GetNode(ring.GetResponsibleNode(entry.Key))
+			if !ok {
+				return errors.New("the cluster has no nodes owning keys")
+			}
+			node, ok := imports[owner.ID]
+			if !ok {
+				if node, err = startImport(ctx, c, owner); err != nil {
+					return err
+				}
+				imports[owner.ID] = node
+			}
+			if err := node.add(entry); err != nil {
+				return err
+			}
+		}
+
+		total := &proto.ImportKeysResponse{}
+		for _, node := range imports {
+			resp, err := node.finish()
+			if err != nil {
+				return err
+			}
+			total.Imported += resp.Imported
+			total.Forwarded += resp.Forwarded
+			total.Expired += resp.Expired
+		}
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "Imported %d keys into %d nodes (%d forwarded by nodes, %d expired)\n",
+			total.Imported+total.Forwarded, len(imports), total.Forwarded, total.Expired)
+		return err
+	},
+}
+
+// nodeImport batches the keys sent to a node
+type nodeImport struct {
+	node   cluster.Node
+	stream grpc.ClientStreamingClient[proto.KeyEntryBatch, proto.ImportKeysResponse]
+	batch  *proto.KeyEntryBatch
+	size   int
+}
+
+func startImport(ctx context.Context, c *client, node cluster.Node) (*nodeImport, error) {
+	conn, err := c.conn(node.PeerAddress())
+	if err != nil {
+		return nil, err
+	}
+	stream, err := proto.NewClusterServiceClient(conn).ImportKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import keys into %s: %w", node.ID, clientError(err))
+	}
+	return &nodeImport{node: node, stream: stream, batch: &proto.KeyEntryBatch{}}, nil
+}
+
+// add queues an entry and sends the batch once it is large enough
+func (n *nodeImport) add(entry *proto.KeyEntry) error {
+	n.batch.Entries = append(n.batch.Entries, entry)
+	n.size += len(entry.Key) + len(entry.Value)
+	if n.size < importBatchSize {
+		return nil
+	}
+	return n.send()
+}
+
+func (n *nodeImport) send() error {
+	if err := n.stream.Send(n.batch); err != nil {
+		// The status of a failed stream is returned when it is closed
+		_, err = n.stream.CloseAndRecv()
+		return fmt.Errorf("failed to import keys into %s: %w", n.node.ID, clientError(err))
+	}
+	n.batch, n.size = &proto.KeyEntryBatch{}, 0
+	return nil
+}
+
+// finish sends the last batch and returns what the node wrote
+func (n *nodeImport) finish() (*proto.ImportKeysResponse, error) {
+	if len(n.batch.Entries) > 0 {
+		if err := n.send(); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := n.stream.CloseAndRecv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to import keys into %s: %w", n.node.ID, clientError(err))
+	}
+	return resp, nil
+}
+
+// fetchRing returns the hash ring of the cluster with the addresses of the nodes owning its ranges
+func fetchRing(ctx context.Context, c *client) (*cluster.HashRing, error) {
+	var state *proto.ClusterState
+	var ring *proto.Ring
+	err := c.call(ctx, func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+		client := proto.NewClusterServiceClient(conn)
+		if state, err = client.GetClusterState(ctx, &emptypb.Empty{}); err != nil {
+			return err
+		}
+		ring, err = client.GetRing(ctx, &emptypb.Empty{})
+		return err
+	})
+	if err != nil {
+		return nil, clientError(err)
+	}
+
+	members := make(map[string]*proto.Node, len(state.Nodes))
+	for _, node := range state.Nodes {
+		members[node.Id] = node
+	}
+	hashRing := cluster.NewHashRing()
+	for _, r := range ring.Ranges {
+		node, ok := members[r.NodeId]
+		if !ok {
+			return nil, fmt.Errorf("node %s of the ring is not a member of the cluster", r.NodeId)
+		}
+		hashRing.AddNode(cluster.MapProtoToNode(node))
+	}
+	if len(ring.Ranges) == 0 {
+		return nil, errors.New("no node of the cluster owns keys")
+	}
+	return hashRing, nil
+}
+
+// openDump returns a reader of the dump file at path, or of stdin for -, and a function closing the file
+func openDump(cmd *cobra.Command, path string) (*dump.Reader, func() error, error) {
+	format, err := dumpFormat(cmd, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	in, closeDump := cmd.InOrStdin(), func() error { return nil }
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		in, closeDump = f, f.Close
+	}
+	r, err := dump.NewReader(in, format)
+	if err != nil {
+		closeDump()
+		return nil, nil, err
+	}
+	return r, closeDump, nil
+}
+
+// dumpFormat returns the format passed with --format, or the format of the file by its extension
+func dumpFormat(cmd *cobra.Command, path string) (string, error) {
+	if !cmd.Flags().Changed("format") {
+		return dump.FormatOf(path), nil
+	}
+	format, _ := cmd.Flags().GetString("format")
+	return format, nil
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd, importCmd)
+
+	for _, cmd := range []*cobra.Command{exportCmd, importCmd} {
+		addClientFlags(cmd)
+		addClusterSecretFlag(cmd)
+		setDefaultTimeout(cmd, time.Hour, "Timeout of the whole "+cmd.Name())
+		cmd.Flags().String("format", dump.JSONLines, "Format of the file: "+strings.Join(dump.Formats, " or ")+". Defaults to proto for files ending with .pb or .bin")
+	}
+	exportCmd.Flags().String("prefix", "", "Only exports keys starting with the prefix")
+}
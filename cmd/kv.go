@@ -1,40 +1,273 @@
-/*
-Copyright © 2024 NAME HERE <EMAIL ADDRESS>
-
-*/
 package cmd
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc"
 )
 
-// kvCmd represents the kv command
+// kvCmd groups the commands reading and writing keys of a cluster
 var kvCmd = &cobra.Command{
 	Use:   "kv",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("kv called")
+	Short: "Reads and writes keys of a cluster",
+	Long: `Reads and writes keys of a cluster over gRPC.
+
+Any node accepts every key and forwards it to the node owning it. Pass several
+nodes with --server to fail over to the next one if a node is unavailable.`,
+}
+
+var kvGetCmd = &cobra.Command{
+	Use:          "get <key>",
+	Short:        "Prints the value of a key",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputRaw, outputJSON, outputHex)
+		if err != nil {
+			return err
+		}
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		var resp *proto.GetKeyResponse
+		err = c.call(cmd.Context(), func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+			resp, err = proto.NewKeyServiceClient(conn).GetKey(ctx, &proto.GetKeyRequest{Key: args[0]})
+			return err
+		})
+		if err != nil {
+			return clientError(err)
+		}
+		if format == outputJSON {
+			return printJSON(cmd, resp)
+		}
+		return printValue(cmd, format, "", resp.Value)
 	},
 }
 
+var kvSetCmd = &cobra.Command{
+	Use:   "set <key> [value]",
+	Short: "Writes a key",
+	Long: `Writes a key. The value is taken from the argument, from the file passed with
+--file, or from stdin if neither is given.`,
+	Args:         cobra.RangeArgs(1, 2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputRaw, outputJSON)
+		if err != nil {
+			return err
+		}
+		value, err := readValue(cmd, args[1:])
+		if err != nil {
+			return err
+		}
+		req := &proto.SetKeyRequest{Key: args[0], Value: value}
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		req.TtlMs = ttl.Milliseconds()
+		ifAbsent, _ := cmd.Flags().GetBool("if-absent")
+		ifPresent, _ := cmd.Flags().GetBool("if-present")
+		switch {
+		case ifAbsent && ifPresent:
+			return errors.New("--if-absent and --if-present can not be combined")
+		case ifAbsent:
+			req.Condition = proto.SetKeyRequest_IF_ABSENT
+		case ifPresent:
+			req.Condition = proto.SetKeyRequest_IF_PRESENT
+		}
+
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		var resp *proto.SetKeyResponse
+		err = c.call(cmd.Context(), func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+			resp, err = proto.NewKeyServiceClient(conn).SetKey(ctx, req)
+			return err
+		})
+		if err != nil {
+			return clientError(err)
+		}
+		if format == outputJSON {
+			return printJSON(cmd, resp)
+		}
+		return nil
+	},
+}
+
+var kvDeleteCmd = &cobra.Command{
+	Use:          "delete <key>...",
+	Aliases:      []string{"del"},
+	Short:        "Deletes keys",
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputRaw, outputJSON)
+		if err != nil {
+			return err
+		}
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		for _, key := range args {
+			var resp *proto.DeleteKeyResponse
+			err = c.call(cmd.Context(), func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+				resp, err = proto.NewKeyServiceClient(conn).DeleteKey(ctx, &proto.DeleteKeyRequest{Key: key})
+				return err
+			})
+			if err != nil {
+				return clientError(err)
+			}
+			if format == outputJSON {
+				err = printJSON(cmd, resp)
+			} else if resp.Found {
+				_, err = fmt.Fprintf(cmd.OutOrStdout(), "%s: deleted\n", key)
+			} else {
+				_, err = fmt.Fprintf(cmd.OutOrStdout(), "%s: not found\n", key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var kvScanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Lists keys in ascending order",
+	Long: `Lists keys in ascending order, one per line. Keys are followed by a tab and
+their value unless --keys-only is passed. The JSON output prints one object per key.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := outputFormat(cmd, outputRaw, outputJSON, outputHex)
+		if err != nil {
+			return err
+		}
+		prefix, _ := cmd.Flags().GetString("prefix")
+		limit, _ := cmd.Flags().GetInt("limit")
+		pageSize, _ := cmd.Flags().GetInt32("page-size")
+		keysOnly, _ := cmd.Flags().GetBool("keys-only")
+
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		req := &proto.ScanKeysRequest{Prefix: prefix, Limit: pageSize, KeysOnly: keysOnly}
+		printed := 0
+		for {
+			if limit > 0 {
+				req.Limit = int32(min(int(pageSize), limit-printed))
+			}
+			var resp *proto.ScanKeysResponse
+			err = c.call(cmd.Context(), func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+				resp, err = proto.NewKeyServiceClient(conn).ScanKeys(ctx, req)
+				return err
+			})
+			if err != nil {
+				return clientError(err)
+			}
+			for _, item := range resp.Items {
+				if format == outputJSON {
+					err = printJSON(cmd, item)
+				} else if keysOnly {
+					_, err = fmt.Fprintln(cmd.OutOrStdout(), item.Key)
+				} else {
+					err = printValue(cmd, format, item.Key+"\t", item.Value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			printed += len(resp.Items)
+			if resp.Cursor == "" || (limit > 0 && printed >= limit) {
+				return nil
+			}
+			req.StartAfter = resp.Cursor
+		}
+	},
+}
+
+// readValue returns the value argument, the content of the --file flag or stdin
+func readValue(cmd *cobra.Command, args []string) ([]byte, error) {
+	file, _ := cmd.Flags().GetString("file")
+	switch {
+	case len(args) > 0 && file != "":
+		return nil, errors.New("the value can not be passed both as argument and with --file")
+	case len(args) > 0:
+		return []byte(args[0]), nil
+	case file != "" && file != "-":
+		return os.ReadFile(file)
+	default:
+		return io.ReadAll(cmd.InOrStdin())
+	}
+}
+
+// printValue writes a value after a prefix in the raw or hex format. Raw values are written as is,
+// followed by a line break only if a prefix is written or stdout is a terminal, so values can be
+// piped unchanged.
+func printValue(cmd *cobra.Command, format, prefix string, value []byte) error {
+	out := cmd.OutOrStdout()
+	if format == outputHex {
+		_, err := fmt.Fprintln(out, prefix+hex.EncodeToString(value))
+		return err
+	}
+	if _, err := io.WriteString(out, prefix); err != nil {
+		return err
+	}
+	if _, err := out.Write(value); err != nil {
+		return err
+	}
+	if prefix != "" || isTerminal(out) {
+		_, err := io.WriteString(out, "\n")
+		return err
+	}
+	return nil
+}
+
+// isTerminal reports whether w is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 func init() {
 	rootCmd.AddCommand(kvCmd)
+	kvCmd.AddCommand(kvGetCmd, kvSetCmd, kvDeleteCmd, kvScanCmd)
 
-	// Here you will define your flags and configuration settings.
+	addClientFlags(kvCmd)
+	addOutputFlag(kvGetCmd, outputRaw, outputJSON, outputHex)
+	addOutputFlag(kvSetCmd, outputRaw, outputJSON)
+	addOutputFlag(kvDeleteCmd, outputRaw, outputJSON)
+	addOutputFlag(kvScanCmd, outputRaw, outputJSON, outputHex)
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// kvCmd.PersistentFlags().String("foo", "", "A help for foo")
+	kvSetCmd.Flags().StringP("file", "f", "", "Reads the value from a file, or from stdin if it is -")
+	kvSetCmd.Flags().Duration("ttl", 0, "Time after which the key expires. The key never expires if 0")
+	kvSetCmd.Flags().Bool("if-absent", false, "Only writes the key if it does not exist")
+	kvSetCmd.Flags().Bool("if-present", false, "Only writes the key if it exists")
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// kvCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	kvScanCmd.Flags().String("prefix", "", "Only lists keys starting with the prefix")
+	kvScanCmd.Flags().Int("limit", 0, "Maximum number of keys listed. All keys are listed if 0")
+	kvScanCmd.Flags().Int32("page-size", 100, "Number of keys requested at once")
+	kvScanCmd.Flags().Bool("keys-only", false, "Only lists the keys without their values")
 }
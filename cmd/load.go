@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/dump"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"google.golang.org/grpc"
+)
+
+// loadChunkSize is the size of the pieces SSTables are sent to nodes in
+const loadChunkSize = 1 << 20
+
+// loadCmd ingests the keys of a file into a cluster as SSTables
+var loadCmd = &cobra.Command{
+	Use:   "load <file>",
+	Short: "Bulk loads the keys of an exported file into a cluster",
+	Long: `Loads the keys of a file written by keyforge export into a cluster much faster than
+keyforge import, for initial loads of large data sets. Pass - to read from stdin.
+
+Every key is routed by the ring of the target cluster. The keys of each node are
+buffered up to --table-size, sorted and written into an SSTable that is streamed
+to the node and ingested directly into its database, bypassing the memtable and
+the write-ahead log. A key appearing several times in a table keeps its last
+value. Keys that already exist are overwritten, and expired keys are skipped.
+
+Nodes verify that they own every key of a table before ingesting it. If the ring
+changes during the load, the load fails and can be run again. Tables ingested
+before the failure are kept.
+
+Up to --table-size of keys and values is buffered in memory per node. Nodes only
+ingest tables on clusters that authenticate nodes, from callers sending the
+cluster secret or a node certificate.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tableSize, _ := cmd.Flags().GetInt("table-size")
+		if tableSize <= 0 {
+			return errors.New("--table-size must be positive")
+		}
+		r, closeDump, err := openDump(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		defer closeDump()
+		dir, err := os.MkdirTemp("", "keyforge-load-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		ring, err := fetchRing(cmd.Context(), c)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := c.requestContext(cmd.Context())
+		defer cancel()
+		loads := map[string]*nodeLoad{}
+		expired := 0
+		now := time.Now()
+		for {
+			entry, err := r.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if entry.Key == "" || storage.IsInternalKey([]byte(entry.Key)) {
+				return fmt.Errorf("key %q is invalid", entry.Key)
+			}
+			if entry.ExpiresAt != 0 && entry.ExpiresAt <= now.UnixMilli() {
+				expired++
+				continue
+			}
+			owner, ok := ring.GetNode(ring.GetResponsibleNode(entry.Key))
+			if !ok {
+				return errors.New("the cluster has no nodes owning keys")
+			}
+			node, ok := loads[owner.ID]
+			if !ok {
+				if node, err = startLoad(ctx, c, owner, filepath.Join(dir, fmt.Sprintf("%d", len(loads)))); err != nil {
+					return err
+				}
+				loads[owner.ID] = node
+			}
+			node.entries = append(node.entries, entry)
+			node.size += len(entry.Key) + len(entry.Value)
+			if node.size < tableSize {
+				continue
+			}
+			if err := node.send(); err != nil {
+				return err
+			}
+		}
+
+		total := &proto.LoadTablesResponse{}
+		for _, node := range loads {
+			resp, err := node.finish()
+			if err != nil {
+				return err
+			}
+			total.Tables += resp.Tables
+			total.Keys += resp.Keys
+			total.Bytes += resp.Bytes
+		}
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "Loaded %d keys into %d nodes in %d SSTables of %d bytes (%d expired)\n",
+			total.Keys, len(loads), total.Tables, total.Bytes, expired)
+		return err
+	},
+}
+
+// nodeLoad buffers the keys of a node and sends them as SSTables
+type nodeLoad struct {
+	node    cluster.Node
+	path    string
+	stream  grpc.ClientStreamingClient[proto.TableChunk, proto.LoadTablesResponse]
+	entries []*proto.KeyEntry
+	size    int
+}
+
+func startLoad(ctx context.Context, c *client, node cluster.Node, path string) (*nodeLoad, error) {
+	conn, err := c.conn(node.PeerAddress())
+	if err != nil {
+		return nil, err
+	}
+	stream, err := proto.NewClusterServiceClient(conn).LoadTables(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keys into %s: %w", node.ID, clientError(err))
+	}
+	return &nodeLoad{node: node, path: path + ".sst", stream: stream}, nil
+}
+
+// send writes the buffered keys into an SSTable and streams it to the node
+func (n *nodeLoad) send() error {
+	if err := n.writeTable(); err != nil {
+		return err
+	}
+	defer os.Remove(n.path)
+	if err := n.sendTable(); err != nil {
+		// The status of a failed stream is returned when it is closed
+		if errors.Is(err, io.EOF) {
+			_, err = n.stream.CloseAndRecv()
+		}
+		return fmt.Errorf("failed to load keys into %s: %w", n.node.ID, clientError(err))
+	}
+	n.entries, n.size = nil, 0
+	return nil
+}
+
+// writeTable writes the buffered keys in ascending order. All keys of a table get the time it is
+// written as version.
+func (n *nodeLoad) writeTable() error {
+	slices.SortStableFunc(n.entries, func(a, b *proto.KeyEntry) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	w, err := storage.NewTableWriter(n.path)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().UnixNano()
+	for i, entry := range n.entries {
+		if i+1 < len(n.entries) && n.entries[i+1].Key == entry.Key {
+			// The last value of a key in the file wins
+			continue
+		}
+		err := w.Add([]byte(entry.Key), &storage.Entry{
+			Value:     entry.Value,
+			Timestamp: timestamp,
+			ExpiresAt: entry.ExpiresAt,
+			Flags:     entry.Flags,
+		})
+		if err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// sendTable streams the table in chunks, followed by its checksum
+func (n *nodeLoad) sendTable() error {
+	f, err := os.Open(n.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	buf := make([]byte, loadChunkSize)
+	for {
+		read, err := io.ReadFull(f, buf)
+		if read > 0 {
+			sum.Write(buf[:read])
+			if err := n.stream.Send(&proto.TableChunk{Data: buf[:read]}); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return n.stream.Send(&proto.TableChunk{Sha256: hex.EncodeToString(sum.Sum(nil))})
+}
+
+// finish sends the last table and returns what the node ingested
+func (n *nodeLoad) finish() (*proto.LoadTablesResponse, error) {
+	if len(n.entries) > 0 {
+		if err := n.send(); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := n.stream.CloseAndRecv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keys into %s: %w", n.node.ID, clientError(err))
+	}
+	return resp, nil
+}
+
+func init() {
+	rootCmd.AddCommand(loadCmd)
+
+	addClientFlags(loadCmd)
+	addClusterSecretFlag(loadCmd)
+	setDefaultTimeout(loadCmd, time.Hour, "Timeout of the whole load")
+	loadCmd.Flags().String("format", dump.JSONLines, "Format of the file: "+strings.Join(dump.Formats, " or ")+". Defaults to proto for files ending with .pb or .bin")
+	loadCmd.Flags().Int("table-size", 64<<20, "Size of the keys and values of a node written into one SSTable")
+}
@@ -0,0 +1,464 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"golang.org/x/term"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// shellCmd starts an interactive prompt sending commands to a cluster
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Starts an interactive prompt to read and write keys",
+	Long: `Starts an interactive prompt to read and write keys of a cluster. Type help
+for the list of commands. Commands are completed with tab, keys of get, set and
+del as well, and earlier commands of the session are recalled with the arrow keys.
+
+Every command prints the time it took. The members of the cluster are added to
+the nodes passed with --server when the shell starts, so the shell reconnects to
+another member if the current node becomes unavailable.
+
+If stdin is not a terminal, commands are read line by line without a prompt.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		s := &shell{client: c, out: cmd.OutOrStdout()}
+		s.discover(cmd.Context())
+		if f, ok := cmd.InOrStdin().(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+			return s.runTerminal(cmd.Context(), f)
+		}
+		return s.run(cmd.Context(), cmd.InOrStdin())
+	},
+}
+
+// shellCommand is a command of the shell
+type shellCommand struct {
+	usage   string
+	help    string
+	minArgs int
+	maxArgs int // maxArgs is -1 if the number of arguments is not limited
+	run     func(s *shell, ctx context.Context, args []string) error
+}
+
+// shellCommands are the commands of the shell by name. They are set in init, as help refers to them.
+var shellCommands map[string]shellCommand
+
+// shell runs the commands read from a terminal or another input
+type shell struct {
+	client    *client
+	out       io.Writer
+	namespace string // namespace is prepended to keys as namespace/ if set
+}
+
+// discover adds the addresses of the healthy members of the cluster to the servers of the client.
+// Failures are ignored, as the cluster state may not be available to clients.
+func (s *shell) discover(ctx context.Context) {
+	var state *proto.ClusterState
+	err := s.client.call(ctx, func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+		state, err = proto.NewClusterServiceClient(conn).GetClusterState(ctx, &emptypb.Empty{})
+		return err
+	})
+	if err != nil {
+		return
+	}
+	for _, node := range state.Nodes {
+		if node.GetHealth().GetStatus() == proto.Status_HEALTHY && !slices.Contains(s.client.servers, node.Address) {
+			s.client.servers = append(s.client.servers, node.Address)
+		}
+	}
+}
+
+// runTerminal reads commands from a terminal with line editing, history and completion
+func (s *shell) runTerminal(ctx context.Context, f *os.File) error {
+	state, err := term.MakeRaw(int(f.Fd()))
+	if err != nil {
+		return err
+	}
+	defer term.Restore(int(f.Fd()), state)
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{f, os.Stdout}, "")
+	if width, height, err := term.GetSize(int(f.Fd())); err == nil && width > 0 {
+		t.SetSize(width, height)
+	}
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return s.complete(ctx, line, pos)
+	}
+	s.out = t
+
+	fmt.Fprintf(t, "Connected to %s. Type help for the list of commands.\n", s.client.server())
+	for {
+		t.SetPrompt(s.prompt())
+		line, err := t.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if s.execute(ctx, line) {
+			return nil
+		}
+	}
+}
+
+// run reads commands line by line without a prompt
+func (s *shell) run(ctx context.Context, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if s.execute(ctx, scanner.Text()) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// prompt shows the current node and namespace
+func (s *shell) prompt() string {
+	if s.namespace != "" {
+		return s.client.server() + "[" + s.namespace + "]> "
+	}
+	return s.client.server() + "> "
+}
+
+// execute runs a line and prints its result and the time it took. It returns true if the shell
+// should exit.
+func (s *shell) execute(ctx context.Context, line string) bool {
+	args, err := splitArgs(line)
+	if err != nil {
+		fmt.Fprintln(s.out, "Error:", err)
+		return false
+	}
+	if len(args) == 0 {
+		return false
+	}
+	name := strings.ToLower(args[0])
+	if name == "exit" || name == "quit" {
+		return true
+	}
+	command, ok := shellCommands[name]
+	if !ok {
+		fmt.Fprintf(s.out, "Error: unknown command %q, type help for the list of commands\n", args[0])
+		return false
+	}
+	args = args[1:]
+	if len(args) < command.minArgs || (command.maxArgs >= 0 && len(args) > command.maxArgs) {
+		fmt.Fprintln(s.out, "Usage:", command.usage)
+		return false
+	}
+
+	server := s.client.server()
+	start := time.Now()
+	err = command.run(s, ctx, args)
+	elapsed := time.Since(start)
+	if current := s.client.server(); current != server {
+		fmt.Fprintf(s.out, "Reconnected to %s as %s is unavailable\n", current, server)
+	}
+	if err != nil {
+		fmt.Fprintln(s.out, "Error:", clientError(err))
+	}
+	if name != "help" && name != "use" && name != "servers" {
+		fmt.Fprintf(s.out, "(%s)\n", elapsed.Round(time.Microsecond))
+	}
+	return false
+}
+
+// key returns the full key of a key relative to the namespace
+func (s *shell) key(key string) string {
+	if s.namespace == "" {
+		return key
+	}
+	return s.namespace + "/" + key
+}
+
+func (s *shell) get(ctx context.Context, args []string) error {
+	var resp *proto.GetKeyResponse
+	err := s.client.call(ctx, func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+		resp, err = proto.NewKeyServiceClient(conn).GetKey(ctx, &proto.GetKeyRequest{Key: s.key(args[0])})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, formatValue(resp.Value))
+	return nil
+}
+
+func (s *shell) set(ctx context.Context, args []string) error {
+	req := &proto.SetKeyRequest{Key: s.key(args[0]), Value: []byte(args[1])}
+	if len(args) > 2 {
+		ttl, err := time.ParseDuration(args[2])
+		if err != nil {
+			return fmt.Errorf("invalid ttl %q: %w", args[2], err)
+		}
+		req.TtlMs = ttl.Milliseconds()
+	}
+	err := s.client.call(ctx, func(ctx context.Context, conn *grpc.ClientConn) error {
+		_, err := proto.NewKeyServiceClient(conn).SetKey(ctx, req)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, "OK")
+	return nil
+}
+
+func (s *shell) del(ctx context.Context, args []string) error {
+	deleted := 0
+	for _, key := range args {
+		var resp *proto.DeleteKeyResponse
+		err := s.client.call(ctx, func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+			resp, err = proto.NewKeyServiceClient(conn).DeleteKey(ctx, &proto.DeleteKeyRequest{Key: s.key(key)})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if resp.Found {
+			deleted++
+		}
+	}
+	fmt.Fprintf(s.out, "Deleted %d of %d keys\n", deleted, len(args))
+	return nil
+}
+
+func (s *shell) scan(ctx context.Context, args []string) error {
+	limit := 100
+	if len(args) > 1 {
+		var err error
+		if limit, err = strconv.Atoi(args[1]); err != nil || limit <= 0 {
+			return fmt.Errorf("invalid limit %q", args[1])
+		}
+	}
+	prefix := s.key("")
+	if len(args) > 0 {
+		prefix = s.key(args[0])
+	}
+	items, err := s.scanKeys(ctx, prefix, limit, false)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+	for _, item := range items {
+		fmt.Fprintf(w, "%s\t%s\n", strings.TrimPrefix(item.Key, s.key("")), formatValue(item.Value))
+	}
+	w.Flush()
+	fmt.Fprintf(s.out, "%d keys\n", len(items))
+	return nil
+}
+
+// scanKeys returns up to limit keys starting with prefix, requesting pages until limit is reached
+func (s *shell) scanKeys(ctx context.Context, prefix string, limit int, keysOnly bool) ([]*proto.KeyValue, error) {
+	var items []*proto.KeyValue
+	req := &proto.ScanKeysRequest{Prefix: prefix, KeysOnly: keysOnly}
+	for len(items) < limit {
+		req.Limit = int32(min(limit-len(items), 100))
+		var resp *proto.ScanKeysResponse
+		err := s.client.call(ctx, func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+			resp, err = proto.NewKeyServiceClient(conn).ScanKeys(ctx, req)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, resp.Items...)
+		if resp.Cursor == "" {
+			break
+		}
+		req.StartAfter = resp.Cursor
+	}
+	return items, nil
+}
+
+func (s *shell) members(ctx context.Context, args []string) error {
+	var state *proto.ClusterState
+	err := s.client.call(ctx, func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+		state, err = proto.NewClusterServiceClient(conn).GetClusterState(ctx, &emptypb.Empty{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(state.Nodes, func(a, b *proto.Node) int { return strings.Compare(a.Id, b.Id) })
+	w := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tSTATUS")
+	for _, node := range state.Nodes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", node.Id, node.Address, node.GetHealth().GetStatus())
+	}
+	return w.Flush()
+}
+
+func (s *shell) use(ctx context.Context, args []string) error {
+	s.namespace = ""
+	if len(args) > 0 {
+		s.namespace = strings.Trim(args[0], "/")
+	}
+	if s.namespace == "" {
+		fmt.Fprintln(s.out, "Using the root namespace")
+	} else {
+		fmt.Fprintf(s.out, "Using namespace %s, keys are prefixed with %s/\n", s.namespace, s.namespace)
+	}
+	return nil
+}
+
+func (s *shell) servers(ctx context.Context, args []string) error {
+	for i, server := range s.client.servers {
+		marker := " "
+		if i == s.client.current {
+			marker = "*"
+		}
+		fmt.Fprintln(s.out, marker, server)
+	}
+	return nil
+}
+
+func (s *shell) help(ctx context.Context, args []string) error {
+	names := make([]string, 0, len(shellCommands))
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	w := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", shellCommands[name].usage, shellCommands[name].help)
+	}
+	return w.Flush()
+}
+
+// complete completes the word before the cursor: the command at the start of the line, or the key
+// after get, set and del. Keys are completed up to the longest prefix the matching keys share.
+func (s *shell) complete(ctx context.Context, line string, pos int) (string, int, bool) {
+	before := line[:pos]
+	start := strings.LastIndexAny(before, " \t") + 1
+	word := before[start:]
+	var candidates []string
+	if strings.TrimSpace(before[:start]) == "" {
+		for name := range shellCommands {
+			if strings.HasPrefix(name, word) {
+				candidates = append(candidates, name+" ")
+			}
+		}
+	} else {
+		command := strings.ToLower(strings.Fields(before)[0])
+		if command != "get" && command != "set" && command != "del" {
+			return "", 0, false
+		}
+		items, err := s.scanKeys(ctx, s.key(word), 50, true)
+		if err != nil {
+			return "", 0, false
+		}
+		for _, item := range items {
+			candidates = append(candidates, strings.TrimPrefix(item.Key, s.key("")))
+		}
+		if len(candidates) == 1 {
+			candidates[0] += " "
+		}
+	}
+	completion := commonPrefix(candidates)
+	if len(completion) <= len(word) {
+		return "", 0, false
+	}
+	return before[:start] + completion + line[pos:], start + len(completion), true
+}
+
+// commonPrefix returns the longest prefix of all strings
+func commonPrefix(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// splitArgs splits a line into words separated by spaces. Words can be quoted with double quotes,
+// which support Go escape sequences, or with single quotes, which are taken literally.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	rest := strings.TrimSpace(line)
+	for rest != "" {
+		var arg string
+		switch rest[0] {
+		case '"':
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, errors.New("unterminated double quote")
+			}
+			arg, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		case '\'':
+			end := strings.IndexByte(rest[1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			arg = rest[1 : end+1]
+			rest = rest[end+2:]
+		default:
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			arg = rest[:end]
+			rest = rest[end:]
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return args, nil
+}
+
+// formatValue returns printable values as they are and quotes all other values
+func formatValue(value []byte) string {
+	if utf8.Valid(value) && strings.IndexFunc(string(value), func(r rune) bool { return !strconv.IsPrint(r) }) < 0 {
+		return string(value)
+	}
+	return strconv.Quote(string(value))
+}
+
+func init() {
+	shellCommands = map[string]shellCommand{
+		"get":     {"get <key>", "Prints the value of a key", 1, 1, (*shell).get},
+		"set":     {"set <key> <value> [ttl]", "Writes a key, expiring after ttl (like 10s or 1h) if given", 2, 3, (*shell).set},
+		"del":     {"del <key>...", "Deletes keys", 1, -1, (*shell).del},
+		"scan":    {"scan [prefix] [limit]", "Lists up to limit (default 100) keys starting with prefix", 0, 2, (*shell).scan},
+		"members": {"members", "Lists the members of the cluster", 0, 0, (*shell).members},
+		"use":     {"use [namespace]", "Makes keys relative to namespace/, or to the root if no namespace is given", 0, 1, (*shell).use},
+		"servers": {"servers", "Lists the nodes the shell connects to, marking the current one", 0, 0, (*shell).servers},
+		"help":    {"help", "Prints the list of commands", 0, 0, (*shell).help},
+		"exit":    {"exit", "Leaves the shell, like quit and Ctrl-D", 0, 0, nil},
+	}
+	rootCmd.AddCommand(shellCmd)
+
+	addClientFlags(shellCmd)
+}
@@ -1,6 +1,10 @@
 package cmd
 
 import (
+	"context"
+	"os/signal"
+	"syscall"
+
 	"github.com/spf13/cobra"
 	"github.com/tdevsin/keyforge/internal/api"
 	"github.com/tdevsin/keyforge/internal/config"
@@ -10,39 +14,152 @@ import (
 // startCmd represents the start command
 var startCmd = &cobra.Command{
 	Use:   "start",
-	Short: "Starts the server at port 8080. If port is in use, it will try the next port",
+	Short: "Starts a node. The node listens on the port of its advertised address unless --listen is passed",
+	Long: `Starts a node.
+
+Settings are resolved in the following order, where later sources take precedence:
+defaults, the config file passed with --config, KEYFORGE_* environment variables
+and command line flags.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		var conf *config.Config
-		env, _ := cmd.Flags().GetString("env")
 		bootstrap, _ := cmd.Flags().GetString("bootstrap")
-		address, _ := cmd.Flags().GetString("address")
-
-		if env == "dev" {
-			conf = config.ReadConfig(config.Dev, address)
-		} else if env == "prod" {
-			conf = config.ReadConfig(config.Prod, address)
-		} else {
-			panic("Invalid environment")
-		}
 
-		err := startup.StartNodeSetupInCluster(conf, bootstrap)
+		settings, err := loadSettings(cmd)
 		if err != nil {
 			panic(err)
 		}
+		applySettingsFlags(cmd, settings)
+		if err := settings.Validate(); err != nil {
+			panic(err)
+		}
 
-		err = api.StartGRPCServer(conf)
+		conf := config.ReadConfig(settings)
+
+		// Cancelled on SIGINT or SIGTERM. A second signal terminates the process immediately
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+
+		err = api.StartGRPCServer(ctx, conf, func() error {
+			return startup.StartNodeSetupInCluster(conf, bootstrap)
+		})
+		conf.Cleanup()
 		if err != nil {
 			panic(err)
 		}
 	},
 }
 
+// applySettingsFlags overrides the settings with the flags explicitly passed on the command line
+func applySettingsFlags(cmd *cobra.Command, settings *config.Settings) {
+	flags := cmd.Flags()
+	if flags.Changed("env") {
+		settings.Environment, _ = flags.GetString("env")
+	}
+	if flags.Changed("address") {
+		settings.Server.AdvertiseAddress, _ = flags.GetString("address")
+	}
+	if flags.Changed("advertise") {
+		settings.Server.AdvertiseAddress, _ = flags.GetString("advertise")
+	}
+	if flags.Changed("listen") {
+		settings.Server.ListenAddress, _ = flags.GetString("listen")
+	}
+	if flags.Changed("internal-advertise") {
+		settings.Internal.AdvertiseAddress, _ = flags.GetString("internal-advertise")
+	}
+	if flags.Changed("internal-listen") {
+		settings.Internal.ListenAddress, _ = flags.GetString("internal-listen")
+	}
+	if flags.Changed("metrics-listen") {
+		settings.Metrics.ListenAddress, _ = flags.GetString("metrics-listen")
+	}
+	if flags.Changed("http-listen") {
+		settings.Gateway.ListenAddress, _ = flags.GetString("http-listen")
+	}
+	if flags.Changed("redis-listen") {
+		settings.Redis.ListenAddress, _ = flags.GetString("redis-listen")
+	}
+	if flags.Changed("memcached-listen") {
+		settings.Memcached.ListenAddress, _ = flags.GetString("memcached-listen")
+	}
+	if flags.Changed("trace-exporter") {
+		settings.Tracing.Exporter, _ = flags.GetString("trace-exporter")
+	}
+	if flags.Changed("trace-endpoint") {
+		settings.Tracing.Endpoint, _ = flags.GetString("trace-endpoint")
+	}
+	if flags.Changed("trace-file") {
+		settings.Tracing.File, _ = flags.GetString("trace-file")
+	}
+	if flags.Changed("data-dir") {
+		settings.DataDir, _ = flags.GetString("data-dir")
+	}
+	if flags.Changed("gossip-interval") {
+		settings.Cluster.GossipInterval, _ = flags.GetDuration("gossip-interval")
+	}
+	if flags.Changed("health-check-interval") {
+		settings.Cluster.HealthCheckInterval, _ = flags.GetDuration("health-check-interval")
+	}
+	if flags.Changed("failure-threshold") {
+		settings.Cluster.FailureThreshold, _ = flags.GetInt("failure-threshold")
+	}
+	if flags.Changed("gossip-fanout") {
+		settings.Cluster.GossipFanout, _ = flags.GetInt("gossip-fanout")
+	}
+	if flags.Changed("drain-timeout") {
+		settings.Server.DrainTimeout, _ = flags.GetDuration("drain-timeout")
+	}
+	if flags.Changed("log-level") {
+		settings.Logging.Level, _ = flags.GetString("log-level")
+	}
+	if flags.Changed("tls-cert") {
+		settings.TLS.CertFile, _ = flags.GetString("tls-cert")
+	}
+	if flags.Changed("tls-key") {
+		settings.TLS.KeyFile, _ = flags.GetString("tls-key")
+	}
+	if flags.Changed("tls-ca") {
+		settings.TLS.CAFile, _ = flags.GetString("tls-ca")
+	}
+	if flags.Changed("tls-client-auth") {
+		settings.TLS.ClientAuth, _ = flags.GetString("tls-client-auth")
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(startCmd)
 
-	startCmd.PersistentFlags().StringP("env", "e", "dev", "Specifies the environment in which the server will run. Accepted values: dev, prod")
-	startCmd.PersistentFlags().StringP("bootstrap", "b", "", "Specifies the address of the bootstrap node to join the cluster. Format: <host>:<port>")
-	startCmd.PersistentFlags().StringP("address", "a", "", "Specifies the address of this node, used by other nodes to connect to it. This can be a DNS name or an IP address with a port. Format: <host>:<port>")
+	defaults := config.DefaultSettings()
+
+	startCmd.PersistentFlags().StringP("env", "e", defaults.Environment, "Specifies the environment in which the server will run. Accepted values: dev, prod")
+	startCmd.PersistentFlags().StringP("bootstrap", "b", "", "Specifies the address of the bootstrap node to join the cluster. Use its internal address if it has one. Format: <host>:<port>")
+	startCmd.PersistentFlags().StringP("advertise", "a", "", "Specifies the address of this node, used by other nodes to connect to it. This can be a DNS name or an IP address with a port. Format: <host>:<port>")
+	startCmd.PersistentFlags().String("address", "", "Alias of --advertise")
+	startCmd.PersistentFlags().StringP("listen", "l", "", "Specifies the address the server binds to. Defaults to all interfaces on the advertised port. Format: [<host>]:<port>")
+	startCmd.PersistentFlags().String("internal-advertise", "", "Specifies the address other nodes use for cluster traffic, served on a separate port. Defaults to the advertised address. Format: <host>:<port>")
+	startCmd.PersistentFlags().String("internal-listen", "", "Specifies the address the internal server binds to. Defaults to all interfaces on the advertised internal port. Format: [<host>]:<port>")
+	startCmd.PersistentFlags().String("metrics-listen", "", "Specifies the address of the HTTP server exposing Prometheus metrics on /metrics. Disabled if empty. Format: [<host>]:<port>")
+	startCmd.PersistentFlags().String("memcached-listen", "", "Specifies the address of a listener serving keys over the memcached text and binary protocols. Disabled if empty. Format: [<host>]:<port>")
+	startCmd.PersistentFlags().String("http-listen", "", "Specifies the address of the HTTP/JSON gateway serving keys and the cluster state. Disabled if empty. Format: [<host>]:<port>")
+	startCmd.PersistentFlags().String("redis-listen", "", "Specifies the address of a listener serving keys over the Redis protocol (RESP2 and RESP3). Disabled if empty. Format: [<host>]:<port>")
+	startCmd.PersistentFlags().String("trace-exporter", "none", "Specifies where OpenTelemetry spans are exported to. Accepted values: none, otlp, stdout")
+	startCmd.PersistentFlags().String("trace-endpoint", "localhost:4317", "Specifies the address of the OTLP gRPC collector used by the otlp exporter")
+	startCmd.PersistentFlags().String("trace-file", "", "Specifies a file the stdout exporter appends spans to instead of stdout")
+	startCmd.PersistentFlags().StringP("data-dir", "d", defaults.DataDir, "Directory containing all files of this node. Every node on the same host needs its own directory")
+	addConfigFlag(startCmd)
+	startCmd.PersistentFlags().Duration("gossip-interval", defaults.Cluster.GossipInterval, "Duration between periodic gossip rounds")
+	startCmd.PersistentFlags().Duration("health-check-interval", defaults.Cluster.HealthCheckInterval, "Duration between periodic health checks of other nodes")
+	startCmd.PersistentFlags().Int("failure-threshold", defaults.Cluster.FailureThreshold, "Number of failed health checks after which a suspected node is marked as permanently failed")
+	startCmd.PersistentFlags().Int("gossip-fanout", defaults.Cluster.GossipFanout, "Number of nodes contacted in every gossip round and health check")
+	startCmd.PersistentFlags().Duration("drain-timeout", defaults.Server.DrainTimeout, "Maximum time to wait for in-flight requests to finish during shutdown")
+	startCmd.PersistentFlags().String("log-level", "", "Minimum level of logged messages. Accepted values: debug, info, warn, error")
+	startCmd.PersistentFlags().String("tls-cert", "", "PEM encoded certificate of this node. Enables TLS for clients and mutual TLS between nodes")
+	startCmd.PersistentFlags().String("tls-key", "", "PEM encoded private key of the TLS certificate")
+	startCmd.PersistentFlags().String("tls-ca", "", "PEM encoded CA used to verify other nodes and client certificates")
+	startCmd.PersistentFlags().String("tls-client-auth", defaults.TLS.ClientAuth, "Client certificate policy. Accepted values: none, optional, require. Only require enforces mutual TLS between nodes")
 
-	startCmd.MarkPersistentFlagRequired("address")
+	startCmd.PersistentFlags().MarkDeprecated("address", "use --advertise instead")
 }
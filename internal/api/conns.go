@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/auth"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/requestid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// listenTLS listens on address for a client facing server, over TLS if it is enabled
+func listenTLS(conf *config.Config, address string) (net.Listener, error) {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		conf.Logger.Error("Failed to listen", zap.String("address", address), zap.Error(err))
+		return nil, err
+	}
+	if conf.Certificates != nil {
+		tlsConfig, err := conf.Certificates.ServerConfig(conf.Settings.TLS.ClientAuth)
+		if err != nil {
+			lis.Close()
+			return nil, err
+		}
+		lis = tls.NewListener(lis, tlsConfig)
+	}
+	return lis, nil
+}
+
+// connServer accepts the connections of a protocol listener and keeps track of them, so they can
+// be drained on shutdown
+type connServer struct {
+	name   string
+	conf   *config.Config
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newConnServer(name string, conf *config.Config) *connServer {
+	return &connServer{name: name, conf: conf, conns: map[net.Conn]struct{}{}}
+}
+
+// serve calls handle for every accepted connection until the listener is closed. Connections are
+// closed once handle returns.
+func (s *connServer) serve(lis net.Listener, handle func(conn net.Conn)) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.conf.Logger.Error(s.name+" failed", zap.Error(err))
+			}
+			return
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			handle(conn)
+		}()
+	}
+}
+
+// track registers a connection, or reports false once the server is closed
+func (s *connServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *connServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+	conn.Close()
+}
+
+// shutdown lets connections finish the command they are running and closes them. Connections
+// still running a command after the timeout are closed forcefully.
+func (s *connServer) shutdown(timeout time.Duration) {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		// Waiting for the next command fails immediately, running commands still send their reply
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		s.conf.Logger.Warn("Drain timeout exceeded, closing "+s.name+" connections", zap.Duration("timeout", timeout))
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+	}
+}
+
+// connContext returns the context of a command received on conn, carrying a new request ID, the
+// peer and the principal of the connection. The token is forwarded with requests proxied to other nodes.
+func connContext(conn net.Conn, principal *auth.Principal, token string) context.Context {
+	md := metadata.MD{}
+	if token != "" {
+		md.Set("authorization", "Bearer "+token)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: conn.RemoteAddr()})
+	if principal != nil {
+		ctx = auth.NewContext(ctx, principal)
+	}
+	return requestid.NewContext(ctx, requestid.New())
+}
@@ -1,11 +1,22 @@
 package controller
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/tdevsin/keyforge/internal/backup"
 	"github.com/tdevsin/keyforge/internal/cluster"
 	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
 	"github.com/tdevsin/keyforge/internal/logger"
 	"github.com/tdevsin/keyforge/internal/proto"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func GetClusterInfo(c *config.Config) (*proto.ClusterState, error) {
@@ -20,20 +31,165 @@ func SetClusterInfo(c *config.Config, state *proto.ClusterState) error {
 	return nil
 }
 
+// ExchangeDigest compares the digest sent by a gossiping node with the local state
+func ExchangeDigest(c *config.Config, digest *proto.GossipDigest) (*proto.GossipDigestAck, error) {
+	var ack proto.GossipDigestAck
+	c.ClusterInfo.CompareDigest(digest, &ack)
+	return &ack, nil
+}
+
+// PushDelta merges the node entries sent by a gossiping node
+func PushDelta(c *config.Config, delta *proto.GossipDelta) error {
+	c.Logger.Debug("Merging gossip delta", zap.Int("nodes", len(delta.Nodes)))
+	c.ClusterInfo.MergeNodes(cluster.MapProtoToNodes(delta.Nodes), int(delta.Version))
+	return nil
+}
+
+// GetClusterTiming returns the gossip and failure detection parameters of this node
+func GetClusterTiming(c *config.Config) (*proto.ClusterTiming, error) {
+	return mapTimingToProto(c.ClusterInfo.GetTiming()), nil
+}
+
+// UpdateClusterTiming applies the set fields of the request on top of the current timing of this node
+func UpdateClusterTiming(c *config.Config, req *proto.ClusterTiming) (*proto.ClusterTiming, error) {
+	timing := c.ClusterInfo.GetTiming()
+	if req.GossipInterval != nil {
+		timing.GossipInterval = req.GossipInterval.AsDuration()
+	}
+	if req.HealthCheckInterval != nil {
+		timing.HealthCheckInterval = req.HealthCheckInterval.AsDuration()
+	}
+	if req.FailureThreshold != 0 {
+		timing.FailureThreshold = int(req.FailureThreshold)
+	}
+	if req.GossipFanout != 0 {
+		timing.GossipN = int(req.GossipFanout)
+	}
+	if req.RpcTimeout != nil {
+		timing.RPCTimeout = req.RpcTimeout.AsDuration()
+	}
+
+	if err := c.ClusterInfo.SetTiming(timing); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	c.Logger.Info("Cluster timing updated",
+		zap.Duration("gossipInterval", timing.GossipInterval),
+		zap.Duration("healthCheckInterval", timing.HealthCheckInterval),
+		zap.Int("failureThreshold", timing.FailureThreshold),
+		zap.Int("gossipFanout", timing.GossipN),
+		zap.Duration("rpcTimeout", timing.RPCTimeout),
+	)
+	return mapTimingToProto(timing), nil
+}
+
+// GetRing returns the ranges of the hash ring of this node and the nodes owning them
+func GetRing(c *config.Config) (*proto.Ring, error) {
+	nodes := c.HashRing.GetNodes()
+	ownership := c.HashRing.Ownership()
+	ring := &proto.Ring{Ranges: make([]*proto.RingRange, 0, len(nodes))}
+	for i, node := range nodes {
+		previous := nodes[(i+len(nodes)-1)%len(nodes)]
+		ring.Ranges = append(ring.Ranges, &proto.RingRange{
+			NodeId:    node.ID,
+			Address:   node.Address,
+			Start:     uint32(previous.Position),
+			End:       uint32(node.Position),
+			Ownership: ownership[node.ID],
+		})
+	}
+	return ring, nil
+}
+
+// RemoveNode removes a failed node from the cluster. The removal reaches the other nodes via gossip.
+func RemoveNode(c *config.Config, req *proto.RemoveNodeRequest) (*proto.Node, error) {
+	node, err := c.ClusterInfo.Remove(req.Id)
+	switch {
+	case errors.Is(err, cluster.ErrNodeNotFound):
+		return nil, constants.StatusErrNodeNotFound
+	case err != nil:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return cluster.MapNodeToProto(node), nil
+}
+
+// DecommissionNode makes a node leave the cluster. Requests for other nodes are forwarded to them,
+// so that the node announces the change itself and stops owning keys.
+func DecommissionNode(ctx context.Context, c *config.Config, req *proto.DecommissionNodeRequest) (*proto.Node, error) {
+	if req.Id == "" || req.Id == c.NodeInfo.ID {
+		c.Logger.Info("Decommissioning node, announcing node as leaving")
+		if err := c.ClusterInfo.Leave(); err != nil {
+			c.Logger.Warn("Failed to announce leave to some nodes", zap.Error(err))
+		}
+		// Requests this node receives from now on are forwarded to the new owners of their keys
+		c.HashRing.RemoveNode(c.NodeInfo.ID)
+		node, _ := c.ClusterInfo.GetNode(c.NodeInfo.ID)
+		return cluster.MapNodeToProto(node), nil
+	}
+
+	node, ok := c.ClusterInfo.GetNode(req.Id)
+	if !ok {
+		return nil, constants.StatusErrNodeNotFound
+	}
+	conn, err := c.ConnectionPool.GetConnection(node.PeerAddress())
+	if err != nil {
+		return nil, err
+	}
+	return proto.NewClusterServiceClient(conn).DecommissionNode(outgoingContext(ctx), req)
+}
+
+// Backup streams checkpoints of the data and metadata databases of this node, followed by a manifest
+// with the cluster state and the checksums of every file. The checkpoints are removed afterwards.
+func Backup(c *config.Config, send func(*proto.BackupChunk) error) error {
+	dir, err := os.MkdirTemp(c.RootDir, ".backup-")
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	createdAt := timestamppb.Now()
+	if err := c.Db.Checkpoint(filepath.Join(dir, backup.DataDir)); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if err := c.MetadataDb.Checkpoint(filepath.Join(dir, backup.MetadataDir)); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	var state proto.ClusterState
+	c.ClusterInfo.GetClusterInfo().MapClusterStateToProto(&state)
+
+	files, err := backup.Send(dir, send)
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			err = status.Error(codes.Internal, err.Error())
+		}
+		return err
+	}
+	c.Logger.Info("Backup sent", zap.Int("files", len(files)))
+	return send(&proto.BackupChunk{Manifest: &proto.BackupManifest{
+		NodeId:    c.NodeInfo.ID,
+		Address:   c.NodeInfo.Address,
+		CreatedAt: createdAt,
+		Cluster:   &state,
+		Files:     files,
+	}})
+}
+
+func mapTimingToProto(timing cluster.Timing) *proto.ClusterTiming {
+	return &proto.ClusterTiming{
+		GossipInterval:      durationpb.New(timing.GossipInterval),
+		HealthCheckInterval: durationpb.New(timing.HealthCheckInterval),
+		FailureThreshold:    int32(timing.FailureThreshold),
+		GossipFanout:        int32(timing.GossipN),
+		RpcTimeout:          durationpb.New(timing.RPCTimeout),
+	}
+}
+
 func MapProtoToClusterInfo(state *proto.ClusterState) *cluster.ClusterInfo {
 	ci := cluster.NewCluster(&logger.Logger{}, "", 2)
 	ci.Version = int(state.Version)
 	ci.LastUpdated = state.LastUpdated.AsTime()
 	ci.Nodes = make(map[string]cluster.Node)
 	for _, node := range state.Nodes {
-		ci.Nodes[node.Id] = cluster.Node{
-			ID:      node.Id,
-			Address: node.Address,
-			Health: cluster.Health{
-				LastChecked: node.Health.LastUpdated.AsTime(),
-				Status:      cluster.Status(node.Health.Status),
-			},
-		}
+		ci.Nodes[node.Id] = cluster.MapProtoToNode(node)
 	}
 	return ci
 }
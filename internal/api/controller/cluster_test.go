@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/tdevsin/keyforge/internal/backup"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// newClusterConfig returns a config of node1 in a cluster of the given nodes
+func newClusterConfig(nodes ...cluster.Node) *config.Config {
+	l := new(logger.MockLogging)
+	l.On("Info", mock.Anything, mock.Anything).Return()
+	l.On("Warn", mock.Anything, mock.Anything).Return()
+	ci := cluster.NewCluster(l, "node1", 2)
+	ring := cluster.NewHashRing()
+	ci.RegisterObserver(ring)
+	for _, node := range nodes {
+		ci.AddOrUpdateNode(node)
+	}
+	self, _ := ci.GetNode("node1")
+	return &config.Config{Logger: l, ClusterInfo: ci, HashRing: ring, NodeInfo: &self}
+}
+
+func TestGetRing(t *testing.T) {
+	c := newClusterConfig(cluster.Node{ID: "node1", Address: "a:1"}, cluster.Node{ID: "node2", Address: "a:2"})
+
+	ring, err := GetRing(c)
+
+	assert.NoError(t, err)
+	assert.Len(t, ring.Ranges, 2)
+	// Every range starts at the position of the previous node, the first one wraps around
+	first, second := ring.Ranges[0], ring.Ranges[1]
+	assert.Equal(t, second.End, first.Start)
+	assert.Equal(t, first.End, second.Start)
+	assert.Less(t, first.End, second.End)
+	assert.InDelta(t, 1, first.Ownership+second.Ownership, 0.000001)
+	assert.Equal(t, uint32(cluster.CalculateNodePosition(first.NodeId)), first.End)
+}
+
+func TestRemoveNode(t *testing.T) {
+	t.Run("Failed node is removed", func(t *testing.T) {
+		c := newClusterConfig(cluster.Node{ID: "node1"}, cluster.Node{ID: "node2", Health: cluster.Health{Status: cluster.PermanentFailed}})
+
+		node, err := RemoveNode(c, &proto.RemoveNodeRequest{Id: "node2"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, proto.Status_REMOVED, node.Health.Status)
+		assert.Len(t, c.HashRing.GetNodes(), 1)
+	})
+
+	t.Run("Unknown node", func(t *testing.T) {
+		c := newClusterConfig(cluster.Node{ID: "node1"})
+
+		_, err := RemoveNode(c, &proto.RemoveNodeRequest{Id: "node2"})
+
+		assert.Equal(t, constants.StatusErrNodeNotFound, err)
+	})
+
+	t.Run("Healthy node", func(t *testing.T) {
+		c := newClusterConfig(cluster.Node{ID: "node1"}, cluster.Node{ID: "node2"})
+
+		_, err := RemoveNode(c, &proto.RemoveNodeRequest{Id: "node2"})
+
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+}
+
+func TestDecommissionNode(t *testing.T) {
+	t.Run("Node decommissions itself", func(t *testing.T) {
+		c := newClusterConfig(cluster.Node{ID: "node1"}, cluster.Node{ID: "node2", Health: cluster.Health{Status: cluster.Leaving}})
+
+		node, err := DecommissionNode(context.TODO(), c, &proto.DecommissionNodeRequest{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, proto.Status_LEAVING, node.Health.Status)
+		_, ok := c.HashRing.GetNode("node1")
+		assert.False(t, ok)
+	})
+
+	t.Run("Unknown node", func(t *testing.T) {
+		c := newClusterConfig(cluster.Node{ID: "node1"})
+
+		_, err := DecommissionNode(context.TODO(), c, &proto.DecommissionNodeRequest{Id: "node2"})
+
+		assert.Equal(t, constants.StatusErrNodeNotFound, err)
+	})
+}
+
+func TestBackup(t *testing.T) {
+	c := newClusterConfig(cluster.Node{ID: "node1", Address: "a:1"}, cluster.Node{ID: "node2"})
+	c.RootDir = t.TempDir()
+	l := logger.GetLogger(false, "test")
+	c.Db = storage.GetDatabaseInstance(l, filepath.Join(c.RootDir, "data"))
+	defer c.Db.Close()
+	c.MetadataDb = storage.GetDatabaseInstance(l, filepath.Join(c.RootDir, "metadata"))
+	defer c.MetadataDb.Close()
+	assert.NoError(t, c.Db.WriteKey([]byte("key"), []byte("value")))
+	assert.NoError(t, c.MetadataDb.WriteKey([]byte("node_id"), []byte("node1")))
+
+	var chunks []*proto.BackupChunk
+	err := Backup(c, func(chunk *proto.BackupChunk) error {
+		chunks = append(chunks, protobuf.Clone(chunk).(*proto.BackupChunk))
+		return nil
+	})
+
+	assert.NoError(t, err)
+	manifest := chunks[len(chunks)-1].GetManifest()
+	assert.Equal(t, "node1", manifest.NodeId)
+	assert.Equal(t, "a:1", manifest.Address)
+	assert.Len(t, manifest.Cluster.Nodes, 2)
+	entries, err := os.ReadDir(c.RootDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2, "the checkpoints are removed")
+
+	// The streamed files are a copy of the databases
+	path := filepath.Join(t.TempDir(), "backup")
+	_, err = backup.Receive(path, func() (*proto.BackupChunk, error) {
+		chunk := chunks[0]
+		chunks = chunks[1:]
+		return chunk, nil
+	})
+	assert.NoError(t, err)
+	db := storage.GetDatabaseInstance(l, filepath.Join(path, backup.DataDir))
+	defer db.Close()
+	value, err := db.ReadKey([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
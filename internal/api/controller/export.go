@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// exportBatchSize is the approximate size of the keys and values sent in one message of an export
+const exportBatchSize = 1 << 20
+
+// ExportKeys streams the keys this node owns in batches. They are read from a snapshot of the
+// database taken when the export starts. Keys stored here that another node owns since the ring
+// changed are skipped, as the cluster does not serve them either.
+func ExportKeys(c *config.Config, req *proto.ExportKeysRequest, send func(*proto.KeyEntryBatch) error) error {
+	if storage.IsInternalKey([]byte(req.GetPrefix())) {
+		return constants.StatusErrInvalidKey
+	}
+	batch := &proto.KeyEntryBatch{}
+	size, exported, skipped := 0, 0, 0
+	err := c.Db.ForEach([]byte(req.GetPrefix()), func(key []byte, entry *storage.Entry) error {
+		if c.HashRing.GetResponsibleNode(string(key)) != c.NodeInfo.ID {
+			skipped++
+			return nil
+		}
+		batch.Entries = append(batch.Entries, &proto.KeyEntry{
+			Key:       string(key),
+			Value:     entry.Value,
+			ExpiresAt: entry.ExpiresAt,
+			Flags:     entry.Flags,
+			Version:   entry.Timestamp,
+		})
+		exported++
+		size += len(key) + len(entry.Value)
+		if size < exportBatchSize {
+			return nil
+		}
+		size = 0
+		err := send(batch)
+		batch = &proto.KeyEntryBatch{}
+		return err
+	})
+	if err == nil && len(batch.Entries) > 0 {
+		err = send(batch)
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			c.Logger.Error("Failed to export keys", zap.Error(err))
+			err = constants.StatusErrInternal
+		}
+		return err
+	}
+	c.Logger.Info("Exported keys", zap.Int("keys", exported), zap.Int("skipped", skipped))
+	return nil
+}
+
+// ImportKeys writes the keys of every received batch. Keys this node owns are written in a single
+// batch, the others are forwarded to their owner, so imports are correct even if the ring changed
+// since the client routed them.
+func ImportKeys(ctx context.Context, c *config.Config, recv func() (*proto.KeyEntryBatch, error)) (*proto.ImportKeysResponse, error) {
+	resp := &proto.ImportKeysResponse{}
+	for {
+		batch, err := recv()
+		if errors.Is(err, io.EOF) {
+			c.Logger.Info("Imported keys", zap.Int64("keys", resp.Imported), zap.Int64("forwarded", resp.Forwarded), zap.Int64("expired", resp.Expired))
+			return resp, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		var keys [][]byte
+		var entries []*storage.Entry
+		for _, e := range batch.Entries {
+			if !validKey(e.Key) {
+				return nil, status.Errorf(codes.InvalidArgument, "Key %q is invalid", e.Key)
+			}
+			entry := &storage.Entry{Value: e.Value, ExpiresAt: e.ExpiresAt, Flags: e.Flags}
+			if entry.Expired(now) {
+				resp.Expired++
+				continue
+			}
+			owner := c.HashRing.GetResponsibleNode(e.Key)
+			if owner == c.NodeInfo.ID {
+				keys = append(keys, []byte(e.Key))
+				entries = append(entries, entry)
+				continue
+			}
+			addr, err := ownerAddress(c, owner)
+			if err != nil {
+				return nil, err
+			}
+			_, err = proxySetRequest(ctx, c, addr, &proto.SetKeyRequest{
+				Key:   e.Key,
+				Value: e.Value,
+				TtlMs: entry.TTL(now).Milliseconds(),
+				Flags: e.Flags,
+			})
+			countProxy("import", err)
+			if err != nil {
+				return nil, err
+			}
+			resp.Forwarded++
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		if err := c.Db.WriteBatch(keys, entries); err != nil {
+			c.Logger.Error("Failed to import keys", zap.Error(err))
+			return nil, constants.StatusErrInternal
+		}
+		resp.Imported += int64(len(keys))
+	}
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"google.golang.org/grpc"
+)
+
+// ownedKeys returns keys of the form k<n> owned by node1 and by node2 in a ring of both
+func ownedKeys(ring cluster.ConsistentHashRing) (node1, node2 []string) {
+	for i := 0; len(node1) < 2 || len(node2) < 2; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if ring.GetResponsibleNode(key) == "node1" {
+			node1 = append(node1, key)
+		} else {
+			node2 = append(node2, key)
+		}
+	}
+	return node1[:2], node2[:2]
+}
+
+func TestExportKeys(t *testing.T) {
+	c := newClusterConfig(cluster.Node{ID: "node1"}, cluster.Node{ID: "node2"})
+	mine, theirs := ownedKeys(c.HashRing)
+	mockDb := new(storage.MockDatabase)
+	mockDb.On("ForEach", []byte("k")).Return([]storage.KeyValue{
+		{Key: []byte(mine[0]), Value: []byte("a")},
+		{Key: []byte(theirs[0]), Value: []byte("b")},
+		{Key: []byte(mine[1]), Value: []byte("c")},
+	}, nil)
+	c.Db = mockDb
+
+	t.Run("Exports the keys the node owns", func(t *testing.T) {
+		var batches []*proto.KeyEntryBatch
+		err := ExportKeys(c, &proto.ExportKeysRequest{Prefix: "k"}, func(batch *proto.KeyEntryBatch) error {
+			batches = append(batches, batch)
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Len(t, batches, 1)
+		assert.Len(t, batches[0].Entries, 2)
+		assert.Equal(t, mine[0], batches[0].Entries[0].Key)
+		assert.Equal(t, []byte("c"), batches[0].Entries[1].Value)
+	})
+
+	t.Run("Rejects internal prefixes", func(t *testing.T) {
+		err := ExportKeys(c, &proto.ExportKeysRequest{Prefix: "\x00"}, nil)
+
+		assert.Equal(t, constants.StatusErrInvalidKey, err)
+	})
+}
+
+// peerImportService records the keys written to another node
+type peerImportService struct {
+	proto.UnimplementedKeyServiceServer
+	mu   sync.Mutex
+	sets []*proto.SetKeyRequest
+}
+
+func (p *peerImportService) SetKey(ctx context.Context, req *proto.SetKeyRequest) (*proto.SetKeyResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sets = append(p.sets, req)
+	return &proto.SetKeyResponse{Key: req.Key}, nil
+}
+
+// batches returns a receive function replaying batches
+func batches(b ...*proto.KeyEntryBatch) func() (*proto.KeyEntryBatch, error) {
+	return func() (*proto.KeyEntryBatch, error) {
+		if len(b) == 0 {
+			return nil, io.EOF
+		}
+		batch := b[0]
+		b = b[1:]
+		return batch, nil
+	}
+}
+
+func TestImportKeys(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	peer := &peerImportService{}
+	proto.RegisterKeyServiceServer(server, peer)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	c := newClusterConfig(cluster.Node{ID: "node1"}, cluster.Node{ID: "node2", Address: lis.Addr().String()})
+	c.ConnectionPool = cluster.NewConnectionPool()
+	defer c.ConnectionPool.Close()
+	mine, theirs := ownedKeys(c.HashRing)
+
+	t.Run("Writes owned keys and forwards the others", func(t *testing.T) {
+		mockDb := new(storage.MockDatabase)
+		mockDb.On("WriteBatch", [][]byte{[]byte(mine[0])}, mock.Anything).Return(nil)
+		mockDb.On("WriteBatch", [][]byte{[]byte(mine[1])}, mock.Anything).Return(nil)
+		c.Db = mockDb
+		expiresAt := time.Now().Add(time.Hour).UnixMilli()
+
+		resp, err := ImportKeys(context.TODO(), c, batches(
+			&proto.KeyEntryBatch{Entries: []*proto.KeyEntry{
+				{Key: mine[0], Value: []byte("a"), Flags: 3},
+				{Key: theirs[0], Value: []byte("b"), ExpiresAt: expiresAt},
+				{Key: mine[1], Value: []byte("c"), ExpiresAt: 1},
+			}},
+			&proto.KeyEntryBatch{Entries: []*proto.KeyEntry{{Key: mine[1], Value: []byte("d"), Version: 42}}},
+		))
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), resp.Imported)
+		assert.Equal(t, int64(1), resp.Forwarded)
+		assert.Equal(t, int64(1), resp.Expired)
+		mockDb.AssertExpectations(t)
+		entries := mockDb.Calls[0].Arguments.Get(1).([]*storage.Entry)
+		assert.Equal(t, &storage.Entry{Value: []byte("a"), Flags: 3}, entries[0])
+		entries = mockDb.Calls[1].Arguments.Get(1).([]*storage.Entry)
+		assert.Zero(t, entries[0].Timestamp, "imported keys get a new version")
+		assert.Len(t, peer.sets, 1)
+		assert.Equal(t, theirs[0], peer.sets[0].Key)
+		assert.InDelta(t, time.Hour.Milliseconds(), peer.sets[0].TtlMs, float64(time.Minute.Milliseconds()))
+	})
+
+	t.Run("Rejects invalid keys", func(t *testing.T) {
+		_, err := ImportKeys(context.TODO(), c, batches(&proto.KeyEntryBatch{Entries: []*proto.KeyEntry{{Key: "\x00internal"}}}))
+
+		assert.ErrorContains(t, err, "is invalid")
+	})
+}
@@ -2,26 +2,53 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/cockroachdb/pebble"
+	"github.com/tdevsin/keyforge/internal/auth"
 	"github.com/tdevsin/keyforge/internal/config"
 	"github.com/tdevsin/keyforge/internal/constants"
 	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/requestid"
+	"github.com/tdevsin/keyforge/internal/storage"
 	"github.com/tdevsin/keyforge/internal/utils"
 	"go.uber.org/zap"
 )
 
+// validKey reports whether clients may use a key. Keys starting with a zero byte are reserved for the database.
+func validKey(key string) bool {
+	return !utils.IsEmpty(key) && !storage.IsInternalKey([]byte(key))
+}
+
 func SetKey(ctx context.Context, c *config.Config, r *proto.SetKeyRequest) (*proto.SetKeyResponse, error) {
-	if utils.IsEmpty(r.GetKey()) {
+	if !validKey(r.GetKey()) {
 		return nil, constants.StatusErrInvalidKey
 	}
 	if r.GetValue() == nil || len(r.GetKey()) == 0 {
 		return nil, constants.StatusErrInvalidValue
 	}
+	if r.GetTtlMs() < 0 {
+		return nil, constants.StatusErrInvalidTTL
+	}
+	if err := authorize(ctx, c, r.GetKey(), auth.Write); err != nil {
+		return nil, err
+	}
 	responsibleNode := c.HashRing.GetResponsibleNode(r.GetKey())
 
 	if c.NodeInfo.ID == responsibleNode {
-		err := c.Db.WriteKey([]byte(r.GetKey()), r.GetValue())
+		var err error
+		if r.GetTtlMs() == 0 && r.GetCondition() == proto.SetKeyRequest_ALWAYS && r.GetFlags() == 0 {
+			err = storage.WriteKey(ctx, c.Db, []byte(r.GetKey()), r.GetValue())
+		} else {
+			err = updateKey(ctx, c, r)
+		}
+		if errors.Is(err, constants.StatusErrConditionFailed) || errors.Is(err, constants.StatusErrKeyNotFound) {
+			return nil, err
+		}
 		if err != nil {
 			c.Logger.Error("Some error occurred while writing key", zap.Error(err))
 			return nil, constants.StatusErrInternal
@@ -32,18 +59,61 @@ func SetKey(ctx context.Context, c *config.Config, r *proto.SetKeyRequest) (*pro
 			Value: r.GetValue(),
 		}, nil
 	} else {
-		return proxySetRequest(ctx, c, c.HashRing.GetNode(responsibleNode).Address, r)
+		addr, err := ownerAddress(c, responsibleNode)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := proxySetRequest(ctx, c, addr, r)
+		countProxy("set", err)
+		return resp, err
+	}
+}
+
+// updateKey writes a key with an expiry, flags or condition. The condition is checked and the key
+// written atomically, so concurrent writes can not both create a key.
+func updateKey(ctx context.Context, c *config.Config, r *proto.SetKeyRequest) error {
+	_, err := storage.Update(ctx, c.Db, []byte(r.GetKey()), func(current *storage.Entry) (*storage.Entry, error) {
+		switch r.GetCondition() {
+		case proto.SetKeyRequest_IF_ABSENT:
+			if current != nil {
+				return nil, constants.StatusErrConditionFailed
+			}
+		case proto.SetKeyRequest_IF_PRESENT:
+			if current == nil {
+				return nil, constants.StatusErrConditionFailed
+			}
+		case proto.SetKeyRequest_IF_VERSION:
+			if current == nil {
+				return nil, constants.StatusErrKeyNotFound
+			}
+			if current.Timestamp != r.GetVersion() {
+				return nil, constants.StatusErrConditionFailed
+			}
+		}
+		return &storage.Entry{Value: r.GetValue(), ExpiresAt: expiresAt(r.GetTtlMs()), Flags: r.GetFlags()}, nil
+	})
+	return err
+}
+
+// expiresAt returns the expiry of a key written now with a TTL in milliseconds, zero if it never expires
+func expiresAt(ttlMs int64) int64 {
+	if ttlMs == 0 {
+		return 0
 	}
+	return time.Now().Add(time.Duration(ttlMs) * time.Millisecond).UnixMilli()
 }
 
 func GetKey(ctx context.Context, c *config.Config, r *proto.GetKeyRequest) (*proto.GetKeyResponse, error) {
-	if utils.IsEmpty(r.GetKey()) {
+	if !validKey(r.GetKey()) {
 		return nil, constants.StatusErrInvalidKey
 	}
+	if err := authorize(ctx, c, r.GetKey(), auth.Read); err != nil {
+		return nil, err
+	}
 	responsibleNode := c.HashRing.GetResponsibleNode(r.GetKey())
 	if c.NodeInfo.ID == responsibleNode {
 		// Get key from db
-		v, err := c.Db.ReadKey([]byte(r.GetKey()))
+		entry, err := storage.ReadEntry(ctx, c.Db, []byte(r.GetKey()))
 		if err != nil {
 			if err == pebble.ErrNotFound {
 				return nil, constants.StatusErrKeyNotFound
@@ -52,31 +122,276 @@ func GetKey(ctx context.Context, c *config.Config, r *proto.GetKeyRequest) (*pro
 			}
 		}
 		return &proto.GetKeyResponse{
-			Key:   r.GetKey(),
-			Value: v,
+			Key:     r.GetKey(),
+			Value:   entry.Value,
+			Version: entry.Timestamp,
+			Flags:   entry.Flags,
 		}, nil
 	} else {
-		return proxyGetRequest(ctx, c, c.HashRing.GetNode(responsibleNode).Address, r)
+		addr, err := ownerAddress(c, responsibleNode)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := proxyGetRequest(ctx, c, addr, r)
+		countProxy("get", err)
+		return resp, err
 	}
 }
 
 func DeleteKey(ctx context.Context, c *config.Config, r *proto.DeleteKeyRequest) (*proto.DeleteKeyResponse, error) {
-	if utils.IsEmpty(r.GetKey()) {
+	if !validKey(r.GetKey()) {
 		return nil, constants.StatusErrInvalidKey
 	}
+	if err := authorize(ctx, c, r.GetKey(), auth.Delete); err != nil {
+		return nil, err
+	}
 	responsibleNode := c.HashRing.GetResponsibleNode(r.GetKey())
 	if c.NodeInfo.ID == responsibleNode {
-		err := c.Db.DeleteKey([]byte(r.GetKey()))
+		found, err := storage.DeleteKey(ctx, c.Db, []byte(r.GetKey()))
 		if err != nil {
 			return nil, constants.StatusErrInternal
 		}
 		return &proto.DeleteKeyResponse{
-			Key: r.GetKey(),
+			Key:   r.GetKey(),
+			Found: found,
 		}, nil
 
 	} else {
-		return proxyDeleteRequest(ctx, c, c.HashRing.GetNode(responsibleNode).Address, r)
+		addr, err := ownerAddress(c, responsibleNode)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := proxyDeleteRequest(ctx, c, addr, r)
+		countProxy("delete", err)
+		return resp, err
+	}
+}
+
+// IncrementKey adds to or subtracts from a counter stored as decimal number. The expiry and flags
+// of existing counters are kept.
+func IncrementKey(ctx context.Context, c *config.Config, r *proto.IncrementKeyRequest) (*proto.IncrementKeyResponse, error) {
+	if !validKey(r.GetKey()) {
+		return nil, constants.StatusErrInvalidKey
+	}
+	if r.GetTtlMs() < 0 {
+		return nil, constants.StatusErrInvalidTTL
+	}
+	if err := authorize(ctx, c, r.GetKey(), auth.Write); err != nil {
+		return nil, err
+	}
+	responsibleNode := c.HashRing.GetResponsibleNode(r.GetKey())
+	if c.NodeInfo.ID != responsibleNode {
+		addr, err := ownerAddress(c, responsibleNode)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := proxyIncrementRequest(ctx, c, addr, r)
+		countProxy("increment", err)
+		return resp, err
+	}
+
+	var value uint64
+	entry, err := storage.Update(ctx, c.Db, []byte(r.GetKey()), func(current *storage.Entry) (*storage.Entry, error) {
+		if current == nil {
+			if !r.GetCreate() {
+				return nil, constants.StatusErrKeyNotFound
+			}
+			value = r.GetInitial()
+			return &storage.Entry{Value: []byte(strconv.FormatUint(value, 10)), ExpiresAt: expiresAt(r.GetTtlMs())}, nil
+		}
+		n, err := strconv.ParseUint(string(current.Value), 10, 64)
+		if err != nil {
+			return nil, constants.StatusErrNotNumber
+		}
+		switch {
+		case !r.GetDecrement():
+			value = n + r.GetDelta()
+		case r.GetDelta() > n:
+			value = 0
+		default:
+			value = n - r.GetDelta()
+		}
+		return &storage.Entry{Value: []byte(strconv.FormatUint(value, 10)), ExpiresAt: current.ExpiresAt, Flags: current.Flags}, nil
+	})
+	if errors.Is(err, constants.StatusErrKeyNotFound) || errors.Is(err, constants.StatusErrNotNumber) {
+		return nil, err
+	}
+	if err != nil {
+		c.Logger.Error("Some error occurred while incrementing key", zap.Error(err))
+		return nil, constants.StatusErrInternal
 	}
+	return &proto.IncrementKeyResponse{Key: r.GetKey(), Value: value, Version: entry.Timestamp}, nil
+}
+
+// TouchKey changes the expiry of a key without changing its value
+func TouchKey(ctx context.Context, c *config.Config, r *proto.TouchKeyRequest) (*proto.TouchKeyResponse, error) {
+	if !validKey(r.GetKey()) {
+		return nil, constants.StatusErrInvalidKey
+	}
+	if r.GetTtlMs() < 0 {
+		return nil, constants.StatusErrInvalidTTL
+	}
+	if err := authorize(ctx, c, r.GetKey(), auth.Write); err != nil {
+		return nil, err
+	}
+	responsibleNode := c.HashRing.GetResponsibleNode(r.GetKey())
+	if c.NodeInfo.ID != responsibleNode {
+		addr, err := ownerAddress(c, responsibleNode)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := proxyTouchRequest(ctx, c, addr, r)
+		countProxy("touch", err)
+		return resp, err
+	}
+
+	_, err := storage.Update(ctx, c.Db, []byte(r.GetKey()), func(current *storage.Entry) (*storage.Entry, error) {
+		if current == nil {
+			return nil, constants.StatusErrKeyNotFound
+		}
+		return &storage.Entry{Value: current.Value, ExpiresAt: expiresAt(r.GetTtlMs()), Flags: current.Flags}, nil
+	})
+	if errors.Is(err, constants.StatusErrKeyNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		c.Logger.Error("Some error occurred while touching key", zap.Error(err))
+		return nil, constants.StatusErrInternal
+	}
+	return &proto.TouchKeyResponse{Key: r.GetKey()}, nil
+}
+
+// DefaultScanLimit is the number of keys returned by a scan without limit, MaxScanLimit the
+// largest limit a scan may request
+const (
+	DefaultScanLimit = 100
+	MaxScanLimit     = 1000
+)
+
+// ScanKeys returns the keys with a prefix in key order, starting after the cursor
+func ScanKeys(ctx context.Context, c *config.Config, r *proto.ScanKeysRequest) (*proto.ScanKeysResponse, error) {
+	limit := int(r.GetLimit())
+	if limit < 0 || limit > MaxScanLimit {
+		return nil, constants.StatusErrInvalidLimit
+	}
+	if limit == 0 {
+		limit = DefaultScanLimit
+	}
+	if storage.IsInternalKey([]byte(r.GetPrefix())) {
+		return nil, constants.StatusErrInvalidKey
+	}
+	// A scan reads every key with the prefix
+	if err := authorize(ctx, c, r.GetPrefix(), auth.Read); err != nil {
+		return nil, err
+	}
+	if r.GetLocal() {
+		return scanLocal(ctx, c, r, limit)
+	}
+
+	// Every node returns its first keys after the cursor. The first keys of all nodes together
+	// are the first keys of the cluster.
+	nodes := c.HashRing.GetNodes()
+	responses := make([]*proto.ScanKeysResponse, len(nodes))
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if node.ID == c.NodeInfo.ID {
+				responses[i], errs[i] = scanLocal(ctx, c, r, limit)
+				return
+			}
+			responses[i], errs[i] = proxyScanRequest(ctx, c, node.PeerAddress(), &proto.ScanKeysRequest{
+				Prefix:     r.GetPrefix(),
+				StartAfter: r.GetStartAfter(),
+				Limit:      int32(limit),
+				KeysOnly:   r.GetKeysOnly(),
+				Local:      true,
+			})
+			countProxy("scan", errs[i])
+		}()
+	}
+	wg.Wait()
+
+	var items []*proto.KeyValue
+	more := false
+	for i, resp := range responses {
+		if errs[i] != nil {
+			c.Logger.Error("Failed to scan node", zap.String("node", nodes[i].ID), zap.Error(errs[i]))
+			return nil, errs[i]
+		}
+		items = append(items, resp.Items...)
+		more = more || resp.Cursor != ""
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	if len(items) > limit {
+		items = items[:limit]
+		more = true
+	}
+	return scanResponse(items, more), nil
+}
+
+// scanLocal scans the keys stored on this node
+func scanLocal(ctx context.Context, c *config.Config, r *proto.ScanKeysRequest, limit int) (*proto.ScanKeysResponse, error) {
+	found, more, err := storage.Scan(ctx, c.Db, []byte(r.GetPrefix()), []byte(r.GetStartAfter()), limit, r.GetKeysOnly())
+	if err != nil {
+		c.Logger.Error("Some error occurred while scanning keys", zap.Error(err))
+		return nil, constants.StatusErrInternal
+	}
+	items := make([]*proto.KeyValue, len(found))
+	for i, item := range found {
+		items[i] = &proto.KeyValue{Key: string(item.Key), Value: item.Value}
+	}
+	return scanResponse(items, more), nil
+}
+
+// scanResponse returns the items with the last key as cursor if more keys match
+func scanResponse(items []*proto.KeyValue, more bool) *proto.ScanKeysResponse {
+	resp := &proto.ScanKeysResponse{Items: items}
+	if more && len(items) > 0 {
+		resp.Cursor = items[len(items)-1].Key
+	}
+	return resp
+}
+
+// authorize checks the ACL for the principal of the request. It is called before proxying so
+// requests are rejected by the node that received them.
+func authorize(ctx context.Context, c *config.Config, key string, permission auth.Permission) error {
+	if c.Authenticator == nil {
+		return nil
+	}
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return constants.StatusErrUnauthenticated
+	}
+	if principal.Node {
+		// Other nodes only forward requests they already authorized
+		return nil
+	}
+	if c.ACL != nil && !c.ACL.Allowed(principal, key, permission) {
+		c.Logger.Warn("Permission denied", zap.String("principal", principal.Name), zap.String("permission", string(permission)))
+		return constants.StatusErrPermissionDenied
+	}
+	return nil
+}
+
+// ownerAddress returns the peer address of the node responsible for a key. The node may have left
+// the ring since it was looked up, then the request can be retried.
+func ownerAddress(c *config.Config, nodeID string) (string, error) {
+	node, ok := c.HashRing.GetNode(nodeID)
+	if !ok {
+		return "", constants.StatusErrOwnerLeft
+	}
+	return node.PeerAddress(), nil
+}
+
+// outgoingContext forwards the token and request ID of the original request, so the responsible
+// node authenticates the original caller and logs the request under the same ID
+func outgoingContext(ctx context.Context) context.Context {
+	return requestid.Forward(auth.ForwardToken(ctx))
 }
 
 func proxyGetRequest(ctx context.Context, conf *config.Config, addr string, request *proto.GetKeyRequest) (*proto.GetKeyResponse, error) {
@@ -85,7 +400,7 @@ func proxyGetRequest(ctx context.Context, conf *config.Config, addr string, requ
 		return nil, err
 	}
 	client := proto.NewKeyServiceClient(conn)
-	return client.GetKey(ctx, request)
+	return client.GetKey(outgoingContext(ctx), request)
 }
 
 func proxySetRequest(ctx context.Context, conf *config.Config, addr string, request *proto.SetKeyRequest) (*proto.SetKeyResponse, error) {
@@ -94,7 +409,34 @@ func proxySetRequest(ctx context.Context, conf *config.Config, addr string, requ
 		return nil, err
 	}
 	client := proto.NewKeyServiceClient(conn)
-	return client.SetKey(ctx, request)
+	return client.SetKey(outgoingContext(ctx), request)
+}
+
+func proxyScanRequest(ctx context.Context, conf *config.Config, addr string, request *proto.ScanKeysRequest) (*proto.ScanKeysResponse, error) {
+	conn, err := conf.ConnectionPool.GetConnection(addr)
+	if err != nil {
+		return nil, err
+	}
+	client := proto.NewKeyServiceClient(conn)
+	return client.ScanKeys(outgoingContext(ctx), request)
+}
+
+func proxyIncrementRequest(ctx context.Context, conf *config.Config, addr string, request *proto.IncrementKeyRequest) (*proto.IncrementKeyResponse, error) {
+	conn, err := conf.ConnectionPool.GetConnection(addr)
+	if err != nil {
+		return nil, err
+	}
+	client := proto.NewKeyServiceClient(conn)
+	return client.IncrementKey(outgoingContext(ctx), request)
+}
+
+func proxyTouchRequest(ctx context.Context, conf *config.Config, addr string, request *proto.TouchKeyRequest) (*proto.TouchKeyResponse, error) {
+	conn, err := conf.ConnectionPool.GetConnection(addr)
+	if err != nil {
+		return nil, err
+	}
+	client := proto.NewKeyServiceClient(conn)
+	return client.TouchKey(outgoingContext(ctx), request)
 }
 
 func proxyDeleteRequest(ctx context.Context, conf *config.Config, addr string, request *proto.DeleteKeyRequest) (*proto.DeleteKeyResponse, error) {
@@ -103,5 +445,5 @@ func proxyDeleteRequest(ctx context.Context, conf *config.Config, addr string, r
 		return nil, err
 	}
 	client := proto.NewKeyServiceClient(conn)
-	return client.DeleteKey(ctx, request)
+	return client.DeleteKey(outgoingContext(ctx), request)
 }
@@ -3,18 +3,21 @@ package controller
 import (
 	"context"
 	"errors"
+	"net"
 	"testing"
 
 	"github.com/cockroachdb/pebble"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/tdevsin/keyforge/internal/auth"
 	"github.com/tdevsin/keyforge/internal/cluster"
 	"github.com/tdevsin/keyforge/internal/config"
 	"github.com/tdevsin/keyforge/internal/constants"
 	"github.com/tdevsin/keyforge/internal/logger"
 	"github.com/tdevsin/keyforge/internal/proto"
 	"github.com/tdevsin/keyforge/internal/storage"
+	"google.golang.org/grpc"
 )
 
 func TestSetKey(t *testing.T) {
@@ -127,6 +130,116 @@ func TestSetKey(t *testing.T) {
 	})
 }
 
+func TestSetKeyWithCondition(t *testing.T) {
+	node := cluster.Node{ID: uuid.NewString()}
+	hashring := cluster.NewHashRing()
+	hashring.AddNode(node)
+	newConfig := func(db *storage.MockDatabase) *config.Config {
+		return &config.Config{Db: db, Logger: new(logger.MockLogging), NodeInfo: &node, HashRing: hashring}
+	}
+	existing := &storage.Entry{Value: []byte("old")}
+
+	tests := []struct {
+		name      string
+		condition proto.SetKeyRequest_Condition
+		current   *storage.Entry
+		wantErr   error
+	}{
+		{name: "If absent writes missing keys", condition: proto.SetKeyRequest_IF_ABSENT},
+		{name: "If absent rejects existing keys", condition: proto.SetKeyRequest_IF_ABSENT, current: existing, wantErr: constants.StatusErrConditionFailed},
+		{name: "If present writes existing keys", condition: proto.SetKeyRequest_IF_PRESENT, current: existing},
+		{name: "If present rejects missing keys", condition: proto.SetKeyRequest_IF_PRESENT, wantErr: constants.StatusErrConditionFailed},
+		{name: "If version writes matching versions", condition: proto.SetKeyRequest_IF_VERSION, current: &storage.Entry{Timestamp: 42}},
+		{name: "If version rejects other versions", condition: proto.SetKeyRequest_IF_VERSION, current: &storage.Entry{Timestamp: 41}, wantErr: constants.StatusErrConditionFailed},
+		{name: "If version rejects missing keys", condition: proto.SetKeyRequest_IF_VERSION, wantErr: constants.StatusErrKeyNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDb := new(storage.MockDatabase)
+			mockDb.On("Update", []byte("key")).Return(tt.current, nil)
+
+			resp, err := SetKey(context.TODO(), newConfig(mockDb), &proto.SetKeyRequest{Key: "key", Value: []byte("value"), Condition: tt.condition, Version: 42})
+
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantErr == nil, resp != nil)
+			mockDb.AssertExpectations(t)
+		})
+	}
+
+	t.Run("Negative TTL", func(t *testing.T) {
+		_, err := SetKey(context.TODO(), newConfig(new(storage.MockDatabase)), &proto.SetKeyRequest{Key: "key", Value: []byte("value"), TtlMs: -1})
+		assert.Equal(t, constants.StatusErrInvalidTTL, err)
+	})
+
+	t.Run("Reserved keys", func(t *testing.T) {
+		_, err := SetKey(context.TODO(), newConfig(new(storage.MockDatabase)), &proto.SetKeyRequest{Key: "\x00exp/", Value: []byte("value")})
+		assert.Equal(t, constants.StatusErrInvalidKey, err)
+	})
+}
+
+func TestIncrementKey(t *testing.T) {
+	node := cluster.Node{ID: uuid.NewString()}
+	hashring := cluster.NewHashRing()
+	hashring.AddNode(node)
+
+	tests := []struct {
+		name    string
+		request *proto.IncrementKeyRequest
+		current *storage.Entry
+		want    uint64
+		wantErr error
+	}{
+		{name: "Increments", request: &proto.IncrementKeyRequest{Delta: 5}, current: &storage.Entry{Value: []byte("10")}, want: 15},
+		{name: "Wraps around", request: &proto.IncrementKeyRequest{Delta: 2}, current: &storage.Entry{Value: []byte("18446744073709551615")}, want: 1},
+		{name: "Decrements", request: &proto.IncrementKeyRequest{Delta: 3, Decrement: true}, current: &storage.Entry{Value: []byte("10")}, want: 7},
+		{name: "Stops at zero", request: &proto.IncrementKeyRequest{Delta: 30, Decrement: true}, current: &storage.Entry{Value: []byte("10")}, want: 0},
+		{name: "Creates missing counters", request: &proto.IncrementKeyRequest{Delta: 1, Create: true, Initial: 100}, want: 100},
+		{name: "Missing counters", request: &proto.IncrementKeyRequest{Delta: 1}, wantErr: constants.StatusErrKeyNotFound},
+		{name: "Values that are not numbers", request: &proto.IncrementKeyRequest{Delta: 1}, current: &storage.Entry{Value: []byte("ten")}, wantErr: constants.StatusErrNotNumber},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDb := new(storage.MockDatabase)
+			mockDb.On("Update", []byte("counter")).Return(tt.current, nil)
+			c := &config.Config{Db: mockDb, Logger: new(logger.MockLogging), NodeInfo: &node, HashRing: hashring}
+			tt.request.Key = "counter"
+
+			resp, err := IncrementKey(context.TODO(), c, tt.request)
+
+			assert.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				assert.Equal(t, tt.want, resp.Value)
+			}
+			mockDb.AssertExpectations(t)
+		})
+	}
+}
+
+func TestTouchKey(t *testing.T) {
+	node := cluster.Node{ID: uuid.NewString()}
+	hashring := cluster.NewHashRing()
+	hashring.AddNode(node)
+
+	t.Run("Missing keys", func(t *testing.T) {
+		mockDb := new(storage.MockDatabase)
+		mockDb.On("Update", []byte("key")).Return(nil, nil)
+		c := &config.Config{Db: mockDb, Logger: new(logger.MockLogging), NodeInfo: &node, HashRing: hashring}
+
+		_, err := TouchKey(context.TODO(), c, &proto.TouchKeyRequest{Key: "key", TtlMs: 1000})
+		assert.Equal(t, constants.StatusErrKeyNotFound, err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		mockDb := new(storage.MockDatabase)
+		mockDb.On("Update", []byte("key")).Return(&storage.Entry{Value: []byte("value"), Flags: 7}, nil)
+		c := &config.Config{Db: mockDb, Logger: new(logger.MockLogging), NodeInfo: &node, HashRing: hashring}
+
+		resp, err := TouchKey(context.TODO(), c, &proto.TouchKeyRequest{Key: "key", TtlMs: 1000})
+		assert.NoError(t, err)
+		assert.Equal(t, "key", resp.Key)
+	})
+}
+
 func TestGetKey(t *testing.T) {
 	t.Run("Invalid Key", func(t *testing.T) {
 		mockDb := new(storage.MockDatabase)
@@ -151,7 +264,7 @@ func TestGetKey(t *testing.T) {
 		mockDb := new(storage.MockDatabase)
 		mockLogger := new(logger.MockLogging)
 
-		mockDb.On("ReadKey", []byte("key")).Return([]byte(nil), pebble.ErrNotFound)
+		mockDb.On("ReadEntry", []byte("key")).Return(nil, pebble.ErrNotFound)
 		id := uuid.NewString()
 		node := cluster.Node{
 			ID: id,
@@ -181,7 +294,7 @@ func TestGetKey(t *testing.T) {
 		mockDb := new(storage.MockDatabase)
 		mockLogger := new(logger.MockLogging)
 
-		mockDb.On("ReadKey", []byte("key")).Return([]byte(nil), errors.New("db error"))
+		mockDb.On("ReadEntry", []byte("key")).Return(nil, errors.New("db error"))
 		id := uuid.NewString()
 		node := cluster.Node{
 			ID: id,
@@ -215,7 +328,7 @@ func TestGetKey(t *testing.T) {
 		}
 		hashring := cluster.NewHashRing()
 		hashring.AddNode(node)
-		mockDb.On("ReadKey", []byte("key")).Return([]byte("value"), nil)
+		mockDb.On("ReadEntry", []byte("key")).Return(&storage.Entry{Value: []byte("value")}, nil)
 
 		c := &config.Config{
 			Db:       mockDb,
@@ -265,7 +378,7 @@ func TestDeleteKey(t *testing.T) {
 		mockDb := new(storage.MockDatabase)
 		mockLogger := new(logger.MockLogging)
 
-		mockDb.On("DeleteKey", []byte("key")).Return(errors.New("db error"))
+		mockDb.On("DeleteKey", []byte("key")).Return(false, errors.New("db error"))
 		id := uuid.NewString()
 		node := cluster.Node{
 			ID: id,
@@ -300,7 +413,7 @@ func TestDeleteKey(t *testing.T) {
 		}
 		hashring := cluster.NewHashRing()
 		hashring.AddNode(node)
-		mockDb.On("DeleteKey", []byte("key")).Return(nil)
+		mockDb.On("DeleteKey", []byte("key")).Return(true, nil)
 
 		c := &config.Config{
 			Db:       mockDb,
@@ -317,10 +430,193 @@ func TestDeleteKey(t *testing.T) {
 
 		assert.NotNil(t, resp)
 		assert.Equal(t, &proto.DeleteKeyResponse{
-			Key: "key",
+			Key:   "key",
+			Found: true,
 		}, resp)
 		assert.Nil(t, err)
 
 		mockDb.AssertExpectations(t)
 	})
 }
+
+func TestOwnerAddress(t *testing.T) {
+	hashring := cluster.NewHashRing()
+	hashring.AddNode(cluster.Node{ID: "node2", Address: "localhost:8081", InternalAddress: "localhost:9081"})
+	c := &config.Config{HashRing: hashring}
+
+	t.Run("Node in the ring", func(t *testing.T) {
+		addr, err := ownerAddress(c, "node2")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "localhost:9081", addr)
+	})
+
+	t.Run("Node left the ring", func(t *testing.T) {
+		hashring.RemoveNode("node2")
+
+		_, err := ownerAddress(c, "node2")
+
+		assert.Equal(t, constants.StatusErrOwnerLeft, err)
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	acl, err := auth.NewACL([]auth.Rule{
+		{Principal: "alice", Prefix: "users/alice/", Permissions: []auth.Permission{auth.Read, auth.Write}},
+	})
+	assert.NoError(t, err)
+
+	newConfig := func() (*config.Config, *storage.MockDatabase) {
+		mockDb := new(storage.MockDatabase)
+		mockLogger := new(logger.MockLogging)
+		mockLogger.On("Warn", "Permission denied", mock.Anything).Maybe()
+		node := cluster.Node{
+			ID: uuid.NewString(),
+		}
+		hashring := cluster.NewHashRing()
+		hashring.AddNode(node)
+		return &config.Config{
+			Db:            mockDb,
+			Logger:        mockLogger,
+			NodeInfo:      &node,
+			HashRing:      hashring,
+			Authenticator: &auth.StaticTokens{},
+			ACL:           acl,
+		}, mockDb
+	}
+	alice := auth.NewContext(context.TODO(), &auth.Principal{Name: "alice"})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		c, _ := newConfig()
+		resp, err := GetKey(context.TODO(), c, &proto.GetKeyRequest{Key: "users/alice/name"})
+		assert.Nil(t, resp)
+		assert.Equal(t, constants.StatusErrUnauthenticated, err)
+	})
+
+	t.Run("Allowed", func(t *testing.T) {
+		c, mockDb := newConfig()
+		mockDb.On("WriteKey", []byte("users/alice/name"), []byte("alice")).Return(nil)
+		_, err := SetKey(alice, c, &proto.SetKeyRequest{Key: "users/alice/name", Value: []byte("alice")})
+		assert.Nil(t, err)
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("Denied by prefix", func(t *testing.T) {
+		c, mockDb := newConfig()
+		resp, err := GetKey(alice, c, &proto.GetKeyRequest{Key: "users/bob/name"})
+		assert.Nil(t, resp)
+		assert.Equal(t, constants.StatusErrPermissionDenied, err)
+		mockDb.AssertNotCalled(t, "ReadKey", mock.Anything)
+	})
+
+	t.Run("Denied by permission", func(t *testing.T) {
+		c, mockDb := newConfig()
+		resp, err := DeleteKey(alice, c, &proto.DeleteKeyRequest{Key: "users/alice/name"})
+		assert.Nil(t, resp)
+		assert.Equal(t, constants.StatusErrPermissionDenied, err)
+		mockDb.AssertNotCalled(t, "DeleteKey", mock.Anything)
+	})
+
+	t.Run("Requests forwarded by nodes are not checked again", func(t *testing.T) {
+		c, mockDb := newConfig()
+		mockDb.On("DeleteKey", []byte("users/bob/name")).Return(true, nil)
+		_, err := DeleteKey(auth.NewContext(context.TODO(), auth.NodePrincipal), c, &proto.DeleteKeyRequest{Key: "users/bob/name"})
+		assert.Nil(t, err)
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("Full access without ACL", func(t *testing.T) {
+		c, mockDb := newConfig()
+		c.ACL = nil
+		mockDb.On("DeleteKey", []byte("users/bob/name")).Return(true, nil)
+		_, err := DeleteKey(alice, c, &proto.DeleteKeyRequest{Key: "users/bob/name"})
+		assert.Nil(t, err)
+		mockDb.AssertExpectations(t)
+	})
+}
+
+// peerKeyService answers local scans of another node with fixed keys
+type peerKeyService struct {
+	proto.UnimplementedKeyServiceServer
+	keys []string
+}
+
+func (p *peerKeyService) ScanKeys(ctx context.Context, req *proto.ScanKeysRequest) (*proto.ScanKeysResponse, error) {
+	var items []*proto.KeyValue
+	for _, key := range p.keys {
+		if key > req.StartAfter && len(items) < int(req.Limit) {
+			items = append(items, &proto.KeyValue{Key: key})
+		}
+	}
+	resp := &proto.ScanKeysResponse{Items: items}
+	if len(items) == int(req.Limit) {
+		resp.Cursor = items[len(items)-1].Key
+	}
+	return resp, nil
+}
+
+func TestScanKeys(t *testing.T) {
+	newConfig := func(db storage.Database, nodes ...cluster.Node) *config.Config {
+		hashring := cluster.NewHashRing()
+		for _, node := range nodes {
+			hashring.AddNode(node)
+		}
+		return &config.Config{
+			Db:             db,
+			NodeInfo:       &nodes[0],
+			HashRing:       hashring,
+			ConnectionPool: cluster.NewConnectionPool(),
+		}
+	}
+
+	t.Run("Invalid limit", func(t *testing.T) {
+		c := newConfig(nil, cluster.Node{ID: "node1"})
+		for _, limit := range []int32{-1, MaxScanLimit + 1} {
+			_, err := ScanKeys(context.TODO(), c, &proto.ScanKeysRequest{Limit: limit})
+			assert.Equal(t, constants.StatusErrInvalidLimit, err)
+		}
+	})
+
+	t.Run("Single node with default limit", func(t *testing.T) {
+		mockDb := new(storage.MockDatabase)
+		mockDb.On("Scan", []byte("user/"), []byte(""), DefaultScanLimit, false).Return([]storage.KeyValue{
+			{Key: []byte("user/1"), Value: []byte("a")},
+		}, false, nil)
+		c := newConfig(mockDb, cluster.Node{ID: "node1"})
+
+		resp, err := ScanKeys(context.TODO(), c, &proto.ScanKeysRequest{Prefix: "user/"})
+
+		assert.NoError(t, err)
+		assert.Len(t, resp.Items, 1)
+		assert.Equal(t, "user/1", resp.Items[0].Key)
+		assert.Equal(t, []byte("a"), resp.Items[0].Value)
+		assert.Empty(t, resp.Cursor)
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("Merges the keys of all nodes", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		server := grpc.NewServer()
+		proto.RegisterKeyServiceServer(server, &peerKeyService{keys: []string{"b", "d", "f"}})
+		go server.Serve(lis)
+		defer server.Stop()
+
+		mockDb := new(storage.MockDatabase)
+		mockDb.On("Scan", []byte(""), []byte(""), 3, true).Return([]storage.KeyValue{
+			{Key: []byte("a")}, {Key: []byte("c")}, {Key: []byte("e")},
+		}, true, nil)
+		c := newConfig(mockDb, cluster.Node{ID: "node1"}, cluster.Node{ID: "node2", Address: lis.Addr().String()})
+		defer c.ConnectionPool.Close()
+
+		resp, err := ScanKeys(context.TODO(), c, &proto.ScanKeysRequest{Limit: 3, KeysOnly: true})
+
+		assert.NoError(t, err)
+		var keys []string
+		for _, item := range resp.Items {
+			keys = append(keys, item.Key)
+		}
+		assert.Equal(t, []string{"a", "b", "c"}, keys)
+		assert.Equal(t, "c", resp.Cursor)
+	})
+}
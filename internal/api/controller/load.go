@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LoadTables receives SSTables into a temporary directory and ingests each of them once its
+// checksum is verified. A table containing a key another node owns is rejected as a whole, so
+// ingested keys always match the ring. Tables ingested before a rejected one are kept.
+func LoadTables(c *config.Config, recv func() (*proto.TableChunk, error)) (*proto.LoadTablesResponse, error) {
+	dir, err := os.MkdirTemp(c.RootDir, ".load-")
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	resp := &proto.LoadTablesResponse{}
+	var table *os.File
+	var sum hash.Hash
+	for {
+		chunk, err := recv()
+		if errors.Is(err, io.EOF) {
+			if table != nil {
+				table.Close()
+				return nil, status.Error(codes.InvalidArgument, "Stream ended in the middle of a table")
+			}
+			c.Logger.Info("Loaded tables", zap.Int64("tables", resp.Tables), zap.Int64("keys", resp.Keys), zap.Int64("bytes", resp.Bytes))
+			return resp, nil
+		}
+		if err != nil {
+			if table != nil {
+				table.Close()
+			}
+			return nil, err
+		}
+
+		if table == nil {
+			if table, err = os.Create(filepath.Join(dir, fmt.Sprintf("%06d.sst", resp.Tables))); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			sum = sha256.New()
+		}
+		if _, err := io.MultiWriter(table, sum).Write(chunk.Data); err != nil {
+			table.Close()
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Bytes += int64(len(chunk.Data))
+		if chunk.Sha256 == "" {
+			continue
+		}
+
+		path := table.Name()
+		err = table.Close()
+		table = nil
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if hex.EncodeToString(sum.Sum(nil)) != chunk.Sha256 {
+			return nil, status.Errorf(codes.DataLoss, "Checksum of table %d does not match", resp.Tables+1)
+		}
+		keys, err := c.Db.Ingest(path, func(key []byte) error {
+			if owner := c.HashRing.GetResponsibleNode(string(key)); owner != c.NodeInfo.ID {
+				return status.Errorf(codes.FailedPrecondition, "Key %q of table %d is owned by node %s", key, resp.Tables+1, owner)
+			}
+			return nil
+		})
+		if err != nil {
+			if _, ok := status.FromError(err); ok {
+				return nil, err
+			}
+			if errors.Is(err, storage.ErrInvalidTable) {
+				return nil, status.Errorf(codes.InvalidArgument, "Table %d: %v", resp.Tables+1, err)
+			}
+			c.Logger.Error("Failed to ingest table", zap.Error(err))
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		os.Remove(path)
+		resp.Tables++
+		resp.Keys += int64(keys)
+	}
+}
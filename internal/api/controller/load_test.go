@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tableChunks writes an SSTable of the keys and splits it into two chunks
+func tableChunks(t *testing.T, keys ...string) []*proto.TableChunk {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.sst")
+	w, err := storage.NewTableWriter(path)
+	require.NoError(t, err)
+	for _, key := range keys {
+		require.NoError(t, w.Add([]byte(key), &storage.Entry{Value: []byte("v-" + key), Timestamp: time.Now().UnixNano()}))
+	}
+	require.NoError(t, w.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	sum := sha256.Sum256(data)
+	return []*proto.TableChunk{{Data: data[:len(data)/2]}, {Data: data[len(data)/2:], Sha256: hex.EncodeToString(sum[:])}}
+}
+
+func chunks(tables ...[]*proto.TableChunk) func() (*proto.TableChunk, error) {
+	var all []*proto.TableChunk
+	for _, table := range tables {
+		all = append(all, table...)
+	}
+	return func() (*proto.TableChunk, error) {
+		if len(all) == 0 {
+			return nil, io.EOF
+		}
+		chunk := all[0]
+		all = all[1:]
+		return chunk, nil
+	}
+}
+
+func TestLoadTables(t *testing.T) {
+	c := newClusterConfig(cluster.Node{ID: "node1"}, cluster.Node{ID: "node2"})
+	c.RootDir = t.TempDir()
+	db := storage.GetDatabaseInstance(logger.GetLogger(false, "test"), filepath.Join(c.RootDir, "data"))
+	defer db.Close()
+	c.Db = db
+	mine, theirs := ownedKeys(c.HashRing)
+
+	t.Run("Ingests tables of owned keys", func(t *testing.T) {
+		resp, err := LoadTables(c, chunks(tableChunks(t, mine[0]), tableChunks(t, mine[1])))
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), resp.Tables)
+		assert.Equal(t, int64(2), resp.Keys)
+		value, err := db.ReadKey([]byte(mine[1]))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v-"+mine[1]), value)
+		entries, err := os.ReadDir(c.RootDir)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1, "the received tables are removed")
+	})
+
+	t.Run("Rejects tables with keys of other nodes", func(t *testing.T) {
+		_, err := LoadTables(c, chunks(tableChunks(t, theirs[0])))
+
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		assert.ErrorContains(t, err, "owned by node node2")
+		_, err = db.ReadKey([]byte(theirs[0]))
+		assert.Error(t, err)
+	})
+
+	t.Run("Rejects corrupted tables", func(t *testing.T) {
+		table := tableChunks(t, mine[0])
+		table[0].Data = append([]byte{}, table[0].Data...)
+		table[0].Data[0] ^= 0xff
+
+		_, err := LoadTables(c, chunks(table))
+
+		assert.Equal(t, codes.DataLoss, status.Code(err))
+	})
+
+	t.Run("Rejects streams ending in the middle of a table", func(t *testing.T) {
+		_, err := LoadTables(c, chunks(tableChunks(t, mine[0])[:1]))
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
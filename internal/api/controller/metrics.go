@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	proxiedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keyforge_proxied_requests_total",
+		Help: "Number of requests forwarded to the node responsible for the key, by operation and result.",
+	}, []string{"operation", "result"})
+	replicatedChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keyforge_replication_received_changes_total",
+		Help: "Number of changes replicated from another cluster written by this node, and of stale ones older than the stored writes.",
+	}, []string{"result"})
+)
+
+// Collectors returns the metrics of the controller
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{proxiedRequests, replicatedChanges}
+}
+
+// countProxy records a request forwarded to another node
+func countProxy(operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	proxiedRequests.WithLabelValues(operation, result).Inc()
+}
+
+// countReplicatedChanges records changes replicated from another cluster
+func countReplicatedChanges(applied, stale int) {
+	replicatedChanges.WithLabelValues("applied").Add(float64(applied))
+	replicatedChanges.WithLabelValues("stale").Add(float64(stale))
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ApplyChanges writes changes replicated from another cluster. Changes of keys this node owns are
+// applied in a single batch, the others are forwarded to their owners in one request per node.
+// Changes older than the stored write of their key are dropped and reported as stale.
+func ApplyChanges(ctx context.Context, c *config.Config, req *proto.ApplyChangesRequest) (*proto.ApplyChangesResponse, error) {
+	var owned []storage.Change
+	forward := map[string]*proto.ApplyChangesRequest{}
+	for _, change := range req.Changes {
+		if !validKey(change.Key) {
+			return nil, status.Errorf(codes.InvalidArgument, "Key %q is invalid", change.Key)
+		}
+		owner := c.HashRing.GetResponsibleNode(change.Key)
+		if owner == c.NodeInfo.ID {
+			owned = append(owned, mapProtoToChange(change))
+			continue
+		}
+		if req.Forwarded {
+			// The rings of the nodes differ while a change of the ring spreads, the origin retries later
+			return nil, status.Errorf(codes.Unavailable, "Key %q is owned by node %s", change.Key, owner)
+		}
+		if forward[owner] == nil {
+			forward[owner] = &proto.ApplyChangesRequest{Origin: req.Origin, Forwarded: true}
+		}
+		forward[owner].Changes = append(forward[owner].Changes, change)
+	}
+
+	resp := &proto.ApplyChangesResponse{}
+	if len(owned) > 0 {
+		applied, err := c.Db.ApplyChanges(owned)
+		if err != nil {
+			c.Logger.Error("Failed to apply replicated changes", zap.String("origin", req.Origin), zap.Error(err))
+			return nil, constants.StatusErrInternal
+		}
+		countReplicatedChanges(applied, len(owned)-applied)
+		resp.Applied, resp.Stale = int64(applied), int64(len(owned)-applied)
+	}
+	for owner, forwarded := range forward {
+		addr, err := ownerAddress(c, owner)
+		if err != nil {
+			return nil, err
+		}
+		forwardedResp, err := proxyApplyChangesRequest(ctx, c, addr, forwarded)
+		countProxy("replicate", err)
+		if err != nil {
+			return nil, err
+		}
+		resp.Applied += forwardedResp.Applied
+		resp.Stale += forwardedResp.Stale
+	}
+	return resp, nil
+}
+
+// mapProtoToChange returns the storage change of a replicated change
+func mapProtoToChange(change *proto.ReplicatedChange) storage.Change {
+	mapped := storage.Change{Key: []byte(change.Key), Timestamp: change.Timestamp}
+	if !change.Deleted {
+		mapped.Entry = &storage.Entry{
+			Value:     change.Value,
+			Timestamp: change.Timestamp,
+			ExpiresAt: change.ExpiresAt,
+			Flags:     change.Flags,
+		}
+	}
+	return mapped
+}
+
+func proxyApplyChangesRequest(ctx context.Context, conf *config.Config, addr string, request *proto.ApplyChangesRequest) (*proto.ApplyChangesResponse, error) {
+	conn, err := conf.ConnectionPool.GetConnection(addr)
+	if err != nil {
+		return nil, err
+	}
+	client := proto.NewClusterServiceClient(conn)
+	return client.ApplyChanges(outgoingContext(ctx), request)
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// peerReplicationService records the changes forwarded to another node
+type peerReplicationService struct {
+	proto.UnimplementedClusterServiceServer
+	mu       sync.Mutex
+	requests []*proto.ApplyChangesRequest
+}
+
+func (p *peerReplicationService) ApplyChanges(ctx context.Context, req *proto.ApplyChangesRequest) (*proto.ApplyChangesResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, req)
+	return &proto.ApplyChangesResponse{Applied: int64(len(req.Changes))}, nil
+}
+
+func TestApplyChanges(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	peer := &peerReplicationService{}
+	proto.RegisterClusterServiceServer(server, peer)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	c := newClusterConfig(cluster.Node{ID: "node1"}, cluster.Node{ID: "node2", Address: lis.Addr().String()})
+	c.ConnectionPool = cluster.NewConnectionPool()
+	defer c.ConnectionPool.Close()
+	mine, theirs := ownedKeys(c.HashRing)
+
+	t.Run("Applies owned changes and forwards the others", func(t *testing.T) {
+		mockDb := new(storage.MockDatabase)
+		mockDb.On("ApplyChanges", []storage.Change{
+			{Key: []byte(mine[0]), Timestamp: 5, Entry: &storage.Entry{Value: []byte("a"), Timestamp: 5, ExpiresAt: 9, Flags: 2}},
+			{Key: []byte(mine[1]), Timestamp: 6},
+		}).Return(1, nil)
+		c.Db = mockDb
+
+		resp, err := ApplyChanges(context.TODO(), c, &proto.ApplyChangesRequest{Origin: "remote", Changes: []*proto.ReplicatedChange{
+			{Key: mine[0], Value: []byte("a"), Timestamp: 5, ExpiresAt: 9, Flags: 2},
+			{Key: theirs[0], Value: []byte("b"), Timestamp: 7},
+			{Key: mine[1], Timestamp: 6, Deleted: true},
+		}})
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), resp.Applied)
+		assert.Equal(t, int64(1), resp.Stale)
+		mockDb.AssertExpectations(t)
+		assert.Len(t, peer.requests, 1)
+		assert.True(t, peer.requests[0].Forwarded)
+		assert.Equal(t, "remote", peer.requests[0].Origin)
+		assert.Len(t, peer.requests[0].Changes, 1)
+		assert.Equal(t, theirs[0], peer.requests[0].Changes[0].Key)
+	})
+
+	t.Run("Does not forward forwarded changes again", func(t *testing.T) {
+		_, err := ApplyChanges(context.TODO(), c, &proto.ApplyChangesRequest{Forwarded: true, Changes: []*proto.ReplicatedChange{{Key: theirs[0]}}})
+
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("Rejects invalid keys", func(t *testing.T) {
+		_, err := ApplyChanges(context.TODO(), c, &proto.ApplyChangesRequest{Changes: []*proto.ReplicatedChange{{Key: "\x00log/"}}})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
@@ -0,0 +1,317 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/api/controller"
+	"github.com/tdevsin/keyforge/internal/auth"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/requestid"
+	"github.com/tdevsin/keyforge/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// maxBodySize limits values sent to the gateway to the default message size of gRPC
+const maxBodySize = 4 << 20
+
+// Content types of values. JSON bodies carry values base64 encoded, raw bodies carry them as is.
+const (
+	contentTypeJSON = "application/json"
+	contentTypeRaw  = "application/octet-stream"
+)
+
+// jsonMarshaler writes field names as in the proto files, like start_after
+var jsonMarshaler = protojson.MarshalOptions{UseProtoNames: true}
+
+// gatewayError is the body of failed requests
+type gatewayError struct {
+	Code    string `json:"code"`    // Code is the name of the gRPC status code, like NotFound
+	Message string `json:"message"` // Message describes the error
+}
+
+// gateway serves KeyService and the cluster state over HTTP/JSON for clients that can not speak gRPC.
+// Requests go through the same request IDs, authentication, access logs and metrics as gRPC requests.
+type gateway struct {
+	conf   *config.Config
+	health *healthState
+}
+
+// gatewayHandler returns a handler whose routes map onto the controller functions
+func gatewayHandler(conf *config.Config, health *healthState) http.Handler {
+	g := &gateway{conf: conf, health: health}
+	mux := http.NewServeMux()
+	// Keys may contain slashes, so the key is the rest of the path
+	mux.Handle("GET /v1/keys/{key...}", g.route("GetKey", true, g.getKey))
+	mux.Handle("PUT /v1/keys/{key...}", g.route("SetKey", true, g.setKey))
+	mux.Handle("DELETE /v1/keys/{key...}", g.route("DeleteKey", true, g.deleteKey))
+	mux.Handle("GET /v1/keys", g.route("ScanKeys", true, g.scanKeys))
+	mux.Handle("GET /v1/cluster", g.route("GetClusterState", false, g.getClusterState))
+	return mux
+}
+
+// gatewayFunc handles a request and writes the response on success. It returns the parsed request,
+// if any, for the access log.
+type gatewayFunc func(ctx context.Context, w http.ResponseWriter, r *http.Request) (any, error)
+
+// route wraps a gateway function with the checks the interceptors run for gRPC requests.
+// Key routes are rejected until the node joined the cluster.
+func (g *gateway) route(method string, keyRoute bool, handle gatewayFunc) http.Handler {
+	fullMethod := "/Gateway/" + method
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := g.requestContext(r)
+		id, _ := requestid.FromContext(ctx)
+		w.Header().Set(requestid.Header, id)
+
+		ctx, span := tracing.Start(ctx, r.Method+" "+r.Pattern, trace.WithSpanKind(trace.SpanKindServer))
+		var req any
+		err := func() (err error) {
+			defer recoverPanic(g.conf.Logger, fullMethod, &err)
+			if keyRoute && !g.health.joined.Load() {
+				return constants.StatusErrNotReady
+			}
+			authenticated, err := g.authenticate(ctx)
+			if err != nil {
+				return err
+			}
+			req, err = handle(authenticated, w, r)
+			return err
+		}()
+		if err != nil {
+			writeError(w, err)
+		}
+		tracing.End(span, err)
+		observeRPC(fullMethod, start, err)
+		logAccess(g.conf.Logger, ctx, fullMethod, req, start, err)
+	})
+}
+
+// requestContext returns a context carrying the request ID, peer and trace of the HTTP request.
+// The bearer token is kept as incoming metadata, so proxied requests forward it like for gRPC requests.
+func (g *gateway) requestContext(r *http.Request) context.Context {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	md := metadata.MD{}
+	if value := r.Header.Get("Authorization"); value != "" {
+		md.Set("authorization", value)
+	}
+	if value := r.Header.Get(requestid.Header); value != "" {
+		md.Set(requestid.Header, value)
+	}
+	ctx = metadata.NewIncomingContext(ctx, md)
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+	return requestid.NewContext(ctx, requestid.FromIncomingContext(ctx))
+}
+
+// authenticate verifies the bearer token of the request if authentication is enabled
+func (g *gateway) authenticate(ctx context.Context) (context.Context, error) {
+	if g.conf.Authenticator == nil {
+		return ctx, nil
+	}
+	token, err := auth.TokenFromIncomingContext(ctx)
+	if err != nil {
+		return nil, constants.StatusErrUnauthenticated
+	}
+	principal, err := g.conf.Authenticator.Authenticate(token)
+	if err != nil {
+		return nil, constants.StatusErrUnauthenticated
+	}
+	return auth.NewContext(ctx, principal), nil
+}
+
+func (g *gateway) getKey(ctx context.Context, w http.ResponseWriter, r *http.Request) (any, error) {
+	req := &proto.GetKeyRequest{Key: r.PathValue("key")}
+	resp, err := controller.GetKey(ctx, g.conf, req)
+	if err != nil {
+		return req, err
+	}
+	if wantsRaw(r) {
+		w.Header().Set("Content-Type", contentTypeRaw)
+		w.Write(resp.Value)
+		return req, nil
+	}
+	return req, writeMessage(w, http.StatusOK, resp)
+}
+
+// setKey stores the body as value if it is sent as application/octet-stream, or the base64 encoded
+// value of a JSON body like {"value": "dmFsdWU="}
+func (g *gateway) setKey(ctx context.Context, w http.ResponseWriter, r *http.Request) (any, error) {
+	req := &proto.SetKeyRequest{Key: r.PathValue("key")}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return req, status.Errorf(codes.InvalidArgument, "Failed to read body: %v", err)
+	}
+	if mediaType(r.Header.Get("Content-Type")) == contentTypeRaw {
+		req.Value = body
+	} else {
+		var value proto.SetKeyRequest
+		if err := protojson.Unmarshal(body, &value); err != nil {
+			return req, status.Errorf(codes.InvalidArgument, "Invalid JSON body: %v", err)
+		}
+		if value.Key != "" && value.Key != req.Key {
+			return req, status.Errorf(codes.InvalidArgument, "Key of the body does not match the path")
+		}
+		req.Value = value.Value
+		if req.Value == nil {
+			// JSON can not tell an empty value from a missing one, both are stored as empty value
+			req.Value = []byte{}
+		}
+	}
+	resp, err := controller.SetKey(ctx, g.conf, req)
+	if err != nil {
+		return req, err
+	}
+	return req, writeMessage(w, http.StatusOK, resp)
+}
+
+func (g *gateway) deleteKey(ctx context.Context, w http.ResponseWriter, r *http.Request) (any, error) {
+	req := &proto.DeleteKeyRequest{Key: r.PathValue("key")}
+	resp, err := controller.DeleteKey(ctx, g.conf, req)
+	if err != nil {
+		return req, err
+	}
+	return req, writeMessage(w, http.StatusOK, resp)
+}
+
+// scanKeys accepts the fields of ScanKeysRequest as query parameters, for example
+// /v1/keys?prefix=user/&limit=10&start_after=user/5&keys_only=true
+func (g *gateway) scanKeys(ctx context.Context, w http.ResponseWriter, r *http.Request) (any, error) {
+	query := r.URL.Query()
+	req := &proto.ScanKeysRequest{
+		Prefix:     query.Get("prefix"),
+		StartAfter: query.Get("start_after"),
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return req, constants.StatusErrInvalidLimit
+		}
+		req.Limit = int32(limit)
+	}
+	if value := query.Get("keys_only"); value != "" {
+		keysOnly, err := strconv.ParseBool(value)
+		if err != nil {
+			return req, status.Errorf(codes.InvalidArgument, "keys_only must be a boolean")
+		}
+		req.KeysOnly = keysOnly
+	}
+	resp, err := controller.ScanKeys(ctx, g.conf, req)
+	if err != nil {
+		return req, err
+	}
+	return req, writeMessage(w, http.StatusOK, resp)
+}
+
+func (g *gateway) getClusterState(ctx context.Context, w http.ResponseWriter, r *http.Request) (any, error) {
+	state, err := controller.GetClusterInfo(g.conf)
+	if err != nil {
+		return nil, err
+	}
+	return nil, writeMessage(w, http.StatusOK, state)
+}
+
+// wantsRaw reports whether the client asked for the raw value instead of JSON, either with
+// ?encoding=raw or by accepting application/octet-stream
+func wantsRaw(r *http.Request) bool {
+	if encoding := r.URL.Query().Get("encoding"); encoding != "" {
+		return encoding == "raw"
+	}
+	return mediaType(r.Header.Get("Accept")) == contentTypeRaw
+}
+
+// mediaType returns the media type of a Content-Type or Accept header without parameters
+func mediaType(header string) string {
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// writeMessage writes a proto message as JSON. Bytes are base64 encoded.
+func writeMessage(w http.ResponseWriter, code int, m protobuf.Message) error {
+	data, err := jsonMarshaler.Marshal(m)
+	if err != nil {
+		return constants.StatusErrInternal
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(code)
+	w.Write(data)
+	return nil
+}
+
+// httpStatus translates gRPC status codes to HTTP status codes
+var httpStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.FailedPrecondition: http.StatusPreconditionFailed,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.Aborted:            http.StatusConflict,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.Canceled:           499, // Client closed request
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+}
+
+// writeError writes the status of err as JSON, like {"code": "NotFound", "message": "Key not found"}
+func writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+	code, ok := httpStatus[s.Code()]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	if s.Code() == codes.Unauthenticated {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(gatewayError{Code: s.Code().String(), Message: s.Message()})
+}
+
+// startGatewayServer serves the HTTP/JSON gateway on the configured gateway address, over TLS if
+// it is enabled. The returned function stops the server.
+func startGatewayServer(conf *config.Config, health *healthState) (func(), error) {
+	lis, err := listenTLS(conf, conf.Settings.Gateway.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: gatewayHandler(conf, health), ReadHeaderTimeout: 10 * time.Second}
+	conf.Logger.Info("Starting HTTP gateway", zap.String("address", lis.Addr().String()), zap.Bool("tls", conf.Certificates != nil))
+	go func() {
+		if err := server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			conf.Logger.Error("HTTP gateway failed", zap.Error(err))
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), conf.Settings.Server.DrainTimeout)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/tdevsin/keyforge/internal/auth"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/requestid"
+	"github.com/tdevsin/keyforge/internal/storage"
+)
+
+// newGatewayServer returns a gateway of a single node cluster storing keys in db
+func newGatewayServer(t *testing.T, db storage.Database, authenticator auth.Authenticator) *httptest.Server {
+	l := new(logger.MockLogging)
+	l.On("Info", mock.Anything, mock.Anything).Maybe()
+	l.On("Warn", mock.Anything, mock.Anything).Maybe()
+	l.On("Error", mock.Anything, mock.Anything).Maybe()
+	node := cluster.Node{ID: "node1", Address: "localhost:8080"}
+	hashring := cluster.NewHashRing()
+	hashring.AddNode(node)
+	clusterInfo := cluster.NewCluster(l, node.ID, 2)
+	clusterInfo.AddOrUpdateNode(node)
+	conf := &config.Config{
+		Logger:        l,
+		Db:            db,
+		NodeInfo:      &node,
+		HashRing:      hashring,
+		ClusterInfo:   clusterInfo,
+		Authenticator: authenticator,
+	}
+	health := newHealthState()
+	health.markReady()
+	server := httptest.NewServer(gatewayHandler(conf, health))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// send sends a request to the gateway and returns the response and its body
+func send(t *testing.T, method, url, contentType, body string, header ...string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func TestGateway(t *testing.T) {
+	value := []byte{0x00, 0xff, 'v'}
+	encoded := base64.StdEncoding.EncodeToString(value)
+	db := new(storage.MockDatabase)
+	db.On("ReadEntry", []byte("user/1")).Return(&storage.Entry{Value: value}, nil)
+	db.On("ReadEntry", []byte("missing")).Return(nil, pebble.ErrNotFound)
+	db.On("WriteKey", []byte("user/1"), value).Return(nil)
+	db.On("DeleteKey", []byte("user/1")).Return(true, nil)
+	db.On("Scan", []byte("user/"), []byte(""), 1, false).Return([]storage.KeyValue{{Key: []byte("user/1"), Value: value}}, true, nil)
+	server := newGatewayServer(t, db, nil)
+
+	t.Run("Get returns the value base64 encoded", func(t *testing.T) {
+		resp, body := send(t, http.MethodGet, server.URL+"/v1/keys/user/1", "", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"key": "user/1", "value": "`+encoded+`"}`, body)
+		assert.NotEmpty(t, resp.Header.Get(requestid.Header))
+	})
+
+	t.Run("Get returns the raw value", func(t *testing.T) {
+		resp, body := send(t, http.MethodGet, server.URL+"/v1/keys/user/1?encoding=raw", "", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+		assert.Equal(t, string(value), body)
+
+		_, body = send(t, http.MethodGet, server.URL+"/v1/keys/user/1", "", "", "Accept", "application/octet-stream")
+		assert.Equal(t, string(value), body)
+	})
+
+	t.Run("Missing keys are not found", func(t *testing.T) {
+		resp, body := send(t, http.MethodGet, server.URL+"/v1/keys/missing", "", "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.JSONEq(t, `{"code": "NotFound", "message": "Key not found"}`, body)
+	})
+
+	t.Run("Put stores a base64 encoded value", func(t *testing.T) {
+		resp, body := send(t, http.MethodPut, server.URL+"/v1/keys/user/1", "application/json", `{"value": "`+encoded+`"}`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, body)
+	})
+
+	t.Run("Put stores a raw value", func(t *testing.T) {
+		resp, body := send(t, http.MethodPut, server.URL+"/v1/keys/user/1", "application/octet-stream", string(value))
+		assert.Equal(t, http.StatusOK, resp.StatusCode, body)
+	})
+
+	t.Run("Put rejects invalid JSON", func(t *testing.T) {
+		resp, body := send(t, http.MethodPut, server.URL+"/v1/keys/user/1", "application/json", `{"value": 1}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Contains(t, body, "InvalidArgument")
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		resp, body := send(t, http.MethodDelete, server.URL+"/v1/keys/user/1", "", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"key": "user/1", "found": true}`, body)
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		resp, body := send(t, http.MethodGet, server.URL+"/v1/keys?prefix=user/&limit=1", "", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"items": [{"key": "user/1", "value": "`+encoded+`"}], "cursor": "user/1"}`, body)
+
+		resp, _ = send(t, http.MethodGet, server.URL+"/v1/keys?limit=many", "", "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Cluster state", func(t *testing.T) {
+		resp, body := send(t, http.MethodGet, server.URL+"/v1/cluster", "", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var state map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(body), &state))
+		assert.Contains(t, state, "nodes")
+	})
+
+	t.Run("Request IDs of the caller are returned", func(t *testing.T) {
+		resp, _ := send(t, http.MethodGet, server.URL+"/v1/keys/user/1", "", "", requestid.Header, "abc-123")
+		assert.Equal(t, "abc-123", resp.Header.Get(requestid.Header))
+	})
+}
+
+func TestGatewayAuthentication(t *testing.T) {
+	db := new(storage.MockDatabase)
+	db.On("ReadEntry", []byte("a")).Return(&storage.Entry{Value: []byte("1")}, nil)
+	server := newGatewayServer(t, db, fakeAuthenticator{"secret": "alice"})
+
+	resp, _ := send(t, http.MethodGet, server.URL+"/v1/keys/a", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+
+	resp, _ = send(t, http.MethodGet, server.URL+"/v1/keys/a", "", "", "Authorization", "Bearer secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestGatewayNotReady(t *testing.T) {
+	l := new(logger.MockLogging)
+	l.On("Info", mock.Anything, mock.Anything).Maybe()
+	server := httptest.NewServer(gatewayHandler(&config.Config{Logger: l}, newHealthState()))
+	defer server.Close()
+
+	resp, body := send(t, http.MethodGet, server.URL+"/v1/keys/a", "", "")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Contains(t, body, "Unavailable")
+}
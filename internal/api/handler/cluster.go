@@ -6,6 +6,7 @@ import (
 	"github.com/tdevsin/keyforge/internal/api/controller"
 	"github.com/tdevsin/keyforge/internal/config"
 	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -23,3 +24,67 @@ func (c *ClusterHandler) SetClusterState(ctx context.Context, req *proto.Cluster
 	c.Conf.Logger.Info("SetClusterState called")
 	return &emptypb.Empty{}, controller.SetClusterInfo(c.Conf, req)
 }
+
+func (c *ClusterHandler) ExchangeDigest(ctx context.Context, req *proto.GossipDigest) (*proto.GossipDigestAck, error) {
+	return controller.ExchangeDigest(c.Conf, req)
+}
+
+func (c *ClusterHandler) PushDelta(ctx context.Context, req *proto.GossipDelta) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, controller.PushDelta(c.Conf, req)
+}
+
+func (c *ClusterHandler) GetClusterTiming(ctx context.Context, req *emptypb.Empty) (*proto.ClusterTiming, error) {
+	return controller.GetClusterTiming(c.Conf)
+}
+
+func (c *ClusterHandler) UpdateClusterTiming(ctx context.Context, req *proto.ClusterTiming) (*proto.ClusterTiming, error) {
+	c.Conf.Logger.Info("UpdateClusterTiming called")
+	return controller.UpdateClusterTiming(c.Conf, req)
+}
+
+func (c *ClusterHandler) GetRing(ctx context.Context, req *emptypb.Empty) (*proto.Ring, error) {
+	return controller.GetRing(c.Conf)
+}
+
+func (c *ClusterHandler) RemoveNode(ctx context.Context, req *proto.RemoveNodeRequest) (*proto.Node, error) {
+	c.Conf.Logger.Info("RemoveNode called")
+	return controller.RemoveNode(c.Conf, req)
+}
+
+func (c *ClusterHandler) DecommissionNode(ctx context.Context, req *proto.DecommissionNodeRequest) (*proto.Node, error) {
+	c.Conf.Logger.Info("DecommissionNode called")
+	return controller.DecommissionNode(ctx, c.Conf, req)
+}
+
+func (c *ClusterHandler) Backup(req *proto.BackupRequest, stream grpc.ServerStreamingServer[proto.BackupChunk]) error {
+	c.Conf.Logger.Info("Backup called")
+	return controller.Backup(c.Conf, stream.Send)
+}
+
+func (c *ClusterHandler) ExportKeys(req *proto.ExportKeysRequest, stream grpc.ServerStreamingServer[proto.KeyEntryBatch]) error {
+	c.Conf.Logger.Info("ExportKeys called")
+	return controller.ExportKeys(c.Conf, req, stream.Send)
+}
+
+func (c *ClusterHandler) ImportKeys(stream grpc.ClientStreamingServer[proto.KeyEntryBatch, proto.ImportKeysResponse]) error {
+	c.Conf.Logger.Info("ImportKeys called")
+	resp, err := controller.ImportKeys(stream.Context(), c.Conf, stream.Recv)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp)
+}
+
+func (c *ClusterHandler) LoadTables(stream grpc.ClientStreamingServer[proto.TableChunk, proto.LoadTablesResponse]) error {
+	c.Conf.Logger.Info("LoadTables called")
+	resp, err := controller.LoadTables(c.Conf, stream.Recv)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp)
+}
+
+func (c *ClusterHandler) ApplyChanges(ctx context.Context, req *proto.ApplyChangesRequest) (*proto.ApplyChangesResponse, error) {
+	c.Conf.Logger.Info("ApplyChanges called")
+	return controller.ApplyChanges(ctx, c.Conf, req)
+}
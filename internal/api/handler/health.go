@@ -4,7 +4,9 @@ import (
 	"context"
 
 	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
 	"github.com/tdevsin/keyforge/internal/proto"
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -14,8 +16,11 @@ type HealthHandler struct {
 	Conf *config.Config
 }
 
-// CheckHealth returns an empty response indicating the service is healthy
+// CheckHealth returns an empty response if the node is healthy, which requires its database to accept writes
 func (h *HealthHandler) CheckHealth(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
-	h.Conf.Logger.Info("Health Check")
+	if err := h.Conf.Db.CheckWritable(); err != nil {
+		h.Conf.Logger.Error("Health check failed, database is not writable", zap.Error(err))
+		return nil, constants.StatusErrNotWritable
+	}
 	return &emptypb.Empty{}, nil
 }
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestCheckHealth(t *testing.T) {
+	t.Run("Healthy when the database is writable", func(t *testing.T) {
+		db := new(storage.MockDatabase)
+		db.On("CheckWritable").Return(nil)
+		h := &HealthHandler{Conf: &config.Config{Db: db}}
+
+		_, err := h.CheckHealth(context.TODO(), &emptypb.Empty{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Unavailable when the database is not writable", func(t *testing.T) {
+		db := new(storage.MockDatabase)
+		db.On("CheckWritable").Return(errors.New("disk full"))
+		l := new(logger.MockLogging)
+		l.On("Error", mock.Anything, mock.Anything).Return()
+		h := &HealthHandler{Conf: &config.Config{Db: db, Logger: l}}
+
+		_, err := h.CheckHealth(context.TODO(), &emptypb.Empty{})
+		assert.Equal(t, constants.StatusErrNotWritable, err)
+		l.AssertExpectations(t)
+	})
+}
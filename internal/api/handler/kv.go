@@ -6,8 +6,6 @@ import (
 	"github.com/tdevsin/keyforge/internal/api/controller"
 	"github.com/tdevsin/keyforge/internal/config"
 	"github.com/tdevsin/keyforge/internal/proto"
-	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 )
 
 // KVHandler is the handler for Key-Value operations
@@ -18,18 +16,30 @@ type KVHandler struct {
 
 // GetKey returns the value for the given key
 func (k *KVHandler) GetKey(ctx context.Context, req *proto.GetKeyRequest) (*proto.GetKeyResponse, error) {
-	k.Conf.Logger.Info("Get Request", zap.Field{Key: req.Key, Type: zapcore.StringType})
 	return controller.GetKey(ctx, k.Conf, req)
 }
 
 // SetKey sets the value for the given key
 func (k *KVHandler) SetKey(ctx context.Context, req *proto.SetKeyRequest) (*proto.SetKeyResponse, error) {
-	k.Conf.Logger.Info("Set Request", zap.Field{Key: req.Key, Type: zapcore.StringType})
 	return controller.SetKey(ctx, k.Conf, req)
 }
 
 // DeleteKey deletes the key
 func (k *KVHandler) DeleteKey(ctx context.Context, req *proto.DeleteKeyRequest) (*proto.DeleteKeyResponse, error) {
-	k.Conf.Logger.Info("Delete Request", zap.Field{Key: req.Key, Type: zapcore.StringType})
 	return controller.DeleteKey(ctx, k.Conf, req)
 }
+
+// ScanKeys returns the keys with a prefix in ascending order
+func (k *KVHandler) ScanKeys(ctx context.Context, req *proto.ScanKeysRequest) (*proto.ScanKeysResponse, error) {
+	return controller.ScanKeys(ctx, k.Conf, req)
+}
+
+// IncrementKey adds to or subtracts from a counter
+func (k *KVHandler) IncrementKey(ctx context.Context, req *proto.IncrementKeyRequest) (*proto.IncrementKeyResponse, error) {
+	return controller.IncrementKey(ctx, k.Conf, req)
+}
+
+// TouchKey changes the expiry of a key
+func (k *KVHandler) TouchKey(ctx context.Context, req *proto.TouchKeyRequest) (*proto.TouchKeyResponse, error) {
+	return controller.TouchKey(ctx, k.Conf, req)
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Services reported by the standard grpc.health.v1 service, besides the empty name for the whole node
+const (
+	LivenessService  = "liveness"  // LivenessService is serving as long as the node runs, including while it joins and drains
+	ReadinessService = "readiness" // ReadinessService is serving while the node is part of the cluster and accepts requests
+)
+
+// readinessServices report whether the node should receive requests. Load balancers usually check the empty name.
+var readinessServices = []string{"", ReadinessService, proto.KeyService_ServiceDesc.ServiceName}
+
+// healthState reports the liveness and readiness of the node over grpc.health.v1
+type healthState struct {
+	server *health.Server
+	joined atomic.Bool // joined is set once the node joined the cluster and stays set while it drains
+}
+
+// newHealthState returns a health state of a node that is alive but not ready yet
+func newHealthState() *healthState {
+	h := &healthState{server: health.NewServer()}
+	h.server.SetServingStatus(LivenessService, healthpb.HealthCheckResponse_SERVING)
+	h.setReadiness(healthpb.HealthCheckResponse_NOT_SERVING)
+	return h
+}
+
+// setReadiness sets the status of all readiness services
+func (h *healthState) setReadiness(status healthpb.HealthCheckResponse_ServingStatus) {
+	for _, service := range readinessServices {
+		h.server.SetServingStatus(service, status)
+	}
+}
+
+// markReady reports the node as ready once it joined the cluster
+func (h *healthState) markReady() {
+	h.joined.Store(true)
+	h.setReadiness(healthpb.HealthCheckResponse_SERVING)
+}
+
+// markDraining reports the node as not ready, so load balancers stop sending requests while
+// in-flight and late requests are still served
+func (h *healthState) markDraining() {
+	h.setReadiness(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// register adds the grpc.health.v1 service to a server
+func (h *healthState) register(server *grpc.Server) {
+	healthpb.RegisterHealthServer(server, h.server)
+}
+
+// shutdown reports every service as not serving, including liveness, once the node stopped
+func (h *healthState) shutdown() {
+	h.server.Shutdown()
+}
+
+// readinessUnaryInterceptor rejects key requests until the node joined the cluster, as it does not
+// know which node owns a key before
+func readinessUnaryInterceptor(h *healthState) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !h.joined.Load() && serviceName(info.FullMethod) == proto.KeyService_ServiceDesc.ServiceName {
+			return nil, constants.StatusErrNotReady
+		}
+		return handler(ctx, req)
+	}
+}
+
+// readinessStreamInterceptor rejects key streams until the node joined the cluster
+func readinessStreamInterceptor(h *healthState) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !h.joined.Load() && serviceName(info.FullMethod) == proto.KeyService_ServiceDesc.ServiceName {
+			return constants.StatusErrNotReady
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthState(t *testing.T) {
+	statusOf := func(h *healthState, service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := h.server.Check(context.TODO(), &healthpb.HealthCheckRequest{Service: service})
+		assert.NoError(t, err)
+		return resp.Status
+	}
+	h := newHealthState()
+
+	t.Run("Alive but not ready while joining", func(t *testing.T) {
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf(h, LivenessService))
+		for _, service := range []string{"", ReadinessService, "KeyService"} {
+			assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(h, service))
+		}
+	})
+
+	t.Run("Ready after joining", func(t *testing.T) {
+		h.markReady()
+		for _, service := range []string{"", LivenessService, ReadinessService, "KeyService"} {
+			assert.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf(h, service))
+		}
+	})
+
+	t.Run("Alive but not ready while draining", func(t *testing.T) {
+		h.markDraining()
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf(h, LivenessService))
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(h, ""))
+		assert.True(t, h.joined.Load(), "Requests are still served while draining")
+	})
+
+	t.Run("Not alive after shutdown", func(t *testing.T) {
+		h.shutdown()
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(h, LivenessService))
+	})
+}
+
+func TestReadinessUnaryInterceptor(t *testing.T) {
+	h := newHealthState()
+	interceptor := readinessUnaryInterceptor(h)
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	}
+	getKey := &grpc.UnaryServerInfo{FullMethod: proto.KeyService_GetKey_FullMethodName}
+	getState := &grpc.UnaryServerInfo{FullMethod: proto.ClusterService_GetClusterState_FullMethodName}
+
+	t.Run("Rejects key requests before joining", func(t *testing.T) {
+		_, err := interceptor(context.TODO(), nil, getKey, handler)
+		assert.Equal(t, constants.StatusErrNotReady, err)
+	})
+
+	t.Run("Serves cluster requests before joining", func(t *testing.T) {
+		resp, err := interceptor(context.TODO(), nil, getState, handler)
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", resp)
+	})
+
+	t.Run("Serves key requests after joining", func(t *testing.T) {
+		h.markReady()
+		resp, err := interceptor(context.TODO(), nil, getKey, handler)
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", resp)
+	})
+}
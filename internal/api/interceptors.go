@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/requestid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// backgroundServices are called periodically by other nodes, so their requests are only logged at debug level
+var backgroundServices = map[string]bool{
+	proto.ClusterService_ServiceDesc.ServiceName: true,
+	proto.HealthService_ServiceDesc.ServiceName:  true,
+}
+
+// keyRequest is implemented by all requests of KeyService
+type keyRequest interface {
+	GetKey() string
+}
+
+// withRequestID returns a context carrying the request ID sent by the caller, or a new one,
+// and returns the ID to the caller in the response header
+func withRequestID(ctx context.Context) context.Context {
+	id := requestid.FromIncomingContext(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(requestid.Header, id))
+	return requestid.NewContext(ctx, id)
+}
+
+// requestIDUnaryInterceptor assigns a request ID to every request
+func requestIDUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(withRequestID(ctx), req)
+}
+
+// requestIDStreamInterceptor assigns a request ID to every stream
+func requestIDStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: withRequestID(ss.Context())})
+}
+
+// hashKey returns a short hash identifying a key in logs without revealing it
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// logAccess logs a handled request. Server errors are logged at error level.
+func logAccess(l logger.Logging, ctx context.Context, fullMethod string, req any, start time.Time, err error) {
+	code := status.Code(err)
+	fields := []zap.Field{
+		zap.String("method", fullMethod),
+		zap.String("code", code.String()),
+		zap.Duration("latency", time.Since(start)),
+	}
+	if id, ok := requestid.FromContext(ctx); ok {
+		fields = append(fields, zap.String("requestId", id))
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+	if r, ok := req.(keyRequest); ok {
+		fields = append(fields, zap.String("keyHash", hashKey(r.GetKey())))
+	}
+
+	switch {
+	case code == codes.Internal || code == codes.Unknown || code == codes.DataLoss:
+		l.Error("Request failed", append(fields, zap.Error(err))...)
+	case backgroundServices[serviceName(fullMethod)]:
+		l.Debug("Request handled", fields...)
+	default:
+		l.Info("Request handled", fields...)
+	}
+}
+
+// accessLogUnaryInterceptor logs every request with its method, key hash, latency, status code and peer
+func accessLogUnaryInterceptor(l logger.Logging) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logAccess(l, ctx, info.FullMethod, req, start, err)
+		return resp, err
+	}
+}
+
+// accessLogStreamInterceptor logs every stream with its method, latency, status code and peer
+func accessLogStreamInterceptor(l logger.Logging) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logAccess(l, ss.Context(), info.FullMethod, nil, start, err)
+		return err
+	}
+}
+
+// recoverPanic turns a panic of a handler into an internal error, so it does not crash the node
+func recoverPanic(l logger.Logging, fullMethod string, err *error) {
+	if r := recover(); r != nil {
+		l.Error("Recovered from panic in handler", zap.String("method", fullMethod), zap.Any("panic", r), zap.Stack("stack"))
+		*err = constants.StatusErrInternal
+	}
+}
+
+// recoveryUnaryInterceptor returns codes.Internal for requests whose handler panics
+func recoveryUnaryInterceptor(l logger.Logging) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer recoverPanic(l, info.FullMethod, &err)
+		return handler(ctx, req)
+	}
+}
+
+// recoveryStreamInterceptor returns codes.Internal for streams whose handler panics
+func recoveryStreamInterceptor(l logger.Logging) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer recoverPanic(l, info.FullMethod, &err)
+		return handler(srv, ss)
+	}
+}
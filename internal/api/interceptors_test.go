@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/requestid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// panickingKeyService panics on GetKey and returns the request ID it sees on SetKey
+type panickingKeyService struct {
+	proto.UnimplementedKeyServiceServer
+}
+
+func (panickingKeyService) GetKey(ctx context.Context, req *proto.GetKeyRequest) (*proto.GetKeyResponse, error) {
+	panic("broken handler")
+}
+
+func (panickingKeyService) SetKey(ctx context.Context, req *proto.SetKeyRequest) (*proto.SetKeyResponse, error) {
+	id, _ := requestid.FromContext(ctx)
+	return &proto.SetKeyResponse{Key: id}, nil
+}
+
+func TestInterceptorChain(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	conf := &config.Config{Logger: &logger.Logger{Logger: zap.New(core)}, Settings: config.DefaultSettings()}
+	opts, err := serverOptions(conf)
+	assert.NoError(t, err)
+
+	server := grpc.NewServer(opts...)
+	proto.RegisterKeyServiceServer(server, panickingKeyService{})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	client := proto.NewKeyServiceClient(conn)
+
+	t.Run("Recovers panics as internal errors", func(t *testing.T) {
+		_, err := client.GetKey(context.Background(), &proto.GetKeyRequest{Key: "secret-key"})
+		assert.Equal(t, codes.Internal, status.Code(err))
+		assert.Equal(t, 1, logs.FilterMessage("Recovered from panic in handler").Len())
+
+		// The server keeps serving after a panic
+		_, err = client.SetKey(context.Background(), &proto.SetKeyRequest{Key: "key"})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Logs requests without the key", func(t *testing.T) {
+		entries := logs.FilterMessage("Request failed").FilterField(zap.String("keyHash", hashKey("secret-key"))).All()
+		assert.Len(t, entries, 1)
+		fields := entries[0].ContextMap()
+		assert.Equal(t, proto.KeyService_GetKey_FullMethodName, fields["method"])
+		assert.Equal(t, "Internal", fields["code"])
+		assert.Contains(t, fields, "latency")
+		assert.Contains(t, fields, "peer")
+		assert.Contains(t, fields, "requestId")
+		assert.NotContains(t, entries[0].Message, "secret-key")
+	})
+
+	t.Run("Uses the request ID of the caller", func(t *testing.T) {
+		var header metadata.MD
+		ctx := metadata.AppendToOutgoingContext(context.Background(), requestid.Header, "abc-123")
+		resp, err := client.SetKey(ctx, &proto.SetKeyRequest{Key: "key"}, grpc.Header(&header))
+		assert.NoError(t, err)
+		assert.Equal(t, "abc-123", resp.Key)
+		assert.Equal(t, []string{"abc-123"}, header.Get(requestid.Header))
+		assert.Equal(t, 1, logs.FilterField(zap.String("requestId", "abc-123")).Len())
+	})
+
+	t.Run("Generates a request ID", func(t *testing.T) {
+		var header metadata.MD
+		resp, err := client.SetKey(context.Background(), &proto.SetKeyRequest{Key: "key"}, grpc.Header(&header))
+		assert.NoError(t, err)
+		assert.NotEmpty(t, resp.Key)
+		assert.Equal(t, []string{resp.Key}, header.Get(requestid.Header))
+	})
+}
+
+func TestLogAccess(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := &logger.Logger{Logger: zap.New(core)}
+
+	t.Run("Logs client errors at info level", func(t *testing.T) {
+		logAccess(l, context.TODO(), proto.KeyService_GetKey_FullMethodName, &proto.GetKeyRequest{Key: "a"}, time.Now(), constants.StatusErrKeyNotFound)
+		entry := logs.TakeAll()[0]
+		assert.Equal(t, zapcore.InfoLevel, entry.Level)
+		assert.Equal(t, "NotFound", entry.ContextMap()["code"])
+	})
+
+	t.Run("Logs cluster traffic at debug level", func(t *testing.T) {
+		logAccess(l, context.TODO(), proto.ClusterService_ExchangeDigest_FullMethodName, nil, time.Now(), nil)
+		entry := logs.TakeAll()[0]
+		assert.Equal(t, zapcore.DebugLevel, entry.Level)
+		assert.NotContains(t, entry.ContextMap(), "keyHash")
+	})
+}
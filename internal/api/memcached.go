@@ -0,0 +1,467 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/api/controller"
+	"github.com/tdevsin/keyforge/internal/auth"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memcachedVersion is the memcached version whose protocol the listener is compatible with
+const memcachedVersion = "1.6.21"
+
+// maxMemcachedLine limits the length of a command line of the text protocol
+const maxMemcachedLine = 2048
+
+// maxRelativeExpiration is the largest expiration time memcached treats as seconds from now. Larger
+// times are unix timestamps.
+const maxRelativeExpiration = 60 * 60 * 24 * 30
+
+// Errors replied to memcached clients
+var (
+	errMemcachedFormat  = status.Error(codes.InvalidArgument, "bad command line format")
+	errMemcachedNoAuth  = status.Error(codes.Unauthenticated, "unauthenticated")
+	errMemcachedAuth    = status.Error(codes.Unauthenticated, "authentication failure")
+	errMemcachedTooBig  = status.Error(codes.InvalidArgument, "object too large for cache")
+	errMemcachedNumeric = status.Error(codes.InvalidArgument, "invalid numeric delta argument")
+	// errMemcachedBadChunk is returned for data blocks not followed by a line break. The connection
+	// is closed, as the start of the next command is unknown.
+	errMemcachedBadChunk = status.Error(codes.InvalidArgument, "bad data chunk")
+)
+
+// memcachedServer serves keys over the memcached text and binary protocols, so clients of a
+// memcached tier can move to a cluster unchanged. Like the Redis listener it maps commands onto
+// the controller, so any node routes keys to the node owning them.
+type memcachedServer struct {
+	*connServer
+	health *healthState
+}
+
+func newMemcachedServer(conf *config.Config, health *healthState) *memcachedServer {
+	return &memcachedServer{connServer: newConnServer("Memcached listener", conf), health: health}
+}
+
+// serve handles the connections of a listener until it is closed
+func (s *memcachedServer) serve(lis net.Listener) {
+	s.connServer.serve(lis, func(conn net.Conn) {
+		c := &memcachedConn{
+			server: s,
+			conn:   conn,
+			r:      bufio.NewReaderSize(conn, maxMemcachedLine),
+			w:      bufio.NewWriter(conn),
+		}
+		c.serve()
+	})
+}
+
+// memcachedConn is a client connection. The protocol is chosen by the first byte the client sends.
+type memcachedConn struct {
+	server  *memcachedServer
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	closing bool // closing is set by quit
+
+	principal *auth.Principal // principal is set once the client authenticated
+	token     string          // token is forwarded with requests proxied to other nodes
+}
+
+func (c *memcachedConn) serve() {
+	magic, err := c.r.Peek(1)
+	if err != nil {
+		return
+	}
+	if magic[0] == binaryRequestMagic {
+		c.serveBinary()
+	} else {
+		c.serveText()
+	}
+}
+
+// run runs a command with the checks the interceptors run for gRPC requests. The command writes
+// its reply on success, the caller replies with the error it returns.
+func (c *memcachedConn) run(method string, keys bool, command func(ctx context.Context) (any, error)) error {
+	start := time.Now()
+	fullMethod := "/Memcached/" + method
+	ctx := connContext(c.conn, c.principal, c.token)
+	ctx, span := tracing.Start(ctx, "memcached "+method, trace.WithSpanKind(trace.SpanKindServer))
+
+	var req any
+	err := func() (err error) {
+		defer recoverPanic(c.server.conf.Logger, fullMethod, &err)
+		if keys && c.server.conf.Authenticator != nil && c.principal == nil {
+			return errMemcachedNoAuth
+		}
+		if keys && !c.server.health.joined.Load() {
+			return constants.StatusErrNotReady
+		}
+		req, err = command(ctx)
+		return err
+	}()
+	tracing.End(span, err)
+	observeRPC(fullMethod, start, err)
+	logAccess(c.server.conf.Logger, ctx, fullMethod, req, start, err)
+	return err
+}
+
+// authenticate verifies a token and keeps its principal for the following commands. Clients send
+// the token as password, the username is ignored.
+func (c *memcachedConn) authenticate(token string) error {
+	if c.server.conf.Authenticator == nil {
+		return errMemcachedAuth
+	}
+	principal, err := c.server.conf.Authenticator.Authenticate(token)
+	if err != nil {
+		return errMemcachedAuth
+	}
+	c.principal = principal
+	c.token = token
+	return nil
+}
+
+// getItem reads a key and reports whether it exists
+func (c *memcachedConn) getItem(ctx context.Context, req *proto.GetKeyRequest) (*proto.GetKeyResponse, bool, error) {
+	resp, err := controller.GetKey(ctx, c.server.conf, req)
+	if status.Code(err) == codes.NotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return resp, true, nil
+}
+
+// memcachedTTL converts a memcached expiration time to a TTL in milliseconds. Zero never expires,
+// times up to 30 days are relative and larger ones are unix timestamps. Times in the past expire
+// the key immediately.
+func memcachedTTL(exptime int64, now time.Time) int64 {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		return 1
+	case exptime <= maxRelativeExpiration:
+		return exptime * 1000
+	}
+	ttl := time.Unix(exptime, 0).Sub(now).Milliseconds()
+	return max(ttl, 1)
+}
+
+// memcachedTextCommands are the commands of the text protocol. Storage commands read a data block
+// after the command line.
+var memcachedTextCommands = map[string]func(c *memcachedConn, args []string) error{
+	"get":       (*memcachedConn).textGet,
+	"gets":      (*memcachedConn).textGet,
+	"set":       (*memcachedConn).textStore,
+	"add":       (*memcachedConn).textStore,
+	"replace":   (*memcachedConn).textStore,
+	"cas":       (*memcachedConn).textStore,
+	"delete":    (*memcachedConn).textDelete,
+	"incr":      (*memcachedConn).textIncrement,
+	"decr":      (*memcachedConn).textIncrement,
+	"touch":     (*memcachedConn).textTouch,
+	"version":   (*memcachedConn).textVersion,
+	"verbosity": (*memcachedConn).textVerbosity,
+	"quit":      (*memcachedConn).textQuit,
+}
+
+// serveText handles commands of the text protocol until the client disconnects or sends quit
+func (c *memcachedConn) serveText() {
+	for !c.closing {
+		line, err := c.r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			c.w.WriteString("CLIENT_ERROR line too long\r\n")
+			c.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		args := strings.Fields(string(line))
+		if len(args) == 0 {
+			c.w.WriteString("ERROR\r\n")
+		} else if command, ok := memcachedTextCommands[args[0]]; !ok {
+			c.w.WriteString("ERROR\r\n")
+		} else if err := command(c, args); err != nil {
+			c.writeTextError(err)
+			if errors.Is(err, errMemcachedBadChunk) {
+				c.w.Flush()
+				return
+			}
+		}
+		// Replies of pipelined commands are sent together
+		if c.r.Buffered() == 0 || c.closing {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeTextError replies with an error. Invalid requests are client errors, anything else is a server error.
+func (c *memcachedConn) writeTextError(err error) {
+	s := status.Convert(err)
+	switch s.Code() {
+	case codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied, codes.FailedPrecondition:
+		c.w.WriteString("CLIENT_ERROR " + s.Message() + "\r\n")
+	default:
+		c.w.WriteString("SERVER_ERROR " + s.Message() + "\r\n")
+	}
+}
+
+// noreply reports whether the last argument asks to not reply on success
+func noreply(args []string, n int) bool {
+	return len(args) == n+1 && args[n] == "noreply"
+}
+
+// reply writes a line unless the client asked for no reply
+func (c *memcachedConn) reply(line string, quiet bool) {
+	if !quiet {
+		c.w.WriteString(line + "\r\n")
+	}
+}
+
+// textGet replies with the values of keys: get|gets <key>*. gets includes the version of every
+// value, which clients pass to cas.
+func (c *memcachedConn) textGet(args []string) error {
+	if len(args) < 2 {
+		return errMemcachedFormat
+	}
+	return c.run(strings.ToUpper(args[0]), true, func(ctx context.Context) (any, error) {
+		var req *proto.GetKeyRequest
+		// Values are read before replying, so a failing key does not leave a partial reply
+		var items [][]byte
+		for _, key := range args[1:] {
+			req = &proto.GetKeyRequest{Key: key}
+			resp, found, err := c.getItem(ctx, req)
+			if err != nil {
+				return req, err
+			}
+			if !found {
+				continue
+			}
+			line := "VALUE " + key + " " + strconv.FormatUint(uint64(resp.Flags), 10) + " " + strconv.Itoa(len(resp.Value))
+			if args[0] == "gets" {
+				line += " " + strconv.FormatInt(resp.Version, 10)
+			}
+			items = append(items, []byte(line+"\r\n"), resp.Value, []byte("\r\n"))
+		}
+		for _, item := range items {
+			c.w.Write(item)
+		}
+		c.w.WriteString("END\r\n")
+		return req, nil
+	})
+}
+
+// textStore writes a key: set|add|replace <key> <flags> <exptime> <bytes> [noreply] or
+// cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (c *memcachedConn) textStore(args []string) error {
+	n := 5
+	if args[0] == "cas" {
+		n = 6
+	}
+	if len(args) != n && !noreply(args, n) {
+		return errMemcachedFormat
+	}
+	flags, err1 := strconv.ParseUint(args[2], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[3], 10, 64)
+	size, err3 := strconv.Atoi(args[4])
+	if err := errors.Join(err1, err2, err3); err != nil || size < 0 {
+		return errMemcachedFormat
+	}
+	if size > maxBodySize {
+		// The data is skipped, so the connection can be used for the next command
+		if _, err := io.CopyN(io.Discard, c.r, int64(size)+2); err != nil {
+			return errMemcachedBadChunk
+		}
+		return errMemcachedTooBig
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil || !bytes.HasSuffix(data, []byte("\r\n")) {
+		return errMemcachedBadChunk
+	}
+	data = data[:size]
+	quiet := noreply(args, n)
+
+	// With authentication enabled, clients authenticate by setting any key to "<username> <password>"
+	if c.server.conf.Authenticator != nil && c.principal == nil {
+		if args[0] != "set" {
+			return errMemcachedNoAuth
+		}
+		return c.run("AUTH", false, func(ctx context.Context) (any, error) {
+			fields := strings.Fields(string(data))
+			if len(fields) == 0 {
+				return nil, errMemcachedAuth
+			}
+			if err := c.authenticate(fields[len(fields)-1]); err != nil {
+				return nil, err
+			}
+			c.reply("STORED", quiet)
+			return nil, nil
+		})
+	}
+
+	req := &proto.SetKeyRequest{Key: args[1], Value: data, Flags: uint32(flags), TtlMs: memcachedTTL(exptime, time.Now())}
+	switch args[0] {
+	case "add":
+		req.Condition = proto.SetKeyRequest_IF_ABSENT
+	case "replace":
+		req.Condition = proto.SetKeyRequest_IF_PRESENT
+	case "cas":
+		version, err := strconv.ParseInt(args[5], 10, 64)
+		if err != nil {
+			return errMemcachedFormat
+		}
+		req.Condition = proto.SetKeyRequest_IF_VERSION
+		req.Version = version
+	}
+	return c.run(strings.ToUpper(args[0]), true, func(ctx context.Context) (any, error) {
+		_, err := controller.SetKey(ctx, c.server.conf, req)
+		switch {
+		case err == nil:
+			c.reply("STORED", quiet)
+		case req.Condition == proto.SetKeyRequest_IF_VERSION && status.Code(err) == codes.FailedPrecondition:
+			c.reply("EXISTS", quiet)
+		case req.Condition == proto.SetKeyRequest_IF_VERSION && status.Code(err) == codes.NotFound:
+			c.reply("NOT_FOUND", quiet)
+		case status.Code(err) == codes.FailedPrecondition:
+			c.reply("NOT_STORED", quiet)
+		default:
+			return req, err
+		}
+		return req, nil
+	})
+}
+
+// textDelete deletes a key: delete <key> [noreply]
+func (c *memcachedConn) textDelete(args []string) error {
+	// Old clients send a time after the key, which memcached accepts if it is zero
+	if len(args) > 2 && args[2] == "0" {
+		args = append(args[:2:2], args[3:]...)
+	}
+	if len(args) != 2 && !noreply(args, 2) {
+		return errMemcachedFormat
+	}
+	quiet := noreply(args, 2)
+	req := &proto.DeleteKeyRequest{Key: args[1]}
+	return c.run("DELETE", true, func(ctx context.Context) (any, error) {
+		resp, err := controller.DeleteKey(ctx, c.server.conf, req)
+		if err != nil {
+			return req, err
+		}
+		if resp.Found {
+			c.reply("DELETED", quiet)
+		} else {
+			c.reply("NOT_FOUND", quiet)
+		}
+		return req, nil
+	})
+}
+
+// textIncrement adds to or subtracts from a counter: incr|decr <key> <value> [noreply]
+func (c *memcachedConn) textIncrement(args []string) error {
+	if len(args) != 3 && !noreply(args, 3) {
+		return errMemcachedFormat
+	}
+	delta, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return errMemcachedNumeric
+	}
+	quiet := noreply(args, 3)
+	req := &proto.IncrementKeyRequest{Key: args[1], Delta: delta, Decrement: args[0] == "decr"}
+	return c.run(strings.ToUpper(args[0]), true, func(ctx context.Context) (any, error) {
+		resp, err := controller.IncrementKey(ctx, c.server.conf, req)
+		switch {
+		case err == nil:
+			c.reply(strconv.FormatUint(resp.Value, 10), quiet)
+		case status.Code(err) == codes.NotFound:
+			c.reply("NOT_FOUND", quiet)
+		case errors.Is(err, constants.StatusErrNotNumber):
+			return req, status.Error(codes.FailedPrecondition, "cannot increment or decrement non-numeric value")
+		default:
+			return req, err
+		}
+		return req, nil
+	})
+}
+
+// textTouch changes the expiration of a key: touch <key> <exptime> [noreply]
+func (c *memcachedConn) textTouch(args []string) error {
+	if len(args) != 3 && !noreply(args, 3) {
+		return errMemcachedFormat
+	}
+	exptime, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errMemcachedFormat
+	}
+	quiet := noreply(args, 3)
+	req := &proto.TouchKeyRequest{Key: args[1], TtlMs: memcachedTTL(exptime, time.Now())}
+	return c.run("TOUCH", true, func(ctx context.Context) (any, error) {
+		_, err := controller.TouchKey(ctx, c.server.conf, req)
+		switch {
+		case err == nil:
+			c.reply("TOUCHED", quiet)
+		case status.Code(err) == codes.NotFound:
+			c.reply("NOT_FOUND", quiet)
+		default:
+			return req, err
+		}
+		return req, nil
+	})
+}
+
+func (c *memcachedConn) textVersion(args []string) error {
+	c.w.WriteString("VERSION " + memcachedVersion + "\r\n")
+	return nil
+}
+
+// textVerbosity accepts and ignores the logging level clients set
+func (c *memcachedConn) textVerbosity(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errMemcachedFormat
+	}
+	c.reply("OK", noreply(args, 2))
+	return nil
+}
+
+func (c *memcachedConn) textQuit(args []string) error {
+	c.closing = true
+	return nil
+}
+
+func startMemcachedServer(conf *config.Config, health *healthState) (func(), error) {
+	lis, err := listenTLS(conf, conf.Settings.Memcached.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	server := newMemcachedServer(conf, health)
+	conf.Logger.Info("Starting memcached listener", zap.String("address", lis.Addr().String()), zap.Bool("tls", conf.Certificates != nil))
+	go server.serve(lis)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			lis.Close()
+			server.shutdown(conf.Settings.Server.DrainTimeout)
+		})
+	}, nil
+}
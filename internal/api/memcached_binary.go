@@ -0,0 +1,363 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/api/controller"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Magic bytes starting requests and responses of the binary protocol
+const (
+	binaryRequestMagic  = 0x80
+	binaryResponseMagic = 0x81
+)
+
+// binaryHeaderSize is the size of the header of every request and response
+const binaryHeaderSize = 24
+
+// Statuses of binary responses
+const (
+	binaryStatusOK          = 0x00
+	binaryStatusNotFound    = 0x01
+	binaryStatusExists      = 0x02
+	binaryStatusTooLarge    = 0x03
+	binaryStatusInvalid     = 0x04
+	binaryStatusNonNumeric  = 0x06
+	binaryStatusAuthError   = 0x20
+	binaryStatusUnknown     = 0x81
+	binaryStatusInternal    = 0x84
+	binaryStatusUnavailable = 0x86
+)
+
+// noCreateExpiration is the expiration of incr and decr requests that fail for missing counters
+// instead of creating them
+const noCreateExpiration = 0xffffffff
+
+// binaryRequest is a request of the binary protocol
+type binaryRequest struct {
+	opcode byte
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+// binaryCommand handles an opcode of the binary protocol. Quiet commands do not reply on success,
+// quiet gets do not reply on misses.
+type binaryCommand struct {
+	method  string
+	quiet   bool
+	extras  int  // extras is the length of the extras the request must have
+	key     bool // key is set for requests that must have a key
+	value   bool // value is set for requests that may have a value
+	handler func(c *memcachedConn, req *binaryRequest, command binaryCommand) error
+}
+
+// binaryCommands are the supported opcodes
+var binaryCommands = map[byte]binaryCommand{
+	0x00: {method: "GET", key: true, handler: (*memcachedConn).binaryGet},
+	0x09: {method: "GET", quiet: true, key: true, handler: (*memcachedConn).binaryGet},
+	0x0c: {method: "GET", key: true, handler: (*memcachedConn).binaryGet},
+	0x0d: {method: "GET", quiet: true, key: true, handler: (*memcachedConn).binaryGet},
+	0x01: {method: "SET", extras: 8, key: true, value: true, handler: (*memcachedConn).binaryStore},
+	0x11: {method: "SET", quiet: true, extras: 8, key: true, value: true, handler: (*memcachedConn).binaryStore},
+	0x02: {method: "ADD", extras: 8, key: true, value: true, handler: (*memcachedConn).binaryStore},
+	0x12: {method: "ADD", quiet: true, extras: 8, key: true, value: true, handler: (*memcachedConn).binaryStore},
+	0x03: {method: "REPLACE", extras: 8, key: true, value: true, handler: (*memcachedConn).binaryStore},
+	0x13: {method: "REPLACE", quiet: true, extras: 8, key: true, value: true, handler: (*memcachedConn).binaryStore},
+	0x04: {method: "DELETE", key: true, handler: (*memcachedConn).binaryDelete},
+	0x14: {method: "DELETE", quiet: true, key: true, handler: (*memcachedConn).binaryDelete},
+	0x05: {method: "INCR", extras: 20, key: true, handler: (*memcachedConn).binaryIncrement},
+	0x15: {method: "INCR", quiet: true, extras: 20, key: true, handler: (*memcachedConn).binaryIncrement},
+	0x06: {method: "DECR", extras: 20, key: true, handler: (*memcachedConn).binaryIncrement},
+	0x16: {method: "DECR", quiet: true, extras: 20, key: true, handler: (*memcachedConn).binaryIncrement},
+	0x1c: {method: "TOUCH", extras: 4, key: true, handler: (*memcachedConn).binaryTouch},
+	0x07: {method: "QUIT", handler: (*memcachedConn).binaryQuit},
+	0x17: {method: "QUIT", quiet: true, handler: (*memcachedConn).binaryQuit},
+	0x0a: {method: "NOOP", handler: (*memcachedConn).binaryNoop},
+	0x0b: {method: "VERSION", handler: (*memcachedConn).binaryVersion},
+	0x20: {method: "SASL_LIST_MECHS", handler: (*memcachedConn).binarySASLList},
+	0x21: {method: "SASL_AUTH", key: true, value: true, handler: (*memcachedConn).binarySASLAuth},
+}
+
+// serveBinary handles requests of the binary protocol until the client disconnects or sends quit
+func (c *memcachedConn) serveBinary() {
+	for !c.closing {
+		req, err := c.readBinaryRequest()
+		if err != nil {
+			if errors.Is(err, errMemcachedTooBig) {
+				c.writeBinaryError(req, err)
+				c.w.Flush()
+			}
+			return
+		}
+		command, ok := binaryCommands[req.opcode]
+		switch {
+		case !ok:
+			c.writeBinary(req, binaryStatusUnknown, nil, nil, []byte("Unknown command"), 0)
+		case len(req.extras) != command.extras || (len(req.key) > 0) != command.key || (len(req.value) > 0 && !command.value):
+			c.writeBinary(req, binaryStatusInvalid, nil, nil, []byte("Invalid arguments"), 0)
+		default:
+			if err := command.handler(c, req, command); err != nil {
+				c.writeBinaryError(req, err)
+			}
+		}
+		// Replies of pipelined requests are sent together
+		if c.r.Buffered() == 0 || c.closing {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readBinaryRequest reads the header and body of a request. Requests whose body is too large fail
+// with errMemcachedTooBig, as the connection can not be used afterward.
+func (c *memcachedConn) readBinaryRequest() (*binaryRequest, error) {
+	var header [binaryHeaderSize]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != binaryRequestMagic {
+		return nil, errors.New("invalid magic byte")
+	}
+	req := &binaryRequest{
+		opcode: header[1],
+		opaque: binary.BigEndian.Uint32(header[12:16]),
+		cas:    binary.BigEndian.Uint64(header[16:24]),
+	}
+	keyLength := int(binary.BigEndian.Uint16(header[2:4]))
+	extrasLength := int(header[4])
+	bodyLength := int(binary.BigEndian.Uint32(header[8:12]))
+	if bodyLength < keyLength+extrasLength {
+		return req, errors.New("invalid body length")
+	}
+	if bodyLength > maxBodySize+keyLength+extrasLength {
+		return req, errMemcachedTooBig
+	}
+	body := make([]byte, bodyLength)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+	req.extras = body[:extrasLength]
+	req.key = body[extrasLength : extrasLength+keyLength]
+	req.value = body[extrasLength+keyLength:]
+	return req, nil
+}
+
+// writeBinary writes a response to a request
+func (c *memcachedConn) writeBinary(req *binaryRequest, status uint16, extras, key, value []byte, cas uint64) {
+	var header [binaryHeaderSize]byte
+	header[0] = binaryResponseMagic
+	header[1] = req.opcode
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(header[6:8], status)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:16], req.opaque)
+	binary.BigEndian.PutUint64(header[16:24], cas)
+	c.w.Write(header[:])
+	c.w.Write(extras)
+	c.w.Write(key)
+	c.w.Write(value)
+}
+
+// writeBinaryError replies with the status matching an error and its message. Errors are sent for
+// quiet requests too.
+func (c *memcachedConn) writeBinaryError(req *binaryRequest, err error) {
+	s := status.Convert(err)
+	code := uint16(binaryStatusInternal)
+	switch {
+	case errors.Is(err, errMemcachedTooBig):
+		code = binaryStatusTooLarge
+	case errors.Is(err, constants.StatusErrNotNumber):
+		code = binaryStatusNonNumeric
+	case s.Code() == codes.InvalidArgument:
+		code = binaryStatusInvalid
+	case s.Code() == codes.Unauthenticated, s.Code() == codes.PermissionDenied:
+		code = binaryStatusAuthError
+	case s.Code() == codes.Unavailable:
+		code = binaryStatusUnavailable
+	}
+	c.writeBinary(req, code, nil, nil, []byte(s.Message()), 0)
+}
+
+// binaryGet replies with the flags and value of a key, and the key itself for GetK and GetKQ
+func (c *memcachedConn) binaryGet(req *binaryRequest, command binaryCommand) error {
+	withKey := req.opcode == 0x0c || req.opcode == 0x0d
+	getReq := &proto.GetKeyRequest{Key: string(req.key)}
+	return c.run(command.method, true, func(ctx context.Context) (any, error) {
+		resp, found, err := c.getItem(ctx, getReq)
+		if err != nil {
+			return getReq, err
+		}
+		var key []byte
+		if withKey {
+			key = req.key
+		}
+		if !found {
+			if !command.quiet {
+				c.writeBinary(req, binaryStatusNotFound, nil, key, []byte("Not found"), 0)
+			}
+			return getReq, nil
+		}
+		extras := binary.BigEndian.AppendUint32(nil, resp.Flags)
+		c.writeBinary(req, binaryStatusOK, extras, key, resp.Value, uint64(resp.Version))
+		return getReq, nil
+	})
+}
+
+// binaryStore writes a key. A cas in the header makes the write conditional on the version of the key.
+func (c *memcachedConn) binaryStore(req *binaryRequest, command binaryCommand) error {
+	setReq := &proto.SetKeyRequest{
+		Key:   string(req.key),
+		Value: req.value,
+		Flags: binary.BigEndian.Uint32(req.extras[0:4]),
+		TtlMs: memcachedTTL(int64(binary.BigEndian.Uint32(req.extras[4:8])), time.Now()),
+	}
+	switch {
+	case command.method == "ADD":
+		setReq.Condition = proto.SetKeyRequest_IF_ABSENT
+	case req.cas != 0:
+		setReq.Condition = proto.SetKeyRequest_IF_VERSION
+		setReq.Version = int64(req.cas)
+	case command.method == "REPLACE":
+		setReq.Condition = proto.SetKeyRequest_IF_PRESENT
+	}
+	return c.run(command.method, true, func(ctx context.Context) (any, error) {
+		_, err := controller.SetKey(ctx, c.server.conf, setReq)
+		switch {
+		case err == nil:
+			if !command.quiet {
+				c.writeBinary(req, binaryStatusOK, nil, nil, nil, 0)
+			}
+		case status.Code(err) == codes.NotFound && setReq.Condition == proto.SetKeyRequest_IF_VERSION,
+			status.Code(err) == codes.FailedPrecondition && setReq.Condition == proto.SetKeyRequest_IF_PRESENT:
+			c.writeBinary(req, binaryStatusNotFound, nil, nil, []byte("Not found"), 0)
+		case status.Code(err) == codes.FailedPrecondition:
+			c.writeBinary(req, binaryStatusExists, nil, nil, []byte("Data exists for key"), 0)
+		default:
+			return setReq, err
+		}
+		return setReq, nil
+	})
+}
+
+func (c *memcachedConn) binaryDelete(req *binaryRequest, command binaryCommand) error {
+	if req.cas != 0 {
+		return status.Error(codes.InvalidArgument, "Delete with cas is not supported")
+	}
+	deleteReq := &proto.DeleteKeyRequest{Key: string(req.key)}
+	return c.run(command.method, true, func(ctx context.Context) (any, error) {
+		resp, err := controller.DeleteKey(ctx, c.server.conf, deleteReq)
+		if err != nil {
+			return deleteReq, err
+		}
+		if !resp.Found {
+			c.writeBinary(req, binaryStatusNotFound, nil, nil, []byte("Not found"), 0)
+		} else if !command.quiet {
+			c.writeBinary(req, binaryStatusOK, nil, nil, nil, 0)
+		}
+		return deleteReq, nil
+	})
+}
+
+// binaryIncrement adds to or subtracts from a counter. Missing counters are created with the
+// initial value of the request, unless its expiration is noCreateExpiration.
+func (c *memcachedConn) binaryIncrement(req *binaryRequest, command binaryCommand) error {
+	exptime := binary.BigEndian.Uint32(req.extras[16:20])
+	incrementReq := &proto.IncrementKeyRequest{
+		Key:       string(req.key),
+		Delta:     binary.BigEndian.Uint64(req.extras[0:8]),
+		Decrement: command.method == "DECR",
+		Create:    exptime != noCreateExpiration,
+		Initial:   binary.BigEndian.Uint64(req.extras[8:16]),
+	}
+	if incrementReq.Create {
+		incrementReq.TtlMs = memcachedTTL(int64(exptime), time.Now())
+	}
+	return c.run(command.method, true, func(ctx context.Context) (any, error) {
+		resp, err := controller.IncrementKey(ctx, c.server.conf, incrementReq)
+		switch {
+		case err == nil:
+			if !command.quiet {
+				c.writeBinary(req, binaryStatusOK, nil, nil, binary.BigEndian.AppendUint64(nil, resp.Value), uint64(resp.Version))
+			}
+		case status.Code(err) == codes.NotFound:
+			c.writeBinary(req, binaryStatusNotFound, nil, nil, []byte("Not found"), 0)
+		default:
+			return incrementReq, err
+		}
+		return incrementReq, nil
+	})
+}
+
+func (c *memcachedConn) binaryTouch(req *binaryRequest, command binaryCommand) error {
+	touchReq := &proto.TouchKeyRequest{Key: string(req.key), TtlMs: memcachedTTL(int64(binary.BigEndian.Uint32(req.extras)), time.Now())}
+	return c.run("TOUCH", true, func(ctx context.Context) (any, error) {
+		_, err := controller.TouchKey(ctx, c.server.conf, touchReq)
+		switch {
+		case err == nil:
+			c.writeBinary(req, binaryStatusOK, nil, nil, nil, 0)
+		case status.Code(err) == codes.NotFound:
+			c.writeBinary(req, binaryStatusNotFound, nil, nil, []byte("Not found"), 0)
+		default:
+			return touchReq, err
+		}
+		return touchReq, nil
+	})
+}
+
+func (c *memcachedConn) binaryQuit(req *binaryRequest, command binaryCommand) error {
+	c.closing = true
+	if !command.quiet {
+		c.writeBinary(req, binaryStatusOK, nil, nil, nil, 0)
+	}
+	return nil
+}
+
+// binaryNoop replies right away. Clients send it after quiet requests to learn they are done.
+func (c *memcachedConn) binaryNoop(req *binaryRequest, command binaryCommand) error {
+	c.writeBinary(req, binaryStatusOK, nil, nil, nil, 0)
+	return nil
+}
+
+func (c *memcachedConn) binaryVersion(req *binaryRequest, command binaryCommand) error {
+	c.writeBinary(req, binaryStatusOK, nil, nil, []byte(memcachedVersion), 0)
+	return nil
+}
+
+// binarySASLList replies with the supported SASL mechanisms
+func (c *memcachedConn) binarySASLList(req *binaryRequest, command binaryCommand) error {
+	if c.server.conf.Authenticator == nil {
+		return status.Error(codes.InvalidArgument, "Authentication is not enabled")
+	}
+	c.writeBinary(req, binaryStatusOK, nil, nil, []byte("PLAIN"), 0)
+	return nil
+}
+
+// binarySASLAuth authenticates with the PLAIN mechanism, whose password is the token
+func (c *memcachedConn) binarySASLAuth(req *binaryRequest, command binaryCommand) error {
+	return c.run("SASL_AUTH", false, func(ctx context.Context) (any, error) {
+		// The value is the authorization identity, username and password separated by null bytes
+		fields := bytes.Split(req.value, []byte{0})
+		if string(req.key) != "PLAIN" || len(fields) != 3 {
+			return nil, errMemcachedAuth
+		}
+		if err := c.authenticate(string(fields[2])); err != nil {
+			return nil, err
+		}
+		c.writeBinary(req, binaryStatusOK, nil, nil, []byte("Authenticated"), 0)
+		return nil, nil
+	})
+}
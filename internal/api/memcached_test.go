@@ -0,0 +1,319 @@
+package api
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tdevsin/keyforge/internal/auth"
+)
+
+// newMemcachedListener starts the memcached listener of a single node cluster storing keys in a new database
+func newMemcachedListener(t *testing.T, authenticator auth.Authenticator, ready bool) string {
+	conf, health := newListenerConfig(t, authenticator, ready)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := newMemcachedServer(conf, health)
+	go server.serve(lis)
+	t.Cleanup(func() {
+		lis.Close()
+		server.shutdown(time.Second)
+	})
+	return lis.Addr().String()
+}
+
+// memcachedClient sends commands of the text protocol and reads the reply lines
+type memcachedClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialMemcached(t *testing.T, address string) *memcachedClient {
+	conn, err := net.Dial("tcp", address)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &memcachedClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a command and returns the lines of its reply up to and including the last one
+func (c *memcachedClient) do(command string, last ...string) []string {
+	_, err := c.conn.Write([]byte(command))
+	assert.NoError(c.t, err)
+	var lines []string
+	for {
+		line := c.line()
+		lines = append(lines, line)
+		if len(last) == 0 || line == last[0] || strings.Contains(line, "ERROR") {
+			return lines
+		}
+	}
+}
+
+func (c *memcachedClient) line() string {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	assert.NoError(c.t, err)
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+func TestMemcachedTextCommands(t *testing.T) {
+	c := dialMemcached(t, newMemcachedListener(t, nil, true))
+
+	t.Run("set and get", func(t *testing.T) {
+		assert.Equal(t, []string{"STORED"}, c.do("set user/1 42 0 5\r\nalice\r\n"))
+		assert.Equal(t, []string{"VALUE user/1 42 5", "alice", "END"}, c.do("get user/1 missing\r\n", "END"))
+		assert.Equal(t, []string{"END"}, c.do("get missing\r\n", "END"))
+	})
+
+	t.Run("add and replace", func(t *testing.T) {
+		assert.Equal(t, []string{"NOT_STORED"}, c.do("add user/1 0 0 3\r\nbob\r\n"))
+		assert.Equal(t, []string{"STORED"}, c.do("add user/2 0 0 3\r\nbob\r\n"))
+		assert.Equal(t, []string{"NOT_STORED"}, c.do("replace user/3 0 0 5\r\ncarol\r\n"))
+		assert.Equal(t, []string{"STORED"}, c.do("replace user/2 0 0 5\r\nbobby\r\n"))
+	})
+
+	t.Run("gets and cas", func(t *testing.T) {
+		lines := c.do("gets user/2\r\n", "END")
+		fields := strings.Fields(lines[0])
+		assert.Len(t, fields, 5)
+		cas := fields[4]
+		assert.Equal(t, []string{"EXISTS"}, c.do("cas user/2 0 0 1 1\r\nx\r\n"))
+		assert.Equal(t, []string{"NOT_FOUND"}, c.do("cas user/3 0 0 1 "+cas+"\r\nx\r\n"))
+		assert.Equal(t, []string{"STORED"}, c.do("cas user/2 0 0 1 "+cas+"\r\nx\r\n"))
+		assert.Equal(t, []string{"EXISTS"}, c.do("cas user/2 0 0 1 "+cas+"\r\ny\r\n"), "The version changes with every write")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		assert.Equal(t, []string{"DELETED"}, c.do("delete user/2\r\n"))
+		assert.Equal(t, []string{"NOT_FOUND"}, c.do("delete user/2\r\n"))
+	})
+
+	t.Run("incr and decr", func(t *testing.T) {
+		assert.Equal(t, []string{"NOT_FOUND"}, c.do("incr counter 1\r\n"))
+		c.do("set counter 0 0 2\r\n10\r\n")
+		assert.Equal(t, []string{"15"}, c.do("incr counter 5\r\n"))
+		assert.Equal(t, []string{"0"}, c.do("decr counter 20\r\n"))
+		assert.Equal(t, []string{"CLIENT_ERROR cannot increment or decrement non-numeric value"}, c.do("incr user/1 1\r\n"))
+		assert.Equal(t, []string{"CLIENT_ERROR invalid numeric delta argument"}, c.do("incr counter -1\r\n"))
+	})
+
+	t.Run("expiration and touch", func(t *testing.T) {
+		assert.Equal(t, []string{"STORED"}, c.do("set session 0 -1 1\r\n1\r\n"))
+		// Expired keys get a TTL of a millisecond
+		time.Sleep(10 * time.Millisecond)
+		assert.Equal(t, []string{"END"}, c.do("get session\r\n", "END"))
+		c.do("set session 0 100 1\r\n1\r\n")
+		assert.Equal(t, []string{"TOUCHED"}, c.do("touch session -1\r\n"))
+		time.Sleep(10 * time.Millisecond)
+		assert.Equal(t, []string{"END"}, c.do("get session\r\n", "END"))
+		assert.Equal(t, []string{"NOT_FOUND"}, c.do("touch session 100\r\n"))
+	})
+
+	t.Run("noreply", func(t *testing.T) {
+		c.conn.Write([]byte("set quiet 0 0 1 noreply\r\n1\r\ndelete missing noreply\r\n"))
+		assert.Equal(t, []string{"VALUE quiet 0 1", "1", "END"}, c.do("get quiet\r\n", "END"))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		assert.Equal(t, []string{"ERROR"}, c.do("flush_all\r\n"))
+		assert.Equal(t, []string{"CLIENT_ERROR bad command line format"}, c.do("set a b 0 1\r\n"))
+		assert.Equal(t, []string{"CLIENT_ERROR Key is invalid"}, c.do("get \x00exp/\r\n"))
+		assert.Equal(t, []string{"VERSION " + memcachedVersion}, c.do("version\r\n"))
+	})
+
+	t.Run("Bad data chunk closes the connection", func(t *testing.T) {
+		c := dialMemcached(t, newMemcachedListener(t, nil, true))
+		assert.Equal(t, []string{"CLIENT_ERROR bad data chunk"}, c.do("set a 0 0 1\r\nabc\r\n"))
+		_, err := c.r.ReadByte()
+		assert.Error(t, err)
+	})
+}
+
+func TestMemcachedTextAuthentication(t *testing.T) {
+	c := dialMemcached(t, newMemcachedListener(t, fakeAuthenticator{"secret": "alice"}, true))
+	assert.Equal(t, []string{"CLIENT_ERROR unauthenticated"}, c.do("get a\r\n"))
+	assert.Equal(t, []string{"CLIENT_ERROR authentication failure"}, c.do("set auth 0 0 11\r\nalice wrong\r\n"))
+	assert.Equal(t, []string{"STORED"}, c.do("set auth 0 0 12\r\nalice secret\r\n"))
+	assert.Equal(t, []string{"END"}, c.do("get a\r\n", "END"))
+}
+
+func TestMemcachedNotReady(t *testing.T) {
+	c := dialMemcached(t, newMemcachedListener(t, nil, false))
+	assert.Equal(t, []string{"VERSION " + memcachedVersion}, c.do("version\r\n"))
+	assert.Equal(t, []string{"SERVER_ERROR Node has not joined the cluster yet"}, c.do("get a\r\n"))
+}
+
+// binaryResponse is a response of the binary protocol
+type binaryResponse struct {
+	opcode byte
+	status uint16
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    string
+	value  []byte
+}
+
+// sendBinary writes a request of the binary protocol
+func sendBinary(t *testing.T, conn net.Conn, opcode byte, opaque uint32, cas uint64, extras []byte, key, value string) {
+	header := make([]byte, binaryHeaderSize)
+	header[0] = binaryRequestMagic
+	header[1] = opcode
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:16], opaque)
+	binary.BigEndian.PutUint64(header[16:24], cas)
+	_, err := conn.Write(append(append(append(header, extras...), key...), value...))
+	assert.NoError(t, err)
+}
+
+func readBinary(t *testing.T, r *bufio.Reader) binaryResponse {
+	header := make([]byte, binaryHeaderSize)
+	_, err := io.ReadFull(r, header)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(binaryResponseMagic), header[0])
+	body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+	_, err = io.ReadFull(r, body)
+	assert.NoError(t, err)
+	keyLength := int(binary.BigEndian.Uint16(header[2:4]))
+	extrasLength := int(header[4])
+	return binaryResponse{
+		opcode: header[1],
+		status: binary.BigEndian.Uint16(header[6:8]),
+		opaque: binary.BigEndian.Uint32(header[12:16]),
+		cas:    binary.BigEndian.Uint64(header[16:24]),
+		extras: body[:extrasLength],
+		key:    string(body[extrasLength : extrasLength+keyLength]),
+		value:  body[extrasLength+keyLength:],
+	}
+}
+
+// storeExtras returns the extras of a set, add or replace request
+func storeExtras(flags, exptime uint32) []byte {
+	return binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, flags), exptime)
+}
+
+// incrementExtras returns the extras of an incr or decr request
+func incrementExtras(delta, initial uint64, exptime uint32) []byte {
+	extras := binary.BigEndian.AppendUint64(nil, delta)
+	extras = binary.BigEndian.AppendUint64(extras, initial)
+	return binary.BigEndian.AppendUint32(extras, exptime)
+}
+
+func TestMemcachedBinaryCommands(t *testing.T) {
+	conn, err := net.Dial("tcp", newMemcachedListener(t, nil, true))
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	t.Run("Set and get", func(t *testing.T) {
+		sendBinary(t, conn, 0x01, 7, 0, storeExtras(42, 0), "user/1", "alice")
+		resp := readBinary(t, r)
+		assert.Equal(t, uint16(binaryStatusOK), resp.status)
+		assert.Equal(t, uint32(7), resp.opaque)
+
+		sendBinary(t, conn, 0x0c, 8, 0, nil, "user/1", "")
+		resp = readBinary(t, r)
+		assert.Equal(t, uint16(binaryStatusOK), resp.status)
+		assert.Equal(t, uint32(42), binary.BigEndian.Uint32(resp.extras))
+		assert.Equal(t, "user/1", resp.key)
+		assert.Equal(t, "alice", string(resp.value))
+		assert.NotZero(t, resp.cas)
+
+		sendBinary(t, conn, 0x00, 9, 0, nil, "missing", "")
+		assert.Equal(t, uint16(binaryStatusNotFound), readBinary(t, r).status)
+	})
+
+	t.Run("cas", func(t *testing.T) {
+		sendBinary(t, conn, 0x00, 0, 0, nil, "user/1", "")
+		cas := readBinary(t, r).cas
+		sendBinary(t, conn, 0x01, 0, cas+1, storeExtras(0, 0), "user/1", "bob")
+		assert.Equal(t, uint16(binaryStatusExists), readBinary(t, r).status)
+		sendBinary(t, conn, 0x01, 0, cas, storeExtras(0, 0), "user/1", "bob")
+		assert.Equal(t, uint16(binaryStatusOK), readBinary(t, r).status)
+		sendBinary(t, conn, 0x02, 0, 0, storeExtras(0, 0), "user/1", "carol")
+		assert.Equal(t, uint16(binaryStatusExists), readBinary(t, r).status)
+		sendBinary(t, conn, 0x03, 0, 0, storeExtras(0, 0), "user/2", "carol")
+		assert.Equal(t, uint16(binaryStatusNotFound), readBinary(t, r).status)
+	})
+
+	t.Run("Quiet requests only reply at the noop", func(t *testing.T) {
+		sendBinary(t, conn, 0x11, 1, 0, storeExtras(0, 0), "quiet", "1")
+		sendBinary(t, conn, 0x09, 2, 0, nil, "missing", "")
+		sendBinary(t, conn, 0x0d, 3, 0, nil, "quiet", "")
+		sendBinary(t, conn, 0x0a, 4, 0, nil, "", "")
+		resp := readBinary(t, r)
+		assert.Equal(t, uint32(3), resp.opaque)
+		assert.Equal(t, "quiet", resp.key)
+		assert.Equal(t, uint32(4), readBinary(t, r).opaque)
+	})
+
+	t.Run("incr, decr and touch", func(t *testing.T) {
+		sendBinary(t, conn, 0x05, 0, 0, incrementExtras(1, 0, noCreateExpiration), "counter", "")
+		assert.Equal(t, uint16(binaryStatusNotFound), readBinary(t, r).status)
+		sendBinary(t, conn, 0x05, 0, 0, incrementExtras(1, 10, 0), "counter", "")
+		assert.Equal(t, uint64(10), binary.BigEndian.Uint64(readBinary(t, r).value))
+		sendBinary(t, conn, 0x06, 0, 0, incrementExtras(3, 0, 0), "counter", "")
+		assert.Equal(t, uint64(7), binary.BigEndian.Uint64(readBinary(t, r).value))
+		sendBinary(t, conn, 0x05, 0, 0, incrementExtras(1, 0, 0), "user/1", "")
+		assert.Equal(t, uint16(binaryStatusNonNumeric), readBinary(t, r).status)
+
+		sendBinary(t, conn, 0x1c, 0, 0, binary.BigEndian.AppendUint32(nil, 100), "counter", "")
+		assert.Equal(t, uint16(binaryStatusOK), readBinary(t, r).status)
+		sendBinary(t, conn, 0x1c, 0, 0, binary.BigEndian.AppendUint32(nil, 100), "missing", "")
+		assert.Equal(t, uint16(binaryStatusNotFound), readBinary(t, r).status)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		sendBinary(t, conn, 0x04, 0, 0, nil, "counter", "")
+		assert.Equal(t, uint16(binaryStatusOK), readBinary(t, r).status)
+		sendBinary(t, conn, 0x04, 0, 0, nil, "counter", "")
+		assert.Equal(t, uint16(binaryStatusNotFound), readBinary(t, r).status)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		sendBinary(t, conn, 0x50, 0, 0, nil, "", "")
+		assert.Equal(t, uint16(binaryStatusUnknown), readBinary(t, r).status)
+		sendBinary(t, conn, 0x01, 0, 0, nil, "a", "b")
+		assert.Equal(t, uint16(binaryStatusInvalid), readBinary(t, r).status)
+		sendBinary(t, conn, 0x0b, 0, 0, nil, "", "")
+		assert.Equal(t, memcachedVersion, string(readBinary(t, r).value))
+	})
+}
+
+func TestMemcachedBinaryAuthentication(t *testing.T) {
+	conn, err := net.Dial("tcp", newMemcachedListener(t, fakeAuthenticator{"secret": "alice"}, true))
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	sendBinary(t, conn, 0x00, 0, 0, nil, "a", "")
+	assert.Equal(t, uint16(binaryStatusAuthError), readBinary(t, r).status)
+	sendBinary(t, conn, 0x20, 0, 0, nil, "", "")
+	assert.Equal(t, "PLAIN", string(readBinary(t, r).value))
+	sendBinary(t, conn, 0x21, 0, 0, nil, "PLAIN", "\x00alice\x00wrong")
+	assert.Equal(t, uint16(binaryStatusAuthError), readBinary(t, r).status)
+	sendBinary(t, conn, 0x21, 0, 0, nil, "PLAIN", "\x00alice\x00secret")
+	assert.Equal(t, uint16(binaryStatusOK), readBinary(t, r).status)
+	sendBinary(t, conn, 0x00, 0, 0, nil, "a", "")
+	assert.Equal(t, uint16(binaryStatusNotFound), readBinary(t, r).status)
+}
+
+func TestMemcachedTTL(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	assert.Equal(t, int64(0), memcachedTTL(0, now))
+	assert.Equal(t, int64(60_000), memcachedTTL(60, now))
+	assert.Equal(t, int64(maxRelativeExpiration*1000), memcachedTTL(maxRelativeExpiration, now))
+	assert.Equal(t, int64(120_000), memcachedTTL(1_700_000_120, now), "Large times are unix timestamps")
+	assert.Equal(t, int64(1), memcachedTTL(1_600_000_000, now), "Times in the past expire immediately")
+	assert.Equal(t, int64(1), memcachedTTL(-1, now))
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tdevsin/keyforge/internal/api/controller"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/replication"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "keyforge_rpc_duration_seconds",
+		Help:    "Duration of gRPC requests handled by this node.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16), // 100µs to 3.3s
+	}, []string{"service", "method"})
+	rpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keyforge_rpc_errors_total",
+		Help: "Number of gRPC requests handled by this node that returned an error, by status code.",
+	}, []string{"service", "method", "code"})
+)
+
+// observeRPC records the duration and result of a request
+func observeRPC(fullMethod string, start time.Time, err error) {
+	service, method := splitMethod(fullMethod)
+	rpcDuration.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		rpcErrors.WithLabelValues(service, method, status.Code(err).String()).Inc()
+	}
+}
+
+// metricsUnaryInterceptor records the duration and errors of every request
+func metricsUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeRPC(info.FullMethod, start, err)
+	return resp, err
+}
+
+// metricsStreamInterceptor records the duration and errors of every stream
+func metricsStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeRPC(info.FullMethod, start, err)
+	return err
+}
+
+// newMetricsRegistry returns a registry containing all metrics of the node
+func newMetricsRegistry(conf *config.Config) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		rpcDuration,
+		rpcErrors,
+		storage.NewPebbleCollector(conf.Db, "data"),
+	)
+	if conf.MetadataDb != nil {
+		registry.MustRegister(storage.NewPebbleCollector(conf.MetadataDb, "metadata"))
+	}
+	registry.MustRegister(controller.Collectors()...)
+	registry.MustRegister(cluster.Collectors(conf.ClusterInfo.GetClusterInfo())...)
+	if conf.Settings.Replication.Remote.Enabled() {
+		registry.MustRegister(replication.Collectors()...)
+	}
+	return registry
+}
+
+// startMetricsServer serves /metrics over HTTP on the configured metrics address.
+// The returned function stops the server.
+func startMetricsServer(conf *config.Config) (func(), error) {
+	address := conf.Settings.Metrics.ListenAddress
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		conf.Logger.Error("Failed to listen", zap.String("address", address), zap.Error(err))
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(newMetricsRegistry(conf), promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	conf.Logger.Info("Starting metrics server", zap.String("address", lis.Addr().String()))
+	go func() {
+		if err := server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			conf.Logger.Error("Metrics server failed", zap.Error(err))
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"google.golang.org/grpc"
+)
+
+func TestMetricsUnaryInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: proto.KeyService_GetKey_FullMethodName}
+	notFound := rpcErrors.WithLabelValues("KeyService", "GetKey", "NotFound")
+	before := testutil.ToFloat64(notFound)
+
+	_, err := metricsUnaryInterceptor(context.TODO(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, constants.StatusErrKeyNotFound
+	})
+	assert.Equal(t, constants.StatusErrKeyNotFound, err)
+	assert.Equal(t, before+1, testutil.ToFloat64(notFound))
+
+	_, err = metricsUnaryInterceptor(context.TODO(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return "value", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, before+1, testutil.ToFloat64(notFound))
+}
+
+func TestMetricsServer(t *testing.T) {
+	mockDb := new(storage.MockDatabase)
+	mockDb.On("Metrics").Return(&pebble.Metrics{})
+	l := logger.GetLogger(false, "test")
+	clusterInfo := cluster.NewCluster(l, "node1", 2)
+	clusterInfo.AddOrUpdateNode(cluster.Node{ID: "node1", Address: "localhost:8080"})
+
+	settings := config.DefaultSettings()
+	settings.Metrics.ListenAddress = "127.0.0.1:19090"
+	conf := &config.Config{
+		Logger:      l,
+		Db:          mockDb,
+		ClusterInfo: clusterInfo,
+		Settings:    settings,
+	}
+
+	stop, err := startMetricsServer(conf)
+	assert.NoError(t, err)
+	defer stop()
+
+	resp, err := http.Get("http://127.0.0.1:19090/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	for _, name := range []string{"keyforge_cluster_node_status", "keyforge_pebble_disk_usage_bytes", "go_goroutines"} {
+		assert.Contains(t, string(body), name)
+	}
+}
@@ -0,0 +1,732 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/api/controller"
+	"github.com/tdevsin/keyforge/internal/auth"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// redisVersion is the Redis version whose commands the listener is compatible with, reported by HELLO
+const redisVersion = "7.2.0"
+
+// Errors replied to Redis clients. Their messages match the ones of Redis, as some clients parse them.
+var (
+	errRedisSyntax     = status.Error(codes.InvalidArgument, "syntax error")
+	errRedisNotInteger = status.Error(codes.InvalidArgument, "value is not an integer or out of range")
+	errRedisCursor     = status.Error(codes.InvalidArgument, "invalid cursor")
+	errRedisNoPassword = status.Error(codes.InvalidArgument, "AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	errRedisWrongPass  = status.Error(codes.Unauthenticated, "invalid username-password pair or user is disabled.")
+	errRedisNoAuth     = status.Error(codes.Unauthenticated, "Authentication required.")
+	errRedisNoProto    = status.Error(codes.InvalidArgument, "unsupported protocol version")
+)
+
+// redisCommand handles a command and writes its reply on success. It returns a request describing
+// the command, if any, for the access log.
+type redisCommand struct {
+	arity   int  // arity is the number of arguments including the name, or the negated minimum if it varies
+	keys    bool // keys is set for commands reading or writing keys, which are rejected until the node is ready
+	handler func(c *redisConn, ctx context.Context, args [][]byte) (any, error)
+}
+
+// redisCommands are the supported commands by lower case name
+var redisCommands = map[string]redisCommand{
+	"ping":    {arity: -1, handler: (*redisConn).ping},
+	"echo":    {arity: 2, handler: (*redisConn).echo},
+	"hello":   {arity: -1, handler: (*redisConn).hello},
+	"auth":    {arity: -2, handler: (*redisConn).auth},
+	"select":  {arity: 2, handler: (*redisConn).selectDB},
+	"client":  {arity: -2, handler: (*redisConn).client},
+	"command": {arity: -1, handler: (*redisConn).command},
+	"quit":    {arity: 1, handler: (*redisConn).quit},
+	"get":     {arity: 2, keys: true, handler: (*redisConn).get},
+	"set":     {arity: -3, keys: true, handler: (*redisConn).set},
+	"del":     {arity: -2, keys: true, handler: (*redisConn).del},
+	"exists":  {arity: -2, keys: true, handler: (*redisConn).exists},
+	"mget":    {arity: -2, keys: true, handler: (*redisConn).mget},
+	"mset":    {arity: -3, keys: true, handler: (*redisConn).mset},
+	"scan":    {arity: -2, keys: true, handler: (*redisConn).scan},
+}
+
+// unauthenticatedCommands may be sent before the client authenticated
+var unauthenticatedCommands = map[string]bool{"hello": true, "auth": true, "quit": true}
+
+// redisServer serves keys over the Redis protocol, so Redis client libraries can be used with a
+// cluster. Commands are mapped onto the controller like gRPC requests, so any node routes keys to
+// the node owning them.
+type redisServer struct {
+	*connServer
+	health  *healthState
+	cursors *scanCursors
+	nextID  atomic.Int64
+}
+
+func newRedisServer(conf *config.Config, health *healthState) *redisServer {
+	return &redisServer{connServer: newConnServer("Redis listener", conf), health: health, cursors: newScanCursors()}
+}
+
+// serve handles the connections of a listener until it is closed
+func (s *redisServer) serve(lis net.Listener) {
+	s.connServer.serve(lis, func(conn net.Conn) {
+		c := &redisConn{
+			server: s,
+			conn:   conn,
+			id:     s.nextID.Add(1),
+			reader: newRESPReader(conn),
+			writer: newRESPWriter(conn),
+		}
+		c.serve()
+	})
+}
+
+// redisConn is a client connection and the state it negotiated
+type redisConn struct {
+	server  *redisServer
+	conn    net.Conn
+	id      int64
+	reader  *respReader
+	writer  *respWriter
+	name    string
+	closing bool // closing is set by QUIT
+
+	principal *auth.Principal // principal is set once the client authenticated
+	token     string          // token is forwarded with requests proxied to other nodes
+}
+
+// serve handles commands until the client disconnects or sends QUIT
+func (c *redisConn) serve() {
+	for !c.closing {
+		args, err := c.reader.readCommand()
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				c.writer.writeError("ERR " + err.Error())
+				c.writer.flush()
+			}
+			return
+		}
+		c.handle(args)
+		// Replies of pipelined commands are sent together
+		if c.reader.r.Buffered() == 0 || c.closing {
+			if err := c.writer.flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle runs a command with the checks the interceptors run for gRPC requests, and replies with
+// an error if it fails
+func (c *redisConn) handle(args [][]byte) {
+	start := time.Now()
+	name := strings.ToLower(string(args[0]))
+	command, ok := redisCommands[name]
+	// Unknown commands share a method, so metrics do not get a label per name clients send
+	method := "UNKNOWN"
+	if ok {
+		method = strings.ToUpper(name)
+	}
+	fullMethod := "/Redis/" + method
+	ctx := c.requestContext()
+	ctx, span := tracing.Start(ctx, "redis "+method, trace.WithSpanKind(trace.SpanKindServer))
+
+	var req any
+	err := func() (err error) {
+		defer recoverPanic(c.server.conf.Logger, fullMethod, &err)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "unknown command '%s', with args beginning with: %s", args[0], formatArgs(args[1:]))
+		}
+		if (command.arity > 0 && len(args) != command.arity) || (command.arity < 0 && len(args) < -command.arity) {
+			return status.Errorf(codes.InvalidArgument, "wrong number of arguments for '%s' command", name)
+		}
+		if c.server.conf.Authenticator != nil && c.principal == nil && !unauthenticatedCommands[name] {
+			return errRedisNoAuth
+		}
+		if command.keys && !c.server.health.joined.Load() {
+			return constants.StatusErrNotReady
+		}
+		req, err = command.handler(c, ctx, args)
+		return err
+	}()
+	if err != nil {
+		c.writer.writeError(redisError(err))
+	}
+	tracing.End(span, err)
+	observeRPC(fullMethod, start, err)
+	logAccess(c.server.conf.Logger, ctx, fullMethod, req, start, err)
+}
+
+// requestContext returns the context of a command, carrying a new request ID, the peer and the
+// principal and token of the connection
+func (c *redisConn) requestContext() context.Context {
+	return connContext(c.conn, c.principal, c.token)
+}
+
+// formatArgs quotes the first arguments of a command for error messages
+func formatArgs(args [][]byte) string {
+	var b strings.Builder
+	for i, arg := range args {
+		if i == 3 {
+			break
+		}
+		b.WriteString("'" + string(arg[:min(len(arg), 128)]) + "' ")
+	}
+	return b.String()
+}
+
+// redisError returns the error reply of a failed command, starting with the error code Redis uses
+func redisError(err error) string {
+	s := status.Convert(err)
+	switch {
+	case err == errRedisNoAuth:
+		return "NOAUTH " + s.Message()
+	case err == errRedisWrongPass:
+		return "WRONGPASS " + s.Message()
+	case err == errRedisNoProto:
+		return "NOPROTO " + s.Message()
+	case s.Code() == codes.PermissionDenied:
+		return "NOPERM " + s.Message()
+	case err == constants.StatusErrNotReady:
+		// Clients retry commands failing with LOADING, like while Redis loads its dataset
+		return "LOADING " + s.Message()
+	default:
+		return "ERR " + s.Message()
+	}
+}
+
+func (c *redisConn) ping(ctx context.Context, args [][]byte) (any, error) {
+	switch len(args) {
+	case 1:
+		c.writer.writeSimple("PONG")
+	case 2:
+		c.writer.writeBulk(args[1])
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "wrong number of arguments for 'ping' command")
+	}
+	return nil, nil
+}
+
+func (c *redisConn) echo(ctx context.Context, args [][]byte) (any, error) {
+	c.writer.writeBulk(args[1])
+	return nil, nil
+}
+
+// hello switches the protocol version and optionally authenticates and names the connection:
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func (c *redisConn) hello(ctx context.Context, args [][]byte) (any, error) {
+	protocol := c.writer.protocol
+	if len(args) > 1 {
+		version, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "Protocol version is not an integer or out of range")
+		}
+		if version != 2 && version != 3 {
+			return nil, errRedisNoProto
+		}
+		protocol = version
+	}
+	name := c.name
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "auth":
+			if i+2 >= len(args) {
+				return nil, errRedisSyntax
+			}
+			if err := c.authenticate(args[i+2]); err != nil {
+				return nil, err
+			}
+			i += 2
+		case "setname":
+			if i+1 >= len(args) {
+				return nil, errRedisSyntax
+			}
+			name = string(args[i+1])
+			i++
+		default:
+			return nil, errRedisSyntax
+		}
+	}
+	if c.server.conf.Authenticator != nil && c.principal == nil {
+		return nil, errRedisNoAuth
+	}
+	c.writer.protocol = protocol
+	c.name = name
+
+	c.writer.writeMap(7)
+	c.writer.writeBulk([]byte("server"))
+	c.writer.writeBulk([]byte("keyforge"))
+	c.writer.writeBulk([]byte("version"))
+	c.writer.writeBulk([]byte(redisVersion))
+	c.writer.writeBulk([]byte("proto"))
+	c.writer.writeInt(int64(protocol))
+	c.writer.writeBulk([]byte("id"))
+	c.writer.writeInt(c.id)
+	c.writer.writeBulk([]byte("mode"))
+	c.writer.writeBulk([]byte("standalone"))
+	c.writer.writeBulk([]byte("role"))
+	c.writer.writeBulk([]byte("master"))
+	c.writer.writeBulk([]byte("modules"))
+	c.writer.writeArray(0)
+	return nil, nil
+}
+
+// auth authenticates the connection with a token sent as password: AUTH [username] password.
+// The username is ignored, the principal is the one of the token.
+func (c *redisConn) auth(ctx context.Context, args [][]byte) (any, error) {
+	if len(args) > 3 {
+		return nil, errRedisSyntax
+	}
+	if err := c.authenticate(args[len(args)-1]); err != nil {
+		return nil, err
+	}
+	c.writer.writeSimple("OK")
+	return nil, nil
+}
+
+// authenticate verifies a token and keeps its principal for the following commands
+func (c *redisConn) authenticate(token []byte) error {
+	if c.server.conf.Authenticator == nil {
+		return errRedisNoPassword
+	}
+	principal, err := c.server.conf.Authenticator.Authenticate(string(token))
+	if err != nil {
+		return errRedisWrongPass
+	}
+	c.principal = principal
+	c.token = string(token)
+	return nil
+}
+
+// selectDB accepts the only database there is
+func (c *redisConn) selectDB(ctx context.Context, args [][]byte) (any, error) {
+	if string(args[1]) != "0" {
+		return nil, status.Error(codes.InvalidArgument, "DB index is out of range")
+	}
+	c.writer.writeSimple("OK")
+	return nil, nil
+}
+
+// client supports the subcommands client libraries send while connecting
+func (c *redisConn) client(ctx context.Context, args [][]byte) (any, error) {
+	switch strings.ToLower(string(args[1])) {
+	case "setname":
+		if len(args) != 3 {
+			return nil, errRedisSyntax
+		}
+		c.name = string(args[2])
+		c.writer.writeSimple("OK")
+	case "getname":
+		if c.name == "" {
+			c.writer.writeNull()
+		} else {
+			c.writer.writeBulk([]byte(c.name))
+		}
+	case "id":
+		c.writer.writeInt(c.id)
+	case "setinfo":
+		c.writer.writeSimple("OK")
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown subcommand '%s'", args[1])
+	}
+	return nil, nil
+}
+
+// command replies with an empty list of command docs, which redis-cli requests when it starts
+func (c *redisConn) command(ctx context.Context, args [][]byte) (any, error) {
+	c.writer.writeArray(0)
+	return nil, nil
+}
+
+func (c *redisConn) quit(ctx context.Context, args [][]byte) (any, error) {
+	c.closing = true
+	c.writer.writeSimple("OK")
+	return nil, nil
+}
+
+func (c *redisConn) get(ctx context.Context, args [][]byte) (any, error) {
+	req := &proto.GetKeyRequest{Key: string(args[1])}
+	value, found, err := c.getValue(ctx, req)
+	if err != nil {
+		return req, err
+	}
+	if !found {
+		c.writer.writeNull()
+	} else {
+		c.writer.writeBulk(value)
+	}
+	return req, nil
+}
+
+// getValue reads a key and reports whether it exists
+func (c *redisConn) getValue(ctx context.Context, req *proto.GetKeyRequest) ([]byte, bool, error) {
+	resp, err := controller.GetKey(ctx, c.server.conf, req)
+	if status.Code(err) == codes.NotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return resp.Value, true, nil
+}
+
+// set writes a key: SET key value [NX | XX] [EX seconds | PX milliseconds]
+func (c *redisConn) set(ctx context.Context, args [][]byte) (any, error) {
+	req := &proto.SetKeyRequest{Key: string(args[1]), Value: args[2]}
+	for i := 3; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "nx" && req.Condition == proto.SetKeyRequest_ALWAYS:
+			req.Condition = proto.SetKeyRequest_IF_ABSENT
+		case option == "xx" && req.Condition == proto.SetKeyRequest_ALWAYS:
+			req.Condition = proto.SetKeyRequest_IF_PRESENT
+		case (option == "ex" || option == "px") && req.TtlMs == 0 && i+1 < len(args):
+			ttl, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return req, errRedisNotInteger
+			}
+			if ttl <= 0 || (option == "ex" && ttl > (1<<63-1)/1000) {
+				return req, status.Error(codes.InvalidArgument, "invalid expire time in 'set' command")
+			}
+			if option == "ex" {
+				ttl *= 1000
+			}
+			req.TtlMs = ttl
+			i++
+		default:
+			return req, errRedisSyntax
+		}
+	}
+
+	_, err := controller.SetKey(ctx, c.server.conf, req)
+	if status.Code(err) == codes.FailedPrecondition {
+		// NX and XX reply with null if the key was not written
+		c.writer.writeNull()
+		return req, nil
+	}
+	if err != nil {
+		return req, err
+	}
+	c.writer.writeSimple("OK")
+	return req, nil
+}
+
+// del deletes keys and replies with the number of keys that existed
+func (c *redisConn) del(ctx context.Context, args [][]byte) (any, error) {
+	var req *proto.DeleteKeyRequest
+	deleted := 0
+	for _, key := range args[1:] {
+		req = &proto.DeleteKeyRequest{Key: string(key)}
+		resp, err := controller.DeleteKey(ctx, c.server.conf, req)
+		if err != nil {
+			return req, err
+		}
+		if resp.Found {
+			deleted++
+		}
+	}
+	c.writer.writeInt(int64(deleted))
+	return req, nil
+}
+
+// exists replies with the number of given keys that exist. Keys given multiple times are counted
+// multiple times.
+func (c *redisConn) exists(ctx context.Context, args [][]byte) (any, error) {
+	var req *proto.GetKeyRequest
+	found := 0
+	for _, key := range args[1:] {
+		req = &proto.GetKeyRequest{Key: string(key)}
+		_, ok, err := c.getValue(ctx, req)
+		if err != nil {
+			return req, err
+		}
+		if ok {
+			found++
+		}
+	}
+	c.writer.writeInt(int64(found))
+	return req, nil
+}
+
+func (c *redisConn) mget(ctx context.Context, args [][]byte) (any, error) {
+	var req *proto.GetKeyRequest
+	values := make([][]byte, len(args)-1)
+	for i, key := range args[1:] {
+		req = &proto.GetKeyRequest{Key: string(key)}
+		value, found, err := c.getValue(ctx, req)
+		if err != nil {
+			return req, err
+		}
+		if found {
+			values[i] = value
+		}
+	}
+	c.writer.writeArray(len(values))
+	for _, value := range values {
+		if value == nil {
+			c.writer.writeNull()
+		} else {
+			c.writer.writeBulk(value)
+		}
+	}
+	return req, nil
+}
+
+// mset writes keys one after another. Keys live on different nodes, so unlike in Redis the keys
+// are not written atomically, and keys before a failed one stay written.
+func (c *redisConn) mset(ctx context.Context, args [][]byte) (any, error) {
+	if len(args)%2 != 1 {
+		return nil, status.Error(codes.InvalidArgument, "wrong number of arguments for 'mset' command")
+	}
+	var req *proto.SetKeyRequest
+	for i := 1; i < len(args); i += 2 {
+		req = &proto.SetKeyRequest{Key: string(args[i]), Value: args[i+1]}
+		if _, err := controller.SetKey(ctx, c.server.conf, req); err != nil {
+			return req, err
+		}
+	}
+	c.writer.writeSimple("OK")
+	return req, nil
+}
+
+// defaultScanCount is the number of keys SCAN looks at if COUNT is not given, like in Redis
+const defaultScanCount = 10
+
+// scan iterates over keys in order: SCAN cursor [MATCH pattern] [COUNT count] [TYPE type].
+// The literal prefix of the pattern is scanned, and the rest of the pattern is matched against the
+// returned keys. Like in Redis, a call may return fewer keys than COUNT, or none, before the scan
+// is complete.
+func (c *redisConn) scan(ctx context.Context, args [][]byte) (any, error) {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errRedisCursor
+	}
+	req := &proto.ScanKeysRequest{Limit: defaultScanCount, KeysOnly: true}
+	pattern := []byte("*")
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errRedisSyntax
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			count, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, errRedisNotInteger
+			}
+			if count < 1 {
+				return nil, errRedisSyntax
+			}
+			req.Limit = int32(min(count, controller.MaxScanLimit))
+		case "type":
+			// All values are strings
+			if !strings.EqualFold(string(args[i+1]), "string") {
+				pattern = nil
+			}
+		default:
+			return nil, errRedisSyntax
+		}
+	}
+	if cursor != 0 {
+		key, ok := c.server.cursors.get(cursor)
+		if !ok {
+			return nil, errRedisCursor
+		}
+		req.StartAfter = key
+	}
+
+	var keys [][]byte
+	next := uint64(0)
+	if pattern != nil {
+		req.Prefix = string(globPrefix(pattern))
+		resp, err := controller.ScanKeys(ctx, c.server.conf, req)
+		if err != nil {
+			return req, err
+		}
+		for _, item := range resp.Items {
+			if matchGlob(pattern, []byte(item.Key)) {
+				keys = append(keys, []byte(item.Key))
+			}
+		}
+		if resp.Cursor != "" {
+			next = c.server.cursors.add(resp.Cursor)
+		}
+	}
+
+	c.writer.writeArray(2)
+	c.writer.writeBulk([]byte(strconv.FormatUint(next, 10)))
+	c.writer.writeArray(len(keys))
+	for _, key := range keys {
+		c.writer.writeBulk(key)
+	}
+	return req, nil
+}
+
+// maxScanCursors is the number of unfinished scans a node remembers. The oldest cursor is
+// forgotten once a new scan exceeds it.
+const maxScanCursors = 10000
+
+// scanCursors maps the numeric cursors Redis clients expect to the last key a scan returned. Cursors
+// are only known to the node that returned them.
+type scanCursors struct {
+	mu    sync.Mutex
+	next  uint64
+	keys  map[uint64]string
+	order []uint64
+}
+
+func newScanCursors() *scanCursors {
+	return &scanCursors{keys: map[uint64]string{}}
+}
+
+// add returns a new cursor continuing after key
+func (s *scanCursors) add(key string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	s.keys[s.next] = key
+	s.order = append(s.order, s.next)
+	if len(s.order) > maxScanCursors {
+		delete(s.keys, s.order[0])
+		s.order = s.order[1:]
+	}
+	return s.next
+}
+
+// get returns the key a cursor continues after
+func (s *scanCursors) get(cursor uint64) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[cursor]
+	return key, ok
+}
+
+// globPrefix returns the literal characters a glob pattern starts with
+func globPrefix(pattern []byte) []byte {
+	var prefix []byte
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[':
+			return prefix
+		case '\\':
+			if i+1 == len(pattern) {
+				return prefix
+			}
+			i++
+		}
+		prefix = append(prefix, pattern[i])
+	}
+	return prefix
+}
+
+// matchGlob reports whether s matches a glob pattern with the syntax of Redis: * matches any
+// characters, ? a single one, [abc], [^abc] and [a-z] sets of characters and \ escapes the next one
+func matchGlob(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			matched, rest := matchClass(pattern[1:], s[0])
+			if !matched {
+				return false
+			}
+			s = s[1:]
+			pattern = rest
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the character class at the start of pattern, after the opening
+// bracket. It returns the pattern after the closing bracket.
+func matchClass(pattern []byte, c byte) (bool, []byte) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			matched = matched || pattern[1] == c
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			low, high := min(pattern[0], pattern[2]), max(pattern[0], pattern[2])
+			matched = matched || (c >= low && c <= high)
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == c
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		// Skip the closing bracket
+		pattern = pattern[1:]
+	}
+	return matched != negate, pattern
+}
+
+// startRedisServer serves the Redis protocol on the configured address, over TLS if it is enabled.
+// The returned function stops the server.
+func startRedisServer(conf *config.Config, health *healthState) (func(), error) {
+	lis, err := listenTLS(conf, conf.Settings.Redis.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	server := newRedisServer(conf, health)
+	conf.Logger.Info("Starting Redis listener", zap.String("address", lis.Addr().String()), zap.Bool("tls", conf.Certificates != nil))
+	go server.serve(lis)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			lis.Close()
+			server.shutdown(conf.Settings.Server.DrainTimeout)
+		})
+	}, nil
+}
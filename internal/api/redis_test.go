@@ -0,0 +1,313 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/tdevsin/keyforge/internal/auth"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/storage"
+)
+
+// redisErrorReply is an error reply of the Redis listener
+type redisErrorReply string
+
+// redisClient sends commands to the Redis listener and parses the replies
+type redisClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newListenerConfig returns the configuration of a single node cluster storing keys in a new
+// database, for the protocol listeners
+func newListenerConfig(t *testing.T, authenticator auth.Authenticator, ready bool) (*config.Config, *healthState) {
+	l := new(logger.MockLogging)
+	l.On("Info", mock.Anything, mock.Anything).Maybe()
+	l.On("Warn", mock.Anything, mock.Anything).Maybe()
+	l.On("Error", mock.Anything, mock.Anything).Maybe()
+	db := storage.GetDatabaseInstance(logger.GetLogger(false, "test"), t.TempDir())
+	t.Cleanup(func() { db.Close() })
+	node := cluster.Node{ID: "node1", Address: "localhost:8080"}
+	hashring := cluster.NewHashRing()
+	hashring.AddNode(node)
+	conf := &config.Config{
+		Logger:        l,
+		Db:            db,
+		NodeInfo:      &node,
+		HashRing:      hashring,
+		Authenticator: authenticator,
+		Settings:      config.DefaultSettings(),
+	}
+	health := newHealthState()
+	if ready {
+		health.markReady()
+	}
+	return conf, health
+}
+
+// newRedisListener starts the Redis listener of a single node cluster storing keys in a new database
+func newRedisListener(t *testing.T, authenticator auth.Authenticator, ready bool) string {
+	conf, health := newListenerConfig(t, authenticator, ready)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := newRedisServer(conf, health)
+	go server.serve(lis)
+	t.Cleanup(func() {
+		lis.Close()
+		server.shutdown(time.Second)
+	})
+	return lis.Addr().String()
+}
+
+func dialRedis(t *testing.T, address string) *redisClient {
+	conn, err := net.Dial("tcp", address)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &redisClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a command and returns its reply
+func (c *redisClient) do(args ...string) any {
+	c.send(args...)
+	return c.read()
+}
+
+func (c *redisClient) send(args ...string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := c.conn.Write([]byte(b.String()))
+	assert.NoError(c.t, err)
+}
+
+// read parses a reply. Strings are returned as string, integers as int64, null as nil, errors as
+// redisErrorReply and arrays and maps as []any.
+func (c *redisClient) read() any {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if !assert.NoError(c.t, err) {
+		return nil
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return redisErrorReply(line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '_':
+		return nil
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		data := make([]byte, n+2)
+		_, err := io.ReadFull(c.r, data)
+		assert.NoError(c.t, err)
+		return string(data[:n])
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		items := []any{}
+		for range n {
+			items = append(items, c.read())
+		}
+		return items
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func TestRedisCommands(t *testing.T) {
+	c := dialRedis(t, newRedisListener(t, nil, true))
+
+	t.Run("PING", func(t *testing.T) {
+		assert.Equal(t, "PONG", c.do("PING"))
+		assert.Equal(t, "hello", c.do("ping", "hello"))
+	})
+
+	t.Run("SET and GET", func(t *testing.T) {
+		assert.Equal(t, "OK", c.do("SET", "user/1", "alice"))
+		assert.Equal(t, "alice", c.do("GET", "user/1"))
+		assert.Nil(t, c.do("GET", "missing"))
+	})
+
+	t.Run("SET NX and XX", func(t *testing.T) {
+		assert.Nil(t, c.do("SET", "user/1", "bob", "NX"))
+		assert.Equal(t, "OK", c.do("SET", "user/2", "bob", "nx"))
+		assert.Nil(t, c.do("SET", "user/3", "carol", "XX"))
+		assert.Equal(t, "OK", c.do("SET", "user/2", "bobby", "XX"))
+		assert.Equal(t, "bobby", c.do("GET", "user/2"))
+		assert.Equal(t, redisErrorReply("ERR syntax error"), c.do("SET", "k", "v", "NX", "XX"))
+	})
+
+	t.Run("SET EX and PX", func(t *testing.T) {
+		assert.Equal(t, "OK", c.do("SET", "session", "1", "PX", "50"))
+		assert.Equal(t, "1", c.do("GET", "session"))
+		time.Sleep(100 * time.Millisecond)
+		assert.Nil(t, c.do("GET", "session"))
+		assert.Equal(t, "OK", c.do("SET", "session", "2", "EX", "100", "NX"))
+		assert.Equal(t, redisErrorReply("ERR invalid expire time in 'set' command"), c.do("SET", "session", "3", "EX", "0"))
+		assert.Equal(t, redisErrorReply("ERR value is not an integer or out of range"), c.do("SET", "session", "3", "PX", "soon"))
+	})
+
+	t.Run("MSET, MGET and EXISTS", func(t *testing.T) {
+		assert.Equal(t, "OK", c.do("MSET", "a", "1", "b", "2"))
+		assert.Equal(t, []any{"1", nil, "2"}, c.do("MGET", "a", "missing", "b"))
+		assert.Equal(t, int64(3), c.do("EXISTS", "a", "b", "a", "missing"))
+		assert.Equal(t, redisErrorReply("ERR wrong number of arguments for 'mset' command"), c.do("MSET", "a", "1", "b"))
+	})
+
+	t.Run("DEL", func(t *testing.T) {
+		assert.Equal(t, int64(2), c.do("DEL", "a", "b", "missing"))
+		assert.Equal(t, int64(0), c.do("EXISTS", "a"))
+	})
+
+	t.Run("SCAN", func(t *testing.T) {
+		for i := range 5 {
+			c.do("SET", fmt.Sprintf("scan/%d", i), "v")
+		}
+		var keys []any
+		cursor := "0"
+		for {
+			reply := c.do("SCAN", cursor, "MATCH", "scan/*", "COUNT", "2").([]any)
+			keys = append(keys, reply[1].([]any)...)
+			cursor = reply[0].(string)
+			if cursor == "0" {
+				break
+			}
+		}
+		assert.Equal(t, []any{"scan/0", "scan/1", "scan/2", "scan/3", "scan/4"}, keys)
+
+		reply := c.do("SCAN", "0", "MATCH", "scan/[13]", "COUNT", "100").([]any)
+		assert.Equal(t, []any{"0", []any{"scan/1", "scan/3"}}, reply)
+		assert.Equal(t, redisErrorReply("ERR invalid cursor"), c.do("SCAN", "12345"))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		assert.Equal(t, redisErrorReply("ERR unknown command 'FLUSHALL', with args beginning with: "), c.do("FLUSHALL"))
+		assert.Equal(t, redisErrorReply("ERR wrong number of arguments for 'get' command"), c.do("GET"))
+		assert.Equal(t, redisErrorReply("ERR Key is invalid"), c.do("GET", "\x00exp/"))
+	})
+
+	t.Run("Pipelining", func(t *testing.T) {
+		c.send("SET", "p", "1")
+		c.send("GET", "p")
+		c.send("PING")
+		assert.Equal(t, "OK", c.read())
+		assert.Equal(t, "1", c.read())
+		assert.Equal(t, "PONG", c.read())
+	})
+
+	t.Run("Inline commands", func(t *testing.T) {
+		c.conn.Write([]byte("PING\r\n\r\nGET p\n"))
+		assert.Equal(t, "PONG", c.read())
+		assert.Equal(t, "1", c.read())
+	})
+}
+
+func TestRedisProtocolVersions(t *testing.T) {
+	c := dialRedis(t, newRedisListener(t, nil, true))
+
+	hello := c.do("HELLO", "3").([]any)
+	assert.Equal(t, []any{"server", "keyforge"}, hello[:2])
+	assert.Equal(t, []any{"proto", int64(3)}, hello[4:6])
+
+	// Version 3 replies with its own null type
+	c.send("GET", "missing")
+	line, err := c.r.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "_\r\n", line)
+
+	assert.Equal(t, redisErrorReply("NOPROTO unsupported protocol version"), c.do("HELLO", "4"))
+	c.do("HELLO", "2")
+	c.send("GET", "missing")
+	line, err = c.r.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "$-1\r\n", line)
+}
+
+func TestRedisAuthentication(t *testing.T) {
+	address := newRedisListener(t, fakeAuthenticator{"secret": "alice"}, true)
+
+	t.Run("Commands need authentication", func(t *testing.T) {
+		c := dialRedis(t, address)
+		assert.Equal(t, redisErrorReply("NOAUTH Authentication required."), c.do("GET", "a"))
+		assert.Equal(t, redisErrorReply("WRONGPASS invalid username-password pair or user is disabled."), c.do("AUTH", "wrong"))
+		assert.Equal(t, "OK", c.do("AUTH", "default", "secret"))
+		assert.Nil(t, c.do("GET", "a"))
+	})
+
+	t.Run("HELLO authenticates", func(t *testing.T) {
+		c := dialRedis(t, address)
+		assert.Equal(t, redisErrorReply("NOAUTH Authentication required."), c.do("HELLO", "3"))
+		c.do("HELLO", "3", "AUTH", "default", "secret")
+		assert.Equal(t, "PONG", c.do("PING"))
+	})
+
+	t.Run("AUTH without authentication", func(t *testing.T) {
+		c := dialRedis(t, newRedisListener(t, nil, true))
+		assert.Contains(t, c.do("AUTH", "secret"), "called without any password configured")
+	})
+}
+
+func TestRedisNotReady(t *testing.T) {
+	c := dialRedis(t, newRedisListener(t, nil, false))
+	assert.Equal(t, "PONG", c.do("PING"))
+	assert.Equal(t, redisErrorReply("LOADING Node has not joined the cluster yet"), c.do("GET", "a"))
+}
+
+func TestRedisProtocolError(t *testing.T) {
+	c := dialRedis(t, newRedisListener(t, nil, true))
+	c.conn.Write([]byte("*1\r\n+PING\r\n"))
+	assert.Contains(t, c.read(), "ERR Protocol error")
+	_, err := c.r.ReadByte()
+	assert.Error(t, err, "The connection should be closed")
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"*", "anything", true},
+		{"user/*", "user/1", true},
+		{"user/*", "users", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+		{"*/*/end", "a/b/end", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchGlob([]byte(tt.pattern), []byte(tt.key)))
+		})
+	}
+
+	assert.Equal(t, "user/", string(globPrefix([]byte("user/*"))))
+	assert.Equal(t, "a*b", string(globPrefix([]byte("a\\*b?"))))
+	assert.Equal(t, "", string(globPrefix([]byte("*"))))
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Limits of commands sent over the Redis protocol. Values are limited like gateway bodies.
+const (
+	maxRESPArgs       = 1 << 16
+	maxRESPBulkLength = maxBodySize
+	maxRESPInline     = 64 << 10
+)
+
+// errRESPProtocol is returned for input that is not a valid RESP command. The connection is closed afterward.
+var errRESPProtocol = errors.New("Protocol error")
+
+// respReader reads the commands Redis clients send, either as arrays of bulk strings or as inline
+// commands typed into a terminal
+type respReader struct {
+	r *bufio.Reader
+}
+
+func newRESPReader(r io.Reader) *respReader {
+	return &respReader{r: bufio.NewReader(r)}
+}
+
+// readCommand returns the name and arguments of the next command. Empty inline lines are skipped.
+func (r *respReader) readCommand() ([][]byte, error) {
+	for {
+		prefix, err := r.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if prefix[0] == '*' {
+			return r.readArray()
+		}
+		line, err := r.readLine(maxRESPInline)
+		if err != nil {
+			return nil, err
+		}
+		if args := bytes.Fields(line); len(args) > 0 {
+			return args, nil
+		}
+	}
+}
+
+// readArray reads an array of bulk strings like *2\r\n$3\r\nGET\r\n$3\r\nkey\r\n
+func (r *respReader) readArray() ([][]byte, error) {
+	line, err := r.readLine(maxRESPInline)
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count > maxRESPArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errRESPProtocol)
+	}
+	args := make([][]byte, 0, max(count, 0))
+	for range count {
+		line, err := r.readLine(maxRESPInline)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", errRESPProtocol, line[:min(len(line), 1)])
+		}
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length < 0 || length > maxRESPBulkLength {
+			return nil, fmt.Errorf("%w: invalid bulk length", errRESPProtocol)
+		}
+		arg := make([]byte, length+2)
+		if _, err := io.ReadFull(r.r, arg); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(arg, []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: bulk string is not terminated", errRESPProtocol)
+		}
+		args = append(args, arg[:length])
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by \r\n or \n and returns it without the terminator
+func (r *respReader) readLine(limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return nil, fmt.Errorf("%w: too big request", errRESPProtocol)
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return line, nil
+}
+
+// respWriter writes replies in the protocol version the client negotiated. Version 3 has its own
+// types for null and maps, version 2 replies with null bulk strings and flat arrays instead.
+type respWriter struct {
+	w        *bufio.Writer
+	protocol int
+}
+
+func newRESPWriter(w io.Writer) *respWriter {
+	return &respWriter{w: bufio.NewWriter(w), protocol: 2}
+}
+
+func (w *respWriter) writeSimple(s string) {
+	w.w.WriteString("+" + s + "\r\n")
+}
+
+// writeError writes an error reply. Its message starts with an error code like ERR or NOAUTH.
+func (w *respWriter) writeError(message string) {
+	w.w.WriteString("-" + message + "\r\n")
+}
+
+func (w *respWriter) writeInt(n int64) {
+	w.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *respWriter) writeBulk(b []byte) {
+	w.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) writeNull() {
+	if w.protocol == 3 {
+		w.w.WriteString("_\r\n")
+	} else {
+		w.w.WriteString("$-1\r\n")
+	}
+}
+
+func (w *respWriter) writeArray(n int) {
+	w.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// writeMap starts a map of n key-value pairs, written as array of 2n elements in version 2
+func (w *respWriter) writeMap(n int) {
+	if w.protocol == 3 {
+		w.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+	} else {
+		w.writeArray(2 * n)
+	}
+}
+
+// flush sends the buffered replies to the client
+func (w *respWriter) flush() error {
+	return w.w.Flush()
+}
@@ -1,66 +1,451 @@
 package api
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/tdevsin/keyforge/internal/api/handler"
+	"github.com/tdevsin/keyforge/internal/auth"
 	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
 	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/replication"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"github.com/tdevsin/keyforge/internal/tracing"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
-// getAvailableListener tries to find an available port within the given range
-func getAvailableListener(start, end int) (net.Listener, error) {
-	for i := start; i <= end; i++ {
-		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", i))
-		if err == nil {
-			return lis, nil
-		}
-	}
-	return nil, fmt.Errorf("no available ports in range %d-%d", start, end)
+// expirySweepInterval is the time between deletions of expired keys
+const expirySweepInterval = time.Minute
+
+// listener is a gRPC server and the address it listens on
+type listener struct {
+	name      string
+	address   string
+	advertise string
+	server    *grpc.Server
+	lis       net.Listener
 }
 
-// StartGRPCServer starts a GRPC server on a port between 8080 and 8100
-func StartGRPCServer(conf *config.Config) error {
-	var lis net.Listener
-	var err error
+// StartGRPCServer starts a GRPC server on the configured listen address, and a second one for
+// cluster traffic if it has a separate port. Once the servers listen, join is called to join the
+// cluster, after which the node reports itself ready over grpc.health.v1 and serves keys.
+// It blocks until ctx is cancelled, after which the node leaves the cluster and the servers are drained.
+func StartGRPCServer(ctx context.Context, conf *config.Config, join func() error) error {
 	if conf.Environment == config.Dev {
 		conf.Logger.Info("Running in development mode")
-		lis, err = getAvailableListener(8080, 8100)
-		if err != nil {
-			conf.Logger.Error("Failed to find an available port", zap.Error(err))
-			return err
-		}
 	} else {
 		conf.Logger.Info("Running in production mode")
-		lis, err = net.Listen("tcp", ":8080")
-		if err != nil {
-			conf.Logger.Error("Failed to listen on port 8080", zap.Error(err))
-			return err
-		}
 	}
 
 	// Setup gRPC server
-	server := grpc.NewServer()
+	opts, err := serverOptions(conf)
+	if err != nil {
+		return err
+	}
+	health := newHealthState()
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(readinessUnaryInterceptor(health)),
+		grpc.ChainStreamInterceptor(readinessStreamInterceptor(health)),
+	)
+	server := grpc.NewServer(opts...)
 
 	// Reflection is used by clients like Postman to list services on the server and understand what methods are available
 	reflection.Register(server)
 
-	// Generate a new NodeId. This will be used to uniquely identify the node
-	conf.Logger.Info("Starting GRPC Server", zap.String("address", lis.Addr().String()))
-
 	// Register services
 	proto.RegisterKeyServiceServer(server, &handler.KVHandler{Conf: conf})
 	proto.RegisterHealthServiceServer(server, &handler.HealthHandler{Conf: conf})
-	proto.RegisterClusterServiceServer(server, &handler.ClusterHandler{Conf: conf})
+	health.register(server)
+	listeners := []*listener{{name: "GRPC Server", address: conf.Settings.Server.Listen(), advertise: conf.NodeInfo.Address, server: server}}
+
+	clusterServer := server
+	if conf.Settings.Internal.Separate() {
+		// Other nodes gossip, check health and proxy requests on the internal server, which can be firewalled from clients
+		clusterServer = grpc.NewServer(opts...)
+		proto.RegisterKeyServiceServer(clusterServer, &handler.KVHandler{Conf: conf})
+		proto.RegisterHealthServiceServer(clusterServer, &handler.HealthHandler{Conf: conf})
+		health.register(clusterServer)
+		listeners = append(listeners, &listener{name: "Internal GRPC Server", address: conf.Settings.Internal.Listen(), advertise: conf.NodeInfo.InternalAddress, server: clusterServer})
+	}
+	proto.RegisterClusterServiceServer(clusterServer, &handler.ClusterHandler{Conf: conf})
+
+	for i, l := range listeners {
+		l.lis, err = net.Listen("tcp", l.address)
+		if err != nil {
+			conf.Logger.Error("Failed to listen", zap.String("address", l.address), zap.Error(err))
+			for _, opened := range listeners[:i] {
+				opened.lis.Close()
+			}
+			return err
+		}
+	}
+
+	if conf.Settings.Metrics.ListenAddress != "" {
+		stopMetrics, err := startMetricsServer(conf)
+		if err != nil {
+			for _, l := range listeners {
+				l.lis.Close()
+			}
+			return err
+		}
+		// Metrics stay available until the servers are drained
+		defer stopMetrics()
+	}
+
+	stopGateway := func() {}
+	if conf.Settings.Gateway.ListenAddress != "" {
+		stopGateway, err = startGatewayServer(conf, health)
+		if err != nil {
+			for _, l := range listeners {
+				l.lis.Close()
+			}
+			return err
+		}
+		// Stops the gateway if the gRPC servers fail. Stopping it again after draining has no effect
+		defer stopGateway()
+	}
+
+	stopRedis := func() {}
+	if conf.Settings.Redis.ListenAddress != "" {
+		stopRedis, err = startRedisServer(conf, health)
+		if err != nil {
+			for _, l := range listeners {
+				l.lis.Close()
+			}
+			return err
+		}
+		defer stopRedis()
+	}
 
-	// Serve the server
-	if err := server.Serve(lis); err != nil {
-		conf.Logger.Error("Failed to start GRPC Server", zap.Error(err))
+	stopMemcached := func() {}
+	if conf.Settings.Memcached.ListenAddress != "" {
+		stopMemcached, err = startMemcachedServer(conf, health)
+		if err != nil {
+			for _, l := range listeners {
+				l.lis.Close()
+			}
+			return err
+		}
+		defer stopMemcached()
+	}
+
+	// Serve the servers
+	serveErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		conf.Logger.Info("Starting "+l.name, zap.String("address", l.lis.Addr().String()), zap.String("advertiseAddress", l.advertise))
+		go func() {
+			serveErr <- l.server.Serve(l.lis)
+		}()
+	}
+
+	// stopAll stops the servers immediately and returns the first of their errors
+	stopAll := func(err error) error {
+		for _, l := range listeners {
+			l.server.Stop()
+		}
+		for range listeners {
+			err = errors.Join(err, <-serveErr)
+		}
 		return err
 	}
-	return nil
+
+	// Other nodes can reach this node from now on, which they may need to while it joins
+	if err := join(); err != nil {
+		conf.Logger.Error("Failed to join the cluster", zap.Error(err))
+		return stopAll(err)
+	}
+	health.markReady()
+	conf.Logger.Info("Node is ready")
+	go storage.SweepExpired(ctx, conf.Db, expirySweepInterval, conf.Logger)
+	if conf.Settings.Replication.Remote.Enabled() {
+		shipper, err := replication.NewShipper(conf)
+		if err != nil {
+			conf.Logger.Error("Failed to start replicating to the remote cluster", zap.Error(err))
+			return stopAll(err)
+		}
+		go shipper.Run(ctx)
+	}
+
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			conf.Logger.Error("Failed to start GRPC Server", zap.Error(err))
+		}
+		// Stop the other servers as the node can not work without all of them
+		for _, l := range listeners {
+			l.server.Stop()
+		}
+		for range listeners[1:] {
+			<-serveErr
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	conf.Logger.Info("Shutdown requested, announcing node as leaving")
+	health.markDraining()
+	if err := conf.ClusterInfo.Leave(); err != nil {
+		conf.Logger.Warn("Failed to announce leave to some nodes", zap.Error(err))
+	}
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		stopGateway()
+	}()
+	go func() {
+		defer wg.Done()
+		stopRedis()
+	}()
+	go func() {
+		defer wg.Done()
+		stopMemcached()
+	}()
+	for _, l := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gracefulStop(conf, l.server, conf.Settings.Server.DrainTimeout)
+		}()
+	}
+	wg.Wait()
+	health.shutdown()
+
+	var errs []error
+	for range listeners {
+		errs = append(errs, <-serveErr)
+	}
+	return errors.Join(errs...)
+}
+
+// serverOptions returns the options of the gRPC server based on the config
+func serverOptions(conf *config.Config) ([]grpc.ServerOption, error) {
+	// Logging and metrics come first so rejected requests are recorded as well. Panics are
+	// recovered inside them, so they are recorded as internal errors.
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			requestIDUnaryInterceptor,
+			accessLogUnaryInterceptor(conf.Logger),
+			metricsUnaryInterceptor,
+			recoveryUnaryInterceptor(conf.Logger),
+		),
+		grpc.ChainStreamInterceptor(
+			requestIDStreamInterceptor,
+			accessLogStreamInterceptor(conf.Logger),
+			metricsStreamInterceptor,
+			recoveryStreamInterceptor(conf.Logger),
+		),
+	}
+	if conf.Settings.Tracing.Enabled() {
+		opts = append(opts, grpc.StatsHandler(tracing.ServerHandler()))
+	}
+	if conf.Certificates != nil {
+		tlsConfig, err := conf.Certificates.ServerConfig(conf.Settings.TLS.ClientAuth)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		conf.Logger.Info("TLS enabled", zap.String("clientAuth", conf.Settings.TLS.ClientAuth))
+	}
+	if conf.NodeAuth != nil {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(nodeAuthUnaryInterceptor(conf.NodeAuth)),
+			grpc.ChainStreamInterceptor(nodeAuthStreamInterceptor(conf.NodeAuth)),
+		)
+		conf.Logger.Info("Node authentication enabled", zap.String("mode", conf.Settings.Internal.Auth))
+	} else {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(dataMethodsUnaryInterceptor),
+			grpc.ChainStreamInterceptor(dataMethodsStreamInterceptor),
+		)
+		if conf.Authenticator != nil {
+			conf.Logger.Warn("Authentication is enabled but nodes are not authenticated as internal.unauthenticated is set, anyone can call ClusterService")
+		}
+	}
+	if conf.Authenticator != nil {
+		if conf.Certificates == nil {
+			conf.Logger.Warn("Authentication is enabled without TLS, tokens are sent in plaintext")
+		}
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(authUnaryInterceptor(conf.Authenticator)),
+			grpc.ChainStreamInterceptor(authStreamInterceptor(conf.Authenticator)),
+		)
+		conf.Logger.Info("Authentication enabled", zap.String("mode", conf.Settings.Auth.Mode))
+	}
+	return opts, nil
+}
+
+// authenticatedServices are the services whose callers must present a valid token or be a node
+var authenticatedServices = map[string]bool{
+	proto.KeyService_ServiceDesc.ServiceName: true,
+}
+
+// nodeServices are the services only other nodes may call when nodes are authenticated
+var nodeServices = map[string]bool{
+	proto.ClusterService_ServiceDesc.ServiceName: true,
+}
+
+// dataMethods are the node service methods reading or writing keys without the checks of
+// KeyService. They are only served when nodes are authenticated, as they would let anyone who can
+// reach the port read or overwrite every key.
+var dataMethods = map[string]bool{
+	proto.ClusterService_Backup_FullMethodName:       true,
+	proto.ClusterService_ExportKeys_FullMethodName:   true,
+	proto.ClusterService_ImportKeys_FullMethodName:   true,
+	proto.ClusterService_LoadTables_FullMethodName:   true,
+	proto.ClusterService_ApplyChanges_FullMethodName: true,
+}
+
+// rejectDataMethod returns an error for data methods, which are not served without node authentication
+func rejectDataMethod(fullMethod string) error {
+	if !dataMethods[fullMethod] {
+		return nil
+	}
+	_, method := splitMethod(fullMethod)
+	return status.Errorf(codes.PermissionDenied, "%s requires the cluster to authenticate nodes, set internal.auth", method)
+}
+
+// dataMethodsUnaryInterceptor rejects requests to data methods on clusters that do not authenticate nodes
+func dataMethodsUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := rejectDataMethod(info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// dataMethodsStreamInterceptor rejects streams of data methods on clusters that do not authenticate nodes
+func dataMethodsStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := rejectDataMethod(info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// splitMethod returns the service and method name of a method. Methods are named /<service>/<method>
+func splitMethod(fullMethod string) (string, string) {
+	service, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	return service, method
+}
+
+// serviceName returns the service of a method
+func serviceName(fullMethod string) string {
+	service, _ := splitMethod(fullMethod)
+	return service
+}
+
+// authenticateNode returns a context carrying the node principal if the request comes from another node.
+// Requests to node services from anyone else are rejected.
+func authenticateNode(ctx context.Context, fullMethod string, nodeAuth auth.NodeAuthenticator) (context.Context, error) {
+	if err := nodeAuth.AuthenticateNode(ctx); err == nil {
+		return auth.NewContext(ctx, auth.NodePrincipal), nil
+	}
+	if nodeServices[serviceName(fullMethod)] {
+		return nil, constants.StatusErrUnauthenticated
+	}
+	return ctx, nil
+}
+
+// authenticate verifies the token of the request and returns a context carrying the principal.
+// Requests already authenticated as a node are passed through.
+func authenticate(ctx context.Context, fullMethod string, authenticator auth.Authenticator) (context.Context, error) {
+	if !authenticatedServices[serviceName(fullMethod)] {
+		return ctx, nil
+	}
+	if principal, ok := auth.FromContext(ctx); ok && principal.Node {
+		return ctx, nil
+	}
+	token, err := auth.TokenFromIncomingContext(ctx)
+	if err != nil {
+		return nil, constants.StatusErrUnauthenticated
+	}
+	principal, err := authenticator.Authenticate(token)
+	if err != nil {
+		return nil, constants.StatusErrUnauthenticated
+	}
+	return auth.NewContext(ctx, principal), nil
+}
+
+// nodeAuthUnaryInterceptor identifies requests of other nodes and rejects everyone else from node services
+func nodeAuthUnaryInterceptor(nodeAuth auth.NodeAuthenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticateNode(ctx, info.FullMethod, nodeAuth)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// nodeAuthStreamInterceptor identifies streams of other nodes and rejects everyone else from node services
+func nodeAuthStreamInterceptor(nodeAuth auth.NodeAuthenticator) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticateNode(ss.Context(), info.FullMethod, nodeAuth)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authUnaryInterceptor rejects unauthenticated requests to authenticated services
+func authUnaryInterceptor(authenticator auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticate(ctx, info.FullMethod, authenticator)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authStreamInterceptor rejects unauthenticated streams to authenticated services
+func authStreamInterceptor(authenticator auth.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), info.FullMethod, authenticator)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// contextStream replaces the context of a server stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// gracefulStop waits for in-flight requests to finish and forcefully stops the server once the timeout passes
+func gracefulStop(conf *config.Config, server *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-stopped:
+		conf.Logger.Info("GRPC Server stopped gracefully")
+	case <-timer.C:
+		conf.Logger.Warn("Drain timeout exceeded, stopping GRPC Server forcefully", zap.Duration("timeout", timeout))
+		server.Stop()
+		<-stopped
+	}
 }
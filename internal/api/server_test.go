@@ -1,17 +1,173 @@
 package api
 
 import (
+	"context"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tdevsin/keyforge/internal/auth"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/constants"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
-func TestGetAvailableListener(t *testing.T) {
-	lis, err := getAvailableListener(8080, 8089)
+func TestGracefulStop(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatalf("Expected an available port, but got error: %v", err)
 	}
-	defer lis.Close()
 
-	if lis.Addr().String() == "" {
-		t.Fatalf("Listener address should not be empty")
+	server := grpc.NewServer()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(lis)
+	}()
+
+	conf := &config.Config{Logger: logger.GetLogger(false, "test")}
+	gracefulStop(conf, server, time.Second)
+
+	select {
+	case err := <-serveErr:
+		// Serve may not have started yet when the server is stopped
+		if err != nil && err != grpc.ErrServerStopped {
+			t.Fatalf("Expected server to stop without error, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Server did not stop")
 	}
 }
+
+type fakeAuthenticator map[string]string
+
+func (f fakeAuthenticator) Authenticate(token string) (*auth.Principal, error) {
+	name, ok := f[token]
+	if !ok {
+		return nil, auth.ErrInvalidToken
+	}
+	return &auth.Principal{Name: name}, nil
+}
+
+func TestAuthUnaryInterceptor(t *testing.T) {
+	interceptor := authUnaryInterceptor(fakeAuthenticator{"secret": "alice"})
+	handler := func(ctx context.Context, req any) (any, error) {
+		principal, ok := auth.FromContext(ctx)
+		if !ok {
+			return "anonymous", nil
+		}
+		return principal.Name, nil
+	}
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.TODO(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+	getKey := &grpc.UnaryServerInfo{FullMethod: proto.KeyService_GetKey_FullMethodName}
+
+	t.Run("Valid token", func(t *testing.T) {
+		resp, err := interceptor(withToken("secret"), nil, getKey, handler)
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", resp)
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		_, err := interceptor(withToken("guess"), nil, getKey, handler)
+		assert.Equal(t, constants.StatusErrUnauthenticated, err)
+	})
+
+	t.Run("Missing token", func(t *testing.T) {
+		_, err := interceptor(context.TODO(), nil, getKey, handler)
+		assert.Equal(t, constants.StatusErrUnauthenticated, err)
+	})
+
+	t.Run("Other services do not require a token", func(t *testing.T) {
+		info := &grpc.UnaryServerInfo{FullMethod: proto.HealthService_CheckHealth_FullMethodName}
+		resp, err := interceptor(context.TODO(), nil, info, handler)
+		assert.NoError(t, err)
+		assert.Equal(t, "anonymous", resp)
+	})
+}
+
+type fakeNodeAuthenticator struct{}
+
+func (fakeNodeAuthenticator) AuthenticateNode(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get("node")) == 0 {
+		return auth.ErrNotANode
+	}
+	return nil
+}
+
+func TestNodeAuthUnaryInterceptor(t *testing.T) {
+	nodeInterceptor := nodeAuthUnaryInterceptor(fakeNodeAuthenticator{})
+	userInterceptor := authUnaryInterceptor(fakeAuthenticator{"secret": "alice"})
+	handler := func(ctx context.Context, req any) (any, error) {
+		principal, ok := auth.FromContext(ctx)
+		if !ok {
+			return "anonymous", nil
+		}
+		return principal.Name, nil
+	}
+	// chain runs both interceptors like the server does
+	chain := func(ctx context.Context, method string) (any, error) {
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		return nodeInterceptor(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+			return userInterceptor(ctx, req, info, handler)
+		})
+	}
+	node := metadata.NewIncomingContext(context.TODO(), metadata.Pairs("node", "1"))
+	client := metadata.NewIncomingContext(context.TODO(), metadata.Pairs("authorization", "Bearer secret"))
+
+	t.Run("Node calls cluster service", func(t *testing.T) {
+		resp, err := chain(node, proto.ClusterService_SetClusterState_FullMethodName)
+		assert.NoError(t, err)
+		assert.Equal(t, auth.NodePrincipal.Name, resp)
+	})
+
+	t.Run("Client calls cluster service", func(t *testing.T) {
+		_, err := chain(client, proto.ClusterService_SetClusterState_FullMethodName)
+		assert.Equal(t, constants.StatusErrUnauthenticated, err)
+	})
+
+	t.Run("Node proxies key request without a token", func(t *testing.T) {
+		resp, err := chain(node, proto.KeyService_GetKey_FullMethodName)
+		assert.NoError(t, err)
+		assert.Equal(t, auth.NodePrincipal.Name, resp)
+	})
+
+	t.Run("Client calls key service", func(t *testing.T) {
+		resp, err := chain(client, proto.KeyService_GetKey_FullMethodName)
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", resp)
+	})
+
+	t.Run("Anyone calls health service", func(t *testing.T) {
+		resp, err := chain(context.TODO(), proto.HealthService_CheckHealth_FullMethodName)
+		assert.NoError(t, err)
+		assert.Equal(t, "anonymous", resp)
+	})
+}
+
+func TestDataMethodsUnaryInterceptor(t *testing.T) {
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "served", nil
+	}
+
+	t.Run("Data methods need node authentication", func(t *testing.T) {
+		info := &grpc.UnaryServerInfo{FullMethod: proto.ClusterService_ImportKeys_FullMethodName}
+		_, err := dataMethodsUnaryInterceptor(context.TODO(), nil, info, handler)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.ErrorContains(t, err, "ImportKeys requires the cluster to authenticate nodes")
+	})
+
+	t.Run("Other methods are served", func(t *testing.T) {
+		info := &grpc.UnaryServerInfo{FullMethod: proto.ClusterService_GetClusterState_FullMethodName}
+		resp, err := dataMethodsUnaryInterceptor(context.TODO(), nil, info, handler)
+		assert.NoError(t, err)
+		assert.Equal(t, "served", resp)
+	})
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Permission is an operation on keys
+type Permission string
+
+const (
+	Read   Permission = "read"
+	Write  Permission = "write"
+	Delete Permission = "delete"
+)
+
+// AnyPrincipal matches every authenticated principal in an ACL rule
+const AnyPrincipal = "*"
+
+// Rule grants permissions on all keys starting with a prefix. An empty prefix matches every key.
+type Rule struct {
+	Principal   string       `yaml:"principal"`
+	Prefix      string       `yaml:"prefix"`
+	Permissions []Permission `yaml:"permissions"`
+}
+
+// ACL decides which principal may perform which operation on which keys.
+// Everything not granted by a rule is denied.
+type ACL struct {
+	rules []Rule
+}
+
+// NewACL returns an ACL made of the given rules
+func NewACL(rules []Rule) (*ACL, error) {
+	for i, rule := range rules {
+		if rule.Principal == "" {
+			return nil, fmt.Errorf("rule %d needs a principal", i+1)
+		}
+		if len(rule.Permissions) == 0 {
+			return nil, fmt.Errorf("rule %d needs at least one permission", i+1)
+		}
+		for _, permission := range rule.Permissions {
+			if permission != Read && permission != Write && permission != Delete {
+				return nil, fmt.Errorf("rule %d: permission must be read, write or delete, got %q", i+1, permission)
+			}
+		}
+	}
+	return &ACL{rules: rules}, nil
+}
+
+// LoadACL reads a YAML file in the following format:
+//
+//	rules:
+//	  - principal: alice
+//	    prefix: "users/alice/"
+//	    permissions: [read, write, delete]
+//	  - principal: "*"
+//	    prefix: "public/"
+//	    permissions: [read]
+func LoadACL(path string) (*ACL, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACL file: %w", err)
+	}
+	var file struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse ACL file %s: %w", path, err)
+	}
+	acl, err := NewACL(file.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("ACL file %s: %w", path, err)
+	}
+	return acl, nil
+}
+
+// Allowed reports if the principal has the permission on the key
+func (a *ACL) Allowed(p *Principal, key string, permission Permission) bool {
+	if p == nil {
+		return false
+	}
+	for _, rule := range a.rules {
+		if rule.Principal != p.Name && rule.Principal != AnyPrincipal {
+			continue
+		}
+		if !strings.HasPrefix(key, rule.Prefix) {
+			continue
+		}
+		for _, granted := range rule.Permissions {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACL(t *testing.T) {
+	acl, err := NewACL([]Rule{
+		{Principal: "alice", Prefix: "users/alice/", Permissions: []Permission{Read, Write, Delete}},
+		{Principal: "bob", Prefix: "users/", Permissions: []Permission{Read}},
+		{Principal: AnyPrincipal, Prefix: "public/", Permissions: []Permission{Read}},
+		{Principal: "admin", Prefix: "", Permissions: []Permission{Read, Write, Delete}},
+	})
+	assert.NoError(t, err)
+
+	alice := &Principal{Name: "alice"}
+	bob := &Principal{Name: "bob"}
+	admin := &Principal{Name: "admin"}
+	carol := &Principal{Name: "carol"}
+
+	tests := []struct {
+		name       string
+		principal  *Principal
+		key        string
+		permission Permission
+		allowed    bool
+	}{
+		{"Own prefix", alice, "users/alice/name", Write, true},
+		{"Other prefix", alice, "users/bob/name", Read, false},
+		{"Read only prefix", bob, "users/alice/name", Read, true},
+		{"Missing permission", bob, "users/alice/name", Delete, false},
+		{"Any principal", carol, "public/motd", Read, true},
+		{"Any principal missing permission", carol, "public/motd", Write, false},
+		{"Empty prefix matches all keys", admin, "anything", Delete, true},
+		{"No rule", carol, "users/carol/name", Read, false},
+		{"No principal", nil, "public/motd", Read, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, acl.Allowed(tt.principal, tt.key, tt.permission))
+		})
+	}
+}
+
+func TestNewACL(t *testing.T) {
+	_, err := NewACL([]Rule{{Prefix: "users/", Permissions: []Permission{Read}}})
+	assert.ErrorContains(t, err, "principal")
+
+	_, err = NewACL([]Rule{{Principal: "alice", Prefix: "users/"}})
+	assert.ErrorContains(t, err, "at least one permission")
+
+	_, err = NewACL([]Rule{{Principal: "alice", Permissions: []Permission{"admin"}}})
+	assert.ErrorContains(t, err, "permission must be")
+}
+
+func TestLoadACL(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("Valid", func(t *testing.T) {
+		file := path.Join(dir, "acl.yaml")
+		os.WriteFile(file, []byte(`
+rules:
+  - principal: alice
+    prefix: "users/alice/"
+    permissions: [read, write]
+`), 0644)
+		acl, err := LoadACL(file)
+		assert.NoError(t, err)
+		assert.True(t, acl.Allowed(&Principal{Name: "alice"}, "users/alice/name", Write))
+		assert.False(t, acl.Allowed(&Principal{Name: "alice"}, "users/alice/name", Delete))
+	})
+
+	t.Run("Unknown key", func(t *testing.T) {
+		file := path.Join(dir, "unknown.yaml")
+		os.WriteFile(file, []byte("rules:\n  - principal: alice\n    prefixes: [a]\n"), 0644)
+		_, err := LoadACL(file)
+		assert.Error(t, err)
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, err := LoadACL(path.Join(dir, "missing.yaml"))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,77 @@
+// Package auth authenticates clients with tokens and authorizes key operations per principal
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name string // Name identifies the caller in ACL rules and logs
+	Node bool   // Node is set for other nodes of the cluster, which authorize requests before forwarding them
+}
+
+// NodePrincipal is the principal of requests sent by other nodes
+var NodePrincipal = &Principal{Name: "node", Node: true}
+
+// Authenticator verifies a bearer token and returns the principal it belongs to
+type Authenticator interface {
+	Authenticate(token string) (*Principal, error)
+}
+
+var (
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// authorizationHeader is the metadata key carrying the bearer token
+const authorizationHeader = "authorization"
+
+type principalKey struct{}
+
+// NewContext returns a context carrying the principal
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the context, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// TokenFromIncomingContext returns the bearer token of an incoming request
+func TokenFromIncomingContext(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", ErrMissingToken
+	}
+	values := md.Get(authorizationHeader)
+	if len(values) == 0 {
+		return "", ErrMissingToken
+	}
+	scheme, token, found := strings.Cut(values[0], " ")
+	if !found || !strings.EqualFold(scheme, "bearer") || token == "" {
+		return "", ErrMissingToken
+	}
+	return token, nil
+}
+
+// ForwardToken copies the bearer token of an incoming request to the outgoing context, so
+// proxied requests are authorized as the original caller
+func ForwardToken(ctx context.Context) context.Context {
+	token, err := TokenFromIncomingContext(ctx)
+	if err != nil {
+		return ctx
+	}
+	return WithToken(ctx, token)
+}
+
+// WithToken returns a context that sends the bearer token with outgoing requests
+func WithToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, authorizationHeader, "Bearer "+token)
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTVerifier authenticates callers with JWTs signed by a key read from a local file.
+// The subject of the token is used as the principal.
+type JWTVerifier struct {
+	key    any
+	parser *jwt.Parser
+}
+
+// NewJWTVerifier reads the verification key from keyFile. A PEM encoded RSA, ECDSA or Ed25519
+// public key verifies RS*, PS*, ES* or EdDSA tokens; any other content is used as the HMAC secret
+// of HS* tokens. Issuer and audience are only checked when they are not empty.
+func NewJWTVerifier(keyFile, issuer, audience string) (*JWTVerifier, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT key file: %w", err)
+	}
+	key, methods, err := parseVerificationKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("JWT key file %s: %w", keyFile, err)
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+	return &JWTVerifier{key: key, parser: jwt.NewParser(opts...)}, nil
+}
+
+// Authenticate verifies the signature and claims of the token
+func (v *JWTVerifier) Authenticate(token string) (*Principal, error) {
+	claims := jwt.RegisteredClaims{}
+	_, err := v.parser.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return v.key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	return &Principal{Name: claims.Subject}, nil
+}
+
+// parseVerificationKey returns the key and the signing methods it can verify
+func parseVerificationKey(data []byte) (any, []string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		// Trailing new lines are not part of the secret
+		secret := bytes.TrimSpace(data)
+		if len(secret) == 0 {
+			return nil, nil, fmt.Errorf("file is empty")
+		}
+		return secret, []string{"HS256", "HS384", "HS512"}, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return key, []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}, nil
+	case *ecdsa.PublicKey:
+		return key, []string{"ES256", "ES384", "ES512"}, nil
+	case ed25519.PublicKey:
+		return key, []string{"EdDSA"}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWTVerifier(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	publicKeyFile := path.Join(dir, "jwt.pub")
+	os.WriteFile(publicKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+
+	secretFile := path.Join(dir, "jwt.secret")
+	os.WriteFile(secretFile, []byte("secret\n"), 0600)
+
+	claims := func(subject string, expiresIn time.Duration) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Subject:   subject,
+			Issuer:    "keyforge-test",
+			Audience:  jwt.ClaimStrings{"keyforge"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		}
+	}
+	sign := func(method jwt.SigningMethod, key any, claims jwt.RegisteredClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		assert.NoError(t, err)
+		return token
+	}
+
+	verifier, err := NewJWTVerifier(publicKeyFile, "keyforge-test", "keyforge")
+	assert.NoError(t, err)
+
+	t.Run("Valid token", func(t *testing.T) {
+		p, err := verifier.Authenticate(sign(jwt.SigningMethodES256, key, claims("alice", time.Minute)))
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", p.Name)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		_, err := verifier.Authenticate(sign(jwt.SigningMethodES256, key, claims("alice", -time.Minute)))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Missing subject", func(t *testing.T) {
+		_, err := verifier.Authenticate(sign(jwt.SigningMethodES256, key, claims("", time.Minute)))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Wrong issuer", func(t *testing.T) {
+		c := claims("alice", time.Minute)
+		c.Issuer = "someone-else"
+		_, err := verifier.Authenticate(sign(jwt.SigningMethodES256, key, c))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Signed by another key", func(t *testing.T) {
+		otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		_, err := verifier.Authenticate(sign(jwt.SigningMethodES256, otherKey, claims("alice", time.Minute)))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("HMAC token is rejected by a public key", func(t *testing.T) {
+		_, err := verifier.Authenticate(sign(jwt.SigningMethodHS256, der, claims("alice", time.Minute)))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("HMAC secret", func(t *testing.T) {
+		hmacVerifier, err := NewJWTVerifier(secretFile, "", "")
+		assert.NoError(t, err)
+		p, err := hmacVerifier.Authenticate(sign(jwt.SigningMethodHS256, []byte("secret"), claims("bob", time.Minute)))
+		assert.NoError(t, err)
+		assert.Equal(t, "bob", p.Name)
+	})
+
+	t.Run("Missing key file", func(t *testing.T) {
+		_, err := NewJWTVerifier(path.Join(dir, "missing"), "", "")
+		assert.Error(t, err)
+	})
+}
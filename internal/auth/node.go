@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// NodeAuthenticator verifies that a request was sent by another node of the cluster
+type NodeAuthenticator interface {
+	AuthenticateNode(ctx context.Context) error
+}
+
+var ErrNotANode = errors.New("caller is not a node of the cluster")
+
+// secretHeader is the metadata key carrying the cluster secret
+const secretHeader = "keyforge-cluster-secret"
+
+// SharedSecret authenticates nodes with a secret known to every node of the cluster. The secret
+// is only sent over TLS unless plaintext is allowed.
+type SharedSecret struct {
+	secret    []byte
+	plaintext bool
+}
+
+// LoadSharedSecret reads the cluster secret from a file. Surrounding whitespace is ignored.
+func LoadSharedSecret(path string) (*SharedSecret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster secret file: %w", err)
+	}
+	secret := bytes.TrimSpace(data)
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("cluster secret in %s must be at least 16 bytes long", path)
+	}
+	return &SharedSecret{secret: secret}, nil
+}
+
+// AuthenticateNode checks the secret sent with the request
+func (s *SharedSecret) AuthenticateNode(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(secretHeader)
+	if len(values) == 0 || subtle.ConstantTimeCompare([]byte(values[0]), s.secret) != 1 {
+		return ErrNotANode
+	}
+	return nil
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials, so the secret is sent with every request of a connection
+func (s *SharedSecret) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{secretHeader: string(s.secret)}, nil
+}
+
+// AllowPlaintext returns a copy of the secret that is also sent over plaintext connections, for local clusters
+func (s *SharedSecret) AllowPlaintext() *SharedSecret {
+	return &SharedSecret{secret: s.secret, plaintext: true}
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials
+func (s *SharedSecret) RequireTransportSecurity() bool {
+	return !s.plaintext
+}
+
+// NodeCertificates authenticates nodes by the certificate they present over mutual TLS. The
+// certificate is verified against the CA during the handshake, and must carry one of the node
+// names, so clients holding other certificates of the same CA are not accepted as nodes.
+type NodeCertificates struct {
+	names []string
+}
+
+// NewNodeCertificates accepts certificates whose common name or a DNS name matches one of names.
+// A * matches any characters within a single label, like node*.keyforge.internal.
+func NewNodeCertificates(names []string) (*NodeCertificates, error) {
+	if len(names) == 0 {
+		return nil, errors.New("at least one node name is required")
+	}
+	for _, name := range names {
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, fmt.Errorf("node name %q is invalid: %w", name, err)
+		}
+	}
+	return &NodeCertificates{names: names}, nil
+}
+
+// AuthenticateNode checks that the caller presented a client certificate of a node
+func (n *NodeCertificates) AuthenticateNode(ctx context.Context) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ErrNotANode
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return ErrNotANode
+	}
+	cert := info.State.PeerCertificates[0]
+	for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+		if n.node(name) {
+			return nil
+		}
+	}
+	return ErrNotANode
+}
+
+// node reports if a certificate name matches one of the node names, label by label
+func (n *NodeCertificates) node(name string) bool {
+	if name == "" {
+		return false
+	}
+	labels := strings.Split(name, ".")
+	for _, pattern := range n.names {
+		patternLabels := strings.Split(pattern, ".")
+		if len(patternLabels) != len(labels) {
+			continue
+		}
+		matched := true
+		for i := range labels {
+			if ok, _ := path.Match(patternLabels[i], labels[i]); !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestSharedSecret(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "cluster.secret")
+	os.WriteFile(file, []byte("0123456789abcdef\n"), 0600)
+
+	secret, err := LoadSharedSecret(file)
+	assert.NoError(t, err)
+
+	t.Run("Request metadata is accepted", func(t *testing.T) {
+		md, err := secret.GetRequestMetadata(context.TODO())
+		assert.NoError(t, err)
+		ctx := metadata.NewIncomingContext(context.TODO(), metadata.New(md))
+		assert.NoError(t, secret.AuthenticateNode(ctx))
+	})
+
+	t.Run("Wrong secret", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.TODO(), metadata.Pairs(secretHeader, "0123456789abcdeX"))
+		assert.ErrorIs(t, secret.AuthenticateNode(ctx), ErrNotANode)
+	})
+
+	t.Run("Missing secret", func(t *testing.T) {
+		assert.ErrorIs(t, secret.AuthenticateNode(context.TODO()), ErrNotANode)
+	})
+
+	t.Run("Requires TLS unless plaintext is allowed", func(t *testing.T) {
+		assert.True(t, secret.RequireTransportSecurity())
+		assert.False(t, secret.AllowPlaintext().RequireTransportSecurity())
+	})
+
+	t.Run("Short secret", func(t *testing.T) {
+		short := path.Join(dir, "short.secret")
+		os.WriteFile(short, []byte("secret"), 0600)
+		_, err := LoadSharedSecret(short)
+		assert.ErrorContains(t, err, "at least 16 bytes")
+	})
+}
+
+func TestNodeCertificates(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}
+	nodes, err := NewNodeCertificates([]string{"node*.keyforge.internal", "admin"})
+	assert.NoError(t, err)
+
+	// withCertificate returns a context of a TLS connection presenting a certificate
+	withCertificate := func(cert *x509.Certificate) context.Context {
+		info := credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}}
+		return peer.NewContext(context.TODO(), &peer.Peer{Addr: addr, AuthInfo: info})
+	}
+
+	t.Run("Missing peer", func(t *testing.T) {
+		assert.ErrorIs(t, nodes.AuthenticateNode(context.TODO()), ErrNotANode)
+	})
+
+	t.Run("Plaintext connection", func(t *testing.T) {
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{Addr: addr})
+		assert.ErrorIs(t, nodes.AuthenticateNode(ctx), ErrNotANode)
+	})
+
+	t.Run("TLS without client certificate", func(t *testing.T) {
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{Addr: addr, AuthInfo: credentials.TLSInfo{}})
+		assert.ErrorIs(t, nodes.AuthenticateNode(ctx), ErrNotANode)
+	})
+
+	t.Run("Certificate of a node", func(t *testing.T) {
+		assert.NoError(t, nodes.AuthenticateNode(withCertificate(&x509.Certificate{DNSNames: []string{"localhost", "node1.keyforge.internal"}})))
+		assert.NoError(t, nodes.AuthenticateNode(withCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "admin"}})))
+	})
+
+	t.Run("Certificate of a client", func(t *testing.T) {
+		assert.ErrorIs(t, nodes.AuthenticateNode(withCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "alice"}})), ErrNotANode)
+		assert.ErrorIs(t, nodes.AuthenticateNode(withCertificate(&x509.Certificate{})), ErrNotANode)
+	})
+
+	t.Run("Wildcards match a single label", func(t *testing.T) {
+		cert := &x509.Certificate{DNSNames: []string{"node1.evil.keyforge.internal", "node1.keyforge.internal.evil"}}
+		assert.ErrorIs(t, nodes.AuthenticateNode(withCertificate(cert)), ErrNotANode)
+	})
+
+	t.Run("Invalid names", func(t *testing.T) {
+		_, err := NewNodeCertificates(nil)
+		assert.ErrorContains(t, err, "at least one node name")
+		_, err = NewNodeCertificates([]string{"node[.internal"})
+		assert.ErrorContains(t, err, "is invalid")
+	})
+}
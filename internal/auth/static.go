@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StaticTokens authenticates callers with tokens listed in a file
+type StaticTokens struct {
+	principals map[[sha256.Size]byte]string // principals by the hash of their token
+}
+
+// tokensFile is the format of the static tokens file
+type tokensFile struct {
+	Tokens []struct {
+		Principal string `yaml:"principal"`
+		Token     string `yaml:"token"`
+	} `yaml:"tokens"`
+}
+
+// LoadStaticTokens reads a YAML file in the following format:
+//
+//	tokens:
+//	  - principal: alice
+//	    token: 4f8a0c...
+func LoadStaticTokens(path string) (*StaticTokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %w", err)
+	}
+	var file tokensFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens file %s: %w", path, err)
+	}
+
+	tokens := &StaticTokens{principals: make(map[[sha256.Size]byte]string)}
+	for i, entry := range file.Tokens {
+		if entry.Principal == "" || entry.Token == "" {
+			return nil, fmt.Errorf("tokens file %s: entry %d needs a principal and a token", path, i+1)
+		}
+		hash := sha256.Sum256([]byte(entry.Token))
+		if _, exists := tokens.principals[hash]; exists {
+			return nil, fmt.Errorf("tokens file %s: token of %s is used more than once", path, entry.Principal)
+		}
+		tokens.principals[hash] = entry.Principal
+	}
+	return tokens, nil
+}
+
+// Authenticate returns the principal owning the token. Tokens are looked up by their hash so
+// the lookup time does not depend on how much of a token matches.
+func (s *StaticTokens) Authenticate(token string) (*Principal, error) {
+	name, ok := s.principals[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return &Principal{Name: name}, nil
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestStaticTokens(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "tokens.yaml")
+	os.WriteFile(file, []byte(`
+tokens:
+  - principal: alice
+    token: alice-token
+  - principal: bob
+    token: bob-token
+`), 0600)
+
+	tokens, err := LoadStaticTokens(file)
+	assert.NoError(t, err)
+
+	t.Run("Valid token", func(t *testing.T) {
+		p, err := tokens.Authenticate("bob-token")
+		assert.NoError(t, err)
+		assert.Equal(t, "bob", p.Name)
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		_, err := tokens.Authenticate("alice-token2")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Duplicate token", func(t *testing.T) {
+		duplicate := path.Join(dir, "duplicate.yaml")
+		os.WriteFile(duplicate, []byte("tokens:\n  - {principal: a, token: x}\n  - {principal: b, token: x}\n"), 0600)
+		_, err := LoadStaticTokens(duplicate)
+		assert.ErrorContains(t, err, "more than once")
+	})
+
+	t.Run("Missing token", func(t *testing.T) {
+		missing := path.Join(dir, "missing.yaml")
+		os.WriteFile(missing, []byte("tokens:\n  - {principal: a}\n"), 0600)
+		_, err := LoadStaticTokens(missing)
+		assert.ErrorContains(t, err, "needs a principal and a token")
+	})
+}
+
+func TestTokenFromIncomingContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []string
+		token  string
+		err    error
+	}{
+		{"Bearer token", []string{"Bearer abc"}, "abc", nil},
+		{"Case insensitive scheme", []string{"bearer abc"}, "abc", nil},
+		{"Missing header", nil, "", ErrMissingToken},
+		{"Other scheme", []string{"Basic abc"}, "", ErrMissingToken},
+		{"Empty token", []string{"Bearer "}, "", ErrMissingToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := metadata.MD{}
+			if tt.header != nil {
+				md.Set("authorization", tt.header...)
+			}
+			token, err := TokenFromIncomingContext(metadata.NewIncomingContext(context.TODO(), md))
+			assert.Equal(t, tt.token, token)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+
+	t.Run("Forward token", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.TODO(), metadata.Pairs("authorization", "Bearer abc"))
+		md, _ := metadata.FromOutgoingContext(ForwardToken(ctx))
+		assert.Equal(t, []string{"Bearer abc"}, md.Get("authorization"))
+
+		_, ok := metadata.FromOutgoingContext(ForwardToken(context.TODO()))
+		assert.False(t, ok)
+	})
+}
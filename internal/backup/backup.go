@@ -0,0 +1,473 @@
+// Package backup streams the files of node checkpoints, writes them into a directory or a tar
+// archive and restores data directories from them, verifying every file against its checksum.
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Layout of a backup
+const (
+	ManifestFile = "MANIFEST.json"
+	DataDir      = "data"     // DataDir contains the checkpoint of the key-value data
+	MetadataDir  = "metadata" // MetadataDir contains the checkpoint of the node metadata
+)
+
+// chunkSize is the size of the data of streamed chunks
+const chunkSize = 1 << 20
+
+// ErrChecksum is returned when a file of a backup does not match the manifest
+var ErrChecksum = errors.New("backup does not match its manifest")
+
+var manifestMarshaler = protojson.MarshalOptions{UseProtoNames: true, Multiline: true}
+
+// IsArchive reports whether a backup path names a tar archive rather than a directory
+func IsArchive(path string) bool {
+	return strings.HasSuffix(path, ".tar") || compressed(path)
+}
+
+// compressed reports whether an archive path names a gzip compressed tar archive
+func compressed(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// Send streams the files below dir in chunks and returns them with their checksums. The data of a
+// chunk is only valid until send returns.
+func Send(dir string, send func(*proto.BackupChunk) error) ([]*proto.BackupFile, error) {
+	var files []*proto.BackupFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file, err := sendFile(path, filepath.ToSlash(name), send)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	})
+	return files, err
+}
+
+// sendFile streams a single file
+func sendFile(path, name string, send func(*proto.BackupChunk) error) (*proto.BackupFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	chunk := &proto.BackupChunk{Path: name, Size: info.Size()}
+	buf := make([]byte, chunkSize)
+	var size int64
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 || chunk.Path != "" {
+			h.Write(buf[:n])
+			size += int64(n)
+			chunk.Data = buf[:n]
+			if err := send(chunk); err != nil {
+				return nil, err
+			}
+			chunk = &proto.BackupChunk{}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if size != info.Size() {
+		return nil, fmt.Errorf("%s changed while it was sent", name)
+	}
+	return &proto.BackupFile{Path: name, Size: size, Sha256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// sink writes the files of a backup
+type sink interface {
+	// create starts a file of the given size. Data written afterwards belongs to it.
+	create(name string, size int64) (io.Writer, error)
+	// close finishes the last file and the backup
+	close() error
+}
+
+// dirSink writes files into a directory
+type dirSink struct {
+	dir  string
+	file *os.File
+}
+
+func (s *dirSink) create(name string, size int64) (io.Writer, error) {
+	if err := s.closeFile(); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.file = f
+	return f, nil
+}
+
+// closeFile syncs and closes the current file
+func (s *dirSink) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+	err := errors.Join(s.file.Sync(), s.file.Close())
+	s.file = nil
+	return err
+}
+
+func (s *dirSink) close() error {
+	return s.closeFile()
+}
+
+// tarSink writes files into a tar archive, optionally gzip compressed
+type tarSink struct {
+	file *os.File
+	gzip *gzip.Writer
+	tar  *tar.Writer
+	now  time.Time
+}
+
+func newTarSink(path string) (*tarSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &tarSink{file: f, now: time.Now()}
+	if compressed(path) {
+		s.gzip = gzip.NewWriter(f)
+		s.tar = tar.NewWriter(s.gzip)
+	} else {
+		s.tar = tar.NewWriter(f)
+	}
+	return s, nil
+}
+
+func (s *tarSink) create(name string, size int64) (io.Writer, error) {
+	err := s.tar.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0o644, ModTime: s.now})
+	return s.tar, err
+}
+
+func (s *tarSink) close() error {
+	err := s.tar.Close()
+	if s.gzip != nil {
+		err = errors.Join(err, s.gzip.Close())
+	}
+	return errors.Join(err, s.file.Sync(), s.file.Close())
+}
+
+// Receive writes a backup stream into path, a directory or a tar archive depending on its
+// extension, and returns its manifest. The received files are verified against the manifest, which
+// is written last. Nothing is left at path if it fails.
+func Receive(path string, recv func() (*proto.BackupChunk, error)) (manifest *proto.BackupManifest, err error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+	var s sink
+	if IsArchive(path) {
+		if s, err = newTarSink(path); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return nil, err
+		}
+		s = &dirSink{dir: path}
+	}
+	defer func() {
+		if err != nil {
+			s.close()
+			os.RemoveAll(path)
+		}
+	}()
+
+	v := newVerifier()
+	var w io.Writer
+	for {
+		chunk, err := recv()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("backup stream ended without a manifest")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chunk.Manifest != nil {
+			manifest = chunk.Manifest
+			break
+		}
+		if chunk.Path != "" {
+			if !filepath.IsLocal(filepath.FromSlash(chunk.Path)) || chunk.Path == ManifestFile {
+				return nil, fmt.Errorf("backup contains invalid path %q", chunk.Path)
+			}
+			if w, err = s.create(chunk.Path, chunk.Size); err != nil {
+				return nil, err
+			}
+			w = v.add(chunk.Path, w)
+		} else if w == nil {
+			return nil, errors.New("backup stream sent data before a file")
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := v.verify(manifest); err != nil {
+		return nil, err
+	}
+
+	data, err := manifestMarshaler.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if w, err = s.create(ManifestFile, int64(len(data))); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	return manifest, s.close()
+}
+
+// verifier computes the checksums of files while they are written
+type verifier struct {
+	files map[string]*hashWriter
+}
+
+func newVerifier() *verifier {
+	return &verifier{files: map[string]*hashWriter{}}
+}
+
+// add returns a writer hashing everything written to w as the content of the named file
+func (v *verifier) add(name string, w io.Writer) io.Writer {
+	hw := &hashWriter{w: w, hash: sha256.New()}
+	v.files[name] = hw
+	return hw
+}
+
+// verify checks that exactly the files of the manifest were written, with the same sizes and checksums
+func (v *verifier) verify(manifest *proto.BackupManifest) error {
+	if len(manifest.Files) != len(v.files) {
+		return fmt.Errorf("%w: it lists %d files but the backup has %d", ErrChecksum, len(manifest.Files), len(v.files))
+	}
+	for _, file := range manifest.Files {
+		hw, ok := v.files[file.Path]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", ErrChecksum, file.Path)
+		}
+		if sum := hex.EncodeToString(hw.hash.Sum(nil)); hw.size != file.Size || sum != file.Sha256 {
+			return fmt.Errorf("%w: %s has size %d and checksum %s, expected %d and %s", ErrChecksum, file.Path, hw.size, sum, file.Size, file.Sha256)
+		}
+	}
+	return nil
+}
+
+// hashWriter hashes and counts the bytes written through it
+type hashWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func (h *hashWriter) Write(p []byte) (int, error) {
+	n, err := h.w.Write(p)
+	h.hash.Write(p[:n])
+	h.size += int64(n)
+	return n, err
+}
+
+// Extract copies the files of a backup directory or tar archive into dir and verifies them against
+// the manifest of the backup, which it returns. Gzip compressed archives are detected by content.
+func Extract(src, dir string) (*proto.BackupManifest, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return extractDir(src, dir)
+	}
+	return extractArchive(src, dir)
+}
+
+// extractDir copies the files listed in the manifest of a backup directory
+func extractDir(src, dir string) (*proto.BackupManifest, error) {
+	manifest, err := readManifest(filepath.Join(src, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	v := newVerifier()
+	s := &dirSink{dir: dir}
+	defer s.close()
+	for _, file := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+			return nil, fmt.Errorf("backup contains invalid path %q", file.Path)
+		}
+		if err := copyFile(s, v, file.Path, filepath.Join(src, filepath.FromSlash(file.Path))); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.close(); err != nil {
+		return nil, err
+	}
+	return manifest, v.verify(manifest)
+}
+
+// copyFile copies a file of a backup directory into a sink
+func copyFile(s sink, v *verifier, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := s.create(name, info.Size())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(v.add(name, w), f)
+	return err
+}
+
+// extractArchive unpacks a tar archive. The manifest is read from the archive as well, so the
+// checksums are only compared once every file was unpacked.
+func extractArchive(src, dir string) (*proto.BackupManifest, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var archive io.Reader = r
+	if magic, _ := r.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		archive = gz
+	}
+
+	tr := tar.NewReader(archive)
+	v := newVerifier()
+	s := &dirSink{dir: dir}
+	defer s.close()
+	var manifest *proto.BackupManifest
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", src, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if header.Name == ManifestFile {
+			if manifest, err = parseManifest(tr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(header.Name)) {
+			return nil, fmt.Errorf("backup contains invalid path %q", header.Name)
+		}
+		w, err := s.create(header.Name, header.Size)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(v.add(header.Name, w), tr); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.close(); err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("%s does not contain %s", src, ManifestFile)
+	}
+	return manifest, v.verify(manifest)
+}
+
+// readManifest reads the manifest file of a backup directory
+func readManifest(path string) (*proto.BackupManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseManifest(f)
+}
+
+func parseManifest(r io.Reader) (*proto.BackupManifest, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var manifest proto.BackupManifest
+	if err := protojson.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ManifestFile, err)
+	}
+	return &manifest, nil
+}
+
+// Restore replaces the data and metadata directories of a node with the content of a backup and
+// returns its manifest. The backup is extracted and verified next to the data directory first, so
+// the existing directories are only replaced once the backup is known to be intact. The node must
+// not be running.
+func Restore(src, dataDir, metadataDir string) (*proto.BackupManifest, error) {
+	staging, err := os.MkdirTemp(filepath.Dir(dataDir), ".restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, err := Extract(src, staging)
+	if err != nil {
+		return nil, err
+	}
+	for name, target := range map[string]string{DataDir: dataDir, MetadataDir: metadataDir} {
+		if err := os.RemoveAll(target); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(filepath.Join(staging, name), target); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
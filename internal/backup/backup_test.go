@@ -0,0 +1,196 @@
+package backup
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tdevsin/keyforge/internal/proto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// files of the node checkpoint used by the tests
+var files = map[string]string{
+	"data/000001.sst":   strings.Repeat("k", chunkSize+10),
+	"data/CURRENT":      "MANIFEST-000001\n",
+	"data/empty.log":    "",
+	"metadata/CURRENT":  "MANIFEST-000002\n",
+	"metadata/000002.s": "node_id",
+}
+
+// writeFiles creates the checkpoint files in a new directory
+func writeFiles(t *testing.T) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return dir
+}
+
+// stream returns the chunks of a backup of dir, ending with its manifest
+func stream(t *testing.T, dir string) []*proto.BackupChunk {
+	var chunks []*proto.BackupChunk
+	sent, err := Send(dir, func(chunk *proto.BackupChunk) error {
+		chunks = append(chunks, protobuf.Clone(chunk).(*proto.BackupChunk))
+		return nil
+	})
+	require.NoError(t, err)
+	return append(chunks, &proto.BackupChunk{Manifest: &proto.BackupManifest{NodeId: "node1", Files: sent}})
+}
+
+// recv returns a receive function replaying chunks
+func recv(chunks []*proto.BackupChunk) func() (*proto.BackupChunk, error) {
+	return func() (*proto.BackupChunk, error) {
+		if len(chunks) == 0 {
+			return nil, io.EOF
+		}
+		chunk := chunks[0]
+		chunks = chunks[1:]
+		return chunk, nil
+	}
+}
+
+// assertFiles checks that dir contains the checkpoint files
+func assertFiles(t *testing.T, dir string) {
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(data), name)
+	}
+}
+
+func TestSend(t *testing.T) {
+	chunks := stream(t, writeFiles(t))
+
+	manifest := chunks[len(chunks)-1].Manifest
+	assert.Len(t, manifest.Files, len(files))
+	for _, file := range manifest.Files {
+		assert.Equal(t, int64(len(files[file.Path])), file.Size, file.Path)
+		assert.Len(t, file.Sha256, 64)
+	}
+	// Large files are split, empty files are still sent
+	assert.Equal(t, "data/000001.sst", chunks[0].Path)
+	assert.Len(t, chunks[0].Data, chunkSize)
+	assert.Equal(t, "", chunks[1].Path)
+	assert.Len(t, chunks[1].Data, 10)
+	assert.Len(t, chunks, len(files)+2)
+}
+
+func TestReceiveAndExtract(t *testing.T) {
+	chunks := stream(t, writeFiles(t))
+
+	for _, name := range []string{"backup", "backup.tar", "backup.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+
+			manifest, err := Receive(path, recv(chunks))
+			require.NoError(t, err)
+			assert.Equal(t, "node1", manifest.NodeId)
+
+			extracted := t.TempDir()
+			restored, err := Extract(path, extracted)
+			require.NoError(t, err)
+			assert.True(t, protobuf.Equal(manifest, restored))
+			assertFiles(t, extracted)
+		})
+	}
+
+	t.Run("Existing path", func(t *testing.T) {
+		_, err := Receive(t.TempDir(), recv(chunks))
+		assert.ErrorContains(t, err, "already exists")
+	})
+}
+
+func TestReceiveInvalidStream(t *testing.T) {
+	valid := stream(t, writeFiles(t))
+	// The content of data/CURRENT differs from the manifest
+	corrupted := append([]*proto.BackupChunk(nil), valid...)
+	corrupted[2] = &proto.BackupChunk{Path: "data/CURRENT", Size: 16, Data: []byte("MANIFEST-000009\n")}
+
+	tests := map[string]struct {
+		chunks []*proto.BackupChunk
+		err    string
+	}{
+		"Missing manifest": {valid[:len(valid)-1], "without a manifest"},
+		"Data before a file": {
+			[]*proto.BackupChunk{{Data: []byte("x")}},
+			"data before a file",
+		},
+		"Path outside the backup": {
+			[]*proto.BackupChunk{{Path: "../escape", Size: 1, Data: []byte("x")}},
+			"invalid path",
+		},
+		"Corrupted file": {corrupted, "data/CURRENT has size 16 and checksum"},
+	}
+	for name, test := range tests {
+		for _, out := range []string{"backup", "backup.tar.gz"} {
+			t.Run(name+" into "+out, func(t *testing.T) {
+				path := filepath.Join(t.TempDir(), out)
+
+				_, err := Receive(path, recv(test.chunks))
+
+				assert.ErrorContains(t, err, test.err)
+				assert.NoFileExists(t, path)
+				assert.NoDirExists(t, path)
+			})
+		}
+	}
+}
+
+func TestExtractCorruptedBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup")
+	_, err := Receive(path, recv(stream(t, writeFiles(t))))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "metadata", "CURRENT"), []byte("MANIFEST-000003\n"), 0o644))
+
+	_, err = Extract(path, t.TempDir())
+
+	assert.ErrorIs(t, err, ErrChecksum)
+	assert.ErrorContains(t, err, "metadata/CURRENT")
+}
+
+func TestRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.tar.gz")
+	_, err := Receive(path, recv(stream(t, writeFiles(t))))
+	require.NoError(t, err)
+
+	t.Run("Replaces the directories of a node", func(t *testing.T) {
+		root := t.TempDir()
+		dataDir, metadataDir := filepath.Join(root, "data"), filepath.Join(root, "metadata")
+		require.NoError(t, os.MkdirAll(dataDir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dataDir, "old.sst"), []byte("old"), 0o644))
+
+		manifest, err := Restore(path, dataDir, metadataDir)
+
+		require.NoError(t, err)
+		assert.Equal(t, "node1", manifest.NodeId)
+		assertFiles(t, root)
+		assert.NoFileExists(t, filepath.Join(dataDir, "old.sst"))
+		entries, err := os.ReadDir(root)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2, "the staging directory is removed")
+	})
+
+	t.Run("Keeps the directories of a node if the backup is corrupted", func(t *testing.T) {
+		root := t.TempDir()
+		dataDir := filepath.Join(root, "data")
+		require.NoError(t, os.MkdirAll(dataDir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dataDir, "old.sst"), []byte("old"), 0o644))
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		copy(data[len(data)/2:], make([]byte, 8))
+		corrupted := filepath.Join(t.TempDir(), "backup.tar.gz")
+		require.NoError(t, os.WriteFile(corrupted, data, 0o644))
+
+		_, err = Restore(corrupted, dataDir, filepath.Join(root, "metadata"))
+
+		assert.Error(t, err)
+		assert.FileExists(t, filepath.Join(dataDir, "old.sst"))
+	})
+}
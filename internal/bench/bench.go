@@ -0,0 +1,474 @@
+// Package bench generates load with a mix of operations on a key space and measures its latency.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Op is an operation sent by the load generator
+type Op string
+
+const (
+	Read   Op = "read"
+	Write  Op = "write"
+	Delete Op = "delete"
+)
+
+// Ops are all operations in the order they are reported
+var Ops = []Op{Read, Write, Delete}
+
+// Key distributions
+const (
+	Uniform    = "uniform"    // Every key is equally likely
+	Zipfian    = "zipfian"    // Few keys get most requests, like a cache with hot keys
+	Sequential = "sequential" // Keys are requested in order, wrapping around at the end of the key space
+)
+
+// maxErrorMessages limits the distinct error messages kept in a report
+const maxErrorMessages = 10
+
+// ErrMiss is returned by a Request reading or deleting a key that does not exist. Misses are
+// counted separately and are not errors.
+var ErrMiss = errors.New("key not found")
+
+// Request sends a single operation. Worker is the index of the worker sending it, which can be
+// used to spread workers over several nodes.
+type Request func(ctx context.Context, worker int, op Op, key string, value []byte) error
+
+// Mix is the weight of every operation. Operations are picked with a probability proportional to
+// their weight.
+type Mix map[Op]int
+
+// ParseMix parses weights like read=80,write=15,delete=5
+func ParseMix(s string) (Mix, error) {
+	mix := Mix{}
+	for _, part := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		op := Op(strings.TrimSpace(name))
+		if !ok || !slices.Contains(Ops, op) {
+			return nil, fmt.Errorf("invalid operation %q in mix, expected read=<weight>, write=<weight> or delete=<weight>", part)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q of %s in mix", value, op)
+		}
+		mix[op] += weight
+	}
+	if mix.total() == 0 {
+		return nil, errors.New("the weights of the mix must not all be 0")
+	}
+	return mix, nil
+}
+
+func (m Mix) total() int {
+	total := 0
+	for _, weight := range m {
+		total += weight
+	}
+	return total
+}
+
+// String formats the mix like ParseMix expects it
+func (m Mix) String() string {
+	var parts []string
+	for _, op := range Ops {
+		if m[op] > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", op, m[op]))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseValueSize parses a value size like 100, or a range like 100-1000 of which sizes are picked
+// uniformly
+func ParseValueSize(s string) (int, int, error) {
+	low, high, isRange := strings.Cut(s, "-")
+	minSize, err := strconv.Atoi(low)
+	if err != nil || minSize < 0 {
+		return 0, 0, fmt.Errorf("invalid value size %q", s)
+	}
+	if !isRange {
+		return minSize, minSize, nil
+	}
+	maxSize, err := strconv.Atoi(high)
+	if err != nil || maxSize < minSize {
+		return 0, 0, fmt.Errorf("invalid value size range %q", s)
+	}
+	return minSize, maxSize, nil
+}
+
+// Options configure a load test
+type Options struct {
+	Concurrency  int           // Concurrency is the number of workers sending requests one after another
+	Duration     time.Duration // Duration is how long requests are sent, unless Requests is set
+	Requests     int           // Requests is the total number of requests sent if it is not 0
+	Mix          Mix           // Mix is the weight of every operation
+	Keys         int           // Keys is the size of the key space
+	KeyPrefix    string        // KeyPrefix is prepended to every key
+	Distribution string        // Distribution picks the keys of requests, see Uniform, Zipfian and Sequential
+	ZipfExponent float64       // ZipfExponent skews the zipfian distribution, it must be larger than 1
+	MinValueSize int           // MinValueSize and MaxValueSize bound the size of written values
+	MaxValueSize int
+}
+
+// Validate checks that the options describe a load test that can run
+func (o Options) Validate() error {
+	switch {
+	case o.Concurrency < 1:
+		return errors.New("concurrency must be at least 1")
+	case o.Requests < 0:
+		return errors.New("the number of requests must not be negative")
+	case o.Requests == 0 && o.Duration <= 0:
+		return errors.New("either a duration or a number of requests is required")
+	case o.Mix.total() == 0:
+		return errors.New("the weights of the mix must not all be 0")
+	case o.Keys < 1:
+		return errors.New("the key space must contain at least 1 key")
+	case o.MinValueSize < 0 || o.MaxValueSize < o.MinValueSize:
+		return errors.New("invalid value size range")
+	}
+	switch o.Distribution {
+	case Uniform, Sequential:
+	case Zipfian:
+		if o.ZipfExponent <= 1 {
+			return errors.New("the zipf exponent must be larger than 1")
+		}
+	default:
+		return fmt.Errorf("invalid key distribution %q, accepted values: %s, %s, %s", o.Distribution, Uniform, Zipfian, Sequential)
+	}
+	return nil
+}
+
+// Key returns the key with the given index in the key space
+func (o Options) Key(i int) string {
+	return o.KeyPrefix + strconv.Itoa(i)
+}
+
+// Latency summarizes the latencies of requests in milliseconds
+type Latency struct {
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P95  float64 `json:"p95_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p99_9_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+// subBucketBits is the number of significant bits kept of recorded durations. Durations in the
+// same bucket differ by at most 1/64 of their length, so reported latencies are within 1% of the exact ones.
+const subBucketBits = 7
+
+// histogram counts request durations in buckets of exponentially growing width, so its memory
+// does not grow with the number of requests. The mean and maximum are exact.
+type histogram struct {
+	counts []int64
+	count  int64
+	total  time.Duration
+	max    time.Duration
+}
+
+// bucket returns the index of the bucket of a duration. Durations below 2^subBucketBits
+// nanoseconds have a bucket each, larger ones keep their subBucketBits most significant bits.
+func bucket(d time.Duration) int {
+	v := uint64(max(d, 0))
+	if v < 1<<subBucketBits {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits
+	return shift<<(subBucketBits-1) + int(v>>shift)
+}
+
+// bucketRange returns the smallest duration of a bucket and the width of the bucket
+func bucketRange(i int) (time.Duration, time.Duration) {
+	if i < 1<<subBucketBits {
+		return time.Duration(i), 1
+	}
+	shift := i>>(subBucketBits-1) - 1
+	top := i - shift<<(subBucketBits-1)
+	return time.Duration(top) << shift, time.Duration(1) << shift
+}
+
+func (h *histogram) record(d time.Duration) {
+	i := bucket(d)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]int64, i+1-len(h.counts))...)
+	}
+	h.counts[i]++
+	h.count++
+	h.total += d
+	h.max = max(h.max, d)
+}
+
+// merge adds the durations recorded by another histogram
+func (h *histogram) merge(other *histogram) {
+	if len(other.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]int64, len(other.counts)-len(h.counts))...)
+	}
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	h.count += other.count
+	h.total += other.total
+	h.max = max(h.max, other.max)
+}
+
+// percentile returns the middle of the bucket containing the duration that p of all durations
+// do not exceed
+func (h *histogram) percentile(p float64) time.Duration {
+	rank := max(int64(math.Ceil(p*float64(h.count))), 1)
+	var seen int64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			low, width := bucketRange(i)
+			return min(low+(width-1)/2, h.max)
+		}
+	}
+	return h.max
+}
+
+// summarize computes the latency summary of the recorded durations
+func (h *histogram) summarize() Latency {
+	if h.count == 0 {
+		return Latency{}
+	}
+	return Latency{
+		Mean: milliseconds(h.total / time.Duration(h.count)),
+		P50:  milliseconds(h.percentile(0.5)),
+		P90:  milliseconds(h.percentile(0.9)),
+		P95:  milliseconds(h.percentile(0.95)),
+		P99:  milliseconds(h.percentile(0.99)),
+		P999: milliseconds(h.percentile(0.999)),
+		Max:  milliseconds(h.max),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// OpReport are the results of a single operation
+type OpReport struct {
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	Misses     int     `json:"misses"`
+	Throughput float64 `json:"throughput"` // Throughput is in requests per second
+	Latency    Latency `json:"latency"`
+}
+
+// Report are the results of a load test
+type Report struct {
+	Duration      time.Duration    `json:"-"`
+	Seconds       float64          `json:"duration_seconds"`
+	Concurrency   int              `json:"concurrency"`
+	Requests      int              `json:"requests"`
+	Errors        int              `json:"errors"`
+	Misses        int              `json:"misses"`
+	Throughput    float64          `json:"throughput"` // Throughput is in requests per second
+	Latency       Latency          `json:"latency"`
+	Operations    map[Op]*OpReport `json:"operations"`
+	ErrorMessages map[string]int   `json:"error_messages,omitempty"` // ErrorMessages counts the first distinct errors
+}
+
+// worker sends requests one after another and records their latencies
+type worker struct {
+	id        int
+	opts      Options
+	request   Request
+	random    *rand.Rand
+	zipf      *rand.Zipf
+	next      *atomic.Int64 // next is the index of the next key of the sequential distribution
+	value     []byte
+	latencies map[Op]*histogram
+	errors    map[Op]int
+	misses    map[Op]int
+	messages  map[string]int
+}
+
+func newWorker(id int, opts Options, request Request, next *atomic.Int64) *worker {
+	w := &worker{
+		id:        id,
+		opts:      opts,
+		request:   request,
+		random:    rand.New(rand.NewSource(time.Now().UnixNano() + int64(id))),
+		next:      next,
+		value:     make([]byte, opts.MaxValueSize),
+		latencies: map[Op]*histogram{},
+		errors:    map[Op]int{},
+		misses:    map[Op]int{},
+		messages:  map[string]int{},
+	}
+	for _, op := range Ops {
+		w.latencies[op] = &histogram{}
+	}
+	w.random.Read(w.value)
+	if opts.Distribution == Zipfian {
+		w.zipf = rand.NewZipf(w.random, opts.ZipfExponent, 1, uint64(opts.Keys-1))
+	}
+	return w
+}
+
+// key picks the index of the key of the next request
+func (w *worker) key() int {
+	switch w.opts.Distribution {
+	case Zipfian:
+		return int(w.zipf.Uint64())
+	case Sequential:
+		return int((w.next.Add(1) - 1) % int64(w.opts.Keys))
+	default:
+		return w.random.Intn(w.opts.Keys)
+	}
+}
+
+// op picks the operation of the next request according to the mix
+func (w *worker) op() Op {
+	n := w.random.Intn(w.opts.Mix.total())
+	for _, op := range Ops {
+		if n < w.opts.Mix[op] {
+			return op
+		}
+		n -= w.opts.Mix[op]
+	}
+	return Read
+}
+
+// send sends a single request and records its result
+func (w *worker) send(ctx context.Context) {
+	op := w.op()
+	var value []byte
+	if op == Write {
+		size := w.opts.MinValueSize + w.random.Intn(w.opts.MaxValueSize-w.opts.MinValueSize+1)
+		value = w.value[:size]
+	}
+	key := w.opts.Key(w.key())
+
+	start := time.Now()
+	err := w.request(ctx, w.id, op, key, value)
+	w.latencies[op].record(time.Since(start))
+	switch {
+	case errors.Is(err, ErrMiss):
+		w.misses[op]++
+	case err != nil:
+		w.errors[op]++
+		if _, ok := w.messages[err.Error()]; ok || len(w.messages) < maxErrorMessages {
+			w.messages[err.Error()]++
+		}
+	}
+}
+
+// Run sends requests with the configured number of workers until the duration elapsed, the number
+// of requests was sent or ctx is cancelled. Requests in flight when the duration elapses complete.
+func Run(ctx context.Context, opts Options, request Request) *Report {
+	stop := ctx
+	if opts.Requests == 0 {
+		var cancel context.CancelFunc
+		stop, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	var sent, next atomic.Int64
+	workers := make([]*worker, opts.Concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range workers {
+		workers[i] = newWorker(i, opts, request, &next)
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			for stop.Err() == nil {
+				if opts.Requests > 0 && sent.Add(1) > int64(opts.Requests) {
+					return
+				}
+				w.send(ctx)
+			}
+		}(workers[i])
+	}
+	wg.Wait()
+	return newReport(opts, time.Since(start), workers)
+}
+
+// newReport merges the results of all workers
+func newReport(opts Options, elapsed time.Duration, workers []*worker) *Report {
+	report := &Report{
+		Duration:    elapsed,
+		Seconds:     elapsed.Seconds(),
+		Concurrency: opts.Concurrency,
+		Operations:  map[Op]*OpReport{},
+	}
+	all := &histogram{}
+	for _, op := range Ops {
+		latencies := &histogram{}
+		opReport := &OpReport{}
+		for _, w := range workers {
+			latencies.merge(w.latencies[op])
+			opReport.Errors += w.errors[op]
+			opReport.Misses += w.misses[op]
+		}
+		if latencies.count == 0 {
+			continue
+		}
+		opReport.Requests = int(latencies.count)
+		opReport.Throughput = float64(latencies.count) / elapsed.Seconds()
+		opReport.Latency = latencies.summarize()
+		report.Operations[op] = opReport
+		report.Requests += opReport.Requests
+		report.Errors += opReport.Errors
+		report.Misses += opReport.Misses
+		all.merge(latencies)
+	}
+	report.Throughput = float64(report.Requests) / elapsed.Seconds()
+	report.Latency = all.summarize()
+
+	for _, w := range workers {
+		for message, count := range w.messages {
+			if report.ErrorMessages == nil {
+				report.ErrorMessages = map[string]int{}
+			}
+			if _, ok := report.ErrorMessages[message]; ok || len(report.ErrorMessages) < maxErrorMessages {
+				report.ErrorMessages[message] += count
+			}
+		}
+	}
+	return report
+}
+
+// Preload writes every key of the key space once, so reads of a following run find their keys.
+// It stops at the first failed write.
+func Preload(ctx context.Context, opts Options, request Request) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		w := newWorker(i, opts, request, &next)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				key := int(next.Add(1) - 1)
+				if key >= opts.Keys {
+					return
+				}
+				size := opts.MinValueSize + w.random.Intn(opts.MaxValueSize-opts.MinValueSize+1)
+				if err := request(ctx, w.id, Write, opts.Key(key), w.value[:size]); err != nil {
+					cancel(fmt.Errorf("failed to write %s: %w", opts.Key(key), err))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return context.Cause(ctx)
+}
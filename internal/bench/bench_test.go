@@ -0,0 +1,245 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMix(t *testing.T) {
+	t.Run("Valid mix", func(t *testing.T) {
+		mix, err := ParseMix("read=80, write=15,delete=5")
+		assert.NoError(t, err)
+		assert.Equal(t, Mix{Read: 80, Write: 15, Delete: 5}, mix)
+		assert.Equal(t, "read=80,write=15,delete=5", mix.String())
+	})
+
+	for _, s := range []string{"", "read", "scan=1", "read=-1", "read=x", "read=0,write=0"} {
+		t.Run("Invalid mix "+s, func(t *testing.T) {
+			_, err := ParseMix(s)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseValueSize(t *testing.T) {
+	minSize, maxSize, err := ParseValueSize("100")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{100, 100}, []int{minSize, maxSize})
+
+	minSize, maxSize, err = ParseValueSize("10-1000")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10, 1000}, []int{minSize, maxSize})
+
+	for _, s := range []string{"", "-1", "a", "100-10", "10-"} {
+		_, _, err := ParseValueSize(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Options{Concurrency: 1, Duration: time.Second, Mix: Mix{Read: 1}, Keys: 10, Distribution: Uniform}
+	assert.NoError(t, valid.Validate())
+
+	tests := map[string]func(o *Options){
+		"No concurrency":         func(o *Options) { o.Concurrency = 0 },
+		"No duration":            func(o *Options) { o.Duration = 0 },
+		"Empty key space":        func(o *Options) { o.Keys = 0 },
+		"Unknown distribution":   func(o *Options) { o.Distribution = "normal" },
+		"Zipf exponent too low":  func(o *Options) { o.Distribution, o.ZipfExponent = Zipfian, 1 },
+		"Inverted value sizes":   func(o *Options) { o.MinValueSize, o.MaxValueSize = 10, 5 },
+		"Negative request count": func(o *Options) { o.Requests = -1 },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := valid
+			change(&o)
+			assert.Error(t, o.Validate())
+		})
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	t.Run("Summarizes latencies within 1%", func(t *testing.T) {
+		h := &histogram{}
+		for i := 1000; i > 0; i-- {
+			h.record(time.Duration(i) * time.Millisecond)
+		}
+
+		latency := h.summarize()
+
+		assert.Equal(t, 500.5, latency.Mean)
+		assert.Equal(t, 1000.0, latency.Max)
+		expected := map[string][]float64{"p50": {500, latency.P50}, "p90": {900, latency.P90}, "p95": {950, latency.P95}, "p99": {990, latency.P99}, "p99.9": {999, latency.P999}}
+		for name, values := range expected {
+			assert.InEpsilon(t, values[0], values[1], 0.01, name)
+		}
+		assert.Equal(t, Latency{}, (&histogram{}).summarize())
+	})
+
+	t.Run("Keeps small durations exact", func(t *testing.T) {
+		h := &histogram{}
+		for _, d := range []time.Duration{3, 1, 2, 4} {
+			h.record(d)
+		}
+
+		assert.Equal(t, time.Duration(2), h.percentile(0.5))
+		assert.Equal(t, time.Duration(4), h.percentile(1))
+	})
+
+	t.Run("Maps durations to the bucket containing them", func(t *testing.T) {
+		for _, d := range []time.Duration{0, 127, 128, 129, 1000, time.Millisecond + 7, time.Hour} {
+			low, width := bucketRange(bucket(d))
+			assert.LessOrEqual(t, low, d)
+			assert.Less(t, d, low+width)
+			assert.LessOrEqual(t, float64(width), float64(max(d, 64))/64)
+		}
+	})
+
+	t.Run("Merges histograms", func(t *testing.T) {
+		a, b := &histogram{}, &histogram{}
+		a.record(time.Millisecond)
+		b.record(3 * time.Millisecond)
+
+		a.merge(b)
+
+		latency := a.summarize()
+		assert.Equal(t, int64(2), a.count)
+		assert.Equal(t, 2.0, latency.Mean)
+		assert.Equal(t, 3.0, latency.Max)
+		assert.InEpsilon(t, 1, latency.P50, 0.01)
+		assert.InEpsilon(t, 3, latency.P90, 0.01)
+	})
+}
+
+// recorder is a Request remembering the operations and keys it was called with
+type recorder struct {
+	mu     sync.Mutex
+	ops    map[Op]int
+	keys   []string
+	values map[int]bool // values are the sizes of written values
+	err    error
+}
+
+func newRecorder() *recorder {
+	return &recorder{ops: map[Op]int{}, values: map[int]bool{}}
+}
+
+func (r *recorder) request(ctx context.Context, worker int, op Op, key string, value []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops[op]++
+	r.keys = append(r.keys, key)
+	if op == Write {
+		r.values[len(value)] = true
+	}
+	if op == Read {
+		return ErrMiss
+	}
+	return r.err
+}
+
+func TestRun(t *testing.T) {
+	opts := Options{Concurrency: 4, Requests: 1000, Mix: Mix{Read: 50, Write: 50}, Keys: 100, KeyPrefix: "k", Distribution: Uniform, MinValueSize: 1, MaxValueSize: 3}
+
+	t.Run("Sends the number of requests with the mix", func(t *testing.T) {
+		r := newRecorder()
+
+		report := Run(context.Background(), opts, r.request)
+
+		assert.Equal(t, 1000, report.Requests)
+		assert.Equal(t, 0, report.Errors)
+		assert.Equal(t, r.ops[Read], report.Misses)
+		assert.Equal(t, r.ops[Read], report.Operations[Read].Requests)
+		assert.Equal(t, r.ops[Write], report.Operations[Write].Requests)
+		assert.InDelta(t, 500, r.ops[Read], 100)
+		assert.Nil(t, report.Operations[Delete])
+		assert.Equal(t, map[int]bool{1: true, 2: true, 3: true}, r.values)
+		assert.Greater(t, report.Throughput, 0.0)
+	})
+
+	t.Run("Sequential keys wrap around", func(t *testing.T) {
+		r := newRecorder()
+		o := opts
+		o.Concurrency, o.Requests, o.Keys, o.Distribution = 1, 5, 3, Sequential
+
+		Run(context.Background(), o, r.request)
+
+		assert.Equal(t, []string{"k0", "k1", "k2", "k0", "k1"}, r.keys)
+	})
+
+	t.Run("Zipfian keys favor the first keys", func(t *testing.T) {
+		r := newRecorder()
+		o := opts
+		o.Distribution, o.ZipfExponent = Zipfian, 1.5
+
+		Run(context.Background(), o, r.request)
+
+		counts := map[string]int{}
+		for _, key := range r.keys {
+			counts[key]++
+		}
+		assert.Greater(t, counts["k0"], counts["k50"]+100)
+	})
+
+	t.Run("Counts errors and keeps their messages", func(t *testing.T) {
+		r := newRecorder()
+		r.err = errors.New("unavailable")
+		o := opts
+		o.Mix = Mix{Delete: 1}
+
+		report := Run(context.Background(), o, r.request)
+
+		assert.Equal(t, 1000, report.Errors)
+		assert.Equal(t, map[string]int{"unavailable": 1000}, report.ErrorMessages)
+	})
+
+	t.Run("Stops after the duration", func(t *testing.T) {
+		o := opts
+		o.Requests, o.Duration = 0, 50*time.Millisecond
+
+		report := Run(context.Background(), o, newRecorder().request)
+
+		assert.Greater(t, report.Requests, 0)
+		assert.Less(t, report.Duration, time.Second)
+	})
+}
+
+func TestPreload(t *testing.T) {
+	t.Run("Writes every key once", func(t *testing.T) {
+		r := newRecorder()
+		opts := Options{Concurrency: 3, Keys: 50, KeyPrefix: "k", MinValueSize: 1, MaxValueSize: 1}
+
+		assert.NoError(t, Preload(context.Background(), opts, r.request))
+
+		assert.Equal(t, map[Op]int{Write: 50}, r.ops)
+		assert.ElementsMatch(t, r.keys, uniqueKeys(r.keys))
+	})
+
+	t.Run("Stops at the first error", func(t *testing.T) {
+		r := newRecorder()
+		r.err = errors.New("disk full")
+		opts := Options{Concurrency: 1, Keys: 50, KeyPrefix: "k", MinValueSize: 1, MaxValueSize: 1}
+
+		err := Preload(context.Background(), opts, r.request)
+
+		assert.ErrorContains(t, err, "failed to write k0: disk full")
+		assert.Equal(t, 1, r.ops[Write])
+	})
+}
+
+func uniqueKeys(keys []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}
@@ -11,57 +11,102 @@ import (
 	"github.com/tdevsin/keyforge/internal/proto"
 	"go.uber.org/zap"
 	"golang.org/x/exp/rand"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // ClusterManager defines the interface for cluster operations.
 type ClusterManager interface {
-	GetClusterInfo() *ClusterInfo                     // Retrieve the current cluster state
-	IncrementVersion()                                // Increment the cluster state version
-	MergeClusterState(receivedState *ClusterInfo)     // Merge received cluster state with the current state
-	AddOrUpdateNode(node Node)                        // Add or update a node in the cluster
-	RemoveNode(nodeID string)                         // Remove a node from the cluster
-	GetNode(nodeID string) (Node, bool)               // Retrieve a node by its ID
-	GetHealthyNodes() []Node                          // Retrieve a list of healthy nodes
-	RegisterObserver(observer ClusterObserver)        // Register an observer to get notified on state changes
-	MapClusterStateToProto(state *proto.ClusterState) // Map the cluster state to proto
+	GetClusterInfo() *ClusterInfo                                         // Retrieve the current cluster state
+	IncrementVersion()                                                    // Increment the cluster state version
+	MergeClusterState(receivedState *ClusterInfo)                         // Merge received cluster state with the current state
+	AddOrUpdateNode(node Node)                                            // Add or update a node in the cluster
+	RemoveNode(nodeID string)                                             // Remove a node from the cluster
+	GetNode(nodeID string) (Node, bool)                                   // Retrieve a node by its ID
+	GetHealthyNodes() []Node                                              // Retrieve a list of healthy nodes
+	RegisterObserver(observer ClusterObserver)                            // Register an observer to get notified on state changes
+	MapClusterStateToProto(state *proto.ClusterState)                     // Map the cluster state to proto
+	CompareDigest(digest *proto.GossipDigest, ack *proto.GossipDigestAck) // Compare a gossip digest with the current state
+	MergeNodes(nodes []Node, version int)                                 // Merge changed node entries received via gossip
+	GetTiming() Timing                                                    // Retrieve the gossip and failure detection parameters
+	SetTiming(timing Timing) error                                        // Validate and apply new gossip and failure detection parameters
+	Leave() error                                                         // Announce that this node is leaving the cluster
+	Remove(nodeID string) (Node, error)                                   // Remove a failed node from the cluster on behalf of an operator
 }
 
+var (
+	ErrNodeNotFound = errors.New("node is not a member of the cluster")
+	ErrRemoveSelf   = errors.New("a node can not remove itself, decommission it instead")
+	ErrNodeHealthy  = errors.New("node is healthy, decommission it instead")
+)
+
 // ClusterInfo represents the overall state of the cluster.
 type ClusterInfo struct {
-	mu                  sync.RWMutex      // Mutex to protect concurrent access
-	Nodes               map[string]Node   // Nodes is a map of nodeId to Node
-	Version             int               // Version helps in identifying the latest cluster state
-	LastUpdated         time.Time         // LastUpdated indicates the last time the cluster info was updated
-	logger              logger.Logging    // Instance of logger for logging
-	observers           []ClusterObserver // List of observers to notify on state changes
-	selfId              string            // selfId is the ID of the current node
-	gossipN             int               // Number of nodes to select for gossip
-	gossipInterval      time.Duration     // Duration between which the cluster state sync will happen
-	healthCheckInterval time.Duration     // Duration between which the health checks of nodes will happen
-	failureThreshold    int               // Number of times a health check should fail to mark it as permanent failed
+	mu               sync.RWMutex      // Mutex to protect concurrent access
+	Nodes            map[string]Node   // Nodes is a map of nodeId to Node
+	Version          int               // Version helps in identifying the latest cluster state
+	LastUpdated      time.Time         // LastUpdated indicates the last time the cluster info was updated
+	logger           logger.Logging    // Instance of logger for logging
+	observers        []ClusterObserver // List of observers to notify on state changes
+	selfId           string            // selfId is the ID of the current node
+	timing           Timing            // Gossip and failure detection parameters
+	gossipReset      chan struct{}     // Signals the periodic gossip loop that the interval changed
+	healthCheckReset chan struct{}     // Signals the periodic health check loop that the interval changed
+	connectionPool   *ConnectionPool   // Pool of connections used for gossip and health checks
 }
 
 // NewCluster creates and initializes a new ClusterInfo.
 func NewCluster(l logger.Logging, selfId string, gossipN int) *ClusterInfo {
+	timing := DefaultTiming()
+	timing.GossipN = gossipN
 	cluster := &ClusterInfo{
-		Nodes:               make(map[string]Node),
-		Version:             -1, // Indicates the node is starting for the first time
-		LastUpdated:         time.Now(),
-		selfId:              selfId,
-		gossipN:             gossipN,
-		gossipInterval:      time.Second * 10,
-		healthCheckInterval: time.Second * 5,
-		failureThreshold:    5,
-		logger:              l,
+		Nodes:            make(map[string]Node),
+		Version:          -1, // Indicates the node is starting for the first time
+		LastUpdated:      time.Now(),
+		selfId:           selfId,
+		timing:           timing,
+		gossipReset:      make(chan struct{}, 1),
+		healthCheckReset: make(chan struct{}, 1),
+		logger:           l,
+		connectionPool:   NewConnectionPool(),
 	}
 
 	return cluster
 }
 
+// GetTiming returns the current gossip and failure detection parameters.
+func (ci *ClusterInfo) GetTiming() Timing {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+	return ci.timing
+}
+
+// SetTiming validates and applies new gossip and failure detection parameters.
+// Running periodic loops pick up the new intervals immediately.
+func (ci *ClusterInfo) SetTiming(timing Timing) error {
+	if err := timing.Validate(); err != nil {
+		return err
+	}
+	ci.mu.Lock()
+	ci.timing = timing
+	ci.mu.Unlock()
+
+	for _, reset := range []chan struct{}{ci.gossipReset, ci.healthCheckReset} {
+		select {
+		case reset <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// SetConnectionPool replaces the connection pool used to reach other nodes, allowing it to be shared with the API layer.
+func (ci *ClusterInfo) SetConnectionPool(pool *ConnectionPool) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.connectionPool = pool
+}
+
 // RegisterObserver registers a new observer for cluster state changes.
 func (ci *ClusterInfo) RegisterObserver(observer ClusterObserver) {
 	ci.mu.Lock()
@@ -87,6 +132,8 @@ func (ci *ClusterInfo) notifyObservers(event string, nodeID string, node *Node)
 			observer.NodeHealthSuspectedFailed(nodeID)
 		case "permanent_failed":
 			observer.NodeHealthPermanentFailed(nodeID)
+		case "left":
+			observer.NodeLeft(nodeID)
 		}
 
 	}
@@ -119,6 +166,8 @@ func (ci *ClusterInfo) MergeClusterState(receivedState *ClusterInfo) {
 	var addedNodes []Node
 	var suspectedFailedNodes []Node
 	var permanentFailedNodes []Node
+	var leftNodes []Node
+	var removedNodes []Node
 
 	for nodeID, receivedNode := range receivedState.Nodes {
 		existingNode, exists := ci.Nodes[nodeID]
@@ -130,13 +179,27 @@ func (ci *ClusterInfo) MergeClusterState(receivedState *ClusterInfo) {
 			if receivedNode.Health.Status == SuspectedFailed && existingNode.Health.Status == Healthy {
 				existingNode.Health.Status = SuspectedFailed
 				existingNode.Health.LastChecked = receivedNode.Health.LastChecked
+				existingNode.Version = max(existingNode.Version, receivedNode.Version)
 				ci.Nodes[nodeID] = existingNode
 				suspectedFailedNodes = append(suspectedFailedNodes, existingNode)
 			} else if receivedNode.Health.Status == PermanentFailed && (existingNode.Health.Status == SuspectedFailed || existingNode.Health.Status == Healthy) {
 				existingNode.Health.Status = PermanentFailed
 				existingNode.Health.LastChecked = receivedNode.Health.LastChecked
+				existingNode.Version = max(existingNode.Version, receivedNode.Version)
 				ci.Nodes[nodeID] = existingNode
 				permanentFailedNodes = append(permanentFailedNodes, existingNode)
+			} else if receivedNode.Health.Status == Leaving && existingNode.Health.Status != Leaving && nodeID != ci.selfId {
+				existingNode.Health.Status = Leaving
+				existingNode.Health.LastChecked = receivedNode.Health.LastChecked
+				existingNode.Version = max(existingNode.Version, receivedNode.Version)
+				ci.Nodes[nodeID] = existingNode
+				leftNodes = append(leftNodes, existingNode)
+			} else if receivedNode.Health.Status == Removed && existingNode.Health.Status != Removed && nodeID != ci.selfId {
+				existingNode.Health.Status = Removed
+				existingNode.Health.LastChecked = receivedNode.Health.LastChecked
+				existingNode.Version = max(existingNode.Version, receivedNode.Version)
+				ci.Nodes[nodeID] = existingNode
+				removedNodes = append(removedNodes, existingNode)
 			}
 		}
 	}
@@ -156,6 +219,12 @@ func (ci *ClusterInfo) MergeClusterState(receivedState *ClusterInfo) {
 	for _, node := range permanentFailedNodes {
 		ci.notifyObservers("permanent_failed", node.ID, &node)
 	}
+	for _, node := range leftNodes {
+		ci.notifyObservers("left", node.ID, &node)
+	}
+	for _, node := range removedNodes {
+		ci.notifyObservers("removed", node.ID, nil)
+	}
 }
 
 // AddOrUpdateNode adds or updates a node in the cluster.
@@ -163,8 +232,10 @@ func (ci *ClusterInfo) AddOrUpdateNode(node Node) {
 	ci.mu.Lock()
 	isNewNode := false
 
-	if _, exists := ci.Nodes[node.ID]; !exists {
+	if existing, exists := ci.Nodes[node.ID]; !exists {
 		isNewNode = true
+	} else if node.Version <= existing.Version {
+		node.Version = existing.Version + 1
 	}
 	ci.Nodes[node.ID] = node
 	ci.LastUpdated = time.Now()
@@ -201,7 +272,7 @@ func (ci *ClusterInfo) GetHealthyNodes() []Node {
 	defer ci.mu.RUnlock()
 	var healthyNodes []Node
 	for _, node := range ci.Nodes {
-		if node.Health.Status != PermanentFailed {
+		if node.Health.Status != PermanentFailed && node.Health.Status != Leaving && node.Health.Status != Removed {
 			healthyNodes = append(healthyNodes, node)
 		}
 	}
@@ -219,7 +290,7 @@ func (ci *ClusterInfo) GetRandomNodesForGossip() []Node {
 		}
 	}
 
-	n := ci.gossipN
+	n := ci.GetTiming().GossipN
 	if n > len(filteredNodes) {
 		n = len(filteredNodes)
 	}
@@ -232,42 +303,15 @@ func (ci *ClusterInfo) GetRandomNodesForGossip() []Node {
 	return filteredNodes[:n]
 }
 
-// createClusterSnapshot creates a consistent snapshot of the current cluster state.
-func (ci *ClusterInfo) createClusterSnapshot() *ClusterInfo {
-	ci.mu.RLock()
-	defer ci.mu.RUnlock()
-
-	copiedNodes := make(map[string]Node, len(ci.Nodes))
-	for id, node := range ci.Nodes {
-		copiedNodes[id] = node
-	}
-
-	return &ClusterInfo{
-		Nodes:       copiedNodes,
-		Version:     ci.Version,
-		LastUpdated: ci.LastUpdated,
-		selfId:      ci.selfId,
-		gossipN:     ci.gossipN,
-	}
-}
-
-// InitiateGossip propagates the cluster state to random nodes.
+// InitiateGossip performs a push-pull exchange with random nodes.
 func (ci *ClusterInfo) InitiateGossip() error {
 	nodes := ci.GetRandomNodesForGossip()
 	var errs []error
 
+	gossipRounds.Inc()
 	for _, node := range nodes {
-		conn, err := grpc.NewClient(node.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
-		if err != nil {
-			errs = append(errs, err)
-			continue
-		}
-		defer conn.Close()
-
-		client := proto.NewClusterServiceClient(conn)
-		var req proto.ClusterState
-		ci.MapClusterStateToProto(&req)
-		_, err = client.SetClusterState(context.TODO(), &req)
+		err := ci.gossipWith(node)
+		gossipExchanges.WithLabelValues(resultLabel(err)).Inc()
 		if err != nil {
 			errs = append(errs, err)
 		}
@@ -279,6 +323,41 @@ func (ci *ClusterInfo) InitiateGossip() error {
 	return nil
 }
 
+// gossipWith exchanges digests with a single node and then sends only the entries it asked for.
+func (ci *ClusterInfo) gossipWith(node Node) error {
+	ci.mu.RLock()
+	pool := ci.connectionPool
+	timeout := ci.timing.RPCTimeout
+	ci.mu.RUnlock()
+
+	conn, err := pool.GetConnection(node.PeerAddress())
+	if err != nil {
+		return err
+	}
+	client := proto.NewClusterServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Push our digest and pull the entries the peer has newer versions of
+	var digest proto.GossipDigest
+	ci.MapDigestToProto(&digest)
+	ack, err := client.ExchangeDigest(ctx, &digest)
+	if err != nil {
+		return err
+	}
+	ci.MergeNodes(MapProtoToNodes(ack.Nodes), int(ack.Version))
+
+	// Push the entries the peer is missing or has older versions of
+	if len(ack.RequestedIds) == 0 {
+		return nil
+	}
+	var delta proto.GossipDelta
+	ci.MapDeltaToProto(ack.RequestedIds, &delta)
+	_, err = client.PushDelta(ctx, &delta)
+	return err
+}
+
 // MapClusterStateToProto maps the current cluster state to a proto message.
 func (ci *ClusterInfo) MapClusterStateToProto(state *proto.ClusterState) {
 	ci.mu.RLock()
@@ -288,17 +367,214 @@ func (ci *ClusterInfo) MapClusterStateToProto(state *proto.ClusterState) {
 	state.LastUpdated = timestamppb.New(ci.LastUpdated)
 	state.Nodes = make([]*proto.Node, 0, len(ci.Nodes))
 	for _, node := range ci.Nodes {
-		state.Nodes = append(state.Nodes, &proto.Node{
-			Id:      node.ID,
-			Address: node.Address,
-			Health: &proto.Health{
-				LastUpdated: timestamppb.New(node.Health.LastChecked),
-				Status:      proto.Status(node.Health.Status),
-			},
+		state.Nodes = append(state.Nodes, MapNodeToProto(node))
+	}
+}
+
+// MapDigestToProto maps the version of every known node entry to a gossip digest.
+func (ci *ClusterInfo) MapDigestToProto(digest *proto.GossipDigest) {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+
+	digest.Version = int64(ci.Version)
+	digest.Digests = make([]*proto.NodeDigest, 0, len(ci.Nodes))
+	for id, node := range ci.Nodes {
+		digest.Digests = append(digest.Digests, &proto.NodeDigest{
+			Id:      id,
+			Version: int64(node.Version),
 		})
 	}
 }
 
+// MapDeltaToProto maps the requested node entries to a gossip delta. Unknown ids are skipped.
+func (ci *ClusterInfo) MapDeltaToProto(ids []string, delta *proto.GossipDelta) {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+
+	delta.Version = int64(ci.Version)
+	delta.Nodes = make([]*proto.Node, 0, len(ids))
+	for _, id := range ids {
+		if node, ok := ci.Nodes[id]; ok {
+			delta.Nodes = append(delta.Nodes, MapNodeToProto(node))
+		}
+	}
+}
+
+// CompareDigest compares a received digest with the current state. The ack is filled with entries
+// this node has newer versions of, and with the ids of entries the sender has newer versions of.
+func (ci *ClusterInfo) CompareDigest(digest *proto.GossipDigest, ack *proto.GossipDigestAck) {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+
+	remote := make(map[string]int, len(digest.Digests))
+	for _, d := range digest.Digests {
+		remote[d.Id] = int(d.Version)
+		if node, ok := ci.Nodes[d.Id]; !ok || node.Version < int(d.Version) {
+			ack.RequestedIds = append(ack.RequestedIds, d.Id)
+		}
+	}
+	for id, node := range ci.Nodes {
+		if version, ok := remote[id]; !ok || version < node.Version {
+			ack.Nodes = append(ack.Nodes, MapNodeToProto(node))
+		}
+	}
+	ack.Version = int64(ci.Version)
+}
+
+// MergeNodes merges node entries received via gossip. An entry replaces the local one only if its
+// version is newer. If another node reports a different view of this node, the local entry is
+// re-announced with a higher version so that the cluster converges on the node's own view.
+func (ci *ClusterInfo) MergeNodes(nodes []Node, version int) {
+	ci.mu.Lock()
+
+	var addedNodes []Node
+	var suspectedFailedNodes []Node
+	var permanentFailedNodes []Node
+	var leftNodes []Node
+	var removedNodes []Node
+
+	for _, receivedNode := range nodes {
+		existingNode, exists := ci.Nodes[receivedNode.ID]
+
+		if receivedNode.ID == ci.selfId && exists {
+			// Peers may know a higher version of this node, for example from before a restart. It is
+			// adopted so digests converge and later announcements of the node win over it.
+			existingNode.Version = max(existingNode.Version, receivedNode.Version)
+			if receivedNode.Version == existingNode.Version &&
+				(receivedNode.Health.Status != existingNode.Health.Status || receivedNode.Address != existingNode.Address || receivedNode.InternalAddress != existingNode.InternalAddress) {
+				existingNode.Version = receivedNode.Version + 1
+			}
+			ci.Nodes[receivedNode.ID] = existingNode
+			continue
+		}
+
+		if !exists {
+			ci.Nodes[receivedNode.ID] = receivedNode
+			if receivedNode.Health.Status != Leaving && receivedNode.Health.Status != Removed {
+				addedNodes = append(addedNodes, receivedNode)
+			}
+			continue
+		}
+		if receivedNode.Version <= existingNode.Version {
+			continue
+		}
+
+		receivedNode.Position = existingNode.Position
+		ci.Nodes[receivedNode.ID] = receivedNode
+		if receivedNode.Health.Status == existingNode.Health.Status {
+			// The ring keeps its own copy of the node, which must be refreshed if the node moved
+			moved := receivedNode.Address != existingNode.Address || receivedNode.InternalAddress != existingNode.InternalAddress
+			if moved && receivedNode.Health.Status == Healthy {
+				addedNodes = append(addedNodes, receivedNode)
+			}
+			continue
+		}
+		switch receivedNode.Health.Status {
+		case Healthy:
+			// A node that left or failed came back, make sure it is part of the ring again
+			addedNodes = append(addedNodes, receivedNode)
+		case SuspectedFailed:
+			suspectedFailedNodes = append(suspectedFailedNodes, receivedNode)
+		case PermanentFailed:
+			permanentFailedNodes = append(permanentFailedNodes, receivedNode)
+		case Leaving:
+			leftNodes = append(leftNodes, receivedNode)
+		case Removed:
+			removedNodes = append(removedNodes, receivedNode)
+		}
+	}
+
+	if version > ci.Version {
+		ci.Version = version
+	}
+	ci.LastUpdated = time.Now()
+	ci.mu.Unlock()
+
+	for _, node := range addedNodes {
+		ci.notifyObservers("added", node.ID, &node)
+	}
+	for _, node := range suspectedFailedNodes {
+		ci.notifyObservers("suspected_failed", node.ID, &node)
+	}
+	for _, node := range permanentFailedNodes {
+		ci.notifyObservers("permanent_failed", node.ID, &node)
+	}
+	for _, node := range leftNodes {
+		ci.notifyObservers("left", node.ID, &node)
+	}
+	for _, node := range removedNodes {
+		ci.notifyObservers("removed", node.ID, nil)
+	}
+}
+
+// Leave marks this node as leaving and synchronously gossips the change to every healthy node,
+// so that they stop routing requests to it. Nodes are contacted concurrently, so unreachable nodes
+// delay it by at most one RPC timeout. The local hash ring is left untouched so that in-flight
+// requests can still be served while the node drains.
+func (ci *ClusterInfo) Leave() error {
+	ci.mu.Lock()
+	node, ok := ci.Nodes[ci.selfId]
+	if ok {
+		node.Health.Status = Leaving
+		node.Health.LastChecked = time.Now()
+		node.Version++
+		ci.Nodes[ci.selfId] = node
+	}
+	ci.mu.Unlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errs []error
+	for _, node := range ci.GetHealthyNodes() {
+		if node.ID == ci.selfId {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ci.gossipWith(node); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Remove marks a node that is failed or suspected to be failed as removed, so that it no longer
+// owns keys. The entry is kept with a higher version so that gossip spreads the removal instead of
+// adding the node back. A removed node that restarts announces itself again and rejoins.
+func (ci *ClusterInfo) Remove(nodeID string) (Node, error) {
+	ci.mu.Lock()
+	node, ok := ci.Nodes[nodeID]
+	switch {
+	case !ok:
+		ci.mu.Unlock()
+		return Node{}, ErrNodeNotFound
+	case nodeID == ci.selfId:
+		ci.mu.Unlock()
+		return Node{}, ErrRemoveSelf
+	case node.Health.Status == Healthy:
+		ci.mu.Unlock()
+		return Node{}, ErrNodeHealthy
+	case node.Health.Status == Removed:
+		ci.mu.Unlock()
+		return node, nil
+	}
+	node.Health.Status = Removed
+	node.Health.LastChecked = time.Now()
+	node.Version++
+	ci.Nodes[nodeID] = node
+	ci.LastUpdated = time.Now()
+	ci.mu.Unlock()
+
+	ci.logger.Warn("Node removed from the cluster", zap.String("target_node_id", nodeID))
+	ci.notifyObservers("removed", nodeID, nil)
+	return node, nil
+}
+
 // ClusterInfo implements the ClusterObserver interface.
 func (ci *ClusterInfo) NodeAdded(node Node) {
 	// Trigger gossip when a new node is added
@@ -318,10 +594,17 @@ func (ci *ClusterInfo) NodeHealthPermanentFailed(nodeId string) {
 	ci.startGossip()
 }
 
+func (ci *ClusterInfo) NodeLeft(nodeId string) {
+	ci.startGossip()
+}
+
 func (ci *ClusterInfo) markAsHealthy(nodeId string) {
 	ci.mu.Lock()
 	defer ci.mu.Unlock()
 	if node, ok := ci.Nodes[nodeId]; ok {
+		if node.Health.Status != Healthy {
+			node.Version++
+		}
 		node.Health = Health{
 			Status:      Healthy,
 			LastChecked: time.Now(),
@@ -332,35 +615,51 @@ func (ci *ClusterInfo) markAsHealthy(nodeId string) {
 
 // startGossip handles initiating gossip in a separate goroutine.
 func (ci *ClusterInfo) startGossip() {
-	// Create a consistent snapshot of the cluster state for gossip
-	snapshot := ci.createClusterSnapshot()
 	go func() {
-		if err := snapshot.InitiateGossip(); err != nil {
+		if err := ci.InitiateGossip(); err != nil {
 			ci.logger.Error("Error during gossip", zap.Error(err))
 		}
 	}()
 }
 
-func (ci *ClusterInfo) StartPeriodicGossip() {
+// StartPeriodicGossip gossips with random nodes every gossip interval until ctx is cancelled.
+func (ci *ClusterInfo) StartPeriodicGossip(ctx context.Context) {
 	go func() {
-		ticker := time.NewTicker(ci.gossipInterval)
+		ticker := time.NewTicker(ci.GetTiming().GossipInterval)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			ci.logger.Info("Periodic gossip started.")
-			ci.startGossip()
+		for {
+			select {
+			case <-ctx.Done():
+				ci.logger.Info("Periodic gossip stopped")
+				return
+			case <-ticker.C:
+				ci.logger.Info("Periodic gossip started.")
+				ci.startGossip()
+			case <-ci.gossipReset:
+				ticker.Reset(ci.GetTiming().GossipInterval)
+			}
 		}
 	}()
 }
 
-func (ci *ClusterInfo) StartPeriodicHealthCheck() {
+// StartPeriodicHealthCheck checks random nodes every health check interval until ctx is cancelled.
+func (ci *ClusterInfo) StartPeriodicHealthCheck(ctx context.Context) {
 	go func() {
-		ticker := time.NewTicker(ci.healthCheckInterval)
+		ticker := time.NewTicker(ci.GetTiming().HealthCheckInterval)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			ci.logger.Info("Periodic health check started")
-			ci.InitiateHealthCheck()
+		for {
+			select {
+			case <-ctx.Done():
+				ci.logger.Info("Periodic health check stopped")
+				return
+			case <-ticker.C:
+				ci.logger.Info("Periodic health check started")
+				ci.InitiateHealthCheck()
+			case <-ci.healthCheckReset:
+				ticker.Reset(ci.GetTiming().HealthCheckInterval)
+			}
 		}
 	}()
 }
@@ -368,18 +667,26 @@ func (ci *ClusterInfo) StartPeriodicHealthCheck() {
 func (ci *ClusterInfo) InitiateHealthCheck() {
 	nodesToCheck := ci.GetRandomNodesForGossip() // Select random nodes for health checks
 
+	ci.mu.RLock()
+	pool := ci.connectionPool
+	timeout := ci.timing.RPCTimeout
+	ci.mu.RUnlock()
+
 	for _, node := range nodesToCheck {
 
 		// Perform the health check
-		conn, err := grpc.NewClient(node.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := pool.GetConnection(node.PeerAddress())
 		if err != nil {
+			healthChecks.WithLabelValues(resultLabel(err)).Inc()
 			ci.handleHealthFailure(node.ID) // Handle failed connection
 			continue
 		}
-		defer conn.Close()
 
 		client := proto.NewHealthServiceClient(conn)
-		_, healthErr := client.CheckHealth(context.TODO(), &emptypb.Empty{})
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_, healthErr := client.CheckHealth(ctx, &emptypb.Empty{})
+		cancel()
+		healthChecks.WithLabelValues(resultLabel(healthErr)).Inc()
 		if healthErr != nil {
 			ci.handleHealthFailure(node.ID) // Handle failed health check
 		} else {
@@ -403,14 +710,16 @@ func (ci *ClusterInfo) handleHealthFailure(nodeID string) {
 		// First failure: Mark as suspected failed
 		node.Health.Status = SuspectedFailed
 		node.Health.LastChecked = time.Now()
+		node.Version++
 		ci.logger.Warn("Node marked as SuspectedFailed", zap.String("target_node_id", nodeID))
 		suspectedFailedNode = &node
 
 	case SuspectedFailed:
 		// Second failure: Mark as permanently failed
-		if node.Health.FailureCount == ci.failureThreshold {
+		if node.Health.FailureCount >= ci.timing.FailureThreshold {
 			node.Health.Status = PermanentFailed
 			node.Health.LastChecked = time.Now()
+			node.Version++
 			permanentFailedNode = &node
 			ci.logger.Warn("Node marked as PermanentFailed", zap.String("target_node_id", nodeID))
 		} else {
@@ -1,6 +1,8 @@
 package cluster
 
 import (
+	"fmt"
+	"net"
 	"strconv"
 	"sync"
 	"testing"
@@ -8,6 +10,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/proto"
 )
 
 func getTestLogger() *logger.Logger {
@@ -489,3 +492,293 @@ func TestGetRandomNodesForGossip(t *testing.T) {
 		}
 	})
 }
+
+func TestCompareDigest(t *testing.T) {
+	cluster := NewCluster(getTestLogger(), "node1", 2)
+	cluster.Nodes = map[string]Node{
+		"node1": {ID: "node1", Version: 3},
+		"node2": {ID: "node2", Version: 1},
+		"node3": {ID: "node3", Version: 2},
+	}
+	cluster.Version = 4
+
+	t.Run("Returns newer entries and requests older or missing ones", func(t *testing.T) {
+		digest := &proto.GossipDigest{
+			Digests: []*proto.NodeDigest{
+				{Id: "node1", Version: 3}, // Same version
+				{Id: "node2", Version: 5}, // Sender is newer
+				{Id: "node4", Version: 0}, // Unknown locally
+			},
+		}
+		var ack proto.GossipDigestAck
+		cluster.CompareDigest(digest, &ack)
+
+		assert.ElementsMatch(t, []string{"node2", "node4"}, ack.RequestedIds)
+		assert.Equal(t, 1, len(ack.Nodes))
+		assert.Equal(t, "node3", ack.Nodes[0].Id)
+		assert.Equal(t, int64(2), ack.Nodes[0].Version)
+		assert.Equal(t, int64(4), ack.Version)
+	})
+
+	t.Run("Returns nothing when digests match", func(t *testing.T) {
+		var digest proto.GossipDigest
+		cluster.MapDigestToProto(&digest)
+
+		var ack proto.GossipDigestAck
+		cluster.CompareDigest(&digest, &ack)
+
+		assert.Empty(t, ack.RequestedIds)
+		assert.Empty(t, ack.Nodes)
+	})
+
+	t.Run("Delta contains only requested entries", func(t *testing.T) {
+		var delta proto.GossipDelta
+		cluster.MapDeltaToProto([]string{"node2", "unknown"}, &delta)
+
+		assert.Equal(t, 1, len(delta.Nodes))
+		assert.Equal(t, "node2", delta.Nodes[0].Id)
+	})
+}
+
+func TestMergeNodes(t *testing.T) {
+	t.Run("Adds unknown nodes and applies newer versions only", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		cluster.Nodes = map[string]Node{
+			"node2": {ID: "node2", Address: "localhost:8081", Version: 2},
+		}
+
+		cluster.MergeNodes([]Node{
+			{ID: "node2", Address: "localhost:9091", Version: 1},
+			{ID: "node3", Address: "localhost:8082", Version: 1},
+		}, 5)
+
+		assert.Equal(t, 2, len(cluster.Nodes))
+		assert.Equal(t, "localhost:8081", cluster.Nodes["node2"].Address)
+		assert.Equal(t, "localhost:8082", cluster.Nodes["node3"].Address)
+		assert.Equal(t, 5, cluster.Version)
+
+		cluster.MergeNodes([]Node{
+			{ID: "node2", Address: "localhost:8081", Version: 3, Health: Health{Status: SuspectedFailed}},
+		}, 1)
+
+		assert.Equal(t, SuspectedFailed, cluster.Nodes["node2"].Health.Status)
+		assert.Equal(t, 3, cluster.Nodes["node2"].Version)
+		assert.Equal(t, 5, cluster.Version) // Older cluster version is ignored
+	})
+
+	t.Run("Refutes a different view of itself", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		cluster.Nodes = map[string]Node{
+			"node1": {ID: "node1", Address: "localhost:8080", Version: 2},
+		}
+
+		cluster.MergeNodes([]Node{
+			{ID: "node1", Address: "localhost:8080", Version: 4, Health: Health{Status: SuspectedFailed}},
+		}, 0)
+
+		assert.Equal(t, Healthy, cluster.Nodes["node1"].Health.Status)
+		assert.Equal(t, 5, cluster.Nodes["node1"].Version)
+	})
+
+	t.Run("Adopts a higher version of the same view of itself", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		cluster.Nodes = map[string]Node{
+			"node1": {ID: "node1", Address: "localhost:8080", Version: 0},
+		}
+
+		cluster.MergeNodes([]Node{
+			{ID: "node1", Address: "localhost:8080", Version: 5},
+		}, 0)
+
+		assert.Equal(t, 5, cluster.Nodes["node1"].Version)
+		assert.Equal(t, Healthy, cluster.Nodes["node1"].Health.Status)
+
+		// Older views do not lower the version
+		cluster.MergeNodes([]Node{
+			{ID: "node1", Address: "localhost:8080", Version: 3},
+		}, 0)
+
+		assert.Equal(t, 5, cluster.Nodes["node1"].Version)
+	})
+
+	t.Run("Refreshes the ring when a node moves", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		ring := NewHashRing()
+		cluster.RegisterObserver(ring)
+		cluster.AddOrUpdateNode(Node{ID: "node2", Address: "localhost:8081", Version: 1})
+
+		cluster.MergeNodes([]Node{
+			{ID: "node2", Address: "localhost:8081", InternalAddress: "localhost:9081", Version: 3},
+		}, 0)
+
+		node, ok := ring.GetNode("node2")
+		assert.True(t, ok)
+		assert.Equal(t, "localhost:9081", node.PeerAddress())
+	})
+}
+
+func TestPeerAddress(t *testing.T) {
+	assert.Equal(t, "localhost:8080", Node{Address: "localhost:8080"}.PeerAddress())
+	assert.Equal(t, "localhost:9080", Node{Address: "localhost:8080", InternalAddress: "localhost:9080"}.PeerAddress())
+}
+
+func TestSetTiming(t *testing.T) {
+	t.Run("Applies valid timing", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		timing := Timing{
+			GossipInterval:      time.Second,
+			HealthCheckInterval: 2 * time.Second,
+			FailureThreshold:    3,
+			GossipN:             4,
+			RPCTimeout:          time.Second,
+		}
+
+		err := cluster.SetTiming(timing)
+
+		assert.NoError(t, err)
+		assert.Equal(t, timing, cluster.GetTiming())
+	})
+
+	t.Run("Rejects invalid timing and keeps the current one", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		timing := DefaultTiming()
+		timing.GossipInterval = 0
+		timing.FailureThreshold = 0
+
+		err := cluster.SetTiming(timing)
+
+		assert.ErrorContains(t, err, "gossip interval")
+		assert.ErrorContains(t, err, "failure threshold")
+		assert.Equal(t, DefaultTiming(), cluster.GetTiming())
+	})
+
+	t.Run("NewCluster uses the given gossip fanout", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 3)
+		assert.Equal(t, 3, cluster.GetTiming().GossipN)
+	})
+}
+
+func TestNodeLeaving(t *testing.T) {
+	t.Run("Leaving node is removed from the ring and from gossip targets", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		ring := NewHashRing()
+		cluster.RegisterObserver(ring)
+		cluster.AddOrUpdateNode(Node{ID: "node1", Version: 1})
+		cluster.AddOrUpdateNode(Node{ID: "node2", Version: 1})
+		assert.Equal(t, 2, len(ring.Nodes))
+
+		cluster.MergeNodes([]Node{
+			{ID: "node2", Version: 2, Health: Health{Status: Leaving}},
+		}, 0)
+
+		assert.Equal(t, Leaving, cluster.Nodes["node2"].Health.Status)
+		assert.Equal(t, 1, len(ring.Nodes))
+		assert.Empty(t, cluster.GetRandomNodesForGossip())
+	})
+
+	t.Run("Returning node is added back to the ring", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		ring := NewHashRing()
+		cluster.RegisterObserver(ring)
+		cluster.AddOrUpdateNode(Node{ID: "node2", Version: 1})
+		cluster.MergeNodes([]Node{{ID: "node2", Version: 2, Health: Health{Status: Leaving}}}, 0)
+		assert.Equal(t, 0, len(ring.Nodes))
+
+		cluster.MergeNodes([]Node{{ID: "node2", Version: 3, Health: Health{Status: Healthy}}}, 0)
+
+		assert.Equal(t, 1, len(ring.Nodes))
+	})
+
+	t.Run("Leave marks the node itself as leaving", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		cluster.AddOrUpdateNode(Node{ID: "node1", Version: 1})
+
+		err := cluster.Leave()
+
+		assert.NoError(t, err)
+		assert.Equal(t, Leaving, cluster.Nodes["node1"].Health.Status)
+		assert.Equal(t, 2, cluster.Nodes["node1"].Version)
+	})
+
+	t.Run("Leave contacts unresponsive nodes concurrently", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		timing := DefaultTiming()
+		timing.RPCTimeout = 300 * time.Millisecond
+		assert.NoError(t, cluster.SetTiming(timing))
+		pool := NewConnectionPool()
+		defer pool.Close()
+		cluster.SetConnectionPool(pool)
+		cluster.AddOrUpdateNode(Node{ID: "node1", Version: 1})
+		for i := 2; i <= 4; i++ {
+			// The listener accepts connections but never answers
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			assert.NoError(t, err)
+			defer lis.Close()
+			cluster.AddOrUpdateNode(Node{ID: fmt.Sprintf("node%d", i), Address: lis.Addr().String(), Version: 1})
+		}
+
+		start := time.Now()
+		err := cluster.Leave()
+
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 2*timing.RPCTimeout)
+	})
+}
+
+func TestNodeRemoval(t *testing.T) {
+	t.Run("Failed node is removed from the ring and from gossip targets", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		ring := NewHashRing()
+		cluster.RegisterObserver(ring)
+		cluster.AddOrUpdateNode(Node{ID: "node1", Version: 1})
+		cluster.AddOrUpdateNode(Node{ID: "node2", Version: 1, Health: Health{Status: PermanentFailed}})
+
+		node, err := cluster.Remove("node2")
+
+		assert.NoError(t, err)
+		assert.Equal(t, Removed, node.Health.Status)
+		assert.Equal(t, 2, cluster.Nodes["node2"].Version)
+		assert.Equal(t, 1, len(ring.Nodes))
+		assert.Empty(t, cluster.GetRandomNodesForGossip())
+	})
+
+	t.Run("Healthy, unknown and own nodes can not be removed", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		cluster.AddOrUpdateNode(Node{ID: "node1", Version: 1})
+		cluster.AddOrUpdateNode(Node{ID: "node2", Version: 1})
+
+		_, err := cluster.Remove("node2")
+		assert.ErrorIs(t, err, ErrNodeHealthy)
+		_, err = cluster.Remove("node3")
+		assert.ErrorIs(t, err, ErrNodeNotFound)
+		_, err = cluster.Remove("node1")
+		assert.ErrorIs(t, err, ErrRemoveSelf)
+		assert.Equal(t, Healthy, cluster.Nodes["node2"].Health.Status)
+	})
+
+	t.Run("Removal received via gossip removes the node from the ring", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		ring := NewHashRing()
+		cluster.RegisterObserver(ring)
+		cluster.AddOrUpdateNode(Node{ID: "node2", Version: 1})
+
+		cluster.MergeNodes([]Node{
+			{ID: "node2", Version: 2, Health: Health{Status: Removed}},
+			{ID: "node3", Version: 4, Health: Health{Status: Removed}},
+		}, 0)
+
+		assert.Equal(t, Removed, cluster.Nodes["node2"].Health.Status)
+		assert.Equal(t, Removed, cluster.Nodes["node3"].Health.Status)
+		assert.Equal(t, 0, len(ring.Nodes))
+	})
+
+	t.Run("Removed node that restarts announces itself again", func(t *testing.T) {
+		cluster := NewCluster(getTestLogger(), "node1", 2)
+		cluster.AddOrUpdateNode(Node{ID: "node1", Version: 1})
+
+		cluster.MergeNodes([]Node{{ID: "node1", Version: 3, Health: Health{Status: Removed}}}, 0)
+
+		assert.Equal(t, Healthy, cluster.Nodes["node1"].Health.Status)
+		assert.Equal(t, 4, cluster.Nodes["node1"].Version)
+	})
+}
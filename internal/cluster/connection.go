@@ -4,16 +4,26 @@ import (
 	"sync"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 type ConnectionPool struct {
 	connections map[string]*grpc.ClientConn
+	dialOptions []grpc.DialOption
 	mu          sync.Mutex
 }
 
+// NewConnectionPool returns a pool of plaintext connections
 func NewConnectionPool() *ConnectionPool {
-	return &ConnectionPool{connections: make(map[string]*grpc.ClientConn)}
+	return NewConnectionPoolWithCredentials(insecure.NewCredentials())
+}
+
+// NewConnectionPoolWithCredentials returns a pool whose connections use the given transport credentials,
+// for example TLS, and additional dial options
+func NewConnectionPoolWithCredentials(creds credentials.TransportCredentials, opts ...grpc.DialOption) *ConnectionPool {
+	dialOptions := append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, opts...)
+	return &ConnectionPool{connections: make(map[string]*grpc.ClientConn), dialOptions: dialOptions}
 }
 
 func (cp *ConnectionPool) GetConnection(addr string) (*grpc.ClientConn, error) {
@@ -24,7 +34,7 @@ func (cp *ConnectionPool) GetConnection(addr string) (*grpc.ClientConn, error) {
 		return conn, nil
 	}
 
-	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(addr, cp.dialOptions...)
 	if err != nil {
 		return nil, err
 	}
@@ -40,4 +50,5 @@ func (cp *ConnectionPool) Close() {
 	for _, conn := range cp.connections {
 		conn.Close()
 	}
+	cp.connections = make(map[string]*grpc.ClientConn)
 }
@@ -10,7 +10,9 @@ type ConsistentHashRing interface {
 	AddNode(node Node)
 	RemoveNode(nodeID string)
 	GetResponsibleNode(key string) string
-	GetNode(nodeId string) Node
+	GetNode(nodeId string) (Node, bool)
+	GetNodes() []Node
+	Ownership() map[string]float64
 }
 
 type HashRing struct {
@@ -36,7 +38,12 @@ func (hr *HashRing) NodeHealthSuspectedFailed(nodeID string) {}
 
 func (hr *HashRing) NodeHealthPermanentFailed(nodeID string) {}
 
-// AddNode adds a node to the hash ring
+// Observer interface implementation. A node that left no longer owns any keys
+func (hr *HashRing) NodeLeft(nodeID string) {
+	hr.RemoveNode(nodeID)
+}
+
+// AddNode adds a node to the hash ring. If the node is already present, it is replaced
 func (hr *HashRing) AddNode(node Node) {
 	position := CalculateNodePosition(node.ID)
 	node.Position = position
@@ -44,6 +51,12 @@ func (hr *HashRing) AddNode(node Node) {
 	hr.mu.Lock()
 	defer hr.mu.Unlock()
 
+	for i, existing := range hr.Nodes {
+		if existing.ID == node.ID {
+			hr.Nodes[i] = node
+			return
+		}
+	}
 	hr.Nodes = append(hr.Nodes, node)
 	sort.Slice(hr.Nodes, func(i, j int) bool {
 		return hr.Nodes[i].Position < hr.Nodes[j].Position
@@ -65,13 +78,47 @@ func (hr *HashRing) RemoveNode(nodeID string) {
 	}
 }
 
-func (hr *HashRing) GetNode(nodeId string) Node {
+// GetNode returns a node of the ring, and false if it is not part of the ring, for example
+// because it left since its ID was looked up
+func (hr *HashRing) GetNode(nodeId string) (Node, bool) {
+	hr.mu.RLock()
+	defer hr.mu.RUnlock()
+
 	for _, v := range hr.Nodes {
 		if v.ID == nodeId {
-			return v
+			return v, true
+		}
+	}
+	return Node{}, false
+}
+
+// GetNodes returns a copy of all nodes owning keys, ordered by their position on the ring
+func (hr *HashRing) GetNodes() []Node {
+	hr.mu.RLock()
+	defer hr.mu.RUnlock()
+	return append([]Node(nil), hr.Nodes...)
+}
+
+// ringSize is the number of positions on the ring, one per crc32 hash
+const ringSize = 1 << 32
+
+// Ownership returns the share of the key space each node owns, between 0 and 1. A node owns the
+// positions after the previous node up to and including its own.
+func (hr *HashRing) Ownership() map[string]float64 {
+	hr.mu.RLock()
+	defer hr.mu.RUnlock()
+
+	shares := make(map[string]float64, len(hr.Nodes))
+	for i, node := range hr.Nodes {
+		previous := hr.Nodes[(i+len(hr.Nodes)-1)%len(hr.Nodes)]
+		owned := (node.Position - previous.Position + ringSize) % ringSize
+		if owned == 0 {
+			// A single node owns the whole ring
+			owned = ringSize
 		}
+		shares[node.ID] = float64(owned) / ringSize
 	}
-	return Node{}
+	return shares
 }
 
 // GetResponsibleNode returns the node responsible for a given key
@@ -1,7 +1,9 @@
 package cluster
 
 import (
+	"fmt"
 	"hash/crc32"
+	"sync"
 	"testing"
 )
 
@@ -155,3 +157,93 @@ func TestHashCalculations(t *testing.T) {
 		}
 	})
 }
+
+func TestHashRingAddExistingNode(t *testing.T) {
+	ring := NewHashRing()
+	ring.AddNode(Node{ID: "NodeA", Address: "127.0.0.1:8000"})
+	ring.AddNode(Node{ID: "NodeA", Address: "127.0.0.1:9000"})
+
+	if len(ring.Nodes) != 1 {
+		t.Fatalf("Expected 1 node after re-adding, got %d", len(ring.Nodes))
+	}
+	if node, _ := ring.GetNode("NodeA"); node.Address != "127.0.0.1:9000" {
+		t.Errorf("Expected address to be updated, got %s", node.Address)
+	}
+}
+
+// TestHashRingGetNodeConcurrently looks up nodes while others join and leave, run with -race
+func TestHashRingGetNodeConcurrently(t *testing.T) {
+	ring := NewHashRing()
+	ring.AddNode(Node{ID: "NodeA", Address: "127.0.0.1:8000"})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			id := fmt.Sprintf("Node%d", i%10)
+			ring.AddNode(Node{ID: id, Address: "127.0.0.1:8001"})
+			ring.AddNode(Node{ID: "NodeA", Address: fmt.Sprintf("127.0.0.1:%d", 8000+i%2)})
+			ring.RemoveNode(id)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		if node, ok := ring.GetNode("NodeA"); !ok || node.Address == "" {
+			t.Fatalf("Expected NodeA to stay in the ring, got %v", node)
+		}
+		if node, ok := ring.GetNode(fmt.Sprintf("Node%d", i%10)); ok && node.Address != "127.0.0.1:8001" {
+			t.Fatalf("Expected a complete node, got %v", node)
+		}
+	}
+	wg.Wait()
+
+	if _, ok := ring.GetNode("Node1"); ok {
+		t.Errorf("Expected a removed node not to be found")
+	}
+}
+
+func TestHashRingGetNodes(t *testing.T) {
+	ring := NewHashRing()
+	ring.AddNode(Node{ID: "NodeA"})
+	ring.AddNode(Node{ID: "NodeB"})
+
+	nodes := ring.GetNodes()
+	if len(nodes) != 2 || nodes[0].Position > nodes[1].Position {
+		t.Fatalf("Expected 2 nodes ordered by position, got %v", nodes)
+	}
+
+	nodes[0].ID = "Changed"
+	if ring.Nodes[0].ID == "Changed" {
+		t.Errorf("Expected a copy of the nodes")
+	}
+}
+
+func TestHashRingOwnership(t *testing.T) {
+	ring := NewHashRing()
+	if len(ring.Ownership()) != 0 {
+		t.Fatalf("Expected no shares for an empty ring")
+	}
+
+	ring.AddNode(Node{ID: "NodeA"})
+	if share := ring.Ownership()["NodeA"]; share != 1 {
+		t.Fatalf("Expected a single node to own the whole ring, got %f", share)
+	}
+
+	ring.AddNode(Node{ID: "NodeB"})
+	ring.AddNode(Node{ID: "NodeC"})
+	shares := ring.Ownership()
+	total := 0.0
+	for _, share := range shares {
+		total += share
+	}
+	if len(shares) != 3 || total < 0.999999 || total > 1.000001 {
+		t.Fatalf("Expected the shares of 3 nodes to add up to 1, got %v", shares)
+	}
+
+	// The first node also owns the positions after the last node
+	first, last := ring.Nodes[0], ring.Nodes[2]
+	expected := float64(first.Position+ringSize-last.Position) / ringSize
+	if shares[first.ID] != expected {
+		t.Errorf("Expected the first node to own %f, got %f", expected, shares[first.ID])
+	}
+}
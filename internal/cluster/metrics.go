@@ -0,0 +1,79 @@
+package cluster
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	gossipRounds = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "keyforge_gossip_rounds_total",
+		Help: "Number of gossip rounds started by this node.",
+	})
+	gossipExchanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keyforge_gossip_exchanges_total",
+		Help: "Number of digest exchanges with other nodes by result.",
+	}, []string{"result"})
+	healthChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keyforge_health_checks_total",
+		Help: "Number of health checks of other nodes by result.",
+	}, []string{"result"})
+
+	nodeStatusDesc = prometheus.NewDesc(
+		"keyforge_cluster_node_status",
+		"Status of every node known to this node. The series of the current status is 1.",
+		[]string{"node_id", "address", "status"}, nil,
+	)
+	clusterVersionDesc = prometheus.NewDesc(
+		"keyforge_cluster_version",
+		"Version of the cluster state known to this node.",
+		nil, nil,
+	)
+)
+
+// statusNames are the values of the status label
+var statusNames = map[Status]string{
+	Healthy:         "healthy",
+	SuspectedFailed: "suspected_failed",
+	PermanentFailed: "permanent_failed",
+	Leaving:         "leaving",
+	Removed:         "removed",
+}
+
+// resultLabel returns the value of the result label for an error
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// Collectors returns the metrics of gossip, health checks and the membership view of ci
+func Collectors(ci *ClusterInfo) []prometheus.Collector {
+	return []prometheus.Collector{gossipRounds, gossipExchanges, healthChecks, &membershipCollector{ci: ci}}
+}
+
+// membershipCollector exports the nodes of the cluster state when metrics are scraped
+type membershipCollector struct {
+	ci *ClusterInfo
+}
+
+func (c *membershipCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodeStatusDesc
+	ch <- clusterVersionDesc
+}
+
+func (c *membershipCollector) Collect(ch chan<- prometheus.Metric) {
+	c.ci.mu.RLock()
+	defer c.ci.mu.RUnlock()
+
+	for _, node := range c.ci.Nodes {
+		for status, name := range statusNames {
+			value := 0.0
+			if node.Health.Status == status {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(nodeStatusDesc, prometheus.GaugeValue, value, node.ID, node.Address, name)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(clusterVersionDesc, prometheus.GaugeValue, float64(c.ci.Version))
+}
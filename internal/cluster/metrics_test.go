@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMembershipCollector(t *testing.T) {
+	cluster := NewCluster(getTestLogger(), "node1", 2)
+	cluster.Nodes = map[string]Node{
+		"node1": {ID: "node1", Address: "localhost:8080"},
+		"node2": {ID: "node2", Address: "localhost:8081", Health: Health{Status: SuspectedFailed}},
+	}
+	cluster.Version = 7
+
+	collector := &membershipCollector{ci: cluster}
+	// One series per node and status plus the version
+	assert.Equal(t, 2*len(statusNames)+1, testutil.CollectAndCount(collector))
+
+	expected := `
+# HELP keyforge_cluster_version Version of the cluster state known to this node.
+# TYPE keyforge_cluster_version gauge
+keyforge_cluster_version 7
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected), "keyforge_cluster_version"))
+}
+
+func TestGossipMetrics(t *testing.T) {
+	rounds := testutil.ToFloat64(gossipRounds)
+	failures := testutil.ToFloat64(gossipExchanges.WithLabelValues("failure"))
+
+	cluster := NewCluster(getTestLogger(), "node1", 2)
+	cluster.Nodes = map[string]Node{
+		"node2": {ID: "node2", Address: "127.0.0.1:1"}, // Nothing listens on port 1
+	}
+	assert.Error(t, cluster.InitiateGossip())
+
+	assert.Equal(t, rounds+1, testutil.ToFloat64(gossipRounds))
+	assert.Equal(t, failures+1, testutil.ToFloat64(gossipExchanges.WithLabelValues("failure")))
+}
+
+func TestResultLabel(t *testing.T) {
+	assert.Equal(t, "success", resultLabel(nil))
+	assert.Equal(t, "failure", resultLabel(errors.New("failed")))
+}
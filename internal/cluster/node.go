@@ -2,6 +2,9 @@ package cluster
 
 import (
 	"time"
+
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type Status int
@@ -10,6 +13,8 @@ const (
 	Healthy Status = iota
 	SuspectedFailed
 	PermanentFailed
+	Leaving // Leaving indicates the node announced that it is shutting down
+	Removed // Removed indicates an operator removed the node from the cluster
 )
 
 type Health struct {
@@ -20,8 +25,55 @@ type Health struct {
 
 // Node defines a single node in the cluster
 type Node struct {
-	ID       string // Unique ID of the Node
-	Address  string // Address of the Node in <host>:<port> format
-	Position int    // Position of this Node on the hash ring
-	Health   Health // Health defines health of this Node
+	ID              string // Unique ID of the Node
+	Address         string // Address of the Node in <host>:<port> format
+	InternalAddress string // InternalAddress is used by other nodes if the Node serves cluster traffic on a separate port
+	Position        int    // Position of this Node on the hash ring
+	Health          Health // Health defines health of this Node
+	Version         int    // Version is incremented every time this entry changes and is used in gossip digests
+}
+
+// PeerAddress returns the address other nodes use to reach this node
+func (n Node) PeerAddress() string {
+	if n.InternalAddress != "" {
+		return n.InternalAddress
+	}
+	return n.Address
+}
+
+// MapNodeToProto maps a node to its proto representation
+func MapNodeToProto(node Node) *proto.Node {
+	return &proto.Node{
+		Id:              node.ID,
+		Address:         node.Address,
+		InternalAddress: node.InternalAddress,
+		Version:         int64(node.Version),
+		Health: &proto.Health{
+			LastUpdated: timestamppb.New(node.Health.LastChecked),
+			Status:      proto.Status(node.Health.Status),
+		},
+	}
+}
+
+// MapProtoToNode maps a proto node to a node
+func MapProtoToNode(node *proto.Node) Node {
+	return Node{
+		ID:              node.GetId(),
+		Address:         node.GetAddress(),
+		InternalAddress: node.GetInternalAddress(),
+		Version:         int(node.GetVersion()),
+		Health: Health{
+			LastChecked: node.GetHealth().GetLastUpdated().AsTime(),
+			Status:      Status(node.GetHealth().GetStatus()),
+		},
+	}
+}
+
+// MapProtoToNodes maps a list of proto nodes to nodes
+func MapProtoToNodes(nodes []*proto.Node) []Node {
+	result := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		result = append(result, MapProtoToNode(node))
+	}
+	return result
 }
@@ -7,4 +7,5 @@ type ClusterObserver interface {
 	NodeRemoved(nodeID string)
 	NodeHealthSuspectedFailed(nodeId string)
 	NodeHealthPermanentFailed(nodeId string)
+	NodeLeft(nodeId string)
 }
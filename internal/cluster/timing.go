@@ -0,0 +1,47 @@
+package cluster
+
+import (
+	"errors"
+	"time"
+)
+
+// Timing groups the parameters that control how fast the cluster spreads state and detects failures
+type Timing struct {
+	GossipInterval      time.Duration // Duration between which the cluster state sync will happen
+	HealthCheckInterval time.Duration // Duration between which the health checks of nodes will happen
+	FailureThreshold    int           // Number of times a health check should fail to mark it as permanent failed
+	GossipN             int           // Number of nodes to select for gossip and health checks
+	RPCTimeout          time.Duration // Maximum duration of a single gossip exchange or health check
+}
+
+// DefaultTiming returns the timing used when nothing is configured
+func DefaultTiming() Timing {
+	return Timing{
+		GossipInterval:      time.Second * 10,
+		HealthCheckInterval: time.Second * 5,
+		FailureThreshold:    5,
+		GossipN:             2,
+		RPCTimeout:          time.Second * 5,
+	}
+}
+
+// Validate checks that all timing parameters are usable
+func (t Timing) Validate() error {
+	var errs []error
+	if t.GossipInterval <= 0 {
+		errs = append(errs, errors.New("gossip interval must be greater than 0"))
+	}
+	if t.HealthCheckInterval <= 0 {
+		errs = append(errs, errors.New("health check interval must be greater than 0"))
+	}
+	if t.FailureThreshold < 1 {
+		errs = append(errs, errors.New("failure threshold must be at least 1"))
+	}
+	if t.GossipN < 1 {
+		errs = append(errs, errors.New("gossip fanout must be at least 1"))
+	}
+	if t.RPCTimeout <= 0 {
+		errs = append(errs, errors.New("rpc timeout must be greater than 0"))
+	}
+	return errors.Join(errs...)
+}
@@ -1,14 +1,27 @@
 package config
 
 import (
+	"context"
+	"errors"
+	"io"
 	"os"
 	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/tdevsin/keyforge/internal/auth"
 	"github.com/tdevsin/keyforge/internal/cluster"
 	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/security"
 	"github.com/tdevsin/keyforge/internal/storage"
+	"github.com/tdevsin/keyforge/internal/tracing"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 type Environment int
@@ -26,20 +39,45 @@ const (
 )
 
 type Config struct {
-	Environment    Environment                // Environment is the environment in which the server is running
-	RootDir        string                     // RootDir will contain all project related files like config, database etc.
-	Logger         logger.Logging             // Logger is the instance of zap logger. This can be used for logging.
-	Db             storage.Database           // Db is the instance of pebble.
-	HashRing       cluster.ConsistentHashRing // HashRing stores all the nodes of the cluster in a ring
-	ClusterInfo    cluster.ClusterManager     // ClusterInfo contains details of all the nodes in the cluster
-	NodeInfo       *cluster.Node              // NodeInfo contains details of this node itself
-	MetadataDb     storage.Database           // MetadataDb stores node related information in database for node recovery
-	Consistency    Consistency                // Consistency defines if we need strong consistency or eventual consistency
-	ConnectionPool *cluster.ConnectionPool    // ConnectionPool enables reusing existing connections
+	Environment    Environment                 // Environment is the environment in which the server is running
+	RootDir        string                      // RootDir will contain all project related files like config, database etc.
+	DataDir        string                      // DataDir contains the key-value data of this node
+	MetadataDir    string                      // MetadataDir contains node related information used for node recovery
+	Logger         logger.Logging              // Logger is the instance of zap logger. This can be used for logging.
+	Db             storage.Database            // Db is the instance of pebble.
+	HashRing       cluster.ConsistentHashRing  // HashRing stores all the nodes of the cluster in a ring
+	ClusterInfo    cluster.ClusterManager      // ClusterInfo contains details of all the nodes in the cluster
+	NodeInfo       *cluster.Node               // NodeInfo contains details of this node itself
+	MetadataDb     storage.Database            // MetadataDb stores node related information in database for node recovery
+	Consistency    Consistency                 // Consistency defines if we need strong consistency or eventual consistency
+	ConnectionPool *cluster.ConnectionPool     // ConnectionPool enables reusing existing connections
+	RemotePool     *cluster.ConnectionPool     // RemotePool connects to the remote cluster writes are replicated to. It is nil when replication to another cluster is disabled
+	Settings       *Settings                   // Settings are the user provided settings this config was built from
+	Certificates   *security.CertReloader      // Certificates used for TLS. It is nil when TLS is disabled
+	Authenticator  auth.Authenticator          // Authenticator verifies tokens of KeyService clients. It is nil when authentication is disabled
+	ACL            *auth.ACL                   // ACL restricts the keys principals can access. It is nil when every authenticated principal has full access
+	NodeAuth       auth.NodeAuthenticator      // NodeAuth verifies that cluster traffic comes from other nodes. It is nil when nodes are not authenticated
+	stopBackground context.CancelFunc          // stopBackground stops the periodic gossip and health checks
+	stopTracing    func(context.Context) error // stopTracing flushes pending spans. It is nil when tracing is disabled
+	rootDirLock    io.Closer                   // rootDirLock prevents other processes from using the same root directory
 }
 
+const (
+	dataDirName     = "data"
+	metadataDirName = "metadata"
+	lockFileName    = "keyforge.lock"
+
+	// migratingDirName holds the files of older versions while they are moved to the data directory
+	migratingDirName = "data.migrating"
+)
+
 var config Config
 
+// Paths returns the data directory, the metadata directory and the lock file of a root directory
+func Paths(rootDir string) (dataDir, metadataDir, lockFile string) {
+	return path.Join(rootDir, dataDirName), path.Join(rootDir, metadataDirName), path.Join(rootDir, lockFileName)
+}
+
 func folderExists(path string) bool {
 	info, err := os.Stat(path)
 	if os.IsNotExist(err) {
@@ -48,13 +86,108 @@ func folderExists(path string) bool {
 	return info.IsDir()
 }
 
-func ReadConfig(env Environment, nodeAddress string) *Config {
-	homeDir, _ := os.UserHomeDir()
-	rootDir := path.Join(homeDir, ".keyforge")
-	metadataDir := path.Join(rootDir, "metadata")
+// pebbleFile reports whether a file in the root directory of older versions belongs to Pebble.
+// Other files, like a config file kept next to the data, stay in the root directory.
+func pebbleFile(name string) bool {
+	switch {
+	case name == "CURRENT", name == "LOCK":
+		return true
+	case strings.HasPrefix(name, "MANIFEST-"), strings.HasPrefix(name, "OPTIONS-"), strings.HasPrefix(name, "marker."):
+		return true
+	}
+	// Logs and tables are named by their file number, like 000001.log
+	number, ext, _ := strings.Cut(name, ".")
+	if ext != "log" && ext != "sst" {
+		return false
+	}
+	_, err := strconv.ParseUint(number, 10, 64)
+	return err == nil
+}
+
+// syncDir syncs a directory, so renames of its entries survive a crash
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(f.Sync(), f.Close())
+}
+
+// migrateLegacyLayout moves data of older versions, which stored Pebble files directly in the
+// root directory, into the data directory. The metadata directory is already in the right place.
+// Files are moved into a staging directory that is renamed to the data directory at the end, so a
+// migration interrupted by a crash is resumed on the next start instead of opening a partial
+// database.
+func migrateLegacyLayout(rootDir, dataDir string) error {
+	if folderExists(dataDir) {
+		return nil
+	}
+	stagingDir := path.Join(rootDir, migratingDirName)
+	entries, err := os.ReadDir(rootDir)
+	if err != nil {
+		return err
+	}
+	legacy := folderExists(stagingDir)
+	for _, entry := range entries {
+		if entry.Name() == "CURRENT" || strings.HasPrefix(entry.Name(), "marker.manifest.") {
+			legacy = true
+		}
+	}
+	if !legacy {
+		// No Pebble database in the root directory
+		return nil
+	}
+
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !pebbleFile(entry.Name()) {
+			continue
+		}
+		if err := os.Rename(path.Join(rootDir, entry.Name()), path.Join(stagingDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if err := errors.Join(syncDir(stagingDir), syncDir(rootDir)); err != nil {
+		return err
+	}
+	if err := os.Rename(stagingDir, dataDir); err != nil {
+		return err
+	}
+	return syncDir(rootDir)
+}
+
+// ReadConfig builds the config of this node from validated settings
+func ReadConfig(settings *Settings) *Config {
+	env := Dev
+	if settings.Production() {
+		env = Prod
+	}
+	consistency := Strong
+	if settings.Replication.Consistency == "eventual" {
+		consistency = Eventual
+	}
+
+	rootDir := settings.DataDir
+	dataDir, metadataDir, lockFile := Paths(rootDir)
 
 	if !folderExists(rootDir) {
-		os.Mkdir(rootDir, 0755)
+		os.MkdirAll(rootDir, 0755)
+	}
+
+	// Only one process can use a root directory at a time
+	rootDirLock, err := storage.LockFile(lockFile)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := migrateLegacyLayout(rootDir, dataDir); err != nil {
+		panic(err)
+	}
+
+	if !folderExists(dataDir) {
+		os.Mkdir(dataDir, 0755)
 	}
 
 	if !folderExists(metadataDir) {
@@ -64,7 +197,7 @@ func ReadConfig(env Environment, nodeAddress string) *Config {
 	// TODO: Get real cluster information here
 	id := uuid.NewString()
 
-	metadataDb := storage.GetDatabaseInstance(logger.GetLogger(env == Prod, ""), metadataDir)
+	metadataDb := storage.GetDatabaseInstance(newLogger(settings, ""), metadataDir)
 	// Check if there is any existing data about node
 	v, e := metadataDb.ReadKey([]byte("node_id"))
 	if e == nil {
@@ -74,19 +207,84 @@ func ReadConfig(env Environment, nodeAddress string) *Config {
 		// Save current ID
 		metadataDb.WriteKey([]byte("node_id"), []byte(id))
 	}
-	l := logger.GetLogger(env == Prod, id)
+	l := newLogger(settings, id)
 	position := cluster.CalculateNodePosition(id)
 	thisNode := cluster.Node{
-		ID:       id,
-		Position: position,
-		Address:  nodeAddress,
+		ID:              id,
+		Position:        position,
+		Address:         settings.Server.AdvertiseAddress,
+		InternalAddress: settings.Internal.AdvertiseAddress,
 		Health: cluster.Health{
 			Status:      cluster.Healthy,
 			LastChecked: time.Now(),
 		},
 	}
 
-	clusterInfo := cluster.NewCluster(l, id, 2)
+	// Background work runs until Cleanup is called
+	ctx, cancel := context.WithCancel(context.Background())
+
+	nodeAuth, err := settings.Internal.NodeAuthenticator()
+	if err != nil {
+		panic(err)
+	}
+	var certificates *security.CertReloader
+	var transportCredentials credentials.TransportCredentials = insecure.NewCredentials()
+	if settings.TLS.Enabled() {
+		certificates, err = security.NewCertReloader(l, settings.TLS.CertFile, settings.TLS.KeyFile, settings.TLS.CAFile)
+		if err != nil {
+			panic(err)
+		}
+		certificates.Start(ctx, settings.TLS.ReloadInterval)
+		// Nodes present their own certificate when connecting to each other
+		transportCredentials = credentials.NewTLS(certificates.ClientConfig())
+	}
+	var dialOptions []grpc.DialOption
+	var stopTracing func(context.Context) error
+	if settings.Tracing.Enabled() {
+		stopTracing, err = tracing.Setup(ctx, settings.Tracing.Options(id))
+		if err != nil {
+			panic(err)
+		}
+		// Proxied requests continue the trace of the original request
+		dialOptions = append(dialOptions, grpc.WithStatsHandler(tracing.ClientHandler()))
+		l.Info("Tracing enabled", zap.String("exporter", settings.Tracing.Exporter))
+	}
+	var remotePool *cluster.ConnectionPool
+	if settings.Replication.Remote.Enabled() {
+		// The remote cluster has its own secret, the TLS settings are shared
+		remoteOptions := slices.Clone(dialOptions)
+		remoteSecret, err := settings.Replication.Remote.Credentials()
+		if err != nil {
+			panic(err)
+		}
+		if remoteSecret != nil && settings.Internal.PlaintextSecret {
+			remoteSecret = remoteSecret.AllowPlaintext()
+		}
+		if remoteSecret != nil {
+			remoteOptions = append(remoteOptions, grpc.WithPerRPCCredentials(remoteSecret))
+		}
+		remotePool = cluster.NewConnectionPoolWithCredentials(transportCredentials, remoteOptions...)
+	}
+	if secret, ok := nodeAuth.(*auth.SharedSecret); ok {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(secret))
+	}
+	connectionPool := cluster.NewConnectionPoolWithCredentials(transportCredentials, dialOptions...)
+
+	authenticator, err := settings.Auth.Authenticator()
+	if err != nil {
+		panic(err)
+	}
+	acl, err := settings.Auth.ACL()
+	if err != nil {
+		panic(err)
+	}
+
+	clusterInfo := cluster.NewCluster(l, id, settings.Cluster.GossipFanout)
+	if err := clusterInfo.SetTiming(settings.Timing()); err != nil {
+		panic(err)
+	}
+	// Gossip and health checks reuse the same connections as request proxying
+	clusterInfo.SetConnectionPool(connectionPool)
 	hashring := cluster.NewHashRing()
 	// Allows HashRing to know when a node is added, updated or removed via the Observer interface
 	clusterInfo.RegisterObserver(hashring)
@@ -94,26 +292,82 @@ func ReadConfig(env Environment, nodeAddress string) *Config {
 	// Register itself as an observer to listen to cluster changes and perform gossip
 	clusterInfo.RegisterObserver(clusterInfo)
 	// Start periodic gossip with other nodes to keep state in sync
-	clusterInfo.StartPeriodicGossip()
-	clusterInfo.StartPeriodicHealthCheck()
+	clusterInfo.StartPeriodicGossip(ctx)
+	clusterInfo.StartPeriodicHealthCheck(ctx)
+
+	db := storage.GetDatabaseInstance(l, dataDir)
+	if remotePool != nil {
+		// Writes are recorded from the start, so the replication misses none
+		if err := db.EnableChangeLog(); err != nil {
+			panic(err)
+		}
+	}
 
 	config = Config{
 		RootDir:        rootDir,
+		DataDir:        dataDir,
+		MetadataDir:    metadataDir,
 		Logger:         l,
-		Db:             storage.GetDatabaseInstance(l, rootDir),
+		Db:             db,
 		HashRing:       hashring,
 		NodeInfo:       &thisNode,
 		Environment:    env,
 		ClusterInfo:    clusterInfo,
-		Consistency:    Strong,
-		ConnectionPool: cluster.NewConnectionPool(),
+		Consistency:    consistency,
+		ConnectionPool: connectionPool,
+		RemotePool:     remotePool,
+		MetadataDb:     metadataDb,
+		Settings:       settings,
+		Certificates:   certificates,
+		Authenticator:  authenticator,
+		ACL:            acl,
+		NodeAuth:       nodeAuth,
+		stopBackground: cancel,
+		stopTracing:    stopTracing,
+		rootDirLock:    rootDirLock,
 	}
 	return &config
 }
 
-// Cleanup closes all the resources and exits gracefully
+// newLogger creates a logger from the logging settings
+func newLogger(settings *Settings, nodeId string) *logger.Logger {
+	l, err := logger.NewLogger(settings.LoggerOptions(), nodeId)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// Cleanup stops background work, flushes pending data and closes all the resources
 func (c *Config) Cleanup() {
-	c.Logger.Sync()
-	c.Db.Close()
+	if c.stopBackground != nil {
+		c.stopBackground()
+	}
+	if err := c.Db.Flush(); err != nil {
+		c.Logger.Error("Failed to flush database", zap.Error(err))
+	}
+	if err := c.Db.Close(); err != nil {
+		c.Logger.Error("Failed to close database", zap.Error(err))
+	}
+	if c.MetadataDb != nil {
+		if err := c.MetadataDb.Close(); err != nil {
+			c.Logger.Error("Failed to close metadata database", zap.Error(err))
+		}
+	}
 	c.ConnectionPool.Close()
+	if c.RemotePool != nil {
+		c.RemotePool.Close()
+	}
+	if c.stopTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := c.stopTracing(ctx); err != nil {
+			c.Logger.Error("Failed to flush traces", zap.Error(err))
+		}
+		cancel()
+	}
+	if c.rootDirLock != nil {
+		c.rootDirLock.Close()
+	}
+	c.Logger.Info("Cleanup completed")
+	c.Logger.Sync()
 }
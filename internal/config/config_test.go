@@ -0,0 +1,61 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateLegacyLayout(t *testing.T) {
+	t.Run("Moves Pebble files from the root directory to the data directory", func(t *testing.T) {
+		rootDir := t.TempDir()
+		dataDir := path.Join(rootDir, dataDirName)
+		assert.NoError(t, os.WriteFile(path.Join(rootDir, "CURRENT"), []byte("MANIFEST-000001"), 0644))
+		assert.NoError(t, os.WriteFile(path.Join(rootDir, "000001.log"), nil, 0644))
+		assert.NoError(t, os.Mkdir(path.Join(rootDir, metadataDirName), 0755))
+		assert.NoError(t, os.WriteFile(path.Join(rootDir, lockFileName), nil, 0644))
+		assert.NoError(t, os.WriteFile(path.Join(rootDir, "keyforge.yaml"), nil, 0644))
+		assert.NoError(t, os.WriteFile(path.Join(rootDir, "keyforge.log"), nil, 0644))
+
+		err := migrateLegacyLayout(rootDir, dataDir)
+
+		assert.NoError(t, err)
+		assert.FileExists(t, path.Join(dataDir, "CURRENT"))
+		assert.FileExists(t, path.Join(dataDir, "000001.log"))
+		assert.NoFileExists(t, path.Join(rootDir, "CURRENT"))
+		assert.DirExists(t, path.Join(rootDir, metadataDirName))
+		assert.FileExists(t, path.Join(rootDir, lockFileName))
+		assert.FileExists(t, path.Join(rootDir, "keyforge.yaml"))
+		assert.FileExists(t, path.Join(rootDir, "keyforge.log"))
+		assert.NoDirExists(t, path.Join(rootDir, migratingDirName))
+	})
+
+	t.Run("Resumes an interrupted migration", func(t *testing.T) {
+		rootDir := t.TempDir()
+		dataDir := path.Join(rootDir, dataDirName)
+		stagingDir := path.Join(rootDir, migratingDirName)
+		// The previous start crashed after moving CURRENT but before moving the table
+		assert.NoError(t, os.Mkdir(stagingDir, 0755))
+		assert.NoError(t, os.WriteFile(path.Join(stagingDir, "CURRENT"), []byte("MANIFEST-000001"), 0644))
+		assert.NoError(t, os.WriteFile(path.Join(rootDir, "000002.sst"), nil, 0644))
+
+		err := migrateLegacyLayout(rootDir, dataDir)
+
+		assert.NoError(t, err)
+		assert.FileExists(t, path.Join(dataDir, "CURRENT"))
+		assert.FileExists(t, path.Join(dataDir, "000002.sst"))
+		assert.NoDirExists(t, stagingDir)
+	})
+
+	t.Run("Does nothing for a new root directory", func(t *testing.T) {
+		rootDir := t.TempDir()
+		dataDir := path.Join(rootDir, dataDirName)
+
+		err := migrateLegacyLayout(rootDir, dataDir)
+
+		assert.NoError(t, err)
+		assert.NoDirExists(t, dataDir)
+	})
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is the prefix of all environment variables that override settings
+const envPrefix = "KEYFORGE"
+
+// applyEnvironment overrides settings with environment variables. The name of a variable is derived
+// from the YAML keys of a setting, for example KEYFORGE_CLUSTER_GOSSIP_INTERVAL overrides
+// cluster.gossip_interval and KEYFORGE_DATA_DIR overrides data_dir.
+func applyEnvironment(settings *Settings, lookup func(string) (string, bool)) error {
+	return applyEnvironmentToStruct(reflect.ValueOf(settings).Elem(), envPrefix, lookup)
+}
+
+func applyEnvironmentToStruct(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	var errs []error
+	for i := 0; i < v.NumField(); i++ {
+		key := v.Type().Field(i).Tag.Get("yaml")
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := applyEnvironmentToStruct(field, name, lookup); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(field, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setFromString parses raw into the type of the given field
+func setFromString(field reflect.Value, raw string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case string:
+		field.SetString(raw)
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
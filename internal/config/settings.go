@@ -0,0 +1,627 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/tdevsin/keyforge/internal/auth"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/security"
+	"github.com/tdevsin/keyforge/internal/tracing"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings contains the user provided settings of a node. They are resolved in the following
+// order, where later sources take precedence: defaults, config file, KEYFORGE_* environment
+// variables and command line flags.
+type Settings struct {
+	Environment string              `yaml:"environment" toml:"environment"` // Environment in which the node runs. Accepted values: dev, prod
+	DataDir     string              `yaml:"data_dir" toml:"data_dir"`       // DataDir contains all the files of this node. Every node on a host needs its own
+	Server      ServerSettings      `yaml:"server" toml:"server"`           // Server contains settings of the gRPC server
+	Cluster     ClusterSettings     `yaml:"cluster" toml:"cluster"`         // Cluster contains gossip and failure detection settings
+	Replication ReplicationSettings `yaml:"replication" toml:"replication"` // Replication contains settings about how data is kept consistent
+	Logging     LoggingSettings     `yaml:"logging" toml:"logging"`         // Logging contains settings of the logger
+	TLS         TLSSettings         `yaml:"tls" toml:"tls"`                 // TLS contains certificates used for client and inter-node traffic
+	Auth        AuthSettings        `yaml:"auth" toml:"auth"`               // Auth contains settings of client authentication and authorization
+	Internal    InternalSettings    `yaml:"internal" toml:"internal"`       // Internal contains settings of traffic between nodes
+	Metrics     MetricsSettings     `yaml:"metrics" toml:"metrics"`         // Metrics contains settings of the Prometheus endpoint
+	Tracing     TracingSettings     `yaml:"tracing" toml:"tracing"`         // Tracing contains settings of the OpenTelemetry exporter
+	Gateway     GatewaySettings     `yaml:"gateway" toml:"gateway"`         // Gateway contains settings of the HTTP/JSON gateway
+	Redis       RedisSettings       `yaml:"redis" toml:"redis"`             // Redis contains settings of the Redis protocol listener
+	Memcached   MemcachedSettings   `yaml:"memcached" toml:"memcached"`     // Memcached contains settings of the memcached protocol listener
+}
+
+// ServerSettings controls the gRPC server
+type ServerSettings struct {
+	ListenAddress    string        `yaml:"listen_address" toml:"listen_address"`       // Address the server binds to. Defaults to all interfaces on the advertised port
+	AdvertiseAddress string        `yaml:"advertise_address" toml:"advertise_address"` // Address other nodes use to reach this node in <host>:<port> format
+	DrainTimeout     time.Duration `yaml:"drain_timeout" toml:"drain_timeout"`         // Maximum time to wait for in-flight requests during shutdown
+}
+
+// ClusterSettings controls how fast cluster state is spread and failures are detected
+type ClusterSettings struct {
+	GossipInterval      time.Duration `yaml:"gossip_interval" toml:"gossip_interval"`             // Duration between periodic gossip rounds
+	HealthCheckInterval time.Duration `yaml:"health_check_interval" toml:"health_check_interval"` // Duration between periodic health checks
+	FailureThreshold    int           `yaml:"failure_threshold" toml:"failure_threshold"`         // Failed health checks before a node is marked as permanently failed
+	GossipFanout        int           `yaml:"gossip_fanout" toml:"gossip_fanout"`                 // Number of nodes contacted in every gossip round and health check
+	RPCTimeout          time.Duration `yaml:"rpc_timeout" toml:"rpc_timeout"`                     // Maximum duration of a single gossip exchange or health check
+}
+
+// ReplicationSettings controls how data is kept consistent
+type ReplicationSettings struct {
+	Consistency string         `yaml:"consistency" toml:"consistency"` // Consistency level. Accepted values: strong, eventual
+	Remote      RemoteSettings `yaml:"remote" toml:"remote"`           // Remote contains settings of asynchronous replication to another cluster
+}
+
+// RemoteSettings controls the asynchronous replication of the writes of this node to another
+// cluster, for example a warm standby in another region. Replication is enabled when addresses are set.
+type RemoteSettings struct {
+	Addresses  string        `yaml:"addresses" toml:"addresses"`     // Comma separated addresses of nodes of the remote cluster, their internal addresses if they have one
+	SecretFile string        `yaml:"secret_file" toml:"secret_file"` // File containing the cluster secret of the remote cluster, if it authenticates nodes with a secret
+	Interval   time.Duration `yaml:"interval" toml:"interval"`       // Duration between shipments once every change is shipped
+	BatchSize  int           `yaml:"batch_size" toml:"batch_size"`   // Maximum number of changes shipped in one request
+}
+
+// Enabled reports if writes are replicated to a remote cluster
+func (s *RemoteSettings) Enabled() bool {
+	return s.Addresses != ""
+}
+
+// AddressList returns the addresses of the remote nodes
+func (s *RemoteSettings) AddressList() []string {
+	return splitList(s.Addresses)
+}
+
+// splitList returns the non-empty items of a comma separated list
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Credentials returns the credentials sent to the remote cluster, or nil if it does not authenticate nodes with a secret
+func (s *RemoteSettings) Credentials() (*auth.SharedSecret, error) {
+	if s.SecretFile == "" {
+		return nil, nil
+	}
+	return auth.LoadSharedSecret(s.SecretFile)
+}
+
+func (s *RemoteSettings) validate() error {
+	if !s.Enabled() {
+		return nil
+	}
+	var errs []error
+	for _, address := range s.AddressList() {
+		if _, _, err := splitAddress(address); err != nil {
+			errs = append(errs, fmt.Errorf("remote address %q is invalid: %w", address, err))
+		}
+	}
+	if _, err := s.Credentials(); err != nil {
+		errs = append(errs, err)
+	}
+	if s.Interval <= 0 {
+		errs = append(errs, errors.New("remote interval must be positive"))
+	}
+	if s.BatchSize <= 0 {
+		errs = append(errs, errors.New("remote batch size must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// LoggingSettings controls the logger. Empty values use the defaults of the environment
+type LoggingSettings struct {
+	Level  string `yaml:"level" toml:"level"`   // Minimum level of logged messages. Accepted values: debug, info, warn, error
+	Format string `yaml:"format" toml:"format"` // Encoding of log messages. Accepted values: json, console
+}
+
+// TLSSettings controls TLS on the listener and on connections to other nodes. TLS is enabled when
+// a certificate is configured. Every node must then use a certificate signed by the CA that is valid
+// for both server and client authentication, so nodes authenticate each other with mutual TLS.
+// Client certificates are required by default. With client auth optional or none, connections
+// without a certificate are accepted, from nodes as well.
+type TLSSettings struct {
+	CertFile       string        `yaml:"cert_file" toml:"cert_file"`             // PEM encoded certificate of this node
+	KeyFile        string        `yaml:"key_file" toml:"key_file"`               // PEM encoded private key of the certificate
+	CAFile         string        `yaml:"ca_file" toml:"ca_file"`                 // PEM encoded CA used to verify other nodes and client certificates
+	ClientAuth     string        `yaml:"client_auth" toml:"client_auth"`         // Client certificate policy. Accepted values: none, optional, require
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval"` // How often the files are checked for changes
+}
+
+// Enabled reports if TLS is configured
+func (s *TLSSettings) Enabled() bool {
+	return s.CertFile != ""
+}
+
+// AuthSettings controls how clients of KeyService are authenticated and which keys they can access
+type AuthSettings struct {
+	Mode        string `yaml:"mode" toml:"mode"`                 // Authentication mode. Accepted values: none, token, jwt
+	TokensFile  string `yaml:"tokens_file" toml:"tokens_file"`   // YAML file of static tokens per principal, used by the token mode
+	JWTKeyFile  string `yaml:"jwt_key_file" toml:"jwt_key_file"` // PEM public key or HMAC secret verifying JWTs, used by the jwt mode
+	JWTIssuer   string `yaml:"jwt_issuer" toml:"jwt_issuer"`     // Required issuer of JWTs. Not checked if empty
+	JWTAudience string `yaml:"jwt_audience" toml:"jwt_audience"` // Required audience of JWTs. Not checked if empty
+	ACLFile     string `yaml:"acl_file" toml:"acl_file"`         // YAML file of rules granting permissions on key prefixes. Without it every authenticated principal has full access
+}
+
+// Enabled reports if clients must authenticate
+func (s *AuthSettings) Enabled() bool {
+	return s.Mode != "" && s.Mode != "none"
+}
+
+// Authenticator returns the authenticator of the configured mode, or nil if authentication is disabled
+func (s *AuthSettings) Authenticator() (auth.Authenticator, error) {
+	switch s.Mode {
+	case "", "none":
+		return nil, nil
+	case "token":
+		if s.TokensFile == "" {
+			return nil, errors.New("tokens file is required in token mode")
+		}
+		return auth.LoadStaticTokens(s.TokensFile)
+	case "jwt":
+		if s.JWTKeyFile == "" {
+			return nil, errors.New("JWT key file is required in jwt mode")
+		}
+		return auth.NewJWTVerifier(s.JWTKeyFile, s.JWTIssuer, s.JWTAudience)
+	default:
+		return nil, fmt.Errorf("mode must be none, token or jwt, got %q", s.Mode)
+	}
+}
+
+// ACL returns the configured ACL, or nil if every authenticated principal has full access
+func (s *AuthSettings) ACL() (*auth.ACL, error) {
+	if s.ACLFile == "" {
+		return nil, nil
+	}
+	if !s.Enabled() {
+		return nil, errors.New("an ACL file requires the token or jwt mode")
+	}
+	return auth.LoadACL(s.ACLFile)
+}
+
+// InternalSettings controls traffic between nodes: ClusterService calls and requests proxied to the node owning a key
+type InternalSettings struct {
+	ListenAddress    string `yaml:"listen_address" toml:"listen_address"`       // Address of a separate server for cluster traffic. Defaults to all interfaces on the advertised internal port
+	AdvertiseAddress string `yaml:"advertise_address" toml:"advertise_address"` // Address other nodes use for cluster traffic. If empty, cluster traffic uses the server address
+	Auth             string `yaml:"auth" toml:"auth"`                           // Authentication of other nodes. Accepted values: none, secret, certificate
+	SecretFile       string `yaml:"secret_file" toml:"secret_file"`             // File containing the cluster secret, used by the secret mode
+	PlaintextSecret  bool   `yaml:"plaintext_secret" toml:"plaintext_secret"`   // Allows sending cluster secrets without TLS, for local clusters
+	NodeNames        string `yaml:"node_names" toml:"node_names"`               // Comma separated names of node certificates, used by the certificate mode. * matches within a label
+	Unauthenticated  bool   `yaml:"unauthenticated" toml:"unauthenticated"`     // Allows nodes without authentication while clients authenticate, leaving ClusterService open
+}
+
+// Separate reports if cluster traffic is served on its own port
+func (s *InternalSettings) Separate() bool {
+	return s.AdvertiseAddress != ""
+}
+
+// Listen returns the address the internal server binds to
+func (s *InternalSettings) Listen() string {
+	server := ServerSettings{ListenAddress: s.ListenAddress, AdvertiseAddress: s.AdvertiseAddress}
+	return server.Listen()
+}
+
+// NodeAuthenticator returns the authenticator of the configured mode, or nil if nodes are not authenticated
+func (s *InternalSettings) NodeAuthenticator() (auth.NodeAuthenticator, error) {
+	switch s.Auth {
+	case "", "none":
+		return nil, nil
+	case "secret":
+		if s.SecretFile == "" {
+			return nil, errors.New("secret file is required in secret mode")
+		}
+		secret, err := auth.LoadSharedSecret(s.SecretFile)
+		if err != nil || !s.PlaintextSecret {
+			return secret, err
+		}
+		return secret.AllowPlaintext(), nil
+	case "certificate":
+		return auth.NewNodeCertificates(splitList(s.NodeNames))
+	default:
+		return nil, fmt.Errorf("auth must be none, secret or certificate, got %q", s.Auth)
+	}
+}
+
+// MetricsSettings controls the HTTP server exposing Prometheus metrics on /metrics
+type MetricsSettings struct {
+	ListenAddress string `yaml:"listen_address" toml:"listen_address"` // Address of the metrics server, for example :9090. Metrics are disabled if empty
+}
+
+// GatewaySettings controls the HTTP/JSON gateway serving keys to clients that can not speak gRPC
+type GatewaySettings struct {
+	ListenAddress string `yaml:"listen_address" toml:"listen_address"` // Address of the gateway, for example :8000. The gateway is disabled if empty
+}
+
+// RedisSettings controls the listener serving keys over the Redis protocol, so existing Redis clients can be used
+type RedisSettings struct {
+	ListenAddress string `yaml:"listen_address" toml:"listen_address"` // Address of the Redis listener, for example :6379. The listener is disabled if empty
+}
+
+// MemcachedSettings controls the listener serving keys over the memcached text and binary protocols
+type MemcachedSettings struct {
+	ListenAddress string `yaml:"listen_address" toml:"listen_address"` // Address of the memcached listener, for example :11211. The listener is disabled if empty
+}
+
+// TracingSettings controls where OpenTelemetry spans of requests are exported to
+type TracingSettings struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter"`         // Accepted values: none, otlp, stdout
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint"`         // Address of the OTLP gRPC collector
+	Insecure    bool    `yaml:"insecure" toml:"insecure"`         // Insecure disables TLS to the OTLP collector
+	File        string  `yaml:"file" toml:"file"`                 // File the stdout exporter appends spans to. Spans are written to stdout if empty
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"` // Fraction of requests that are traced, between 0 and 1
+}
+
+// Enabled reports whether spans are exported
+func (s *TracingSettings) Enabled() bool {
+	return s.Exporter != "" && s.Exporter != tracing.ExporterNone
+}
+
+// Options returns the tracing options of the node with the given ID
+func (s *TracingSettings) Options(nodeId string) tracing.Options {
+	return tracing.Options{
+		Exporter:    s.Exporter,
+		Endpoint:    s.Endpoint,
+		Insecure:    s.Insecure,
+		File:        s.File,
+		SampleRatio: s.SampleRatio,
+		NodeID:      nodeId,
+	}
+}
+
+func (s *TracingSettings) validate() error {
+	switch s.Exporter {
+	case "", tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		if s.Endpoint == "" {
+			return errors.New("endpoint is required for the otlp exporter")
+		}
+	default:
+		return fmt.Errorf("exporter must be none, otlp or stdout, got %q", s.Exporter)
+	}
+	if s.SampleRatio < 0 || s.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio must be between 0 and 1, got %v", s.SampleRatio)
+	}
+	return nil
+}
+
+// DefaultSettings returns the settings used when nothing is configured
+func DefaultSettings() *Settings {
+	timing := cluster.DefaultTiming()
+	homeDir, _ := os.UserHomeDir()
+	return &Settings{
+		Environment: "dev",
+		DataDir:     path.Join(homeDir, ".keyforge"),
+		Cluster: ClusterSettings{
+			GossipInterval:      timing.GossipInterval,
+			HealthCheckInterval: timing.HealthCheckInterval,
+			FailureThreshold:    timing.FailureThreshold,
+			GossipFanout:        timing.GossipN,
+			RPCTimeout:          timing.RPCTimeout,
+		},
+		Server: ServerSettings{
+			DrainTimeout: 30 * time.Second,
+		},
+		Replication: ReplicationSettings{
+			Consistency: "strong",
+			Remote: RemoteSettings{
+				Interval:  time.Second,
+				BatchSize: 1000,
+			},
+		},
+		TLS: TLSSettings{
+			ClientAuth:     security.ClientAuthRequire,
+			ReloadInterval: time.Minute,
+		},
+		Auth: AuthSettings{
+			Mode: "none",
+		},
+		Internal: InternalSettings{
+			Auth: "none",
+		},
+		Tracing: TracingSettings{
+			Exporter:    tracing.ExporterNone,
+			Endpoint:    "localhost:4317",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// LoadSettings reads settings from the given config file on top of the defaults and applies
+// KEYFORGE_* environment variables. The format of the file is detected from its extension:
+// .yaml, .yml or .toml. If path is empty, only the environment variables are applied.
+func LoadSettings(path string) (*Settings, error) {
+	settings := DefaultSettings()
+	if path != "" {
+		if err := readSettingsFile(path, settings); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnvironment(settings, os.LookupEnv); err != nil {
+		return nil, fmt.Errorf("invalid environment variable: %w", err)
+	}
+	return settings, nil
+}
+
+// readSettingsFile decodes a YAML or TOML file into settings. Unknown keys are rejected.
+func readSettingsFile(path string, settings *Settings) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(settings); err != nil && err != io.EOF {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	case ".toml":
+		metadata, err := toml.Decode(string(data), settings)
+		if err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("failed to parse config file %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("config file %s must have a .yaml, .yml or .toml extension", path)
+	}
+	return nil
+}
+
+// Production reports if the node runs in the production environment
+func (s *Settings) Production() bool {
+	return s.Environment == "prod"
+}
+
+// Timing returns the cluster timing described by the settings
+func (s *Settings) Timing() cluster.Timing {
+	return cluster.Timing{
+		GossipInterval:      s.Cluster.GossipInterval,
+		HealthCheckInterval: s.Cluster.HealthCheckInterval,
+		FailureThreshold:    s.Cluster.FailureThreshold,
+		GossipN:             s.Cluster.GossipFanout,
+		RPCTimeout:          s.Cluster.RPCTimeout,
+	}
+}
+
+// LoggerOptions returns the logger options described by the settings
+func (s *Settings) LoggerOptions() logger.Options {
+	return logger.Options{
+		Production: s.Production(),
+		Level:      s.Logging.Level,
+		Format:     s.Logging.Format,
+	}
+}
+
+// Validate checks that the settings can be used to start a node. All problems are reported at once.
+func (s *Settings) Validate() error {
+	var errs []error
+	if s.Environment != "dev" && s.Environment != "prod" {
+		errs = append(errs, fmt.Errorf("environment must be dev or prod, got %q", s.Environment))
+	}
+	if s.DataDir == "" {
+		errs = append(errs, errors.New("data directory is required"))
+	}
+	if err := validateAddresses(s.Server.Listen(), s.Server.AdvertiseAddress); err != nil {
+		errs = append(errs, fmt.Errorf("invalid server settings: %w", err))
+	}
+	if s.Server.DrainTimeout < 0 {
+		errs = append(errs, errors.New("invalid server settings: drain timeout must not be negative"))
+	}
+	if err := s.Timing().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid cluster settings: %w", err))
+	}
+	if s.Replication.Consistency != "strong" && s.Replication.Consistency != "eventual" {
+		errs = append(errs, fmt.Errorf("invalid replication settings: consistency must be strong or eventual, got %q", s.Replication.Consistency))
+	}
+	if err := s.Replication.Remote.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid replication settings: %w", err))
+	}
+	if s.Replication.Remote.Enabled() && s.Replication.Remote.SecretFile != "" && !s.TLS.Enabled() && !s.Internal.PlaintextSecret {
+		errs = append(errs, errors.New("invalid replication settings: remote secret requires TLS, or plaintext secrets to be allowed"))
+	}
+	if s.Logging.Level != "" {
+		if _, err := zapcore.ParseLevel(s.Logging.Level); err != nil {
+			errs = append(errs, fmt.Errorf("invalid logging settings: %w", err))
+		}
+	}
+	if s.Logging.Format != "" && s.Logging.Format != "json" && s.Logging.Format != "console" {
+		errs = append(errs, fmt.Errorf("invalid logging settings: format must be json or console, got %q", s.Logging.Format))
+	}
+	if err := s.TLS.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid tls settings: %w", err))
+	}
+	// Files are loaded to report problems in their content as well
+	if _, err := s.Auth.Authenticator(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid auth settings: %w", err))
+	}
+	if _, err := s.Auth.ACL(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid auth settings: %w", err))
+	}
+	if err := s.validateInternal(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid internal settings: %w", err))
+	}
+	if err := s.validateListeners(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.Tracing.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid tracing settings: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// validateListeners checks the addresses of the optional listeners, and that every listener uses
+// its own port
+func (s *Settings) validateListeners() error {
+	listeners := []struct{ name, description, address string }{
+		{"metrics", "the metrics server", s.Metrics.ListenAddress},
+		{"gateway", "the gateway", s.Gateway.ListenAddress},
+		{"redis", "the Redis listener", s.Redis.ListenAddress},
+		{"memcached", "the memcached listener", s.Memcached.ListenAddress},
+	}
+	var errs []error
+	used := map[int]string{} // used maps the ports of the listeners checked so far to their description
+	for _, listener := range listeners {
+		if listener.address == "" {
+			continue
+		}
+		_, port, err := splitAddress(listener.address)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("invalid %s settings: listen address %q is invalid: %w", listener.name, listener.address, err))
+		case s.grpcPort(port):
+			errs = append(errs, fmt.Errorf("invalid %s settings: port %d is already used by a gRPC server", listener.name, port))
+		case used[port] != "":
+			errs = append(errs, fmt.Errorf("invalid %s settings: port %d is already used by %s", listener.name, port, used[port]))
+		default:
+			used[port] = listener.description
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Settings) validateInternal() error {
+	var errs []error
+	if s.Internal.Separate() {
+		if err := validateAddresses(s.Internal.Listen(), s.Internal.AdvertiseAddress); err != nil {
+			errs = append(errs, err)
+		} else if internalPort := addressPort(s.Internal.Listen()); internalPort == addressPort(s.Server.Listen()) {
+			errs = append(errs, fmt.Errorf("internal port %d must differ from the server port", internalPort))
+		}
+	} else if s.Internal.ListenAddress != "" {
+		errs = append(errs, errors.New("advertise address is required when a listen address is set"))
+	}
+	if _, err := s.Internal.NodeAuthenticator(); err != nil {
+		errs = append(errs, err)
+	}
+	if s.Internal.Auth == "certificate" && (!s.TLS.Enabled() || s.TLS.ClientAuth == security.ClientAuthNone) {
+		errs = append(errs, errors.New("certificate auth requires TLS with client certificates"))
+	}
+	if s.Auth.Enabled() && (s.Internal.Auth == "" || s.Internal.Auth == "none") && !s.Internal.Unauthenticated {
+		errs = append(errs, errors.New("auth is required when clients authenticate, as anyone could call ClusterService, or set unauthenticated to allow it"))
+	}
+	if s.Internal.Auth == "secret" && !s.TLS.Enabled() && !s.Internal.PlaintextSecret {
+		errs = append(errs, errors.New("secret auth requires TLS, or plaintext secrets to be allowed"))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *TLSSettings) validate() error {
+	if !s.Enabled() {
+		if s.KeyFile != "" || s.CAFile != "" {
+			return errors.New("cert file is required when a key or CA file is set")
+		}
+		return nil
+	}
+	var errs []error
+	files := []struct{ name, path string }{{"cert file", s.CertFile}, {"key file", s.KeyFile}, {"CA file", s.CAFile}}
+	for _, file := range files {
+		if file.path == "" {
+			errs = append(errs, fmt.Errorf("%s is required", file.name))
+		} else if _, err := os.Stat(file.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.name, err))
+		}
+	}
+	switch s.ClientAuth {
+	case security.ClientAuthNone, security.ClientAuthOptional, security.ClientAuthRequire:
+	default:
+		errs = append(errs, fmt.Errorf("client auth must be none, optional or require, got %q", s.ClientAuth))
+	}
+	if s.ReloadInterval <= 0 {
+		errs = append(errs, errors.New("reload interval must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// Listen returns the address the server binds to. If no listen address is set, the server
+// listens on all interfaces using the port of the advertised address.
+func (s *ServerSettings) Listen() string {
+	if s.ListenAddress != "" {
+		return s.ListenAddress
+	}
+	_, port, err := net.SplitHostPort(s.AdvertiseAddress)
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort("", port)
+}
+
+// validateAddresses makes sure other nodes can reach this node on the advertised address. The
+// ports may differ, for example behind NAT or Docker port mapping.
+func validateAddresses(listen, advertise string) error {
+	if advertise == "" {
+		return errors.New("advertise address is required")
+	}
+	advertiseHost, _, err := splitAddress(advertise)
+	if err != nil {
+		return fmt.Errorf("advertise address %q is invalid: %w", advertise, err)
+	}
+	if advertiseHost == "" {
+		return fmt.Errorf("advertise address %q must contain a host", advertise)
+	}
+	if ip := net.ParseIP(advertiseHost); ip != nil && ip.IsUnspecified() {
+		return fmt.Errorf("advertise address %q must not be an unspecified address", advertise)
+	}
+
+	listenHost, _, err := splitAddress(listen)
+	if err != nil {
+		return fmt.Errorf("listen address %q is invalid: %w", listen, err)
+	}
+	if isLoopback(listenHost) && !isLoopback(advertiseHost) {
+		return fmt.Errorf("listen address %q only accepts local connections but %q is advertised", listen, advertise)
+	}
+	return nil
+}
+
+// splitAddress splits an address in <host>:<port> format and validates the port
+func splitAddress(address string) (string, int, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("port must be a number between 1 and 65535")
+	}
+	return host, port, nil
+}
+
+// grpcPort reports if the gRPC server or the internal server listens on port
+func (s *Settings) grpcPort(port int) bool {
+	return port == addressPort(s.Server.Listen()) || (s.Internal.Separate() && port == addressPort(s.Internal.Listen()))
+}
+
+// addressPort returns the port of an address, or 0 if it is invalid
+func addressPort(address string) int {
+	_, port, err := splitAddress(address)
+	if err != nil {
+		return 0
+	}
+	return port
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
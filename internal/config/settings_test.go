@@ -0,0 +1,497 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tdevsin/keyforge/internal/security/securitytest"
+)
+
+func TestLoadSettings(t *testing.T) {
+	t.Run("Returns defaults when no file is given", func(t *testing.T) {
+		settings, err := LoadSettings("")
+
+		assert.NoError(t, err)
+		assert.Equal(t, DefaultSettings(), settings)
+	})
+
+	t.Run("Overrides defaults with values from the file", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "keyforge.yaml")
+		err := os.WriteFile(file, []byte("cluster:\n  gossip_interval: 2s\n  failure_threshold: 3\n"), 0644)
+		assert.NoError(t, err)
+
+		settings, err := LoadSettings(file)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2*time.Second, settings.Cluster.GossipInterval)
+		assert.Equal(t, 3, settings.Cluster.FailureThreshold)
+		assert.Equal(t, DefaultSettings().Cluster.HealthCheckInterval, settings.Cluster.HealthCheckInterval)
+	})
+
+	t.Run("Returns error for missing file", func(t *testing.T) {
+		_, err := LoadSettings(path.Join(t.TempDir(), "missing.yaml"))
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateSettings(t *testing.T) {
+	settings := DefaultSettings()
+	assert.ErrorContains(t, settings.Validate(), "advertise address is required")
+
+	settings.Server.AdvertiseAddress = "localhost:8080"
+	assert.NoError(t, settings.Validate())
+
+	settings.Cluster.GossipFanout = 0
+	assert.ErrorContains(t, settings.Validate(), "gossip fanout")
+}
+
+func TestValidateAddresses(t *testing.T) {
+	tests := []struct {
+		name      string
+		listen    string
+		advertise string
+		wantErr   string
+	}{
+		{name: "Listen on all interfaces", listen: ":8080", advertise: "localhost:8080"},
+		{name: "Listen on specific interface", listen: "10.0.0.1:8080", advertise: "node1.internal:8080"},
+		{name: "Loopback for local cluster", listen: "127.0.0.1:8081", advertise: "localhost:8081"},
+		{name: "Missing advertise address", listen: ":8080", advertise: "", wantErr: "advertise address is required"},
+		{name: "Advertise without port", listen: ":8080", advertise: "localhost", wantErr: "advertise address"},
+		{name: "Advertise without host", listen: ":8080", advertise: ":8080", wantErr: "must contain a host"},
+		{name: "Advertise unspecified address", listen: ":8080", advertise: "0.0.0.0:8080", wantErr: "unspecified"},
+		{name: "Invalid listen port", listen: ":http", advertise: "localhost:8080", wantErr: "listen address"},
+		{name: "Port mapping", listen: ":9090", advertise: "node1.example.com:8080"},
+		{name: "Loopback listen with remote advertise", listen: "127.0.0.1:8080", advertise: "10.0.0.1:8080", wantErr: "only accepts local connections"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAddresses(tt.listen, tt.advertise)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListen(t *testing.T) {
+	server := ServerSettings{AdvertiseAddress: "localhost:8081"}
+	assert.Equal(t, ":8081", server.Listen())
+
+	server.ListenAddress = "127.0.0.1:8081"
+	assert.Equal(t, "127.0.0.1:8081", server.Listen())
+}
+
+func TestLoadSettingsFormats(t *testing.T) {
+	t.Run("Reads TOML files", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "keyforge.toml")
+		content := "environment = \"prod\"\n\n[server]\nadvertise_address = \"node1:8080\"\n\n[cluster]\ngossip_interval = \"3s\"\n"
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0644))
+
+		settings, err := LoadSettings(file)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "prod", settings.Environment)
+		assert.Equal(t, "node1:8080", settings.Server.AdvertiseAddress)
+		assert.Equal(t, 3*time.Second, settings.Cluster.GossipInterval)
+	})
+
+	t.Run("Rejects unknown YAML keys", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "keyforge.yaml")
+		assert.NoError(t, os.WriteFile(file, []byte("cluster:\n  gossip_intervall: 2s\n"), 0644))
+
+		_, err := LoadSettings(file)
+
+		assert.ErrorContains(t, err, "gossip_intervall")
+	})
+
+	t.Run("Rejects unknown TOML keys", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "keyforge.toml")
+		assert.NoError(t, os.WriteFile(file, []byte("[cluster]\ngossip_intervall = \"2s\"\n"), 0644))
+
+		_, err := LoadSettings(file)
+
+		assert.ErrorContains(t, err, "gossip_intervall")
+	})
+
+	t.Run("Rejects unknown extensions", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "keyforge.json")
+		assert.NoError(t, os.WriteFile(file, []byte("{}"), 0644))
+
+		_, err := LoadSettings(file)
+
+		assert.ErrorContains(t, err, "extension")
+	})
+
+	t.Run("Example config file is valid", func(t *testing.T) {
+		settings, err := LoadSettings("../../keyforge.example.yaml")
+
+		assert.NoError(t, err)
+		assert.NoError(t, settings.Validate())
+	})
+}
+
+func TestEnvironmentOverrides(t *testing.T) {
+	t.Run("Environment variables take precedence over the config file", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "keyforge.yaml")
+		assert.NoError(t, os.WriteFile(file, []byte("cluster:\n  gossip_interval: 2s\n  gossip_fanout: 3\n"), 0644))
+		t.Setenv("KEYFORGE_CLUSTER_GOSSIP_INTERVAL", "7s")
+		t.Setenv("KEYFORGE_SERVER_ADVERTISE_ADDRESS", "node2:8081")
+		t.Setenv("KEYFORGE_DATA_DIR", "/tmp/node2")
+		t.Setenv("KEYFORGE_TRACING_SAMPLE_RATIO", "0.25")
+
+		settings, err := LoadSettings(file)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 7*time.Second, settings.Cluster.GossipInterval)
+		assert.Equal(t, 3, settings.Cluster.GossipFanout)
+		assert.Equal(t, "node2:8081", settings.Server.AdvertiseAddress)
+		assert.Equal(t, "/tmp/node2", settings.DataDir)
+		assert.Equal(t, 0.25, settings.Tracing.SampleRatio)
+	})
+
+	t.Run("Invalid values are reported with the variable name", func(t *testing.T) {
+		t.Setenv("KEYFORGE_CLUSTER_FAILURE_THRESHOLD", "many")
+
+		_, err := LoadSettings("")
+
+		assert.ErrorContains(t, err, "KEYFORGE_CLUSTER_FAILURE_THRESHOLD")
+	})
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Environment = "staging"
+	settings.Replication.Consistency = "quorum"
+	settings.Logging.Level = "loud"
+	settings.Logging.Format = "xml"
+
+	err := settings.Validate()
+
+	assert.ErrorContains(t, err, "environment")
+	assert.ErrorContains(t, err, "advertise address is required")
+	assert.ErrorContains(t, err, "consistency")
+	assert.ErrorContains(t, err, "unrecognized level")
+	assert.ErrorContains(t, err, "format")
+}
+
+func TestValidateTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := securitytest.NewCA(t, dir, "ca")
+	files := ca.Issue(t, dir, "node")
+
+	newSettings := func() *Settings {
+		settings := DefaultSettings()
+		settings.Server.AdvertiseAddress = "localhost:8080"
+		settings.TLS.CertFile = files.CertFile
+		settings.TLS.KeyFile = files.KeyFile
+		settings.TLS.CAFile = ca.CAFile
+		return settings
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		settings := newSettings()
+		assert.True(t, settings.TLS.Enabled())
+		assert.NoError(t, settings.Validate())
+	})
+
+	t.Run("Disabled without certificate", func(t *testing.T) {
+		settings := DefaultSettings()
+		assert.False(t, settings.TLS.Enabled())
+		settings.TLS.CAFile = ca.CAFile
+		assert.ErrorContains(t, settings.Validate(), "cert file is required")
+	})
+
+	t.Run("Missing files", func(t *testing.T) {
+		settings := newSettings()
+		settings.TLS.KeyFile = ""
+		settings.TLS.CAFile = dir + "/missing.crt"
+		err := settings.Validate()
+		assert.ErrorContains(t, err, "key file is required")
+		assert.ErrorContains(t, err, "CA file")
+	})
+
+	t.Run("Invalid client auth", func(t *testing.T) {
+		settings := newSettings()
+		settings.TLS.ClientAuth = "always"
+		assert.ErrorContains(t, settings.Validate(), "client auth")
+	})
+
+	t.Run("Invalid reload interval", func(t *testing.T) {
+		settings := newSettings()
+		settings.TLS.ReloadInterval = 0
+		assert.ErrorContains(t, settings.Validate(), "reload interval")
+	})
+}
+
+func TestValidateAuth(t *testing.T) {
+	dir := t.TempDir()
+	tokensFile := path.Join(dir, "tokens.yaml")
+	os.WriteFile(tokensFile, []byte("tokens:\n  - {principal: alice, token: secret}\n"), 0600)
+	aclFile := path.Join(dir, "acl.yaml")
+	os.WriteFile(aclFile, []byte("rules:\n  - {principal: alice, prefix: a/, permissions: [read]}\n"), 0600)
+	secretFile := path.Join(dir, "cluster.secret")
+	os.WriteFile(secretFile, []byte("0123456789abcdef"), 0600)
+
+	newSettings := func() *Settings {
+		settings := DefaultSettings()
+		settings.Server.AdvertiseAddress = "localhost:8080"
+		return settings
+	}
+
+	t.Run("Disabled by default", func(t *testing.T) {
+		settings := newSettings()
+		assert.False(t, settings.Auth.Enabled())
+		assert.NoError(t, settings.Validate())
+	})
+
+	t.Run("Token mode", func(t *testing.T) {
+		settings := newSettings()
+		settings.Auth.Mode = "token"
+		settings.Auth.TokensFile = tokensFile
+		settings.Auth.ACLFile = aclFile
+		settings.Internal.Auth = "secret"
+		settings.Internal.SecretFile = secretFile
+		settings.Internal.PlaintextSecret = true
+		assert.NoError(t, settings.Validate())
+	})
+
+	t.Run("Token mode without node authentication", func(t *testing.T) {
+		settings := newSettings()
+		settings.Auth.Mode = "token"
+		settings.Auth.TokensFile = tokensFile
+		assert.ErrorContains(t, settings.Validate(), "anyone could call ClusterService")
+		settings.Internal.Unauthenticated = true
+		assert.NoError(t, settings.Validate())
+	})
+
+	t.Run("Token mode without tokens file", func(t *testing.T) {
+		settings := newSettings()
+		settings.Auth.Mode = "token"
+		assert.ErrorContains(t, settings.Validate(), "tokens file is required")
+	})
+
+	t.Run("JWT mode without key file", func(t *testing.T) {
+		settings := newSettings()
+		settings.Auth.Mode = "jwt"
+		assert.ErrorContains(t, settings.Validate(), "JWT key file is required")
+	})
+
+	t.Run("ACL without authentication", func(t *testing.T) {
+		settings := newSettings()
+		settings.Auth.ACLFile = aclFile
+		assert.ErrorContains(t, settings.Validate(), "requires the token or jwt mode")
+	})
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		settings := newSettings()
+		settings.Auth.Mode = "basic"
+		assert.ErrorContains(t, settings.Validate(), "mode must be none, token or jwt")
+	})
+}
+
+func TestValidateInternal(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := path.Join(dir, "cluster.secret")
+	os.WriteFile(secretFile, []byte("0123456789abcdef"), 0600)
+	ca := securitytest.NewCA(t, dir, "ca")
+	files := ca.Issue(t, dir, "node")
+
+	newSettings := func() *Settings {
+		settings := DefaultSettings()
+		settings.Server.AdvertiseAddress = "localhost:8080"
+		return settings
+	}
+
+	t.Run("Separate port", func(t *testing.T) {
+		settings := newSettings()
+		settings.Internal.AdvertiseAddress = "localhost:9080"
+		assert.True(t, settings.Internal.Separate())
+		assert.Equal(t, ":9080", settings.Internal.Listen())
+		assert.NoError(t, settings.Validate())
+	})
+
+	t.Run("Same port as the server", func(t *testing.T) {
+		settings := newSettings()
+		settings.Internal.AdvertiseAddress = "127.0.0.1:8080"
+		assert.ErrorContains(t, settings.Validate(), "internal port 8080 must differ")
+	})
+
+	t.Run("Listen address without advertise address", func(t *testing.T) {
+		settings := newSettings()
+		settings.Internal.ListenAddress = ":9080"
+		assert.ErrorContains(t, settings.Validate(), "advertise address is required")
+	})
+
+	t.Run("Secret", func(t *testing.T) {
+		settings := newSettings()
+		settings.Internal.Auth = "secret"
+		assert.ErrorContains(t, settings.Validate(), "secret file is required")
+		settings.Internal.SecretFile = secretFile
+		assert.ErrorContains(t, settings.Validate(), "secret auth requires TLS")
+		settings.Internal.PlaintextSecret = true
+		assert.NoError(t, settings.Validate())
+	})
+
+	t.Run("Secret with TLS", func(t *testing.T) {
+		settings := newSettings()
+		settings.TLS.CertFile, settings.TLS.KeyFile, settings.TLS.CAFile = files.CertFile, files.KeyFile, ca.CAFile
+		settings.Internal.Auth = "secret"
+		settings.Internal.SecretFile = secretFile
+		assert.NoError(t, settings.Validate())
+	})
+
+	t.Run("Certificate", func(t *testing.T) {
+		settings := newSettings()
+		settings.TLS.CertFile, settings.TLS.KeyFile, settings.TLS.CAFile = files.CertFile, files.KeyFile, ca.CAFile
+		settings.Internal.Auth = "certificate"
+		assert.ErrorContains(t, settings.Validate(), "at least one node name is required")
+		settings.Internal.NodeNames = "node*.keyforge.internal, localhost"
+		assert.NoError(t, settings.Validate())
+	})
+
+	t.Run("Certificate without TLS", func(t *testing.T) {
+		settings := newSettings()
+		settings.Internal.Auth = "certificate"
+		settings.Internal.NodeNames = "localhost"
+		assert.ErrorContains(t, settings.Validate(), "requires TLS")
+	})
+
+	t.Run("Invalid auth", func(t *testing.T) {
+		settings := newSettings()
+		settings.Internal.Auth = "token"
+		assert.ErrorContains(t, settings.Validate(), "auth must be none, secret or certificate")
+	})
+}
+
+func TestValidateRemoteReplication(t *testing.T) {
+	secretFile := path.Join(t.TempDir(), "remote.secret")
+	os.WriteFile(secretFile, []byte("0123456789abcdef"), 0600)
+	settings := DefaultSettings()
+	settings.Server.AdvertiseAddress = "localhost:8080"
+	assert.False(t, settings.Replication.Remote.Enabled())
+
+	settings.Replication.Remote.Addresses = "standby-1:9080, standby-2:9080,"
+	settings.Replication.Remote.SecretFile = secretFile
+	assert.True(t, settings.Replication.Remote.Enabled())
+	assert.Equal(t, []string{"standby-1:9080", "standby-2:9080"}, settings.Replication.Remote.AddressList())
+	assert.ErrorContains(t, settings.Validate(), "remote secret requires TLS")
+	settings.Internal.PlaintextSecret = true
+	assert.NoError(t, settings.Validate())
+
+	settings.Replication.Remote.Addresses = "standby-1"
+	assert.ErrorContains(t, settings.Validate(), `remote address "standby-1" is invalid`)
+
+	settings.Replication.Remote.Addresses = "standby-1:9080"
+	settings.Replication.Remote.SecretFile = path.Join(t.TempDir(), "missing")
+	settings.Replication.Remote.BatchSize = 0
+	err := settings.Validate()
+	assert.ErrorContains(t, err, "failed to read cluster secret file")
+	assert.ErrorContains(t, err, "remote batch size must be positive")
+}
+
+func TestValidateMetrics(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Server.AdvertiseAddress = "localhost:8080"
+	settings.Internal.AdvertiseAddress = "localhost:9080"
+
+	settings.Metrics.ListenAddress = ":9090"
+	assert.NoError(t, settings.Validate())
+
+	settings.Metrics.ListenAddress = "9090"
+	assert.ErrorContains(t, settings.Validate(), "invalid metrics settings")
+
+	settings.Metrics.ListenAddress = ":8080"
+	assert.ErrorContains(t, settings.Validate(), "port 8080 is already used")
+
+	settings.Metrics.ListenAddress = ":9080"
+	assert.ErrorContains(t, settings.Validate(), "port 9080 is already used")
+
+	// Ports are compared with the ports the servers listen on, which can differ from the advertised ones
+	settings.Server.ListenAddress = ":7070"
+	settings.Metrics.ListenAddress = ":8080"
+	assert.NoError(t, settings.Validate())
+	settings.Metrics.ListenAddress = ":7070"
+	assert.ErrorContains(t, settings.Validate(), "port 7070 is already used")
+}
+
+func TestValidateTracing(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Server.AdvertiseAddress = "localhost:8080"
+	assert.False(t, settings.Tracing.Enabled())
+
+	settings.Tracing.Exporter = "otlp"
+	assert.True(t, settings.Tracing.Enabled())
+	assert.NoError(t, settings.Validate())
+
+	settings.Tracing.Endpoint = ""
+	assert.ErrorContains(t, settings.Validate(), "endpoint is required")
+
+	settings.Tracing.Exporter = "stdout"
+	settings.Tracing.SampleRatio = 1.5
+	assert.ErrorContains(t, settings.Validate(), "sample ratio must be between 0 and 1")
+
+	settings.Tracing.Exporter = "zipkin"
+	settings.Tracing.SampleRatio = 0.5
+	assert.ErrorContains(t, settings.Validate(), "exporter must be none, otlp or stdout")
+}
+
+func TestValidateGateway(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Server.AdvertiseAddress = "localhost:8080"
+	settings.Metrics.ListenAddress = ":9090"
+
+	settings.Gateway.ListenAddress = ":8000"
+	assert.NoError(t, settings.Validate())
+
+	settings.Gateway.ListenAddress = ":8080"
+	assert.ErrorContains(t, settings.Validate(), "port 8080 is already used by a gRPC server")
+
+	settings.Gateway.ListenAddress = ":9090"
+	assert.ErrorContains(t, settings.Validate(), "port 9090 is already used by the metrics server")
+}
+
+func TestValidateRedis(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Server.AdvertiseAddress = "localhost:8080"
+	settings.Metrics.ListenAddress = ":9090"
+	settings.Gateway.ListenAddress = ":8000"
+
+	settings.Redis.ListenAddress = ":6379"
+	assert.NoError(t, settings.Validate())
+
+	settings.Redis.ListenAddress = ":8080"
+	assert.ErrorContains(t, settings.Validate(), "port 8080 is already used by a gRPC server")
+
+	settings.Redis.ListenAddress = ":8000"
+	assert.ErrorContains(t, settings.Validate(), "port 8000 is already used by the gateway")
+
+	settings.Redis.ListenAddress = "6379"
+	assert.ErrorContains(t, settings.Validate(), "invalid redis settings")
+}
+
+func TestValidateMemcached(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Server.AdvertiseAddress = "localhost:8080"
+	settings.Redis.ListenAddress = ":6379"
+
+	settings.Memcached.ListenAddress = ":11211"
+	assert.NoError(t, settings.Validate())
+
+	settings.Memcached.ListenAddress = ":8080"
+	assert.ErrorContains(t, settings.Validate(), "port 8080 is already used by a gRPC server")
+
+	settings.Memcached.ListenAddress = ":6379"
+	assert.ErrorContains(t, settings.Validate(), "port 6379 is already used by the Redis listener")
+
+	settings.Metrics.ListenAddress = ":9090"
+	settings.Memcached.ListenAddress = ":9090"
+	assert.ErrorContains(t, settings.Validate(), "invalid memcached settings: port 9090 is already used by the metrics server")
+
+	settings.Memcached.ListenAddress = "11211"
+	assert.ErrorContains(t, settings.Validate(), "invalid memcached settings")
+}
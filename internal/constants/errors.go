@@ -6,8 +6,19 @@ import (
 )
 
 var (
-	StatusErrInvalidKey   = status.Errorf(codes.InvalidArgument, "Key is invalid")
-	StatusErrInvalidValue = status.Errorf(codes.InvalidArgument, "Value is invalid")
-	StatusErrKeyNotFound  = status.Errorf(codes.NotFound, "Key not found")
-	StatusErrInternal     = status.Errorf(codes.Internal, "Some internal error occurred while processing your request")
+	StatusErrInvalidKey      = status.Errorf(codes.InvalidArgument, "Key is invalid")
+	StatusErrInvalidValue    = status.Errorf(codes.InvalidArgument, "Value is invalid")
+	StatusErrKeyNotFound     = status.Errorf(codes.NotFound, "Key not found")
+	StatusErrInvalidLimit    = status.Errorf(codes.InvalidArgument, "Limit is invalid")
+	StatusErrInvalidTTL      = status.Errorf(codes.InvalidArgument, "TTL is invalid")
+	StatusErrConditionFailed = status.Errorf(codes.FailedPrecondition, "Condition of the write is not met")
+	StatusErrNotNumber       = status.Errorf(codes.FailedPrecondition, "Value is not a number")
+	StatusErrInternal        = status.Errorf(codes.Internal, "Some internal error occurred while processing your request")
+	StatusErrNotReady        = status.Errorf(codes.Unavailable, "Node has not joined the cluster yet")
+	StatusErrNotWritable     = status.Errorf(codes.Unavailable, "Storage does not accept writes")
+	StatusErrNodeNotFound    = status.Errorf(codes.NotFound, "Node is not a member of the cluster")
+	StatusErrOwnerLeft       = status.Errorf(codes.Unavailable, "Node responsible for the key left the cluster")
+
+	StatusErrUnauthenticated  = status.Errorf(codes.Unauthenticated, "Missing or invalid credentials")
+	StatusErrPermissionDenied = status.Errorf(codes.PermissionDenied, "Permission denied")
 )
@@ -0,0 +1,124 @@
+// Package dump reads and writes the portable files keys of a cluster are exported to. A file is a
+// sequence of KeyEntry records, either as JSON lines or as varint length-prefixed protobuf.
+package dump
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Formats of dump files
+const (
+	JSONLines = "jsonl" // JSONLines writes every entry as a line of JSON, with values encoded in base64
+	Protobuf  = "proto" // Protobuf writes every entry as protobuf prefixed by its size as varint
+)
+
+// Formats lists the accepted formats
+var Formats = []string{JSONLines, Protobuf}
+
+var jsonMarshaler = protojson.MarshalOptions{UseProtoNames: true}
+
+// FormatOf returns the format of a file by its extension: Protobuf for .pb and .bin files,
+// JSONLines for any other
+func FormatOf(path string) string {
+	if strings.HasSuffix(path, ".pb") || strings.HasSuffix(path, ".bin") {
+		return Protobuf
+	}
+	return JSONLines
+}
+
+// checkFormat returns an error if format is not one of Formats
+func checkFormat(format string) error {
+	if format != JSONLines && format != Protobuf {
+		return fmt.Errorf("invalid dump format %q, accepted formats: %s", format, strings.Join(Formats, ", "))
+	}
+	return nil
+}
+
+// Writer writes entries to a dump file
+type Writer struct {
+	w      *bufio.Writer
+	format string
+}
+
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	if err := checkFormat(format); err != nil {
+		return nil, err
+	}
+	return &Writer{w: bufio.NewWriter(w), format: format}, nil
+}
+
+// Write appends an entry
+func (w *Writer) Write(entry *proto.KeyEntry) error {
+	if w.format == Protobuf {
+		_, err := protodelim.MarshalTo(w.w, entry)
+		return err
+	}
+	data, err := jsonMarshaler.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+	return w.w.WriteByte('\n')
+}
+
+// Flush writes buffered entries to the underlying writer
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Reader reads entries from a dump file
+type Reader struct {
+	r      *bufio.Reader
+	format string
+	line   int
+}
+
+func NewReader(r io.Reader, format string) (*Reader, error) {
+	if err := checkFormat(format); err != nil {
+		return nil, err
+	}
+	return &Reader{r: bufio.NewReader(r), format: format}, nil
+}
+
+// Read returns the next entry, or io.EOF after the last one
+func (r *Reader) Read() (*proto.KeyEntry, error) {
+	var entry proto.KeyEntry
+	if r.format == Protobuf {
+		// Entries are as large as a gRPC message at most, which is the default limit
+		if err := protodelim.UnmarshalFrom(r.r, &entry); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, errors.New("dump file ends in the middle of an entry")
+			}
+			return nil, err
+		}
+		return &entry, nil
+	}
+
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.line++
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		if err := protojson.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("invalid entry on line %d: %w", r.line, err)
+		}
+		return &entry, nil
+	}
+}
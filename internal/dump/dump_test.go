@@ -0,0 +1,102 @@
+package dump
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tdevsin/keyforge/internal/proto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+var entries = []*proto.KeyEntry{
+	{Key: "users/1", Value: []byte("alice"), Version: 42},
+	{Key: "users/2", Value: []byte{0, 0xff}, ExpiresAt: 1700000000000, Flags: 3},
+	{Key: "empty"},
+}
+
+// readAll returns every entry of a dump
+func readAll(t *testing.T, r *Reader) []*proto.KeyEntry {
+	var read []*proto.KeyEntry
+	for {
+		entry, err := r.Read()
+		if err == io.EOF {
+			return read
+		}
+		require.NoError(t, err)
+		read = append(read, entry)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range Formats {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, format)
+			require.NoError(t, err)
+			for _, entry := range entries {
+				assert.NoError(t, w.Write(entry))
+			}
+			assert.NoError(t, w.Flush())
+
+			r, err := NewReader(&buf, format)
+			require.NoError(t, err)
+			read := readAll(t, r)
+
+			assert.Len(t, read, len(entries))
+			for i := range entries {
+				assert.True(t, protobuf.Equal(entries[i], read[i]), "entry %d", i)
+			}
+		})
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, JSONLines)
+	assert.NoError(t, w.Write(entries[0]))
+	assert.NoError(t, w.Flush())
+	assert.JSONEq(t, `{"key":"users/1","value":"YWxpY2U=","version":"42"}`, buf.String())
+	assert.True(t, strings.HasSuffix(buf.String(), "\n"))
+
+	t.Run("Blank lines and a missing final newline are accepted", func(t *testing.T) {
+		r, _ := NewReader(strings.NewReader("\n{\"key\":\"a\"}\n\n{\"key\":\"b\"}"), JSONLines)
+		read := readAll(t, r)
+		assert.Len(t, read, 2)
+		assert.Equal(t, "b", read[1].Key)
+	})
+
+	t.Run("Invalid lines are reported with their number", func(t *testing.T) {
+		r, _ := NewReader(strings.NewReader("{\"key\":\"a\"}\nnot json\n"), JSONLines)
+		_, err := r.Read()
+		assert.NoError(t, err)
+		_, err = r.Read()
+		assert.ErrorContains(t, err, "invalid entry on line 2")
+	})
+}
+
+func TestTruncatedProtobuf(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, Protobuf)
+	assert.NoError(t, w.Write(entries[0]))
+	assert.NoError(t, w.Flush())
+
+	r, _ := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-2]), Protobuf)
+	_, err := r.Read()
+
+	assert.ErrorContains(t, err, "ends in the middle of an entry")
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, Protobuf, FormatOf("keys.pb"))
+	assert.Equal(t, JSONLines, FormatOf("keys.jsonl"))
+	assert.Equal(t, JSONLines, FormatOf("-"))
+
+	_, err := NewWriter(io.Discard, "csv")
+	assert.ErrorContains(t, err, `invalid dump format "csv"`)
+	_, err = NewReader(strings.NewReader(""), "csv")
+	assert.Error(t, err)
+}
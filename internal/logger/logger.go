@@ -19,24 +19,49 @@ type Logger struct {
 	Logger *zap.Logger
 }
 
+// Options controls the level and encoding of log messages
+type Options struct {
+	Production bool   // Production logs JSON at info level, otherwise human readable logs at debug level are used
+	Level      string // Level overrides the minimum level of logged messages, for example debug, info, warn or error
+	Format     string // Format overrides the encoding of log messages. Accepted values: json, console
+}
+
 // getLogger returns logger instance.
 func GetLogger(isProd bool, nodeId string) *Logger {
-	var logger Logger
-	var err error
-	var baseLogger *zap.Logger
-	if isProd {
-		config := zap.NewProductionConfig()
+	logger, err := NewLogger(Options{Production: isProd}, nodeId)
+	if err != nil {
+		return &Logger{Logger: zap.NewNop()} // Fallback to no-op logger
+	}
+	return logger
+}
+
+// NewLogger returns a logger instance built from the given options.
+func NewLogger(opts Options, nodeId string) (*Logger, error) {
+	var config zap.Config
+	if opts.Production {
+		config = zap.NewProductionConfig()
 		config.EncoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout("2006-01-02 15:04:05.000 MST")
-		baseLogger, err = config.Build()
 	} else {
-		baseLogger, err = zap.NewDevelopment()
+		config = zap.NewDevelopmentConfig()
+	}
+	if opts.Level != "" {
+		level, err := zap.ParseAtomicLevel(opts.Level)
+		if err != nil {
+			return nil, err
+		}
+		config.Level = level
 	}
+	if opts.Format != "" {
+		config.Encoding = opts.Format
+	}
+
+	baseLogger, err := config.Build()
 	if err != nil {
-		logger.Logger = zap.NewNop() // Fallback to no-op logger
-		return &logger
+		return nil, err
 	}
-	logger.Logger = baseLogger.WithOptions(zap.AddCaller(), zap.AddCallerSkip(1)).With(zap.String("nodeId", nodeId))
-	return &logger
+	return &Logger{
+		Logger: baseLogger.WithOptions(zap.AddCaller(), zap.AddCallerSkip(1)).With(zap.String("nodeId", nodeId)),
+	}, nil
 }
 
 // Info logs a message at the info level.
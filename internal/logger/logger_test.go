@@ -121,3 +121,33 @@ func TestWarn(t *testing.T) {
 		t.Errorf("Expected field 'warning' to be 'disk_space_low', got '%v'", loggedData["warning"])
 	}
 }
+
+// TestNewLogger tests building a logger from options.
+func TestNewLogger(t *testing.T) {
+	t.Run("Applies level", func(t *testing.T) {
+		logger, err := NewLogger(Options{Production: true, Level: "warn"}, "test")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if logger.Logger.Core().Enabled(zap.InfoLevel) {
+			t.Errorf("Expected info level to be disabled")
+		}
+		if !logger.Logger.Core().Enabled(zap.WarnLevel) {
+			t.Errorf("Expected warn level to be enabled")
+		}
+	})
+
+	t.Run("Rejects invalid level", func(t *testing.T) {
+		_, err := NewLogger(Options{Level: "loud"}, "test")
+		if err == nil {
+			t.Errorf("Expected error for invalid level")
+		}
+	})
+
+	t.Run("Rejects invalid format", func(t *testing.T) {
+		_, err := NewLogger(Options{Format: "xml"}, "test")
+		if err == nil {
+			t.Errorf("Expected error for invalid format")
+		}
+	})
+}
@@ -20,8 +20,20 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	ClusterService_GetClusterState_FullMethodName = "/ClusterService/GetClusterState"
-	ClusterService_SetClusterState_FullMethodName = "/ClusterService/SetClusterState"
+	ClusterService_GetClusterState_FullMethodName     = "/ClusterService/GetClusterState"
+	ClusterService_SetClusterState_FullMethodName     = "/ClusterService/SetClusterState"
+	ClusterService_ExchangeDigest_FullMethodName      = "/ClusterService/ExchangeDigest"
+	ClusterService_PushDelta_FullMethodName           = "/ClusterService/PushDelta"
+	ClusterService_GetClusterTiming_FullMethodName    = "/ClusterService/GetClusterTiming"
+	ClusterService_UpdateClusterTiming_FullMethodName = "/ClusterService/UpdateClusterTiming"
+	ClusterService_GetRing_FullMethodName             = "/ClusterService/GetRing"
+	ClusterService_RemoveNode_FullMethodName          = "/ClusterService/RemoveNode"
+	ClusterService_DecommissionNode_FullMethodName    = "/ClusterService/DecommissionNode"
+	ClusterService_Backup_FullMethodName              = "/ClusterService/Backup"
+	ClusterService_ExportKeys_FullMethodName          = "/ClusterService/ExportKeys"
+	ClusterService_ImportKeys_FullMethodName          = "/ClusterService/ImportKeys"
+	ClusterService_LoadTables_FullMethodName          = "/ClusterService/LoadTables"
+	ClusterService_ApplyChanges_FullMethodName        = "/ClusterService/ApplyChanges"
 )
 
 // ClusterServiceClient is the client API for ClusterService service.
@@ -30,6 +42,22 @@ const (
 type ClusterServiceClient interface {
 	GetClusterState(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ClusterState, error)
 	SetClusterState(ctx context.Context, in *ClusterState, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ExchangeDigest(ctx context.Context, in *GossipDigest, opts ...grpc.CallOption) (*GossipDigestAck, error)
+	PushDelta(ctx context.Context, in *GossipDelta, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	GetClusterTiming(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ClusterTiming, error)
+	UpdateClusterTiming(ctx context.Context, in *ClusterTiming, opts ...grpc.CallOption) (*ClusterTiming, error)
+	// Admin RPCs used by the keyforge cluster command
+	GetRing(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Ring, error)
+	RemoveNode(ctx context.Context, in *RemoveNodeRequest, opts ...grpc.CallOption) (*Node, error)
+	DecommissionNode(ctx context.Context, in *DecommissionNodeRequest, opts ...grpc.CallOption) (*Node, error)
+	Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BackupChunk], error)
+	// Streams the keys this node owns from a snapshot of its database
+	ExportKeys(ctx context.Context, in *ExportKeysRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[KeyEntryBatch], error)
+	ImportKeys(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[KeyEntryBatch, ImportKeysResponse], error)
+	// Ingests SSTables of keys this node owns, bypassing the write path
+	LoadTables(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[TableChunk, LoadTablesResponse], error)
+	// Writes changes replicated from another cluster, forwarding them to the nodes owning their keys
+	ApplyChanges(ctx context.Context, in *ApplyChangesRequest, opts ...grpc.CallOption) (*ApplyChangesResponse, error)
 }
 
 type clusterServiceClient struct {
@@ -60,12 +88,172 @@ func (c *clusterServiceClient) SetClusterState(ctx context.Context, in *ClusterS
 	return out, nil
 }
 
+func (c *clusterServiceClient) ExchangeDigest(ctx context.Context, in *GossipDigest, opts ...grpc.CallOption) (*GossipDigestAck, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GossipDigestAck)
+	err := c.cc.Invoke(ctx, ClusterService_ExchangeDigest_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterServiceClient) PushDelta(ctx context.Context, in *GossipDelta, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, ClusterService_PushDelta_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterServiceClient) GetClusterTiming(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ClusterTiming, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClusterTiming)
+	err := c.cc.Invoke(ctx, ClusterService_GetClusterTiming_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterServiceClient) UpdateClusterTiming(ctx context.Context, in *ClusterTiming, opts ...grpc.CallOption) (*ClusterTiming, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClusterTiming)
+	err := c.cc.Invoke(ctx, ClusterService_UpdateClusterTiming_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterServiceClient) GetRing(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Ring, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ring)
+	err := c.cc.Invoke(ctx, ClusterService_GetRing_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterServiceClient) RemoveNode(ctx context.Context, in *RemoveNodeRequest, opts ...grpc.CallOption) (*Node, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Node)
+	err := c.cc.Invoke(ctx, ClusterService_RemoveNode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterServiceClient) DecommissionNode(ctx context.Context, in *DecommissionNodeRequest, opts ...grpc.CallOption) (*Node, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Node)
+	err := c.cc.Invoke(ctx, ClusterService_DecommissionNode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterServiceClient) Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BackupChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ClusterService_ServiceDesc.Streams[0], ClusterService_Backup_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BackupRequest, BackupChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClusterService_BackupClient = grpc.ServerStreamingClient[BackupChunk]
+
+func (c *clusterServiceClient) ExportKeys(ctx context.Context, in *ExportKeysRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[KeyEntryBatch], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ClusterService_ServiceDesc.Streams[1], ClusterService_ExportKeys_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExportKeysRequest, KeyEntryBatch]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClusterService_ExportKeysClient = grpc.ServerStreamingClient[KeyEntryBatch]
+
+func (c *clusterServiceClient) ImportKeys(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[KeyEntryBatch, ImportKeysResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ClusterService_ServiceDesc.Streams[2], ClusterService_ImportKeys_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[KeyEntryBatch, ImportKeysResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClusterService_ImportKeysClient = grpc.ClientStreamingClient[KeyEntryBatch, ImportKeysResponse]
+
+func (c *clusterServiceClient) LoadTables(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[TableChunk, LoadTablesResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ClusterService_ServiceDesc.Streams[3], ClusterService_LoadTables_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TableChunk, LoadTablesResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClusterService_LoadTablesClient = grpc.ClientStreamingClient[TableChunk, LoadTablesResponse]
+
+func (c *clusterServiceClient) ApplyChanges(ctx context.Context, in *ApplyChangesRequest, opts ...grpc.CallOption) (*ApplyChangesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ApplyChangesResponse)
+	err := c.cc.Invoke(ctx, ClusterService_ApplyChanges_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ClusterServiceServer is the server API for ClusterService service.
 // All implementations must embed UnimplementedClusterServiceServer
 // for forward compatibility.
 type ClusterServiceServer interface {
 	GetClusterState(context.Context, *emptypb.Empty) (*ClusterState, error)
 	SetClusterState(context.Context, *ClusterState) (*emptypb.Empty, error)
+	ExchangeDigest(context.Context, *GossipDigest) (*GossipDigestAck, error)
+	PushDelta(context.Context, *GossipDelta) (*emptypb.Empty, error)
+	GetClusterTiming(context.Context, *emptypb.Empty) (*ClusterTiming, error)
+	UpdateClusterTiming(context.Context, *ClusterTiming) (*ClusterTiming, error)
+	// Admin RPCs used by the keyforge cluster command
+	GetRing(context.Context, *emptypb.Empty) (*Ring, error)
+	RemoveNode(context.Context, *RemoveNodeRequest) (*Node, error)
+	DecommissionNode(context.Context, *DecommissionNodeRequest) (*Node, error)
+	Backup(*BackupRequest, grpc.ServerStreamingServer[BackupChunk]) error
+	// Streams the keys this node owns from a snapshot of its database
+	ExportKeys(*ExportKeysRequest, grpc.ServerStreamingServer[KeyEntryBatch]) error
+	ImportKeys(grpc.ClientStreamingServer[KeyEntryBatch, ImportKeysResponse]) error
+	// Ingests SSTables of keys this node owns, bypassing the write path
+	LoadTables(grpc.ClientStreamingServer[TableChunk, LoadTablesResponse]) error
+	// Writes changes replicated from another cluster, forwarding them to the nodes owning their keys
+	ApplyChanges(context.Context, *ApplyChangesRequest) (*ApplyChangesResponse, error)
 	mustEmbedUnimplementedClusterServiceServer()
 }
 
@@ -82,6 +270,42 @@ func (UnimplementedClusterServiceServer) GetClusterState(context.Context, *empty
 func (UnimplementedClusterServiceServer) SetClusterState(context.Context, *ClusterState) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetClusterState not implemented")
 }
+func (UnimplementedClusterServiceServer) ExchangeDigest(context.Context, *GossipDigest) (*GossipDigestAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExchangeDigest not implemented")
+}
+func (UnimplementedClusterServiceServer) PushDelta(context.Context, *GossipDelta) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PushDelta not implemented")
+}
+func (UnimplementedClusterServiceServer) GetClusterTiming(context.Context, *emptypb.Empty) (*ClusterTiming, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetClusterTiming not implemented")
+}
+func (UnimplementedClusterServiceServer) UpdateClusterTiming(context.Context, *ClusterTiming) (*ClusterTiming, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateClusterTiming not implemented")
+}
+func (UnimplementedClusterServiceServer) GetRing(context.Context, *emptypb.Empty) (*Ring, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRing not implemented")
+}
+func (UnimplementedClusterServiceServer) RemoveNode(context.Context, *RemoveNodeRequest) (*Node, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveNode not implemented")
+}
+func (UnimplementedClusterServiceServer) DecommissionNode(context.Context, *DecommissionNodeRequest) (*Node, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DecommissionNode not implemented")
+}
+func (UnimplementedClusterServiceServer) Backup(*BackupRequest, grpc.ServerStreamingServer[BackupChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method Backup not implemented")
+}
+func (UnimplementedClusterServiceServer) ExportKeys(*ExportKeysRequest, grpc.ServerStreamingServer[KeyEntryBatch]) error {
+	return status.Errorf(codes.Unimplemented, "method ExportKeys not implemented")
+}
+func (UnimplementedClusterServiceServer) ImportKeys(grpc.ClientStreamingServer[KeyEntryBatch, ImportKeysResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method ImportKeys not implemented")
+}
+func (UnimplementedClusterServiceServer) LoadTables(grpc.ClientStreamingServer[TableChunk, LoadTablesResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method LoadTables not implemented")
+}
+func (UnimplementedClusterServiceServer) ApplyChanges(context.Context, *ApplyChangesRequest) (*ApplyChangesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ApplyChanges not implemented")
+}
 func (UnimplementedClusterServiceServer) mustEmbedUnimplementedClusterServiceServer() {}
 func (UnimplementedClusterServiceServer) testEmbeddedByValue()                        {}
 
@@ -139,6 +363,186 @@ func _ClusterService_SetClusterState_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ClusterService_ExchangeDigest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GossipDigest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServiceServer).ExchangeDigest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterService_ExchangeDigest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServiceServer).ExchangeDigest(ctx, req.(*GossipDigest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterService_PushDelta_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GossipDelta)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServiceServer).PushDelta(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterService_PushDelta_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServiceServer).PushDelta(ctx, req.(*GossipDelta))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterService_GetClusterTiming_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServiceServer).GetClusterTiming(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterService_GetClusterTiming_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServiceServer).GetClusterTiming(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterService_UpdateClusterTiming_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClusterTiming)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServiceServer).UpdateClusterTiming(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterService_UpdateClusterTiming_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServiceServer).UpdateClusterTiming(ctx, req.(*ClusterTiming))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterService_GetRing_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServiceServer).GetRing(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterService_GetRing_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServiceServer).GetRing(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterService_RemoveNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServiceServer).RemoveNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterService_RemoveNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServiceServer).RemoveNode(ctx, req.(*RemoveNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterService_DecommissionNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecommissionNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServiceServer).DecommissionNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterService_DecommissionNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServiceServer).DecommissionNode(ctx, req.(*DecommissionNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterService_Backup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BackupRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ClusterServiceServer).Backup(m, &grpc.GenericServerStream[BackupRequest, BackupChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClusterService_BackupServer = grpc.ServerStreamingServer[BackupChunk]
+
+func _ClusterService_ExportKeys_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportKeysRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ClusterServiceServer).ExportKeys(m, &grpc.GenericServerStream[ExportKeysRequest, KeyEntryBatch]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClusterService_ExportKeysServer = grpc.ServerStreamingServer[KeyEntryBatch]
+
+func _ClusterService_ImportKeys_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ClusterServiceServer).ImportKeys(&grpc.GenericServerStream[KeyEntryBatch, ImportKeysResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClusterService_ImportKeysServer = grpc.ClientStreamingServer[KeyEntryBatch, ImportKeysResponse]
+
+func _ClusterService_LoadTables_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ClusterServiceServer).LoadTables(&grpc.GenericServerStream[TableChunk, LoadTablesResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClusterService_LoadTablesServer = grpc.ClientStreamingServer[TableChunk, LoadTablesResponse]
+
+func _ClusterService_ApplyChanges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApplyChangesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServiceServer).ApplyChanges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterService_ApplyChanges_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServiceServer).ApplyChanges(ctx, req.(*ApplyChangesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ClusterService_ServiceDesc is the grpc.ServiceDesc for ClusterService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -154,7 +558,60 @@ var ClusterService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SetClusterState",
 			Handler:    _ClusterService_SetClusterState_Handler,
 		},
+		{
+			MethodName: "ExchangeDigest",
+			Handler:    _ClusterService_ExchangeDigest_Handler,
+		},
+		{
+			MethodName: "PushDelta",
+			Handler:    _ClusterService_PushDelta_Handler,
+		},
+		{
+			MethodName: "GetClusterTiming",
+			Handler:    _ClusterService_GetClusterTiming_Handler,
+		},
+		{
+			MethodName: "UpdateClusterTiming",
+			Handler:    _ClusterService_UpdateClusterTiming_Handler,
+		},
+		{
+			MethodName: "GetRing",
+			Handler:    _ClusterService_GetRing_Handler,
+		},
+		{
+			MethodName: "RemoveNode",
+			Handler:    _ClusterService_RemoveNode_Handler,
+		},
+		{
+			MethodName: "DecommissionNode",
+			Handler:    _ClusterService_DecommissionNode_Handler,
+		},
+		{
+			MethodName: "ApplyChanges",
+			Handler:    _ClusterService_ApplyChanges_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Backup",
+			Handler:       _ClusterService_Backup_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ExportKeys",
+			Handler:       _ClusterService_ExportKeys_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ImportKeys",
+			Handler:       _ClusterService_ImportKeys_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "LoadTables",
+			Handler:       _ClusterService_LoadTables_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "cluster.proto",
 }
@@ -19,9 +19,12 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	KeyService_GetKey_FullMethodName    = "/KeyService/GetKey"
-	KeyService_SetKey_FullMethodName    = "/KeyService/SetKey"
-	KeyService_DeleteKey_FullMethodName = "/KeyService/DeleteKey"
+	KeyService_GetKey_FullMethodName       = "/KeyService/GetKey"
+	KeyService_SetKey_FullMethodName       = "/KeyService/SetKey"
+	KeyService_DeleteKey_FullMethodName    = "/KeyService/DeleteKey"
+	KeyService_ScanKeys_FullMethodName     = "/KeyService/ScanKeys"
+	KeyService_IncrementKey_FullMethodName = "/KeyService/IncrementKey"
+	KeyService_TouchKey_FullMethodName     = "/KeyService/TouchKey"
 )
 
 // KeyServiceClient is the client API for KeyService service.
@@ -31,6 +34,9 @@ type KeyServiceClient interface {
 	GetKey(ctx context.Context, in *GetKeyRequest, opts ...grpc.CallOption) (*GetKeyResponse, error)
 	SetKey(ctx context.Context, in *SetKeyRequest, opts ...grpc.CallOption) (*SetKeyResponse, error)
 	DeleteKey(ctx context.Context, in *DeleteKeyRequest, opts ...grpc.CallOption) (*DeleteKeyResponse, error)
+	ScanKeys(ctx context.Context, in *ScanKeysRequest, opts ...grpc.CallOption) (*ScanKeysResponse, error)
+	IncrementKey(ctx context.Context, in *IncrementKeyRequest, opts ...grpc.CallOption) (*IncrementKeyResponse, error)
+	TouchKey(ctx context.Context, in *TouchKeyRequest, opts ...grpc.CallOption) (*TouchKeyResponse, error)
 }
 
 type keyServiceClient struct {
@@ -71,6 +77,36 @@ func (c *keyServiceClient) DeleteKey(ctx context.Context, in *DeleteKeyRequest,
 	return out, nil
 }
 
+func (c *keyServiceClient) ScanKeys(ctx context.Context, in *ScanKeysRequest, opts ...grpc.CallOption) (*ScanKeysResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ScanKeysResponse)
+	err := c.cc.Invoke(ctx, KeyService_ScanKeys_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyServiceClient) IncrementKey(ctx context.Context, in *IncrementKeyRequest, opts ...grpc.CallOption) (*IncrementKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IncrementKeyResponse)
+	err := c.cc.Invoke(ctx, KeyService_IncrementKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyServiceClient) TouchKey(ctx context.Context, in *TouchKeyRequest, opts ...grpc.CallOption) (*TouchKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TouchKeyResponse)
+	err := c.cc.Invoke(ctx, KeyService_TouchKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // KeyServiceServer is the server API for KeyService service.
 // All implementations must embed UnimplementedKeyServiceServer
 // for forward compatibility.
@@ -78,6 +114,9 @@ type KeyServiceServer interface {
 	GetKey(context.Context, *GetKeyRequest) (*GetKeyResponse, error)
 	SetKey(context.Context, *SetKeyRequest) (*SetKeyResponse, error)
 	DeleteKey(context.Context, *DeleteKeyRequest) (*DeleteKeyResponse, error)
+	ScanKeys(context.Context, *ScanKeysRequest) (*ScanKeysResponse, error)
+	IncrementKey(context.Context, *IncrementKeyRequest) (*IncrementKeyResponse, error)
+	TouchKey(context.Context, *TouchKeyRequest) (*TouchKeyResponse, error)
 	mustEmbedUnimplementedKeyServiceServer()
 }
 
@@ -97,6 +136,15 @@ func (UnimplementedKeyServiceServer) SetKey(context.Context, *SetKeyRequest) (*S
 func (UnimplementedKeyServiceServer) DeleteKey(context.Context, *DeleteKeyRequest) (*DeleteKeyResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteKey not implemented")
 }
+func (UnimplementedKeyServiceServer) ScanKeys(context.Context, *ScanKeysRequest) (*ScanKeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ScanKeys not implemented")
+}
+func (UnimplementedKeyServiceServer) IncrementKey(context.Context, *IncrementKeyRequest) (*IncrementKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IncrementKey not implemented")
+}
+func (UnimplementedKeyServiceServer) TouchKey(context.Context, *TouchKeyRequest) (*TouchKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TouchKey not implemented")
+}
 func (UnimplementedKeyServiceServer) mustEmbedUnimplementedKeyServiceServer() {}
 func (UnimplementedKeyServiceServer) testEmbeddedByValue()                    {}
 
@@ -172,6 +220,60 @@ func _KeyService_DeleteKey_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KeyService_ScanKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanKeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyServiceServer).ScanKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyService_ScanKeys_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyServiceServer).ScanKeys(ctx, req.(*ScanKeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyService_IncrementKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IncrementKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyServiceServer).IncrementKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyService_IncrementKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyServiceServer).IncrementKey(ctx, req.(*IncrementKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyService_TouchKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TouchKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyServiceServer).TouchKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyService_TouchKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyServiceServer).TouchKey(ctx, req.(*TouchKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // KeyService_ServiceDesc is the grpc.ServiceDesc for KeyService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -191,6 +293,18 @@ var KeyService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteKey",
 			Handler:    _KeyService_DeleteKey_Handler,
 		},
+		{
+			MethodName: "ScanKeys",
+			Handler:    _KeyService_ScanKeys_Handler,
+		},
+		{
+			MethodName: "IncrementKey",
+			Handler:    _KeyService_IncrementKey_Handler,
+		},
+		{
+			MethodName: "TouchKey",
+			Handler:    _KeyService_TouchKey_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "keyforge.proto",
@@ -0,0 +1,29 @@
+package replication
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	shippedChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "keyforge_replication_shipped_changes_total",
+		Help: "Number of changes of this node shipped to the remote cluster.",
+	})
+	shipErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "keyforge_replication_ship_errors_total",
+		Help: "Number of failed attempts to ship changes to the remote cluster.",
+	})
+	lagChanges = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "keyforge_replication_lag_changes",
+		Help: "Number of changes of this node not yet shipped to the remote cluster.",
+	})
+	lagSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "keyforge_replication_lag_seconds",
+		Help: "Age of the oldest change of this node not yet shipped to the remote cluster, zero if every change is shipped.",
+	})
+)
+
+// Collectors returns the metrics of the replication to the remote cluster
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{shippedChanges, shipErrors, lagChanges, lagSeconds}
+}
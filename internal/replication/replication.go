@@ -0,0 +1,178 @@
+// Package replication ships the writes of a node to another cluster. Writes are read from the
+// change log of the database and sent in batches to a node of the remote cluster, which writes
+// them to the nodes owning their keys. The position up to which changes are shipped is stored in
+// the metadata database, so shipping resumes where it stopped when the node restarts.
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"go.uber.org/zap"
+)
+
+// positionKey is the key of the metadata database storing the sequence number of the last shipped change
+const positionKey = "replication_position"
+
+// shipTimeout is the maximum duration of shipping a batch to a remote node
+const shipTimeout = 30 * time.Second
+
+// Shipper ships the change log of a node to a remote cluster
+type Shipper struct {
+	db         storage.Database
+	metadataDb storage.Database
+	pool       *cluster.ConnectionPool
+	addresses  []string
+	origin     string
+	batchSize  int
+	interval   time.Duration
+	logger     logger.Logging
+	// position is the sequence number of the last shipped change
+	position uint64
+	// next is the index of the remote address tried first, the last one that worked
+	next int
+}
+
+// NewShipper returns a shipper of the changes of the node to the configured remote cluster,
+// starting after the last change shipped before
+func NewShipper(c *config.Config) (*Shipper, error) {
+	remote := c.Settings.Replication.Remote
+	s := &Shipper{
+		db:         c.Db,
+		metadataDb: c.MetadataDb,
+		pool:       c.RemotePool,
+		addresses:  remote.AddressList(),
+		origin:     c.NodeInfo.ID,
+		batchSize:  remote.BatchSize,
+		interval:   remote.Interval,
+		logger:     c.Logger,
+	}
+	value, err := c.MetadataDb.ReadKey([]byte(positionKey))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.position, err = strconv.ParseUint(string(value), 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid replication position %q: %w", value, err)
+	}
+	return s, nil
+}
+
+// Run ships changes until ctx is cancelled. Batches are shipped back to back while changes are
+// pending, and every interval once all are shipped or after the remote cluster failed.
+func (s *Shipper) Run(ctx context.Context) {
+	s.logger.Info("Replicating changes to the remote cluster", zap.Strings("addresses", s.addresses), zap.Uint64("position", s.position))
+	for {
+		shipped, err := s.Ship(ctx)
+		if err != nil && ctx.Err() == nil {
+			shipErrors.Inc()
+			s.logger.Warn("Failed to ship changes to the remote cluster", zap.Error(err))
+		}
+		if err == nil && shipped == s.batchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// Ship sends the next batch of changes to the remote cluster and returns how many it shipped.
+// Changes are shipped at least once: if the node stops before it stored its position, they are
+// shipped again, which the remote cluster ignores as stale.
+func (s *Shipper) Ship(ctx context.Context) (int, error) {
+	changes, committed, err := s.db.Changes(s.position, s.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	lagChanges.Set(float64(committed - s.position))
+	if len(changes) == 0 {
+		lagSeconds.Set(0)
+		return 0, nil
+	}
+	lagSeconds.Set(max(time.Since(time.Unix(0, changes[0].Timestamp)).Seconds(), 0))
+
+	req := &proto.ApplyChangesRequest{Origin: s.origin, Changes: make([]*proto.ReplicatedChange, len(changes))}
+	for i, change := range changes {
+		req.Changes[i] = mapChangeToProto(change)
+	}
+	resp, err := s.send(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	last := changes[len(changes)-1].Sequence
+	if err := s.metadataDb.WriteKey([]byte(positionKey), []byte(strconv.FormatUint(last, 10))); err != nil {
+		return 0, fmt.Errorf("failed to store the replication position: %w", err)
+	}
+	s.position = last
+	shippedChanges.Add(float64(len(changes)))
+	lagChanges.Set(float64(committed - last))
+	if last == committed {
+		lagSeconds.Set(0)
+	}
+	s.logger.Debug("Shipped changes to the remote cluster", zap.Int("changes", len(changes)), zap.Int64("stale", resp.Stale), zap.Uint64("position", last))
+	// Records that are not trimmed are trimmed with the next batch
+	if err := s.db.TrimChanges(last); err != nil {
+		s.logger.Warn("Failed to trim the change log", zap.Error(err))
+	}
+	return len(changes), nil
+}
+
+// send sends a batch to the first remote node that accepts it, starting with the last one that did
+func (s *Shipper) send(ctx context.Context, req *proto.ApplyChangesRequest) (*proto.ApplyChangesResponse, error) {
+	var errs []error
+	for i := range s.addresses {
+		index := (s.next + i) % len(s.addresses)
+		resp, err := s.sendTo(ctx, s.addresses[index], req)
+		if err == nil {
+			s.next = index
+			return resp, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", s.addresses[index], err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (s *Shipper) sendTo(ctx context.Context, address string, req *proto.ApplyChangesRequest) (*proto.ApplyChangesResponse, error) {
+	conn, err := s.pool.GetConnection(address)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, shipTimeout)
+	defer cancel()
+	return proto.NewClusterServiceClient(conn).ApplyChanges(ctx, req)
+}
+
+// mapChangeToProto returns the replicated form of a change
+func mapChangeToProto(change storage.Change) *proto.ReplicatedChange {
+	if change.Entry == nil {
+		return &proto.ReplicatedChange{Key: string(change.Key), Timestamp: change.Timestamp, Deleted: true}
+	}
+	return &proto.ReplicatedChange{
+		Key:       string(change.Key),
+		Value:     change.Entry.Value,
+		ExpiresAt: change.Entry.ExpiresAt,
+		Flags:     change.Entry.Flags,
+		Timestamp: change.Timestamp,
+	}
+}
@@ -0,0 +1,129 @@
+package replication
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tdevsin/keyforge/internal/cluster"
+	"github.com/tdevsin/keyforge/internal/config"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/storage"
+	"google.golang.org/grpc"
+)
+
+// remoteService records the changes shipped to the remote cluster
+type remoteService struct {
+	proto.UnimplementedClusterServiceServer
+	mu       sync.Mutex
+	requests []*proto.ApplyChangesRequest
+}
+
+func (r *remoteService) ApplyChanges(ctx context.Context, req *proto.ApplyChangesRequest) (*proto.ApplyChangesResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	return &proto.ApplyChangesResponse{Applied: int64(len(req.Changes))}, nil
+}
+
+// newShipperConfig returns the config of node1 replicating to the given remote addresses
+func newShipperConfig(t *testing.T, addresses string) *config.Config {
+	dir := t.TempDir()
+	l := logger.GetLogger(false, "test")
+	db := storage.GetDatabaseInstance(l, filepath.Join(dir, "data"))
+	require.NoError(t, db.EnableChangeLog())
+	metadataDb := storage.GetDatabaseInstance(l, filepath.Join(dir, "metadata"))
+	pool := cluster.NewConnectionPool()
+	t.Cleanup(func() {
+		pool.Close()
+		db.Close()
+		metadataDb.Close()
+	})
+	settings := &config.Settings{}
+	settings.Replication.Remote = config.RemoteSettings{Addresses: addresses, Interval: time.Second, BatchSize: 2}
+	return &config.Config{
+		Logger:     l,
+		Db:         db,
+		MetadataDb: metadataDb,
+		RemotePool: pool,
+		NodeInfo:   &cluster.Node{ID: "node1"},
+		Settings:   settings,
+	}
+}
+
+func TestShip(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	remote := &remoteService{}
+	proto.RegisterClusterServiceServer(server, remote)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// Nothing listens on the first address, so batches fail over to the second
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable.Close()
+	c := newShipperConfig(t, unreachable.Addr().String()+", "+lis.Addr().String())
+	s, err := NewShipper(c)
+	require.NoError(t, err)
+
+	assert.NoError(t, c.Db.WriteKey([]byte("a"), []byte("1")))
+	assert.NoError(t, c.Db.WriteKey([]byte("b"), []byte("2")))
+	_, err = c.Db.DeleteKey([]byte("a"))
+	assert.NoError(t, err)
+
+	t.Run("Ships changes in batches", func(t *testing.T) {
+		shipped, err := s.Ship(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, shipped)
+		shipped, err = s.Ship(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, shipped)
+		shipped, err = s.Ship(context.TODO())
+		assert.NoError(t, err)
+		assert.Zero(t, shipped)
+
+		assert.Len(t, remote.requests, 2)
+		assert.Equal(t, "node1", remote.requests[0].Origin)
+		first := remote.requests[0].Changes
+		assert.Equal(t, "a", first[0].Key)
+		assert.True(t, first[0].Deleted, "changes carry the latest write of their key")
+		assert.Equal(t, []byte("2"), first[1].Value)
+		assert.NotZero(t, first[1].Timestamp)
+		assert.True(t, remote.requests[1].Changes[0].Deleted)
+		assert.Equal(t, 1, s.next, "the reachable address is tried first")
+	})
+
+	t.Run("Resumes after the stored position", func(t *testing.T) {
+		assert.NoError(t, c.Db.WriteKey([]byte("c"), []byte("3")))
+		resumed, err := NewShipper(c)
+		require.NoError(t, err)
+		assert.Equal(t, s.position, resumed.position)
+
+		shipped, err := resumed.Ship(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, shipped)
+		assert.Equal(t, "c", remote.requests[2].Changes[0].Key)
+	})
+
+	t.Run("Keeps the position if the remote cluster fails", func(t *testing.T) {
+		failing := newShipperConfig(t, unreachable.Addr().String())
+		s, err := NewShipper(failing)
+		require.NoError(t, err)
+		assert.NoError(t, failing.Db.WriteKey([]byte("a"), []byte("1")))
+
+		_, err = s.Ship(context.TODO())
+		assert.ErrorContains(t, err, unreachable.Addr().String())
+		assert.Zero(t, s.position)
+		changes, _, err := failing.Db.Changes(0, 10)
+		assert.NoError(t, err)
+		assert.Len(t, changes, 1)
+	})
+}
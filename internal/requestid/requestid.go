@@ -0,0 +1,67 @@
+// Package requestid identifies requests across the nodes they are proxied through
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+// Header is the metadata key carrying the request ID
+const Header = "x-request-id"
+
+// maxLength limits request IDs sent by clients, so they can not flood the logs
+const maxLength = 128
+
+type requestIDKey struct{}
+
+// New returns a new random request ID
+func New() string {
+	return uuid.NewString()
+}
+
+// NewContext returns a context carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// FromContext returns the request ID of the context, if any
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// FromIncomingContext returns the request ID sent by the caller, or a new one if the caller
+// did not send a valid one
+func FromIncomingContext(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(Header)
+	if len(values) == 0 || !valid(values[0]) {
+		return New()
+	}
+	return values[0]
+}
+
+// Forward sends the request ID of the context with outgoing requests, so proxied requests are
+// logged with the ID of the original request
+func Forward(ctx context.Context) context.Context {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, Header, id)
+}
+
+// valid reports whether id is short and only contains printable ASCII characters
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
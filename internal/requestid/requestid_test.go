@@ -0,0 +1,44 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestFromIncomingContext(t *testing.T) {
+	t.Run("Uses the ID sent by the caller", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(Header, "abc-123"))
+		assert.Equal(t, "abc-123", FromIncomingContext(ctx))
+	})
+
+	t.Run("Generates an ID if none was sent", func(t *testing.T) {
+		id := FromIncomingContext(context.Background())
+		assert.Len(t, id, 36)
+		assert.NotEqual(t, id, FromIncomingContext(context.Background()))
+	})
+
+	t.Run("Replaces invalid IDs", func(t *testing.T) {
+		for _, id := range []string{"", "with space", "line\nbreak", strings.Repeat("a", maxLength+1)} {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(Header, id))
+			assert.NotEqual(t, id, FromIncomingContext(ctx))
+		}
+	})
+}
+
+func TestForward(t *testing.T) {
+	t.Run("Sends the ID with outgoing requests", func(t *testing.T) {
+		ctx := Forward(NewContext(context.Background(), "abc-123"))
+		md, _ := metadata.FromOutgoingContext(ctx)
+		assert.Equal(t, []string{"abc-123"}, md.Get(Header))
+	})
+
+	t.Run("Leaves contexts without ID unchanged", func(t *testing.T) {
+		ctx := Forward(context.Background())
+		_, ok := metadata.FromOutgoingContext(ctx)
+		assert.False(t, ok)
+	})
+}
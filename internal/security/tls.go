@@ -0,0 +1,218 @@
+package security
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Accepted values of the client authentication setting
+const (
+	ClientAuthNone     = "none"     // Client certificates are not requested
+	ClientAuthOptional = "optional" // Client certificates are verified if presented
+	ClientAuthRequire  = "require"  // Every client, including other nodes, must present a valid certificate
+)
+
+// CertReloader holds the certificate, key and CA of a node in memory and reloads them when the
+// files change, so certificates can be rotated without restarting the node.
+// The TLS configs it returns always use the latest loaded files, including for new connections
+// of already created gRPC servers and clients.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	logger   logger.Logging
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	caPool   *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// NewCertReloader loads the given files. It fails if any of them can not be loaded.
+func NewCertReloader(l logger.Logging, certFile, keyFile, caFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		logger:   l,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the files again if any of them was modified since the last load.
+// On failure the previously loaded certificates stay in use.
+func (r *CertReloader) Reload() (bool, error) {
+	modTimes, err := r.readModTimes()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	changed := false
+	for file, modTime := range modTimes {
+		if !r.modTimes[file].Equal(modTime) {
+			changed = true
+		}
+	}
+	r.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, r.load()
+}
+
+// Start checks the files for changes every interval until ctx is cancelled
+func (r *CertReloader) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reloaded, err := r.Reload()
+				if err != nil {
+					r.logger.Error("Failed to reload certificates, keeping the previous ones", zap.Error(err))
+				} else if reloaded {
+					r.logger.Info("Reloaded certificates", zap.String("certFile", r.certFile), zap.String("caFile", r.caFile))
+				}
+			}
+		}
+	}()
+}
+
+// ServerConfig returns the TLS config of the gRPC server. clientAuth is one of
+// ClientAuthNone, ClientAuthOptional or ClientAuthRequire.
+func (r *CertReloader) ServerConfig(clientAuth string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		},
+	}
+	// Client certificates are verified in VerifyConnection instead of by crypto/tls so a reloaded CA is used
+	switch clientAuth {
+	case ClientAuthNone:
+		config.ClientAuth = tls.NoClientCert
+	case ClientAuthOptional:
+		config.ClientAuth = tls.RequestClientCert
+	case ClientAuthRequire:
+		config.ClientAuth = tls.RequireAnyClientCert
+	default:
+		return nil, fmt.Errorf("client auth must be %s, %s or %s, got %q", ClientAuthNone, ClientAuthOptional, ClientAuthRequire, clientAuth)
+	}
+	if config.ClientAuth != tls.NoClientCert {
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				// Missing certificates are already rejected by crypto/tls when they are required
+				return nil
+			}
+			return r.verify(state.PeerCertificates, "", x509.ExtKeyUsageClientAuth)
+		}
+	}
+	return config, nil
+}
+
+// ClientConfig returns the TLS config used to connect to other nodes. The node certificate is
+// presented to the server, which enables mutual TLS, and the server is verified against the CA.
+func (r *CertReloader) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		},
+		// The server certificate is verified in VerifyConnection instead of by crypto/tls so a reloaded CA is used
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return r.verify(state.PeerCertificates, state.ServerName, x509.ExtKeyUsageServerAuth)
+		},
+	}
+}
+
+func (r *CertReloader) certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// verify checks the peer certificate chain against the CA. If serverName is not empty it must match the certificate.
+func (r *CertReloader) verify(certificates []*x509.Certificate, serverName string, usage x509.ExtKeyUsage) error {
+	if len(certificates) == 0 {
+		return errors.New("peer did not present a certificate")
+	}
+	r.mu.RLock()
+	roots := r.caPool
+	r.mu.RUnlock()
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range certificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certificates[0].Verify(opts)
+	return err
+}
+
+// load reads all the files and replaces the loaded certificates if all of them are valid
+func (r *CertReloader) load() error {
+	modTimes, err := r.readModTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %w", r.certFile, err)
+	}
+	caPool, err := LoadCertPool(r.caFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.caPool = caPool
+	r.modTimes = modTimes
+	return nil
+}
+
+func (r *CertReloader) readModTimes() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// LoadCertPool reads PEM encoded CA certificates from a file
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA file %s does not contain any PEM encoded certificate", caFile)
+	}
+	return pool, nil
+}
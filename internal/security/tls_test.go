@@ -0,0 +1,226 @@
+package security
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/security/securitytest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+)
+
+// startServer starts a gRPC server using the given reloader and returns its address
+func startServer(t *testing.T, r *CertReloader, clientAuth string) string {
+	t.Helper()
+	tlsConfig, err := r.ServerConfig(clientAuth)
+	assert.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+// check calls the server and returns the common name of the server certificate
+func check(t *testing.T, addr string, tlsConfig *tls.Config) (string, error) {
+	t.Helper()
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var p peer.Peer
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Peer(&p))
+	if err != nil {
+		return "", err
+	}
+	state := p.AuthInfo.(credentials.TLSInfo).State
+	return state.PeerCertificates[0].Subject.CommonName, nil
+}
+
+func newReloader(t *testing.T, files securitytest.CertFiles, caFile string) *CertReloader {
+	t.Helper()
+	mockLogger := new(logger.MockLogging)
+	mockLogger.On("Info", mock.Anything, mock.Anything).Maybe()
+	mockLogger.On("Error", mock.Anything, mock.Anything).Maybe()
+	r, err := NewCertReloader(mockLogger, files.CertFile, files.KeyFile, caFile)
+	assert.NoError(t, err)
+	return r
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := securitytest.NewCA(t, dir, "ca")
+	server := newReloader(t, ca.Issue(t, dir, "node1"), ca.CAFile)
+	client := newReloader(t, ca.Issue(t, dir, "node2"), ca.CAFile)
+
+	t.Run("Nodes authenticate each other", func(t *testing.T) {
+		addr := startServer(t, server, ClientAuthRequire)
+		name, err := check(t, addr, client.ClientConfig())
+		assert.NoError(t, err)
+		assert.Equal(t, "node1", name)
+	})
+
+	t.Run("Client without certificate is rejected when certificates are required", func(t *testing.T) {
+		addr := startServer(t, server, ClientAuthRequire)
+		pool, err := LoadCertPool(ca.CAFile)
+		assert.NoError(t, err)
+		_, err = check(t, addr, &tls.Config{RootCAs: pool})
+		assert.Error(t, err)
+	})
+
+	t.Run("Client without certificate is accepted when certificates are optional", func(t *testing.T) {
+		addr := startServer(t, server, ClientAuthOptional)
+		pool, err := LoadCertPool(ca.CAFile)
+		assert.NoError(t, err)
+		name, err := check(t, addr, &tls.Config{RootCAs: pool})
+		assert.NoError(t, err)
+		assert.Equal(t, "node1", name)
+	})
+
+	t.Run("Client certificate of another CA is rejected", func(t *testing.T) {
+		otherDir := t.TempDir()
+		otherCA := securitytest.NewCA(t, otherDir, "other-ca")
+		// Trusts the right CA but presents a certificate signed by another one
+		intruder := newReloader(t, otherCA.Issue(t, otherDir, "intruder"), ca.CAFile)
+
+		addr := startServer(t, server, ClientAuthOptional)
+		_, err := check(t, addr, intruder.ClientConfig())
+		assert.Error(t, err)
+	})
+
+	t.Run("Server certificate of another CA is rejected", func(t *testing.T) {
+		otherDir := t.TempDir()
+		otherCA := securitytest.NewCA(t, otherDir, "other-ca")
+		intruder := newReloader(t, otherCA.Issue(t, otherDir, "intruder"), otherCA.CAFile)
+
+		addr := startServer(t, intruder, ClientAuthOptional)
+		_, err := check(t, addr, client.ClientConfig())
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid client auth", func(t *testing.T) {
+		_, err := server.ServerConfig("always")
+		assert.Error(t, err)
+	})
+}
+
+func TestCertReload(t *testing.T) {
+	t.Run("Rotated certificate is used for new connections", func(t *testing.T) {
+		dir := t.TempDir()
+		ca := securitytest.NewCA(t, dir, "ca")
+		server := newReloader(t, ca.Issue(t, dir, "node"), ca.CAFile)
+		client := newReloader(t, ca.Issue(t, dir, "client"), ca.CAFile)
+		addr := startServer(t, server, ClientAuthRequire)
+
+		reloaded, err := server.Reload()
+		assert.NoError(t, err)
+		assert.False(t, reloaded)
+
+		// Issue a new certificate into the same files under a different name
+		rotated := ca.Issue(t, t.TempDir(), "rotated")
+		copyFile(t, rotated.CertFile, dir+"/node.crt")
+		copyFile(t, rotated.KeyFile, dir+"/node.key")
+
+		reloaded, err = server.Reload()
+		assert.NoError(t, err)
+		assert.True(t, reloaded)
+
+		name, err := check(t, addr, client.ClientConfig())
+		assert.NoError(t, err)
+		assert.Equal(t, "rotated", name)
+	})
+
+	t.Run("Rotated CA is trusted after reload", func(t *testing.T) {
+		dir := t.TempDir()
+		oldCA := securitytest.NewCA(t, dir, "ca")
+		client := newReloader(t, oldCA.Issue(t, dir, "client"), oldCA.CAFile)
+
+		newDir := t.TempDir()
+		newCA := securitytest.NewCA(t, newDir, "ca")
+		server := newReloader(t, newCA.Issue(t, newDir, "node"), newCA.CAFile)
+		addr := startServer(t, server, ClientAuthNone)
+
+		_, err := check(t, addr, client.ClientConfig())
+		assert.Error(t, err)
+
+		copyFile(t, newCA.CAFile, oldCA.CAFile)
+		reloaded, err := client.Reload()
+		assert.NoError(t, err)
+		assert.True(t, reloaded)
+
+		_, err = check(t, addr, client.ClientConfig())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Invalid files keep the previous certificate", func(t *testing.T) {
+		dir := t.TempDir()
+		ca := securitytest.NewCA(t, dir, "ca")
+		files := ca.Issue(t, dir, "node")
+		r := newReloader(t, files, ca.CAFile)
+		before := r.certificate()
+
+		writeFile(t, files.CertFile, "not a certificate")
+		_, err := r.Reload()
+		assert.Error(t, err)
+		assert.Same(t, before, r.certificate())
+	})
+
+	t.Run("Start reloads periodically", func(t *testing.T) {
+		dir := t.TempDir()
+		ca := securitytest.NewCA(t, dir, "ca")
+		r := newReloader(t, ca.Issue(t, dir, "node"), ca.CAFile)
+		before := r.certificate()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r.Start(ctx, 10*time.Millisecond)
+
+		ca.Issue(t, dir, "node")
+		assert.Eventually(t, func() bool {
+			return r.certificate() != before
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestNewCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := securitytest.NewCA(t, dir, "ca")
+	files := ca.Issue(t, dir, "node")
+
+	_, err := NewCertReloader(new(logger.MockLogging), files.CertFile, files.KeyFile, dir+"/missing.crt")
+	assert.Error(t, err)
+
+	_, err = NewCertReloader(new(logger.MockLogging), files.CertFile, ca.CAFile, ca.CAFile)
+	assert.Error(t, err)
+
+	writeFile(t, dir+"/empty.crt", "")
+	_, err = NewCertReloader(new(logger.MockLogging), files.CertFile, files.KeyFile, dir+"/empty.crt")
+	assert.Error(t, err)
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	assert.NoError(t, err)
+	writeFile(t, dst, string(data))
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0600))
+}
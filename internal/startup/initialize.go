@@ -2,14 +2,13 @@ package startup
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/tdevsin/keyforge/internal/api/controller"
 	"github.com/tdevsin/keyforge/internal/config"
 	"github.com/tdevsin/keyforge/internal/proto"
 	"github.com/tdevsin/keyforge/internal/utils"
 	"go.uber.org/zap"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -27,18 +26,17 @@ func StartNodeSetupInCluster(conf *config.Config, bootstrapNodeAddress string) e
 	// If bootstrap node is provided, join the cluster
 	conf.Logger.Info("Joining existing cluster", zap.String("bootstrapNodeAddress", bootstrapNodeAddress))
 
-	// Create client for calling bootstrap node
-	conn, err := grpc.NewClient(bootstrapNodeAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	defer conn.Close()
+	// Create client for calling bootstrap node. The pool uses the same credentials as gossip
+	conn, err := conf.ConnectionPool.GetConnection(bootstrapNodeAddress)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to connect to bootstrap node: %w", err)
 	}
 	client := proto.NewClusterServiceClient(conn)
 
 	// Get the cluster state from the bootstrap node
 	clusterState, err := client.GetClusterState(context.TODO(), &emptypb.Empty{})
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to get cluster state from bootstrap node: %w", err)
 	}
 
 	// Merge the cluster state with the local cluster state
@@ -52,9 +50,8 @@ func StartNodeSetupInCluster(conf *config.Config, bootstrapNodeAddress string) e
 	conf.ClusterInfo.GetClusterInfo().MapClusterStateToProto(&req)
 	_, err = client.SetClusterState(context.TODO(), &req)
 
-	// Panic if state update fails
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to send cluster state to bootstrap node: %w", err)
 	}
 
 	conf.Logger.Info("Joined the cluster", zap.String("bootstrapNodeAddress", bootstrapNodeAddress))
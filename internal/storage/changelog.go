@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// changePrefix starts the keys of the change log. Each record is made of the prefix and a sequence
+// number, so records are read in the order of their writes.
+var changePrefix = []byte{internalPrefix, 'l', 'o', 'g', '/'}
+
+// tombstonePrefix starts the keys recording when a key was deleted, so replicated writes older than
+// the deletion do not recreate it. Tombstones are entries expiring after tombstoneRetention, so the
+// expiry sweeper removes them.
+var tombstonePrefix = []byte{internalPrefix, 'd', 'e', 'l', '/'}
+
+// tombstoneRetention is how long a deletion wins over older replicated writes of the key
+const tombstoneRetention = 24 * time.Hour
+
+// Change is a write recorded in the change log
+type Change struct {
+	Sequence  uint64 // Position of the change in the log
+	Key       []byte
+	Timestamp int64  // Time of the write in unix nanoseconds
+	Entry     *Entry // Written entry, nil for deletions
+}
+
+// changeLog hands out the sequence numbers of change records. Writes commit concurrently, so a
+// record is only read once the records of all lower sequence numbers are committed.
+type changeLog struct {
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]bool
+}
+
+// begin reserves n consecutive sequence numbers and returns the first
+func (l *changeLog) begin(n int) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	first := l.next
+	l.next += uint64(n)
+	l.pending[first] = true
+	return first
+}
+
+// end releases the sequence numbers reserved by begin once their records are committed or dropped
+func (l *changeLog) end(first uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.pending, first)
+}
+
+// committed returns the sequence number up to which all records are committed
+func (l *changeLog) committed() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	last := l.next - 1
+	for first := range l.pending {
+		last = min(last, first-1)
+	}
+	return last
+}
+
+// changeKey returns the key of the change record with sequence number seq
+func changeKey(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(bytes.Clone(changePrefix), seq)
+}
+
+// encodeChange returns a change record: whether the key was deleted, the timestamp and the key.
+// Values are not recorded, they are read when the change is shipped.
+func encodeChange(key []byte, timestamp int64, deleted bool) []byte {
+	record := make([]byte, 9, 9+len(key))
+	if deleted {
+		record[0] = 1
+	}
+	binary.BigEndian.PutUint64(record[1:9], uint64(timestamp))
+	return append(record, key...)
+}
+
+func tombstoneKey(key []byte) []byte {
+	return append(bytes.Clone(tombstonePrefix), key...)
+}
+
+// EnableChangeLog records every later write in the change log. It must be called before the
+// database is written to.
+func (p *PebbleDB) EnableChangeLog() error {
+	iter, err := p.db.NewIter(&pebble.IterOptions{LowerBound: changePrefix, UpperBound: prefixUpperBound(changePrefix)})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	p.changes = &changeLog{next: 1, pending: map[uint64]bool{}}
+	if iter.Last() {
+		p.changes.next = binary.BigEndian.Uint64(iter.Key()[len(changePrefix):]) + 1
+	}
+	return iter.Error()
+}
+
+// logChange adds the change record of a write of key to batch, and a tombstone for deletions. The
+// returned function must be called once the batch is committed or dropped. Nothing is recorded if
+// the change log is disabled.
+func (p *PebbleDB) logChange(batch *pebble.Batch, key []byte, timestamp int64, deleted bool) func() {
+	if p.changes == nil {
+		return func() {}
+	}
+	seq := p.changes.begin(1)
+	batch.Set(changeKey(seq), encodeChange(key, timestamp, deleted), nil)
+	if deleted {
+		writeTombstone(batch, key, timestamp)
+	}
+	return func() { p.changes.end(seq) }
+}
+
+// writeTombstone adds the tombstone of a key deleted at timestamp to batch
+func writeTombstone(batch *pebble.Batch, key []byte, timestamp int64) {
+	tombstone := tombstoneKey(key)
+	expiresAt := time.Now().Add(tombstoneRetention).UnixMilli()
+	batch.Set(tombstone, encodeEntry(&Entry{Timestamp: timestamp, ExpiresAt: expiresAt}), nil)
+	batch.Set(expiryKey(expiresAt, tombstone), nil, nil)
+}
+
+// Changes returns up to limit changes recorded after the sequence number after, and the sequence
+// number of the latest committed change. Written entries are read when the changes are returned,
+// so a change carries the latest entry of its key. Changes of keys deleted or expired since are
+// returned as deletions.
+func (p *PebbleDB) Changes(after uint64, limit int) ([]Change, uint64, error) {
+	if p.changes == nil {
+		return nil, 0, errors.New("change log is disabled")
+	}
+	committed := p.changes.committed()
+	if committed <= after {
+		return nil, committed, nil
+	}
+	iter, err := p.db.NewIter(&pebble.IterOptions{LowerBound: changeKey(after + 1), UpperBound: changeKey(committed + 1)})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer iter.Close()
+
+	now := time.Now()
+	var changes []Change
+	for valid := iter.First(); valid && len(changes) < limit; valid = iter.Next() {
+		record := iter.Value()
+		if len(record) < 9 {
+			return nil, 0, fmt.Errorf("change record %x is corrupt", iter.Key())
+		}
+		change := Change{
+			Sequence:  binary.BigEndian.Uint64(iter.Key()[len(changePrefix):]),
+			Key:       bytes.Clone(record[9:]),
+			Timestamp: int64(binary.BigEndian.Uint64(record[1:9])),
+		}
+		if record[0] == 0 {
+			entry, err := p.readEntry(change.Key)
+			if err != nil {
+				return nil, 0, err
+			}
+			if entry != nil && !entry.Expired(now) {
+				change.Timestamp, change.Entry = entry.Timestamp, entry
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, committed, iter.Error()
+}
+
+// TrimChanges deletes the change records before the sequence number upTo. The record of upTo is
+// kept, so sequence numbers continue after it when the database is opened again.
+func (p *PebbleDB) TrimChanges(upTo uint64) error {
+	return p.db.DeleteRange(changePrefix, changeKey(upTo), pebble.NoSync)
+}
+
+// ApplyChanges writes changes replicated from another cluster. A change is only applied if it is
+// newer than the entry or the tombstone of its key. Between writes with the same timestamp the
+// deletion wins, or else the greater value, so clusters applying each other's changes agree.
+// Applied changes are not recorded in the change log. It returns the number of applied changes.
+func (p *PebbleDB) ApplyChanges(changes []Change) (int, error) {
+	keys := make([][]byte, len(changes))
+	for i, change := range changes {
+		keys[i] = change.Key
+	}
+	unlock := p.lockKeys(keys)
+	defer unlock()
+
+	// Reading through the batch orders several changes of a key
+	batch := p.db.NewIndexedBatch()
+	applied := 0
+	for _, change := range changes {
+		p.observeTimestamp(change.Timestamp)
+		current, err := readEntryFrom(batch, change.Key)
+		if err != nil {
+			return 0, err
+		}
+		deleted := current == nil
+		if deleted {
+			if current, err = readEntryFrom(batch, tombstoneKey(change.Key)); err != nil {
+				return 0, err
+			}
+		}
+		if current != nil && !newerChange(change, current, deleted) {
+			continue
+		}
+		if change.Entry == nil {
+			batch.Delete(change.Key, nil)
+			writeTombstone(batch, change.Key, change.Timestamp)
+		} else {
+			batch.Set(change.Key, encodeEntry(change.Entry), nil)
+			if change.Entry.ExpiresAt != 0 {
+				batch.Set(expiryKey(change.Entry.ExpiresAt, change.Key), nil, nil)
+			}
+		}
+		applied++
+	}
+	return applied, batch.Commit(pebble.Sync)
+}
+
+// newerChange reports whether a change wins over the stored entry of its key, or over its
+// tombstone if the key is deleted
+func newerChange(change Change, current *Entry, deleted bool) bool {
+	if change.Timestamp != current.Timestamp {
+		return change.Timestamp > current.Timestamp
+	}
+	if change.Entry == nil || deleted {
+		return change.Entry == nil && !deleted
+	}
+	return bytes.Compare(change.Entry.Value, current.Value) > 0
+}
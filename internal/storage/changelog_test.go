@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tdevsin/keyforge/internal/logger"
+)
+
+// changeSummary describes changes as key and operation, like "a=1" or "a deleted"
+func changeSummary(changes []Change) []string {
+	var summary []string
+	for _, change := range changes {
+		if change.Entry == nil {
+			summary = append(summary, string(change.Key)+" deleted")
+		} else {
+			summary = append(summary, string(change.Key)+"="+string(change.Entry.Value))
+		}
+	}
+	return summary
+}
+
+func TestChangeLog(t *testing.T) {
+	dir := t.TempDir()
+	db := GetDatabaseInstance(logger.GetLogger(false, "test"), dir)
+	require.NoError(t, db.EnableChangeLog())
+
+	assert.NoError(t, db.WriteKey([]byte("a"), []byte("1")))
+	_, err := db.Update([]byte("b"), func(*Entry) (*Entry, error) { return &Entry{Value: []byte("2")}, nil })
+	assert.NoError(t, err)
+	_, err = db.DeleteKey([]byte("a"))
+	assert.NoError(t, err)
+	assert.NoError(t, db.WriteBatch([][]byte{[]byte("c"), []byte("d")}, []*Entry{{Value: []byte("3")}, {Value: []byte("4")}}))
+	_, err = db.Update([]byte("c"), func(*Entry) (*Entry, error) { return &Entry{Value: []byte("5")}, nil })
+	assert.NoError(t, err)
+
+	changes, committed, err := db.Changes(0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), committed)
+	// The first change of c carries its latest entry
+	assert.Equal(t, []string{"a deleted", "b=2", "a deleted", "c=5", "d=4", "c=5"}, changeSummary(changes))
+	assert.Equal(t, uint64(1), changes[0].Sequence)
+	assert.Equal(t, changes[3].Entry.Timestamp, changes[3].Timestamp)
+
+	changes, _, err = db.Changes(2, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a deleted", "c=5"}, changeSummary(changes))
+
+	t.Run("Records are not visible to reads", func(t *testing.T) {
+		items, _, err := db.Scan(nil, nil, 10, true)
+		assert.NoError(t, err)
+		assert.Len(t, items, 3)
+	})
+
+	t.Run("Sequence numbers continue after trimming and reopening", func(t *testing.T) {
+		assert.NoError(t, db.TrimChanges(6))
+		assert.NoError(t, db.Close())
+		db = GetDatabaseInstance(logger.GetLogger(false, "test"), dir)
+		require.NoError(t, db.EnableChangeLog())
+		assert.NoError(t, db.WriteKey([]byte("e"), []byte("6")))
+
+		changes, committed, err := db.Changes(0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(7), committed)
+		assert.Equal(t, []string{"c=5", "e=6"}, changeSummary(changes))
+		assert.Equal(t, uint64(6), changes[0].Sequence)
+	})
+	assert.NoError(t, db.Close())
+}
+
+func TestChangeLogDisabled(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(t, db)
+	assert.NoError(t, db.WriteKey([]byte("a"), []byte("1")))
+
+	_, _, err := db.Changes(0, 10)
+	assert.Error(t, err)
+	iter, err := db.db.NewIter(&pebble.IterOptions{LowerBound: changePrefix, UpperBound: prefixUpperBound(changePrefix)})
+	require.NoError(t, err)
+	defer iter.Close()
+	assert.False(t, iter.First(), "no change is recorded")
+}
+
+func TestChangeLogCommitted(t *testing.T) {
+	log := &changeLog{next: 1, pending: map[uint64]bool{}}
+	first := log.begin(1)
+	second := log.begin(3)
+	third := log.begin(1)
+	assert.Equal(t, uint64(0), log.committed())
+
+	log.end(second)
+	assert.Equal(t, uint64(0), log.committed(), "changes are not visible before earlier ones commit")
+	log.end(first)
+	assert.Equal(t, uint64(4), log.committed())
+	log.end(third)
+	assert.Equal(t, uint64(5), log.committed())
+}
+
+func TestApplyChanges(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(t, db)
+	require.NoError(t, db.EnableChangeLog())
+	stored, err := db.Update([]byte("a"), func(*Entry) (*Entry, error) { return &Entry{Value: []byte("local")}, nil })
+	require.NoError(t, err)
+	_, committed, _ := db.Changes(0, 10)
+	ts := stored.Timestamp
+
+	set := func(key, value string, timestamp int64) Change {
+		return Change{Key: []byte(key), Timestamp: timestamp, Entry: &Entry{Value: []byte(value), Timestamp: timestamp}}
+	}
+	del := func(key string, timestamp int64) Change {
+		return Change{Key: []byte(key), Timestamp: timestamp}
+	}
+	value := func(key string) string {
+		value, err := db.ReadKey([]byte(key))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(value)
+	}
+
+	tests := []struct {
+		name    string
+		changes []Change
+		applied int
+		key     string
+		want    string
+	}{
+		{"Older writes are ignored", []Change{set("a", "old", ts-1)}, 0, "a", "local"},
+		{"The greater value wins a tie", []Change{set("a", "aaa", ts), set("a", "zzz", ts)}, 1, "a", "zzz"},
+		{"Newer writes are applied", []Change{set("a", "new", ts+10)}, 1, "a", "new"},
+		{"Older deletions are ignored", []Change{del("a", ts+5)}, 0, "a", "new"},
+		{"The deletion wins a tie", []Change{del("a", ts+10)}, 1, "a", "<missing>"},
+		{"Writes older than a deletion do not recreate the key", []Change{set("a", "old", ts+9)}, 0, "a", "<missing>"},
+		{"Writes newer than a deletion recreate the key", []Change{set("a", "again", ts+20)}, 1, "a", "again"},
+		{"Later changes of a key in a batch see earlier ones", []Change{set("b", "1", ts+2), set("b", "0", ts+1)}, 1, "b", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applied, err := db.ApplyChanges(tt.changes)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.applied, applied)
+			assert.Equal(t, tt.want, value(tt.key))
+		})
+	}
+
+	t.Run("Applied changes are not recorded", func(t *testing.T) {
+		_, latest, err := db.Changes(0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, committed, latest)
+	})
+
+	t.Run("Local writes get later timestamps", func(t *testing.T) {
+		future := time.Now().Add(time.Hour).UnixNano()
+		_, err := db.ApplyChanges([]Change{set("c", "remote", future)})
+		assert.NoError(t, err)
+		written, err := db.Update([]byte("c"), func(*Entry) (*Entry, error) { return &Entry{Value: []byte("local")}, nil })
+		assert.NoError(t, err)
+		assert.Greater(t, written.Timestamp, future)
+	})
+
+	t.Run("Tombstones expire", func(t *testing.T) {
+		deleted, err := db.DeleteExpired(time.Now().Add(tombstoneRetention+time.Minute), 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+		tombstone, err := db.readEntry(tombstoneKey([]byte("a")))
+		assert.NoError(t, err)
+		assert.Nil(t, tombstone)
+	})
+}
+
+func TestIngestRecordsChanges(t *testing.T) {
+	db := GetDatabaseInstance(logger.GetLogger(false, "test"), filepath.Join(t.TempDir(), "db"))
+	defer db.Close()
+	require.NoError(t, db.EnableChangeLog())
+	assert.NoError(t, db.WriteKey([]byte("a"), []byte("old")))
+
+	table := writeTable(t, []string{"a", "b"}, []*Entry{
+		{Value: []byte("1"), Timestamp: time.Now().UnixNano(), ExpiresAt: time.Now().Add(time.Hour).UnixMilli()},
+		{Value: []byte("2"), Timestamp: time.Now().UnixNano()},
+	})
+	_, err := db.Ingest(table, func([]byte) error { return nil })
+	require.NoError(t, err)
+
+	changes, _, err := db.Changes(0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a=1", "a=1", "b=2"}, changeSummary(changes))
+}
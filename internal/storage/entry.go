@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// entryFormat is the first byte of every stored value. It allows changing the layout of entries later.
+// Format 1 has no flags, entries are written in format 2.
+const entryFormat byte = 2
+
+// entryHeaderSizes are the sizes of the format, timestamp, expiry and flags stored before the value, by format
+var entryHeaderSizes = map[byte]int{1: 1 + 8 + 8, 2: 1 + 8 + 8 + 4}
+
+// ErrCorruptEntry is returned for stored values that are not in the entry format
+var ErrCorruptEntry = errors.New("stored value has an unknown format")
+
+// internalPrefix starts the keys the database keeps for itself, like the expiry index. Clients can
+// not write keys starting with it and scans skip them.
+const internalPrefix = 0x00
+
+// IsInternalKey reports whether key is reserved for the database
+func IsInternalKey(key []byte) bool {
+	return len(key) > 0 && key[0] == internalPrefix
+}
+
+// Entry is a value together with the metadata stored alongside it
+type Entry struct {
+	Value []byte
+	// Timestamp is the time of the write in unix nanoseconds. It increases with every write on a
+	// node, so it also serves as version of the value.
+	Timestamp int64
+	// ExpiresAt is the time in unix milliseconds after which the key no longer exists, zero if it never expires
+	ExpiresAt int64
+	// Flags are opaque to the database. Memcached clients use them to store how the value is serialized.
+	Flags uint32
+}
+
+// Expired reports whether the entry has expired at now
+func (e *Entry) Expired(now time.Time) bool {
+	return e.ExpiresAt != 0 && e.ExpiresAt <= now.UnixMilli()
+}
+
+// TTL returns the time left until the entry expires, zero if it never expires
+func (e *Entry) TTL(now time.Time) time.Duration {
+	if e.ExpiresAt == 0 {
+		return 0
+	}
+	return max(time.UnixMilli(e.ExpiresAt).Sub(now), time.Millisecond)
+}
+
+// encodeEntry returns the stored form of an entry: the format, timestamp, expiry and flags followed by the value
+func encodeEntry(e *Entry) []byte {
+	headerSize := entryHeaderSizes[entryFormat]
+	data := make([]byte, headerSize+len(e.Value))
+	data[0] = entryFormat
+	binary.BigEndian.PutUint64(data[1:9], uint64(e.Timestamp))
+	binary.BigEndian.PutUint64(data[9:17], uint64(e.ExpiresAt))
+	binary.BigEndian.PutUint32(data[17:21], e.Flags)
+	copy(data[headerSize:], e.Value)
+	return data
+}
+
+// decodeEntry parses a stored value of any format. The value is copied, so data may be reused afterward.
+func decodeEntry(data []byte) (*Entry, error) {
+	if len(data) == 0 {
+		return nil, ErrCorruptEntry
+	}
+	headerSize, ok := entryHeaderSizes[data[0]]
+	if !ok || len(data) < headerSize {
+		return nil, ErrCorruptEntry
+	}
+	entry := &Entry{
+		Value:     append([]byte{}, data[headerSize:]...),
+		Timestamp: int64(binary.BigEndian.Uint64(data[1:9])),
+		ExpiresAt: int64(binary.BigEndian.Uint64(data[9:17])),
+	}
+	if data[0] >= 2 {
+		entry.Flags = binary.BigEndian.Uint32(data[17:21])
+	}
+	return entry, nil
+}
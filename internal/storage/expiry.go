@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"go.uber.org/zap"
+)
+
+// expiryPrefix starts the keys of the expiry index. Each key with an expiry has an index key made of
+// the prefix, the expiry in unix milliseconds and the key, so expired keys are found in order.
+var expiryPrefix = []byte{internalPrefix, 'e', 'x', 'p', '/'}
+
+// expiryKey returns the index key of a key expiring at expiresAt
+func expiryKey(expiresAt int64, key []byte) []byte {
+	index := make([]byte, 0, len(expiryPrefix)+8+len(key))
+	index = append(index, expiryPrefix...)
+	index = binary.BigEndian.AppendUint64(index, uint64(expiresAt))
+	return append(index, key...)
+}
+
+// DeleteExpired deletes keys whose expiry passed. Index keys of entries that were overwritten are
+// removed without deleting the key.
+func (p *PebbleDB) DeleteExpired(now time.Time, limit int) (int, error) {
+	upper := expiryKey(now.UnixMilli()+1, nil)
+	iter, err := p.db.NewIter(&pebble.IterOptions{LowerBound: expiryPrefix, UpperBound: upper})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	deleted := 0
+	for valid := iter.First(); valid && deleted < limit; valid = iter.Next() {
+		index := bytes.Clone(iter.Key())
+		expiresAt := int64(binary.BigEndian.Uint64(index[len(expiryPrefix):]))
+		removed, err := p.deleteIfExpired(index, index[len(expiryPrefix)+8:], expiresAt, now)
+		if err != nil {
+			return deleted, err
+		}
+		if removed {
+			deleted++
+		}
+	}
+	return deleted, iter.Error()
+}
+
+// deleteIfExpired deletes the index key and the key if it still expires at expiresAt
+func (p *PebbleDB) deleteIfExpired(index, key []byte, expiresAt int64, now time.Time) (bool, error) {
+	unlock := p.lock(key)
+	defer unlock()
+
+	entry, err := p.readEntry(key)
+	if err != nil {
+		return false, err
+	}
+	batch := p.db.NewBatch()
+	batch.Delete(index, nil)
+	expired := entry != nil && entry.ExpiresAt == expiresAt && entry.Expired(now)
+	if expired {
+		batch.Delete(key, nil)
+	}
+	// Expired keys are not returned anymore, so losing the deletion in a crash is harmless
+	return expired, batch.Commit(pebble.NoSync)
+}
+
+// expiryBatchSize is the number of keys deleted by one pass of the expiry sweeper
+const expiryBatchSize = 1000
+
+// SweepExpired deletes expired keys of db every interval until ctx is cancelled. Expired keys are
+// already hidden from reads, sweeping frees their space.
+func SweepExpired(ctx context.Context, db Database, interval time.Duration, l logger.Logging) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for {
+			deleted, err := db.DeleteExpired(time.Now(), expiryBatchSize)
+			if err != nil {
+				l.Error("Failed to delete expired keys", zap.Error(err))
+				break
+			}
+			if deleted > 0 {
+				l.Debug("Deleted expired keys", zap.Int("keys", deleted))
+			}
+			if deleted < expiryBatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// LockFile takes an exclusive lock on the given file, creating it if needed.
+// The lock is held until the returned closer is closed or the process exits.
+func LockFile(path string) (io.Closer, error) {
+	lock, err := vfs.Default.Lock(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s, is another process using it? %w", path, err)
+	}
+	return lock, nil
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockFile(t *testing.T) {
+	lockPath := path.Join(t.TempDir(), "test.lock")
+
+	lock, err := LockFile(lockPath)
+	assert.NoError(t, err, "Failed to take lock")
+
+	_, err = LockFile(lockPath)
+	assert.Error(t, err, "Lock should not be taken twice")
+
+	assert.NoError(t, lock.Close(), "Failed to release lock")
+
+	lock, err = LockFile(lockPath)
+	assert.NoError(t, err, "Lock should be available after release")
+	lock.Close()
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PebbleCollector exports statistics of a database when metrics are scraped
+type PebbleCollector struct {
+	db Database
+
+	compactions   *prometheus.Desc
+	flushes       *prometheus.Desc
+	memtableSize  *prometheus.Desc
+	memtableCount *prometheus.Desc
+	l0Files       *prometheus.Desc
+	l0Sublevels   *prometheus.Desc
+	readAmp       *prometheus.Desc
+	diskUsage     *prometheus.Desc
+}
+
+// NewPebbleCollector returns a collector for db. name is used as the db label to tell databases apart.
+func NewPebbleCollector(db Database, name string) *PebbleCollector {
+	labels := prometheus.Labels{"db": name}
+	return &PebbleCollector{
+		db:            db,
+		compactions:   prometheus.NewDesc("keyforge_pebble_compactions_total", "Number of compactions.", nil, labels),
+		flushes:       prometheus.NewDesc("keyforge_pebble_flushes_total", "Number of memtable flushes.", nil, labels),
+		memtableSize:  prometheus.NewDesc("keyforge_pebble_memtable_size_bytes", "Bytes allocated by memtables.", nil, labels),
+		memtableCount: prometheus.NewDesc("keyforge_pebble_memtables", "Number of memtables.", nil, labels),
+		l0Files:       prometheus.NewDesc("keyforge_pebble_l0_files", "Number of files in level 0.", nil, labels),
+		l0Sublevels:   prometheus.NewDesc("keyforge_pebble_l0_sublevels", "Number of sublevels in level 0.", nil, labels),
+		readAmp:       prometheus.NewDesc("keyforge_pebble_read_amplification", "Number of sorted runs a read may have to check.", nil, labels),
+		diskUsage:     prometheus.NewDesc("keyforge_pebble_disk_usage_bytes", "Bytes used on disk by the database, including WAL and obsolete files.", nil, labels),
+	}
+}
+
+func (c *PebbleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.compactions
+	ch <- c.flushes
+	ch <- c.memtableSize
+	ch <- c.memtableCount
+	ch <- c.l0Files
+	ch <- c.l0Sublevels
+	ch <- c.readAmp
+	ch <- c.diskUsage
+}
+
+func (c *PebbleCollector) Collect(ch chan<- prometheus.Metric) {
+	m := c.db.Metrics()
+	ch <- prometheus.MustNewConstMetric(c.compactions, prometheus.CounterValue, float64(m.Compact.Count))
+	ch <- prometheus.MustNewConstMetric(c.flushes, prometheus.CounterValue, float64(m.Flush.Count))
+	ch <- prometheus.MustNewConstMetric(c.memtableSize, prometheus.GaugeValue, float64(m.MemTable.Size))
+	ch <- prometheus.MustNewConstMetric(c.memtableCount, prometheus.GaugeValue, float64(m.MemTable.Count))
+	ch <- prometheus.MustNewConstMetric(c.l0Files, prometheus.GaugeValue, float64(m.Levels[0].NumFiles))
+	ch <- prometheus.MustNewConstMetric(c.l0Sublevels, prometheus.GaugeValue, float64(m.Levels[0].Sublevels))
+	ch <- prometheus.MustNewConstMetric(c.readAmp, prometheus.GaugeValue, float64(m.ReadAmp()))
+	ch <- prometheus.MustNewConstMetric(c.diskUsage, prometheus.GaugeValue, float64(m.DiskSpaceUsage()))
+}
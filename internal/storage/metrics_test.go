@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPebbleCollector(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(t, db)
+
+	assert.NoError(t, db.WriteKey([]byte("key"), []byte("value")))
+	assert.NoError(t, db.Flush())
+
+	collector := NewPebbleCollector(db, "data")
+	assert.Equal(t, 8, testutil.CollectAndCount(collector))
+
+	expected := `
+# HELP keyforge_pebble_flushes_total Number of memtable flushes.
+# TYPE keyforge_pebble_flushes_total counter
+keyforge_pebble_flushes_total{db="data"} 1
+# HELP keyforge_pebble_l0_files Number of files in level 0.
+# TYPE keyforge_pebble_l0_files gauge
+keyforge_pebble_l0_files{db="data"} 1
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "keyforge_pebble_flushes_total", "keyforge_pebble_l0_files")
+	assert.NoError(t, err)
+}
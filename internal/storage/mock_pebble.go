@@ -1,6 +1,11 @@
 package storage
 
-import "github.com/stretchr/testify/mock"
+import (
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/stretchr/testify/mock"
+)
 
 type MockDatabase struct {
 	mock.Mock
@@ -21,7 +26,91 @@ func (m *MockDatabase) ReadKey(key []byte) ([]byte, error) {
 	return args.Get(0).([]byte), args.Error(1)
 }
 
-func (m *MockDatabase) DeleteKey(key []byte) error {
+func (m *MockDatabase) DeleteKey(key []byte) (bool, error) {
+	args := m.Called(key)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockDatabase) ReadEntry(key []byte) (*Entry, error) {
+	args := m.Called(key)
+	entry, _ := args.Get(0).(*Entry)
+	return entry, args.Error(1)
+}
+
+// Update passes the entry given to Return to fn, and returns what fn returns
+func (m *MockDatabase) Update(key []byte, fn func(current *Entry) (*Entry, error)) (*Entry, error) {
 	args := m.Called(key)
+	if err := args.Error(1); err != nil {
+		return nil, err
+	}
+	entry, _ := args.Get(0).(*Entry)
+	return fn(entry)
+}
+
+func (m *MockDatabase) DeleteExpired(now time.Time, limit int) (int, error) {
+	args := m.Called(now, limit)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockDatabase) Flush() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockDatabase) Metrics() *pebble.Metrics {
+	args := m.Called()
+	return args.Get(0).(*pebble.Metrics)
+}
+
+func (m *MockDatabase) CheckWritable() error {
+	args := m.Called()
 	return args.Error(0)
 }
+
+func (m *MockDatabase) Scan(prefix, startAfter []byte, limit int, keysOnly bool) ([]KeyValue, bool, error) {
+	args := m.Called(prefix, startAfter, limit, keysOnly)
+	return args.Get(0).([]KeyValue), args.Bool(1), args.Error(2)
+}
+
+func (m *MockDatabase) Checkpoint(dir string) error {
+	args := m.Called(dir)
+	return args.Error(0)
+}
+
+// ForEach calls fn for every key of the KeyValue slice given to Return, as entries without metadata
+func (m *MockDatabase) ForEach(prefix []byte, fn func(key []byte, entry *Entry) error) error {
+	args := m.Called(prefix)
+	items, _ := args.Get(0).([]KeyValue)
+	for _, item := range items {
+		if err := fn(item.Key, &Entry{Value: item.Value}); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockDatabase) WriteBatch(keys [][]byte, entries []*Entry) error {
+	args := m.Called(keys, entries)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) Ingest(path string, check func(key []byte) error) (int, error) {
+	args := m.Called(path)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockDatabase) Changes(after uint64, limit int) ([]Change, uint64, error) {
+	args := m.Called(after, limit)
+	changes, _ := args.Get(0).([]Change)
+	return changes, args.Get(1).(uint64), args.Error(2)
+}
+
+func (m *MockDatabase) TrimChanges(upTo uint64) error {
+	args := m.Called(upTo)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) ApplyChanges(changes []Change) (int, error) {
+	args := m.Called(changes)
+	return args.Int(0), args.Error(1)
+}
@@ -1,8 +1,21 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/cockroachdb/pebble"
 	"github.com/tdevsin/keyforge/internal/logger"
+	"go.uber.org/zap"
 )
 
 // Database defines the methods required to interact with a key-value storage.
@@ -16,12 +29,79 @@ type Database interface {
 	// ReadKey reads the value of a given key from the database.
 	ReadKey(key []byte) ([]byte, error)
 
-	// DeleteKey deletes a key-value pair from the database.
-	DeleteKey(key []byte) error
+	// DeleteKey deletes a key-value pair from the database and reports whether the key existed.
+	DeleteKey(key []byte) (bool, error)
+
+	// ReadEntry reads the value of a given key together with its metadata.
+	ReadEntry(key []byte) (*Entry, error)
+
+	// Update atomically replaces the entry of a key with the result of fn. fn receives the current
+	// entry, or nil if the key does not exist. If fn returns nil the key is deleted, and if it returns
+	// an error nothing is written and the error is returned. It returns the written entry.
+	Update(key []byte, fn func(current *Entry) (*Entry, error)) (*Entry, error)
+
+	// DeleteExpired deletes up to limit keys that expired at now and returns how many it deleted.
+	DeleteExpired(now time.Time, limit int) (int, error)
+
+	// Flush writes the in-memory data to disk.
+	Flush() error
+
+	// Metrics returns statistics about the internals of the database.
+	Metrics() *pebble.Metrics
+
+	// CheckWritable verifies that the database accepts durable writes.
+	CheckWritable() error
+
+	// Scan returns up to limit key-value pairs in ascending key order, whose keys start with prefix
+	// and come after startAfter. It also reports whether more keys match.
+	Scan(prefix, startAfter []byte, limit int, keysOnly bool) ([]KeyValue, bool, error)
+
+	// Checkpoint writes a consistent copy of the database into dir, which must not exist.
+	Checkpoint(dir string) error
+
+	// ForEach calls fn for every key starting with prefix in ascending order, reading a snapshot of
+	// the database so writes made meanwhile are not seen. Internal and expired keys are skipped. It
+	// stops at the first error of fn and returns it.
+	ForEach(prefix []byte, fn func(key []byte, entry *Entry) error) error
+
+	// WriteBatch atomically writes the entries of several keys. Entries without timestamp get the
+	// time of the write.
+	WriteBatch(keys [][]byte, entries []*Entry) error
+
+	// Ingest verifies an SSTable written by a TableWriter, calling check for every key, and adds its
+	// keys to the database in one step. It returns the number of ingested keys.
+	Ingest(path string, check func(key []byte) error) (int, error)
+
+	// Changes returns up to limit changes of the change log after the sequence number after, and the
+	// sequence number of the latest committed change.
+	Changes(after uint64, limit int) ([]Change, uint64, error)
+
+	// TrimChanges deletes the changes of the change log before the sequence number upTo.
+	TrimChanges(upTo uint64) error
+
+	// ApplyChanges writes changes replicated from another cluster that are newer than the stored
+	// entries of their keys, and returns how many were applied.
+	ApplyChanges(changes []Change) (int, error)
 }
 
+// KeyValue is a key and its value returned by a scan
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// keyLocks is the number of locks serializing writes. Keys are spread over them by hash.
+const keyLocks = 64
+
 type PebbleDB struct {
-	db *pebble.DB
+	db   *pebble.DB
+	path string
+	// locks serialize writes of the same key, so updates read and write an entry atomically
+	locks [keyLocks]sync.Mutex
+	// clock is the timestamp of the latest write
+	clock atomic.Int64
+	// changes records local writes so they can be replicated. It is nil if the change log is disabled.
+	changes *changeLog
 }
 
 func GetDatabaseInstance(logger *logger.Logger, path string) *PebbleDB {
@@ -31,37 +111,475 @@ func GetDatabaseInstance(logger *logger.Logger, path string) *PebbleDB {
 	if err != nil {
 		panic(err)
 	}
-	instance := &PebbleDB{db: db}
+	instance := &PebbleDB{db: db, path: path}
+	if err := instance.migrate(logger, path); err != nil {
+		db.Close()
+		panic(err)
+	}
 	return instance
 }
 
+// formatFile records the entry format a database directory was migrated to
+const formatFile = "KEYFORGE_FORMAT"
+
+// migrationKey stores the last key converted by an unfinished migration. It is committed with the
+// converted entries, so a migration interrupted by a crash resumes after them instead of wrapping
+// their values a second time.
+var migrationKey = []byte{internalPrefix, 'm', 'i', 'g', 'r', 'a', 't', 'i', 'o', 'n'}
+
+// migrate converts the plain values written before entries had metadata into entries. The format
+// is kept in a file next to the database once every value is converted.
+func (p *PebbleDB) migrate(logger *logger.Logger, path string) error {
+	file := filepath.Join(path, formatFile)
+	data, err := os.ReadFile(file)
+	if err == nil {
+		// Entries of older formats are still read, so they are not rewritten
+		format, err := strconv.Atoi(string(bytes.TrimSpace(data)))
+		if err != nil || format < 1 || format > int(entryFormat) {
+			return fmt.Errorf("database %s has unsupported format %q", path, bytes.TrimSpace(data))
+		}
+		// The key is left behind if the node stopped between writing the file and deleting it
+		return p.deleteMigrationKey()
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// Older versions accepted keys starting with the prefix now reserved for the database. They
+	// would be hidden from clients and not be readable as entries, so they are not converted.
+	reserved, err := p.reservedKeys()
+	if err != nil {
+		return err
+	}
+	if len(reserved) > 0 {
+		return fmt.Errorf("database %s contains %d keys starting with the reserved byte 0x00, delete or rename them with the previous version before upgrading: %s",
+			path, len(reserved), strings.Join(reserved[:min(len(reserved), maxReportedKeys)], ", "))
+	}
+
+	options := &pebble.IterOptions{}
+	last, closer, err := p.db.Get(migrationKey)
+	if err == nil {
+		options.LowerBound = append(bytes.Clone(last), 0)
+		closer.Close()
+		logger.Info("Resuming an interrupted migration to the entry format", zap.String("path", path))
+	} else if !errors.Is(err, pebble.ErrNotFound) {
+		return err
+	}
+	iter, err := p.db.NewIter(options)
+	if err != nil {
+		return err
+	}
+	batch := p.db.NewBatch()
+	migrated := 0
+	timestamp := p.nextTimestamp()
+	for valid := iter.First(); valid; valid = iter.Next() {
+		if IsInternalKey(iter.Key()) {
+			continue
+		}
+		value, err := iter.ValueAndErr()
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		batch.Set(iter.Key(), encodeEntry(&Entry{Value: value, Timestamp: timestamp}), nil)
+		migrated++
+		if batch.Len() > 4<<20 {
+			batch.Set(migrationKey, iter.Key(), nil)
+			if err := batch.Commit(pebble.NoSync); err != nil {
+				iter.Close()
+				return err
+			}
+			batch = p.db.NewBatch()
+		}
+	}
+	if err := errors.Join(iter.Close(), batch.Commit(pebble.Sync)); err != nil {
+		return err
+	}
+	if migrated > 0 {
+		logger.Info("Migrated stored values to the entry format", zap.String("path", path), zap.Int("keys", migrated))
+	}
+	if err := writeFormatFile(file); err != nil {
+		return err
+	}
+	return p.deleteMigrationKey()
+}
+
+// maxReportedKeys limits the keys named in the error of a migration
+const maxReportedKeys = 10
+
+// reservedKeys returns the quoted keys of a database of an older version that start with the
+// internal prefix. The progress of an interrupted migration is not one of them.
+func (p *PebbleDB) reservedKeys() ([]string, error) {
+	iter, err := p.db.NewIter(&pebble.IterOptions{LowerBound: []byte{internalPrefix}, UpperBound: []byte{internalPrefix + 1}})
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		if !bytes.Equal(iter.Key(), migrationKey) {
+			keys = append(keys, strconv.Quote(string(iter.Key())))
+		}
+	}
+	return keys, iter.Close()
+}
+
+// deleteMigrationKey deletes the progress of a finished migration
+func (p *PebbleDB) deleteMigrationKey() error {
+	_, closer, err := p.db.Get(migrationKey)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	closer.Close()
+	return p.db.Delete(migrationKey, pebble.Sync)
+}
+
+// writeFormatFile records the current entry format and syncs the file and its directory, so the
+// format is not lost once the progress of the migration is deleted
+func writeFormatFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, entryFormat); err != nil {
+		f.Close()
+		return err
+	}
+	if err := errors.Join(f.Sync(), f.Close()); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	return errors.Join(dir.Sync(), dir.Close())
+}
+
 // Close closes the Pebble database.
 func (p *PebbleDB) Close() error {
 	return p.db.Close()
 }
 
-// WriteKey writes a key-value pair to the Pebble database.
-func (p *PebbleDB) WriteKey(key, value []byte) error {
-	if err := p.db.Set(key, value, pebble.Sync); err != nil {
-		return err
+// lock locks the writes of a key and returns the function unlocking them
+func (p *PebbleDB) lock(key []byte) func() {
+	mu := &p.locks[lockIndex(key)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockIndex returns the lock serializing the writes of a key
+func lockIndex(key []byte) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % keyLocks)
+}
+
+// nextTimestamp returns the current time in unix nanoseconds, or one more than the previous
+// timestamp if the clock did not advance or went backward
+func (p *PebbleDB) nextTimestamp() int64 {
+	for {
+		last := p.clock.Load()
+		next := max(time.Now().UnixNano(), last+1)
+		if p.clock.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+// observeTimestamp moves the clock past a timestamp written by another node
+func (p *PebbleDB) observeTimestamp(timestamp int64) {
+	for {
+		last := p.clock.Load()
+		if timestamp <= last || p.clock.CompareAndSwap(last, timestamp) {
+			return
+		}
 	}
-	return nil
+}
+
+// WriteKey writes a key-value pair to the Pebble database. Any expiry of the key is removed.
+func (p *PebbleDB) WriteKey(key, value []byte) error {
+	unlock := p.lock(key)
+	defer unlock()
+	timestamp := p.nextTimestamp()
+	batch := p.db.NewBatch()
+	batch.Set(key, encodeEntry(&Entry{Value: value, Timestamp: timestamp}), nil)
+	done := p.logChange(batch, key, timestamp, false)
+	defer done()
+	return batch.Commit(pebble.Sync)
 }
 
 // ReadKey reads the value of a given key from the Pebble database.
 func (p *PebbleDB) ReadKey(key []byte) ([]byte, error) {
-	value, closer, err := p.db.Get(key)
+	entry, err := p.ReadEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Value, nil
+}
+
+// ReadEntry reads the entry of a given key from the Pebble database. Expired keys are not found.
+func (p *PebbleDB) ReadEntry(key []byte) (*Entry, error) {
+	entry, err := p.readEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || entry.Expired(time.Now()) {
+		return nil, pebble.ErrNotFound
+	}
+	return entry, nil
+}
+
+// readEntry returns the stored entry of a key, including expired ones, or nil if there is none
+func (p *PebbleDB) readEntry(key []byte) (*Entry, error) {
+	return readEntryFrom(p.db, key)
+}
+
+// readEntryFrom reads the stored entry of a key from a database or a batch
+func readEntryFrom(r pebble.Reader, key []byte) (*Entry, error) {
+	value, closer, err := r.Get(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 	defer closer.Close()
-	return value, nil
+	return decodeEntry(value)
+}
+
+// Update atomically replaces the entry of a key in the Pebble database. Entries without timestamp
+// get the time of the write.
+func (p *PebbleDB) Update(key []byte, fn func(current *Entry) (*Entry, error)) (*Entry, error) {
+	unlock := p.lock(key)
+	defer unlock()
+
+	stored, err := p.readEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	current := stored
+	if current != nil && current.Expired(time.Now()) {
+		current = nil
+	}
+	next, err := fn(current)
+	if err != nil {
+		return nil, err
+	}
+	if next == nil {
+		if stored == nil {
+			return nil, nil
+		}
+		return nil, p.deleteKey(key)
+	}
+
+	if next.Timestamp == 0 {
+		next.Timestamp = p.nextTimestamp()
+	} else {
+		p.observeTimestamp(next.Timestamp)
+	}
+	batch := p.db.NewBatch()
+	batch.Set(key, encodeEntry(next), nil)
+	if next.ExpiresAt != 0 {
+		batch.Set(expiryKey(next.ExpiresAt, key), nil, nil)
+	}
+	done := p.logChange(batch, key, next.Timestamp, false)
+	defer done()
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// Flush flushes the memtable of the Pebble database to disk.
+func (p *PebbleDB) Flush() error {
+	return p.db.Flush()
+}
+
+// Metrics returns statistics about the internals of the Pebble database.
+func (p *PebbleDB) Metrics() *pebble.Metrics {
+	return p.db.Metrics()
+}
+
+// CheckWritable syncs a record to the write-ahead log of the Pebble database without changing any key.
+func (p *PebbleDB) CheckWritable() error {
+	return p.db.LogData([]byte("health-check"), pebble.Sync)
 }
 
-// DeleteKey deletes a key-value pair from the Pebble database.
-func (p *PebbleDB) DeleteKey(key []byte) error {
-	if err := p.db.Delete(key, pebble.Sync); err != nil {
+// Checkpoint creates a Pebble checkpoint in dir. Files are hard linked where possible, so it is
+// cheap if dir is on the same file system. The entry format file is copied along, otherwise the
+// entries of the copy would be migrated again when it is opened.
+func (p *PebbleDB) Checkpoint(dir string) error {
+	if err := p.db.Checkpoint(dir, pebble.WithFlushedWAL()); err != nil {
 		return err
 	}
+	data, err := os.ReadFile(filepath.Join(p.path, formatFile))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, formatFile), data, 0o644)
+}
+
+// Scan iterates over the keys of the Pebble database in ascending order. Values are copied, so they
+// stay valid after the iterator is closed. Internal and expired keys are skipped.
+func (p *PebbleDB) Scan(prefix, startAfter []byte, limit int, keysOnly bool) ([]KeyValue, bool, error) {
+	lower := prefix
+	if len(startAfter) > 0 {
+		// The smallest key after startAfter is startAfter followed by a zero byte
+		if after := append(bytes.Clone(startAfter), 0); bytes.Compare(after, lower) > 0 {
+			lower = after
+		}
+	}
+	if firstKey := []byte{internalPrefix + 1}; bytes.Compare(lower, firstKey) < 0 {
+		lower = firstKey
+	}
+	iter, err := p.db.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: prefixUpperBound(prefix)})
+	if err != nil {
+		return nil, false, err
+	}
+	defer iter.Close()
+
+	now := time.Now()
+	var items []KeyValue
+	for valid := iter.First(); valid; valid = iter.Next() {
+		value, err := iter.ValueAndErr()
+		if err != nil {
+			return nil, false, err
+		}
+		entry, err := decodeEntry(value)
+		if err != nil {
+			return nil, false, err
+		}
+		if entry.Expired(now) {
+			continue
+		}
+		if len(items) == limit {
+			return items, true, iter.Error()
+		}
+		item := KeyValue{Key: bytes.Clone(iter.Key())}
+		if !keysOnly {
+			item.Value = entry.Value
+		}
+		items = append(items, item)
+	}
+	return items, false, iter.Error()
+}
+
+// ForEach iterates over a Pebble snapshot. The key passed to fn is only valid during the call.
+func (p *PebbleDB) ForEach(prefix []byte, fn func(key []byte, entry *Entry) error) error {
+	lower := prefix
+	if firstKey := []byte{internalPrefix + 1}; bytes.Compare(lower, firstKey) < 0 {
+		lower = firstKey
+	}
+	snapshot := p.db.NewSnapshot()
+	defer snapshot.Close()
+	iter, err := snapshot.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: prefixUpperBound(prefix)})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	now := time.Now()
+	for valid := iter.First(); valid; valid = iter.Next() {
+		value, err := iter.ValueAndErr()
+		if err != nil {
+			return err
+		}
+		entry, err := decodeEntry(value)
+		if err != nil {
+			return err
+		}
+		if entry.Expired(now) {
+			continue
+		}
+		if err := fn(iter.Key(), entry); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// WriteBatch writes the entries of several keys with a single sync, holding the locks of all keys.
+func (p *PebbleDB) WriteBatch(keys [][]byte, entries []*Entry) error {
+	if len(keys) != len(entries) {
+		return fmt.Errorf("got %d keys but %d entries", len(keys), len(entries))
+	}
+	unlock := p.lockKeys(keys)
+	defer unlock()
+
+	batch := p.db.NewBatch()
+	for i, entry := range entries {
+		if entry.Timestamp == 0 {
+			entry.Timestamp = p.nextTimestamp()
+		} else {
+			p.observeTimestamp(entry.Timestamp)
+		}
+		batch.Set(keys[i], encodeEntry(entry), nil)
+		if entry.ExpiresAt != 0 {
+			batch.Set(expiryKey(entry.ExpiresAt, keys[i]), nil, nil)
+		}
+		done := p.logChange(batch, keys[i], entry.Timestamp, false)
+		defer done()
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+// lockKeys locks the writes of several keys and returns the function unlocking them. Locks are
+// taken in ascending order, so callers locking overlapping keys can not deadlock.
+func (p *PebbleDB) lockKeys(keys [][]byte) func() {
+	var locked [keyLocks]bool
+	for _, key := range keys {
+		locked[lockIndex(key)] = true
+	}
+	for i := range locked {
+		if locked[i] {
+			p.locks[i].Lock()
+		}
+	}
+	return func() {
+		for i := range locked {
+			if locked[i] {
+				p.locks[i].Unlock()
+			}
+		}
+	}
+}
+
+// prefixUpperBound returns the smallest key greater than all keys starting with prefix, or nil if
+// there is none
+func prefixUpperBound(prefix []byte) []byte {
+	upper := bytes.Clone(prefix)
+	for i := len(upper) - 1; i >= 0; i-- {
+		if upper[i] < 0xff {
+			upper[i]++
+			return upper[:i+1]
+		}
+	}
 	return nil
 }
+
+// DeleteKey deletes a key-value pair from the Pebble database. Expired keys are deleted as well,
+// but are not reported as existing.
+func (p *PebbleDB) DeleteKey(key []byte) (bool, error) {
+	unlock := p.lock(key)
+	defer unlock()
+
+	entry, err := p.readEntry(key)
+	if err != nil && !errors.Is(err, ErrCorruptEntry) {
+		return false, err
+	}
+	if err := p.deleteKey(key); err != nil {
+		return false, err
+	}
+	return entry != nil && !entry.Expired(time.Now()), nil
+}
+
+// deleteKey deletes a key whose lock is held and records the deletion in the change log
+func (p *PebbleDB) deleteKey(key []byte) error {
+	batch := p.db.NewBatch()
+	batch.Delete(key, nil)
+	done := p.logChange(batch, key, p.nextTimestamp(), true)
+	defer done()
+	return batch.Commit(pebble.Sync)
+}
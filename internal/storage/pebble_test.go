@@ -1,11 +1,14 @@
 package storage
 
 import (
+	"errors"
 	"log"
 	"os"
 	"path"
 	"testing"
+	"time"
 
+	"github.com/cockroachdb/pebble"
 	"github.com/stretchr/testify/assert"
 	"github.com/tdevsin/keyforge/internal/logger"
 )
@@ -95,11 +98,351 @@ func TestPebbleDB(t *testing.T) {
 		assert.NoError(t, err, "Failed to write key")
 
 		// Test: Delete the key
-		err = pebbleDB.DeleteKey(key)
+		found, err := pebbleDB.DeleteKey(key)
 		assert.NoError(t, err, "Failed to delete key")
+		assert.True(t, found, "Existing key should be reported as found")
 
 		// Verify: Key should not exist
 		_, err = pebbleDB.ReadKey(key)
 		assert.Error(t, err, "Key should not exist after deletion")
 	})
+
+	// Test CheckWritable
+	t.Run("CheckWritable", func(t *testing.T) {
+		pebbleDB := setupTestDB(t)
+		defer teardownTestDB(t, pebbleDB)
+
+		err := pebbleDB.CheckWritable()
+		assert.NoError(t, err, "Database should be writable")
+
+		// Verify: No key is written
+		iter, err := pebbleDB.db.NewIter(nil)
+		assert.NoError(t, err)
+		assert.False(t, iter.First(), "Health check should not write keys")
+		iter.Close()
+	})
+
+	// Test Scan
+	t.Run("Scan", func(t *testing.T) {
+		pebbleDB := setupTestDB(t)
+		defer teardownTestDB(t, pebbleDB)
+
+		// Setup: Write keys with and without the prefix
+		for _, key := range []string{"a", "user/1", "user/2", "user/3", "users", "v"} {
+			err := pebbleDB.WriteKey([]byte(key), []byte("value-"+key))
+			assert.NoError(t, err, "Failed to write key")
+		}
+		keys := func(items []KeyValue) []string {
+			var keys []string
+			for _, item := range items {
+				keys = append(keys, string(item.Key))
+			}
+			return keys
+		}
+
+		items, more, err := pebbleDB.Scan([]byte("user/"), nil, 2, false)
+		assert.NoError(t, err)
+		assert.True(t, more)
+		assert.Equal(t, []string{"user/1", "user/2"}, keys(items))
+		assert.Equal(t, []byte("value-user/1"), items[0].Value)
+
+		// Test: Continue after the last returned key
+		items, more, err = pebbleDB.Scan([]byte("user/"), []byte("user/2"), 2, true)
+		assert.NoError(t, err)
+		assert.False(t, more)
+		assert.Equal(t, []string{"user/3"}, keys(items))
+		assert.Nil(t, items[0].Value, "Values should not be returned for keys only scans")
+
+		// Test: Scan without prefix
+		items, more, err = pebbleDB.Scan(nil, []byte("user/3"), 10, true)
+		assert.NoError(t, err)
+		assert.False(t, more)
+		assert.Equal(t, []string{"users", "v"}, keys(items))
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	pebbleDB := setupTestDB(t)
+	defer teardownTestDB(t, pebbleDB)
+	key := []byte("test-key")
+
+	t.Run("Creates missing keys", func(t *testing.T) {
+		entry, err := pebbleDB.Update(key, func(current *Entry) (*Entry, error) {
+			assert.Nil(t, current)
+			return &Entry{Value: []byte("1")}, nil
+		})
+		assert.NoError(t, err)
+		assert.NotZero(t, entry.Timestamp, "Writes should get a timestamp")
+	})
+
+	t.Run("Passes the current entry and increases the timestamp", func(t *testing.T) {
+		previous, err := pebbleDB.ReadEntry(key)
+		assert.NoError(t, err)
+		entry, err := pebbleDB.Update(key, func(current *Entry) (*Entry, error) {
+			assert.Equal(t, previous, current)
+			return &Entry{Value: append(current.Value, '2')}, nil
+		})
+		assert.NoError(t, err)
+		assert.Greater(t, entry.Timestamp, previous.Timestamp)
+		value, err := pebbleDB.ReadKey(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("12"), value)
+	})
+
+	t.Run("Errors leave the key unchanged", func(t *testing.T) {
+		failure := errors.New("condition failed")
+		_, err := pebbleDB.Update(key, func(current *Entry) (*Entry, error) {
+			return nil, failure
+		})
+		assert.ErrorIs(t, err, failure)
+		value, err := pebbleDB.ReadKey(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("12"), value)
+	})
+
+	t.Run("Returning nil deletes the key", func(t *testing.T) {
+		_, err := pebbleDB.Update(key, func(current *Entry) (*Entry, error) {
+			return nil, nil
+		})
+		assert.NoError(t, err)
+		_, err = pebbleDB.ReadKey(key)
+		assert.ErrorIs(t, err, pebble.ErrNotFound)
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	pebbleDB := setupTestDB(t)
+	defer teardownTestDB(t, pebbleDB)
+	expired := time.Now().Add(-time.Second).UnixMilli()
+	write := func(key string, expiresAt int64) {
+		_, err := pebbleDB.Update([]byte(key), func(*Entry) (*Entry, error) {
+			return &Entry{Value: []byte("value"), ExpiresAt: expiresAt}, nil
+		})
+		assert.NoError(t, err)
+	}
+	write("expired", expired)
+	write("live", time.Now().Add(time.Hour).UnixMilli())
+	write("overwritten", expired)
+	assert.NoError(t, pebbleDB.WriteKey([]byte("overwritten"), []byte("value")))
+
+	t.Run("Expired keys are not found", func(t *testing.T) {
+		_, err := pebbleDB.ReadKey([]byte("expired"))
+		assert.ErrorIs(t, err, pebble.ErrNotFound)
+		found, err := pebbleDB.DeleteKey([]byte("missing"))
+		assert.NoError(t, err)
+		assert.False(t, found)
+		_, err = pebbleDB.Update([]byte("expired"), func(current *Entry) (*Entry, error) {
+			assert.Nil(t, current, "Expired entries should not be passed to updates")
+			return nil, errors.New("no change")
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("Scans skip expired and internal keys", func(t *testing.T) {
+		items, more, err := pebbleDB.Scan(nil, nil, 10, true)
+		assert.NoError(t, err)
+		assert.False(t, more)
+		assert.Len(t, items, 2)
+		assert.Equal(t, []byte("live"), items[0].Key)
+		assert.Equal(t, []byte("overwritten"), items[1].Key)
+	})
+
+	t.Run("DeleteExpired only deletes keys that still expire", func(t *testing.T) {
+		deleted, err := pebbleDB.DeleteExpired(time.Now(), 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+		_, closer, err := pebbleDB.db.Get([]byte("expired"))
+		assert.ErrorIs(t, err, pebble.ErrNotFound, "Expired key should be removed from disk")
+		if closer != nil {
+			closer.Close()
+		}
+		_, err = pebbleDB.ReadKey([]byte("overwritten"))
+		assert.NoError(t, err)
+
+		iter, err := pebbleDB.db.NewIter(&pebble.IterOptions{LowerBound: expiryPrefix, UpperBound: prefixUpperBound(expiryPrefix)})
+		assert.NoError(t, err)
+		count := 0
+		for valid := iter.First(); valid; valid = iter.Next() {
+			count++
+		}
+		iter.Close()
+		assert.Equal(t, 1, count, "Only the index key of the live key should be left")
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	dbPath := path.Join(".", "testDb")
+	assert.NoError(t, os.RemoveAll(dbPath))
+	defer os.RemoveAll(dbPath)
+
+	// Setup: Write a plain value like before entries had metadata
+	db, err := pebble.Open(dbPath, &pebble.Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Set([]byte("legacy"), []byte("value"), pebble.Sync))
+	assert.NoError(t, db.Close())
+
+	pebbleDB := GetDatabaseInstance(logger.GetLogger(false, "test"), dbPath)
+	value, err := pebbleDB.ReadKey([]byte("legacy"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.NoError(t, pebbleDB.Close())
+
+	// Verify: Entries are not migrated again
+	pebbleDB = GetDatabaseInstance(logger.GetLogger(false, "test"), dbPath)
+	value, err = pebbleDB.ReadKey([]byte("legacy"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.NoError(t, pebbleDB.Close())
+}
+
+func TestMigrateResumes(t *testing.T) {
+	dir := t.TempDir()
+
+	// Setup: A migration stopped after converting the first key
+	db, err := pebble.Open(dir, &pebble.Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Set([]byte("a"), encodeEntry(&Entry{Value: []byte("1"), Timestamp: 1}), pebble.Sync))
+	assert.NoError(t, db.Set([]byte("b"), []byte("2"), pebble.Sync))
+	assert.NoError(t, db.Set(migrationKey, []byte("a"), pebble.Sync))
+	assert.NoError(t, db.Close())
+
+	pebbleDB := GetDatabaseInstance(logger.GetLogger(false, "test"), dir)
+	defer pebbleDB.Close()
+	entry, err := pebbleDB.ReadEntry([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), entry.Value, "converted entries are not wrapped again")
+	assert.Equal(t, int64(1), entry.Timestamp)
+	entry, err = pebbleDB.ReadEntry([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("2"), entry.Value)
+	assert.FileExists(t, path.Join(dir, formatFile))
+	_, closer, err := pebbleDB.db.Get(migrationKey)
+	assert.ErrorIs(t, err, pebble.ErrNotFound, "the progress of the migration is deleted")
+	if closer != nil {
+		closer.Close()
+	}
+}
+
+func TestMigrateRejectsReservedKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	// Setup: Older versions accepted keys starting with a zero byte
+	db, err := pebble.Open(dir, &pebble.Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Set([]byte("\x00legacy"), []byte("1"), pebble.Sync))
+	assert.NoError(t, db.Set([]byte("b"), []byte("2"), pebble.Sync))
+	pebbleDB := &PebbleDB{db: db, path: dir}
+	defer pebbleDB.Close()
+
+	err = pebbleDB.migrate(logger.GetLogger(false, "test"), dir)
+
+	assert.ErrorContains(t, err, `contains 1 keys starting with the reserved byte 0x00`)
+	assert.ErrorContains(t, err, `"\x00legacy"`)
+	assert.NoFileExists(t, path.Join(dir, formatFile))
+	value, closer, err := db.Get([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("2"), value, "no value is converted")
+	closer.Close()
+}
+
+func TestCheckpoint(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(t, db)
+	assert.NoError(t, db.WriteKey([]byte("key"), []byte("value")))
+	dir := path.Join(t.TempDir(), "checkpoint")
+
+	assert.NoError(t, db.Checkpoint(dir))
+	// Writes after the checkpoint are not part of it
+	assert.NoError(t, db.WriteKey([]byte("later"), []byte("value")))
+
+	checkpoint := GetDatabaseInstance(logger.GetLogger(false, "test"), dir)
+	defer checkpoint.Close()
+	entry, err := checkpoint.ReadEntry([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), entry.Value)
+	_, err = checkpoint.ReadKey([]byte("later"))
+	assert.Error(t, err)
+	assert.FileExists(t, path.Join(dir, formatFile))
+
+	assert.Error(t, db.Checkpoint(dir), "existing directories are not overwritten")
+}
+
+func TestForEach(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(t, db)
+	assert.NoError(t, db.WriteKey([]byte("a/2"), []byte("2")))
+	assert.NoError(t, db.WriteKey([]byte("a/1"), []byte("1")))
+	assert.NoError(t, db.WriteKey([]byte("b/1"), []byte("3")))
+	_, err := db.Update([]byte("a/expired"), func(*Entry) (*Entry, error) {
+		return &Entry{Value: []byte("x"), ExpiresAt: time.Now().Add(-time.Second).UnixMilli()}, nil
+	})
+	assert.NoError(t, err)
+
+	t.Run("Iterates over a snapshot of the keys with the prefix", func(t *testing.T) {
+		var keys []string
+		err := db.ForEach([]byte("a/"), func(key []byte, entry *Entry) error {
+			keys = append(keys, string(key)+"="+string(entry.Value))
+			// Writes during the iteration are not seen
+			return db.WriteKey([]byte("a/3"), []byte("3"))
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a/1=1", "a/2=2"}, keys)
+	})
+
+	t.Run("Stops at the first error", func(t *testing.T) {
+		calls := 0
+		err := db.ForEach(nil, func(key []byte, entry *Entry) error {
+			calls++
+			return errors.New("stop")
+		})
+
+		assert.EqualError(t, err, "stop")
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestWriteBatch(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(t, db)
+	expiresAt := time.Now().Add(time.Hour).UnixMilli()
+
+	err := db.WriteBatch(
+		[][]byte{[]byte("k1"), []byte("k2")},
+		[]*Entry{{Value: []byte("v1"), Flags: 7}, {Value: []byte("v2"), ExpiresAt: expiresAt}},
+	)
+
+	assert.NoError(t, err)
+	first, err := db.ReadEntry([]byte("k1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), first.Value)
+	assert.Equal(t, uint32(7), first.Flags)
+	second, err := db.ReadEntry([]byte("k2"))
+	assert.NoError(t, err)
+	assert.Equal(t, expiresAt, second.ExpiresAt)
+	assert.Greater(t, second.Timestamp, first.Timestamp)
+
+	assert.Error(t, db.WriteBatch([][]byte{[]byte("k1")}, nil))
+}
+
+func TestEntryEncoding(t *testing.T) {
+	entry := &Entry{Value: []byte("value"), Timestamp: 42, ExpiresAt: 7, Flags: 3}
+	decoded, err := decodeEntry(encodeEntry(entry))
+	assert.NoError(t, err)
+	assert.Equal(t, entry, decoded)
+
+	// Entries of format 1 have no flags
+	decoded, err = decodeEntry(append([]byte{1, 0, 0, 0, 0, 0, 0, 0, 42, 0, 0, 0, 0, 0, 0, 0, 7}, "value"...))
+	assert.NoError(t, err)
+	assert.Equal(t, &Entry{Value: []byte("value"), Timestamp: 42, ExpiresAt: 7}, decoded)
+
+	_, err = decodeEntry([]byte("value"))
+	assert.ErrorIs(t, err, ErrCorruptEntry)
+}
+
+func TestPrefixUpperBound(t *testing.T) {
+	assert.Equal(t, []byte("b"), prefixUpperBound([]byte("a")))
+	assert.Equal(t, []byte("b"), prefixUpperBound([]byte{'a', 0xff}))
+	assert.Nil(t, prefixUpperBound([]byte{0xff}))
+	assert.Nil(t, prefixUpperBound(nil))
 }
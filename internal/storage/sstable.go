@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// tableFormat is the format of the SSTables written for ingestion. It is the newest format every
+// Pebble format version accepts, so tables can be ingested by any database.
+const tableFormat = sstable.TableFormatRocksDBv2
+
+// ErrInvalidTable is returned when an SSTable can not be ingested because of its content
+var ErrInvalidTable = errors.New("invalid SSTable")
+
+// TableWriter writes entries into an SSTable that a database ingests. Keys must be added in
+// strictly ascending order.
+type TableWriter struct {
+	w    *sstable.Writer
+	keys int
+}
+
+// NewTableWriter creates an SSTable at path
+func NewTableWriter(path string) (*TableWriter, error) {
+	w, err := newTableWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TableWriter{w: w}, nil
+}
+
+func newTableWriter(path string) (*sstable.Writer, error) {
+	f, err := vfs.Default.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+		TableFormat: tableFormat,
+		Comparer:    pebble.DefaultComparer,
+	}), nil
+}
+
+// Add appends the entry of a key. The entry should have a timestamp, as it is stored unchanged.
+func (t *TableWriter) Add(key []byte, entry *Entry) error {
+	if len(key) == 0 || IsInternalKey(key) {
+		return fmt.Errorf("key %q is reserved", key)
+	}
+	if err := t.w.Set(key, encodeEntry(entry)); err != nil {
+		return err
+	}
+	t.keys++
+	return nil
+}
+
+// Keys returns the number of keys added
+func (t *TableWriter) Keys() int {
+	return t.keys
+}
+
+// Close finishes the table and syncs it to disk
+func (t *TableWriter) Close() error {
+	return t.w.Close()
+}
+
+// Ingest adds the keys of an SSTable written by a TableWriter to the Pebble database, bypassing the
+// memtable and the write-ahead log. Every entry is decoded and passed to check before anything is
+// ingested, so a table is either ingested completely or not at all. The expiry index and the
+// change log records of the keys are written into a second table ingested along. It returns the
+// number of ingested keys.
+//
+// Ingested keys replace the entries of existing keys. The file is linked into the database, or
+// copied if it is on another file system, and may be removed afterward.
+func (p *PebbleDB) Ingest(path string, check func(key []byte) error) (int, error) {
+	var expiries, changes [][]byte
+	var latest int64
+	keys := 0
+	err := readTable(path, func(key []byte, entry *Entry) error {
+		if err := check(key); err != nil {
+			return err
+		}
+		if entry.ExpiresAt != 0 {
+			expiries = append(expiries, expiryKey(entry.ExpiresAt, key))
+		}
+		if p.changes != nil {
+			changes = append(changes, encodeChange(key, entry.Timestamp, false))
+		}
+		latest = max(latest, entry.Timestamp)
+		keys++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Index keys sort before change records, and both before every key, so the tables do not overlap
+	slices.SortFunc(expiries, bytes.Compare)
+	internal := make([]KeyValue, 0, len(expiries)+len(changes))
+	for _, key := range expiries {
+		internal = append(internal, KeyValue{Key: key})
+	}
+	if len(changes) > 0 {
+		first := p.changes.begin(len(changes))
+		defer p.changes.end(first)
+		for i, record := range changes {
+			internal = append(internal, KeyValue{Key: changeKey(first + uint64(i)), Value: record})
+		}
+	}
+	paths := []string{path}
+	if len(internal) > 0 {
+		index := path + ".internal"
+		if err := writeInternalTable(index, internal); err != nil {
+			return 0, err
+		}
+		defer os.Remove(index)
+		paths = append(paths, index)
+	}
+
+	// Holding every lock keeps updates from reading an entry before the ingestion and writing it after
+	for i := range p.locks {
+		p.locks[i].Lock()
+		defer p.locks[i].Unlock()
+	}
+	if err := p.db.Ingest(paths); err != nil {
+		return 0, err
+	}
+	p.observeTimestamp(latest)
+	return keys, nil
+}
+
+// readTable verifies every entry of an SSTable and passes it to fn. The key is only valid during the call.
+func readTable(path string, fn func(key []byte, entry *Entry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	readable, err := sstable.NewSimpleReadable(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	reader, err := sstable.NewReader(readable, sstable.ReaderOptions{Comparer: pebble.DefaultComparer})
+	if err != nil {
+		readable.Close()
+		return fmt.Errorf("%w: %v", ErrInvalidTable, err)
+	}
+	defer reader.Close()
+	if props := reader.Properties; props.NumDeletions > 0 || props.NumRangeKeys() > 0 || props.NumMergeOperands > 0 {
+		return fmt.Errorf("%w: it contains other records than keys", ErrInvalidTable)
+	}
+
+	iter, err := reader.NewIter(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		key := k.UserKey
+		if k.Kind() != pebble.InternalKeyKindSet || len(key) == 0 || IsInternalKey(key) {
+			return fmt.Errorf("%w: key %q can not be stored", ErrInvalidTable, key)
+		}
+		value, _, err := v.Value(nil)
+		if err != nil {
+			return err
+		}
+		entry, err := decodeEntry(value)
+		if err != nil {
+			return fmt.Errorf("%w: the value of key %q is not an entry", ErrInvalidTable, key)
+		}
+		if err := fn(key, entry); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// writeInternalTable writes sorted internal keys and their values into an SSTable
+func writeInternalTable(path string, items []KeyValue) error {
+	w, err := newTableWriter(path)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := w.Set(item.Key, item.Value); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTable writes an SSTable of the entries of keys added in order
+func writeTable(t *testing.T, keys []string, entries []*Entry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.sst")
+	w, err := NewTableWriter(path)
+	require.NoError(t, err)
+	for i, key := range keys {
+		require.NoError(t, w.Add([]byte(key), entries[i]))
+	}
+	assert.Equal(t, len(keys), w.Keys())
+	require.NoError(t, w.Close())
+	return path
+}
+
+func TestIngest(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(t, db)
+	assert.NoError(t, db.WriteKey([]byte("a"), []byte("old")))
+	now := time.Now()
+	timestamp := now.Add(time.Hour).UnixNano()
+
+	path := writeTable(t, []string{"a", "b", "c"}, []*Entry{
+		{Value: []byte("1"), Timestamp: timestamp, Flags: 5},
+		{Value: []byte("2"), Timestamp: timestamp, ExpiresAt: now.Add(-time.Second).UnixMilli()},
+		{Value: []byte("3"), Timestamp: timestamp},
+	})
+	var checked []string
+	keys, err := db.Ingest(path, func(key []byte) error {
+		checked = append(checked, string(key))
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, keys)
+	assert.Equal(t, []string{"a", "b", "c"}, checked)
+	entry, err := db.ReadEntry([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, &Entry{Value: []byte("1"), Timestamp: timestamp, Flags: 5}, entry)
+	_, err = db.ReadKey([]byte("b"))
+	assert.ErrorIs(t, err, pebble.ErrNotFound)
+
+	t.Run("The expiry index of ingested keys is written", func(t *testing.T) {
+		deleted, err := db.DeleteExpired(now, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+	})
+
+	t.Run("Later writes get a later timestamp", func(t *testing.T) {
+		written, err := db.Update([]byte("c"), func(*Entry) (*Entry, error) { return &Entry{Value: []byte("4")}, nil })
+		assert.NoError(t, err)
+		assert.Greater(t, written.Timestamp, timestamp)
+	})
+}
+
+func TestIngestRejected(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(t, db)
+	errNotOwned := errors.New("not owned")
+
+	path := writeTable(t, []string{"a", "b"}, []*Entry{{Value: []byte("1")}, {Value: []byte("2")}})
+	_, err := db.Ingest(path, func(key []byte) error {
+		if string(key) == "b" {
+			return errNotOwned
+		}
+		return nil
+	})
+
+	assert.ErrorIs(t, err, errNotOwned)
+	_, err = db.ReadKey([]byte("a"))
+	assert.ErrorIs(t, err, pebble.ErrNotFound, "no key of a rejected table is ingested")
+
+	t.Run("Values that are not entries", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "raw.sst")
+		w, err := newTableWriter(path)
+		require.NoError(t, err)
+		require.NoError(t, w.Set([]byte("a"), []byte("raw")))
+		require.NoError(t, w.Close())
+
+		_, err = db.Ingest(path, func([]byte) error { return nil })
+		assert.ErrorIs(t, err, ErrInvalidTable)
+	})
+
+	t.Run("Files that are not tables", func(t *testing.T) {
+		_, err := db.Ingest("sstable_test.go", func([]byte) error { return nil })
+		assert.ErrorIs(t, err, ErrInvalidTable)
+	})
+
+	t.Run("Internal keys can not be added", func(t *testing.T) {
+		w, err := NewTableWriter(filepath.Join(t.TempDir(), "internal.sst"))
+		require.NoError(t, err)
+		defer w.Close()
+		assert.Error(t, w.Add(expiryKey(1, []byte("a")), &Entry{}))
+	})
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/tdevsin/keyforge/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts a child span of ctx for an operation on the database
+func startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "pebble."+operation,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attribute.String("db.system", "pebble"), attribute.String("db.operation", operation)),
+	)
+}
+
+// ReadKey reads a key from db in a child span of ctx. A missing key is not recorded as an error.
+func ReadKey(ctx context.Context, db Database, key []byte) ([]byte, error) {
+	_, span := startSpan(ctx, "ReadKey")
+	value, err := db.ReadKey(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		span.SetAttributes(attribute.Bool("db.found", false))
+		tracing.End(span, nil)
+	} else {
+		tracing.End(span, err)
+	}
+	return value, err
+}
+
+// WriteKey writes a key to db in a child span of ctx
+func WriteKey(ctx context.Context, db Database, key, value []byte) error {
+	_, span := startSpan(ctx, "WriteKey")
+	err := db.WriteKey(key, value)
+	tracing.End(span, err)
+	return err
+}
+
+// DeleteKey deletes a key from db in a child span of ctx
+func DeleteKey(ctx context.Context, db Database, key []byte) (bool, error) {
+	_, span := startSpan(ctx, "DeleteKey")
+	found, err := db.DeleteKey(key)
+	span.SetAttributes(attribute.Bool("db.found", found))
+	tracing.End(span, err)
+	return found, err
+}
+
+// ReadEntry reads the entry of a key from db in a child span of ctx. A missing key is not recorded as an error.
+func ReadEntry(ctx context.Context, db Database, key []byte) (*Entry, error) {
+	_, span := startSpan(ctx, "ReadEntry")
+	entry, err := db.ReadEntry(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		span.SetAttributes(attribute.Bool("db.found", false))
+		tracing.End(span, nil)
+	} else {
+		tracing.End(span, err)
+	}
+	return entry, err
+}
+
+// Update updates a key of db in a child span of ctx
+func Update(ctx context.Context, db Database, key []byte, fn func(current *Entry) (*Entry, error)) (*Entry, error) {
+	_, span := startSpan(ctx, "Update")
+	entry, err := db.Update(key, fn)
+	tracing.End(span, err)
+	return entry, err
+}
+
+// Scan scans db in a child span of ctx
+func Scan(ctx context.Context, db Database, prefix, startAfter []byte, limit int, keysOnly bool) ([]KeyValue, bool, error) {
+	_, span := startSpan(ctx, "Scan")
+	items, more, err := db.Scan(prefix, startAfter, limit, keysOnly)
+	span.SetAttributes(attribute.Int("db.keys", len(items)))
+	tracing.End(span, err)
+	return items, more, err
+}
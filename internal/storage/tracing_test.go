@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracedOperations(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	db := new(MockDatabase)
+	db.On("WriteKey", []byte("a"), []byte("1")).Return(nil)
+	db.On("ReadKey", []byte("a")).Return([]byte("1"), nil)
+	db.On("ReadKey", []byte("missing")).Return([]byte(nil), pebble.ErrNotFound)
+	db.On("DeleteKey", []byte("a")).Return(false, errors.New("disk failure"))
+
+	assert.NoError(t, WriteKey(ctx, db, []byte("a"), []byte("1")))
+	value, err := ReadKey(ctx, db, []byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+	_, err = ReadKey(ctx, db, []byte("missing"))
+	assert.ErrorIs(t, err, pebble.ErrNotFound)
+	_, err = DeleteKey(ctx, db, []byte("a"))
+	assert.Error(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 5)
+	for i, name := range []string{"pebble.WriteKey", "pebble.ReadKey", "pebble.ReadKey", "pebble.DeleteKey"} {
+		assert.Equal(t, name, spans[i].Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), spans[i].Parent().SpanID())
+	}
+	assert.Equal(t, codes.Unset, spans[2].Status().Code, "missing keys are not errors")
+	assert.Equal(t, codes.Error, spans[3].Status().Code)
+}
@@ -0,0 +1,141 @@
+// Package tracing exports OpenTelemetry traces of requests, including the hops of requests
+// proxied to the node owning a key
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tdevsin/keyforge/internal/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/stats"
+)
+
+// Accepted values of the exporter setting
+const (
+	ExporterNone   = "none"   // Tracing is disabled
+	ExporterOTLP   = "otlp"   // Spans are sent to an OpenTelemetry collector over gRPC
+	ExporterStdout = "stdout" // Spans are written as JSON to stdout or a file, for local debugging
+)
+
+// instrumentationName identifies the spans created by keyforge itself
+const instrumentationName = "github.com/tdevsin/keyforge"
+
+// Options controls where spans are exported to
+type Options struct {
+	Exporter    string  // Exporter is one of ExporterNone, ExporterOTLP or ExporterStdout
+	Endpoint    string  // Endpoint is the address of the OTLP collector
+	Insecure    bool    // Insecure disables TLS to the OTLP collector
+	File        string  // File receives the spans of the stdout exporter. Spans are written to stdout if empty
+	SampleRatio float64 // SampleRatio is the fraction of requests that are traced, unless the caller already decided
+	NodeID      string  // NodeID identifies the node in the resource of every span
+}
+
+// Setup installs a global tracer provider exporting spans as configured, and the W3C trace
+// context propagator. The returned function flushes pending spans and stops the exporter.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	exporter, closeOutput, err := newExporter(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewSchemaless(
+		semconv.ServiceName("keyforge"),
+		semconv.ServiceInstanceID(opts.NodeID),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Spans of proxied requests follow the decision of the node that received the request
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		return errors.Join(provider.Shutdown(ctx), closeOutput())
+	}, nil
+}
+
+// newExporter returns the exporter of the options and a function closing its output
+func newExporter(ctx context.Context, opts Options) (sdktrace.SpanExporter, func() error, error) {
+	noClose := func() error { return nil }
+	switch opts.Exporter {
+	case ExporterOTLP:
+		clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.Endpoint)}
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		return exporter, noClose, nil
+	case ExporterStdout:
+		var output io.Writer = os.Stdout
+		closeOutput := noClose
+		if opts.File != "" {
+			file, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to open trace file: %w", err)
+			}
+			output, closeOutput = file, file.Close
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(output))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		return exporter, closeOutput, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+}
+
+// untracedServices are called periodically by other nodes and would drown the traces of requests
+var untracedServices = map[string]bool{
+	proto.ClusterService_ServiceDesc.ServiceName: true,
+	proto.HealthService_ServiceDesc.ServiceName:  true,
+}
+
+// traced reports whether spans are created for a gRPC call. Methods are named /<service>/<method>
+func traced(info *stats.RPCTagInfo) bool {
+	service, _, _ := strings.Cut(strings.TrimPrefix(info.FullMethodName, "/"), "/")
+	return !untracedServices[service]
+}
+
+// ServerHandler creates a span for every incoming request, continuing the trace of the caller
+func ServerHandler() stats.Handler {
+	return otelgrpc.NewServerHandler(otelgrpc.WithFilter(traced))
+}
+
+// ClientHandler creates a span for every outgoing request and sends its context with the request
+func ClientHandler() stats.Handler {
+	return otelgrpc.NewClientHandler(otelgrpc.WithFilter(traced))
+}
+
+// Start starts a child span of the span in ctx. Spans are dropped if tracing is disabled.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End records the error of a span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
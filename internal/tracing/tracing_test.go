@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc/stats"
+)
+
+func TestTraced(t *testing.T) {
+	tests := []struct {
+		method string
+		want   bool
+	}{
+		{method: proto.KeyService_GetKey_FullMethodName, want: true},
+		{method: proto.ClusterService_ExchangeDigest_FullMethodName, want: false},
+		{method: proto.HealthService_CheckHealth_FullMethodName, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			assert.Equal(t, tt.want, traced(&stats.RPCTagInfo{FullMethodName: tt.method}))
+		})
+	}
+}
+
+func TestSetup(t *testing.T) {
+	t.Run("Writes spans to a file", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "spans.json")
+		shutdown, err := Setup(context.Background(), Options{Exporter: ExporterStdout, File: file, SampleRatio: 1, NodeID: "node1"})
+		assert.NoError(t, err)
+
+		_, span := Start(context.Background(), "test-span")
+		End(span, nil)
+		assert.NoError(t, shutdown(context.Background()))
+
+		data, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "test-span")
+		assert.Contains(t, string(data), "node1")
+	})
+
+	t.Run("Rejects unknown exporters", func(t *testing.T) {
+		_, err := Setup(context.Background(), Options{Exporter: "zipkin"})
+		assert.ErrorContains(t, err, "unknown trace exporter")
+	})
+}
@@ -0,0 +1,308 @@
+package test
+
+import (
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tdevsin/keyforge/internal/logger"
+	"github.com/tdevsin/keyforge/internal/storage"
+)
+
+// runCLI runs a client command of the application and returns its output
+func runCLI(t *testing.T, stdin string, args ...string) (string, error) {
+	cmd := exec.Command(appBinary, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestKVCommands(t *testing.T) {
+	_, cleanup := runApp(t)
+	defer cleanup()
+
+	t.Run("Should write and read keys", func(t *testing.T) {
+		_, err := runCLI(t, "", "kv", "set", "cli/1", "alice")
+		assert.NoError(t, err)
+		_, err = runCLI(t, "bob", "kv", "set", "cli/2")
+		assert.NoError(t, err)
+
+		out, err := runCLI(t, "", "kv", "get", "cli/1")
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", out)
+		out, err = runCLI(t, "", "kv", "get", "cli/2", "-o", "hex")
+		assert.NoError(t, err)
+		assert.Equal(t, "626f62\n", out)
+	})
+
+	t.Run("Should scan keys", func(t *testing.T) {
+		out, err := runCLI(t, "", "kv", "scan", "--prefix", "cli/")
+		assert.NoError(t, err)
+		assert.Equal(t, "cli/1\talice\ncli/2\tbob\n", out)
+		out, err = runCLI(t, "", "kv", "scan", "--prefix", "cli/", "--limit", "1", "-o", "json")
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"key":"cli/1","value":"YWxpY2U="}`, out)
+	})
+
+	t.Run("Should delete keys", func(t *testing.T) {
+		out, err := runCLI(t, "", "kv", "delete", "cli/1", "cli/1")
+		assert.NoError(t, err)
+		assert.Equal(t, "cli/1: deleted\ncli/1: not found\n", out)
+		out, err = runCLI(t, "", "kv", "get", "cli/1")
+		assert.Error(t, err)
+		assert.Contains(t, out, "NotFound: Key not found")
+	})
+
+	t.Run("Should fail over to the next server", func(t *testing.T) {
+		out, err := runCLI(t, "", "kv", "get", "cli/2", "--server", "localhost:1,localhost:8080")
+		assert.NoError(t, err)
+		assert.Equal(t, "bob", out)
+	})
+}
+
+func TestCheckCommand(t *testing.T) {
+	_, cleanup := runApp(t)
+	defer cleanup()
+
+	t.Run("Should pass for a healthy node", func(t *testing.T) {
+		out, err := runCLI(t, "", "check")
+		assert.NoError(t, err)
+		assert.Contains(t, out, "All 1 nodes agree on cluster version")
+		assert.Contains(t, out, "100.0%")
+	})
+
+	t.Run("Should fail if no node is reachable", func(t *testing.T) {
+		_, err := runCLI(t, "", "check", "--server", "localhost:1")
+		assert.Error(t, err)
+	})
+}
+
+func TestClusterCommands(t *testing.T) {
+	_, cleanup := runApp(t)
+	defer cleanup()
+
+	t.Run("Should print the cluster status", func(t *testing.T) {
+		out, err := runCLI(t, "", "cluster", "status")
+		assert.NoError(t, err)
+		assert.Contains(t, out, "1 HEALTHY")
+		assert.Contains(t, out, "Ring owners:    1")
+	})
+
+	t.Run("Should list members and ring ranges", func(t *testing.T) {
+		out, err := runCLI(t, "", "cluster", "members")
+		assert.NoError(t, err)
+		assert.Contains(t, out, "localhost:8080")
+		assert.Contains(t, out, "HEALTHY")
+
+		out, err = runCLI(t, "", "cluster", "ring", "-o", "json")
+		assert.NoError(t, err)
+		var ring struct {
+			Ranges []struct {
+				Address   string  `json:"address"`
+				Ownership float64 `json:"ownership"`
+			} `json:"ranges"`
+		}
+		assert.NoError(t, json.Unmarshal([]byte(out), &ring))
+		assert.Len(t, ring.Ranges, 1)
+		assert.Equal(t, 1.0, ring.Ranges[0].Ownership)
+	})
+
+	t.Run("Should refuse to remove unknown nodes", func(t *testing.T) {
+		out, err := runCLI(t, "", "cluster", "remove", "unknown")
+		assert.Error(t, err)
+		assert.Contains(t, out, "NotFound: Node is not a member of the cluster")
+	})
+}
+
+func TestShellCommand(t *testing.T) {
+	_, cleanup := runApp(t)
+	defer cleanup()
+
+	t.Run("Should run commands read from stdin", func(t *testing.T) {
+		out, err := runCLI(t, "use shell\nset a \"hello world\"\nget a\nscan\nuse\nget shell/a\ndel shell/a\nexit\nget shell/a\n", "shell")
+		assert.NoError(t, err)
+		assert.Contains(t, out, "Using namespace shell, keys are prefixed with shell/\nOK\n")
+		assert.Contains(t, out, "hello world\n(")
+		assert.Contains(t, out, "a  hello world\n1 keys\n")
+		assert.Contains(t, out, "Deleted 1 of 1 keys\n")
+		assert.Equal(t, 3, strings.Count(out, "hello world\n"), "commands after exit must not run")
+	})
+
+	t.Run("Should report errors and keep running", func(t *testing.T) {
+		out, err := runCLI(t, "get missing/key\nfoo\nget\n", "shell")
+		assert.NoError(t, err)
+		assert.Contains(t, out, "Error: NotFound: Key not found\n")
+		assert.Contains(t, out, `Error: unknown command "foo"`)
+		assert.Contains(t, out, "Usage: get <key>\n")
+	})
+}
+
+func TestBenchCommand(t *testing.T) {
+	_, cleanup := runApp(t)
+	defer cleanup()
+
+	t.Run("Should report every request", func(t *testing.T) {
+		out, err := runCLI(t, "", "bench", "--requests", "200", "--keys", "50", "--preload", "--mix", "read=1", "--key-prefix", "bench-test/", "-o", "json")
+		assert.NoError(t, err)
+		var report struct {
+			Requests   int `json:"requests"`
+			Errors     int `json:"errors"`
+			Misses     int `json:"misses"`
+			Operations map[string]struct {
+				Requests int `json:"requests"`
+			} `json:"operations"`
+		}
+		// Preloading reports progress before the JSON report
+		assert.NoError(t, json.Unmarshal([]byte(out[strings.Index(out, "{"):]), &report))
+		assert.Equal(t, 200, report.Requests)
+		assert.Equal(t, 0, report.Errors)
+		assert.Equal(t, 0, report.Misses, "preloaded keys must be found")
+		assert.Equal(t, 200, report.Operations["read"].Requests)
+	})
+
+	t.Run("Should reject invalid options", func(t *testing.T) {
+		out, err := runCLI(t, "", "bench", "--distribution", "normal")
+		assert.Error(t, err)
+		assert.Contains(t, out, `invalid key distribution "normal"`)
+	})
+}
+
+func TestBackupCommand(t *testing.T) {
+	secret, cleanup := runAuthenticatedApp(t)
+	defer cleanup()
+	_, err := runCLI(t, "", "kv", "set", "backup/1", "alice")
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "node.tar.gz")
+
+	t.Run("Should write a verified backup", func(t *testing.T) {
+		out, err := runCLI(t, "", append([]string{"backup", archive}, secret...)...)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "Backed up node ")
+		assert.FileExists(t, archive)
+
+		out, err = runCLI(t, "", append([]string{"backup", archive}, secret...)...)
+		assert.Error(t, err)
+		assert.Contains(t, out, "already exists")
+	})
+
+	t.Run("Should require the cluster secret", func(t *testing.T) {
+		out, err := runCLI(t, "", "backup", filepath.Join(dir, "unauthenticated"))
+		assert.Error(t, err)
+		assert.Contains(t, out, "Unauthenticated")
+	})
+
+	t.Run("Should restore the backup into a stopped node", func(t *testing.T) {
+		root := filepath.Join(dir, "restored")
+		out, err := runCLI(t, "", "restore", archive, "--data-dir", root)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "Restored node ")
+
+		out, err = runCLI(t, "", "restore", archive, "--data-dir", root)
+		assert.Error(t, err)
+		assert.Contains(t, out, "pass --force to replace it")
+
+		db := storage.GetDatabaseInstance(logger.GetLogger(false, "test"), filepath.Join(root, "data"))
+		defer db.Close()
+		value, err := db.ReadKey([]byte("backup/1"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("alice"), value)
+	})
+
+	t.Run("Should not restore into a running node", func(t *testing.T) {
+		out, err := runCLI(t, "", "restore", archive, "--force")
+		assert.Error(t, err)
+		assert.Contains(t, out, "is another process using it?")
+	})
+}
+
+func TestExportImportCommands(t *testing.T) {
+	secret, cleanup := runAuthenticatedApp(t)
+	defer cleanup()
+	for _, key := range []string{"export/1", "export/2"} {
+		_, err := runCLI(t, "", "kv", "set", key, "value of "+key)
+		assert.NoError(t, err)
+	}
+	dir := t.TempDir()
+
+	for _, file := range []string{"keys.jsonl", "keys.pb"} {
+		t.Run("Should export and import keys as "+file, func(t *testing.T) {
+			path := filepath.Join(dir, file)
+			out, err := runCLI(t, "", append([]string{"export", path, "--prefix", "export/"}, secret...)...)
+			assert.NoError(t, err)
+			assert.Contains(t, out, "Exported 2 keys from 1 nodes")
+
+			_, err = runCLI(t, "", "kv", "delete", "export/1")
+			assert.NoError(t, err)
+			out, err = runCLI(t, "", append([]string{"import", path}, secret...)...)
+			assert.NoError(t, err)
+			assert.Contains(t, out, "Imported 2 keys into 1 nodes")
+
+			out, err = runCLI(t, "", "kv", "get", "export/1")
+			assert.NoError(t, err)
+			assert.Equal(t, "value of export/1", out)
+		})
+	}
+
+	t.Run("Should write JSON lines to stdout", func(t *testing.T) {
+		out, err := runCLI(t, "", append([]string{"export", "-", "--prefix", "export/2"}, secret...)...)
+		assert.NoError(t, err)
+		var entry map[string]string
+		assert.NoError(t, json.Unmarshal([]byte(strings.SplitN(out, "\n", 2)[0]), &entry))
+		assert.Equal(t, "export/2", entry["key"])
+		assert.Equal(t, "dmFsdWUgb2YgZXhwb3J0LzI=", entry["value"])
+	})
+
+	t.Run("Should require the cluster secret", func(t *testing.T) {
+		out, err := runCLI(t, "", "export", "-")
+		assert.Error(t, err)
+		assert.Contains(t, out, "Unauthenticated")
+	})
+
+	t.Run("Should reject invalid files", func(t *testing.T) {
+		out, err := runCLI(t, "not json\n", append([]string{"import", "-"}, secret...)...)
+		assert.Error(t, err)
+		assert.Contains(t, out, "invalid entry on line 1")
+	})
+}
+
+func TestLoadCommand(t *testing.T) {
+	secret, cleanup := runAuthenticatedApp(t)
+	defer cleanup()
+	_, err := runCLI(t, "", "kv", "set", "load/1", "old")
+	assert.NoError(t, err)
+
+	t.Run("Should ingest the keys of a file", func(t *testing.T) {
+		keys := `{"key":"load/1","value":"bmV3"}
+{"key":"load/2","value":"YQ=="}
+{"key":"load/2","value":"Yg=="}
+{"key":"load/3","value":"Yw==","expires_at":"1"}
+`
+		out, err := runCLI(t, keys, append([]string{"load", "-"}, secret...)...)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "Loaded 2 keys into 1 nodes in 1 SSTables")
+		assert.Contains(t, out, "(1 expired)")
+
+		out, err = runCLI(t, "", "kv", "get", "load/1")
+		assert.NoError(t, err)
+		assert.Equal(t, "new", out)
+		out, err = runCLI(t, "", "kv", "get", "load/2")
+		assert.NoError(t, err)
+		assert.Equal(t, "b", out, "the last value of a key in the file wins")
+	})
+
+	t.Run("Should require the cluster secret", func(t *testing.T) {
+		out, err := runCLI(t, "{\"key\":\"load/4\"}\n", "load", "-")
+		assert.Error(t, err)
+		assert.Contains(t, out, "Unauthenticated")
+	})
+
+	t.Run("Should reject internal keys", func(t *testing.T) {
+		out, err := runCLI(t, "{\"key\":\"\\u0000exp/a\"}\n", append([]string{"load", "-"}, secret...)...)
+		assert.Error(t, err)
+		assert.Contains(t, out, "is invalid")
+	})
+}
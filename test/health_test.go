@@ -5,6 +5,9 @@ import (
 	"testing"
 
 	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -31,3 +34,28 @@ func TestHealth(t *testing.T) {
 		}
 	})
 }
+
+// TestStandardHealth tests the grpc.health.v1 service used by orchestrators and load balancers
+func TestStandardHealth(t *testing.T) {
+	_, cleanup := runApp(t)
+	defer cleanup()
+	conn := getGrpcConnection()
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	for _, service := range []string{"", "liveness", "readiness", "KeyService"} {
+		t.Run("Should report "+service+" as serving once the node joined", func(t *testing.T) {
+			resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+			if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+				t.Errorf("Expected %v, Got: %v, %v", healthpb.HealthCheckResponse_SERVING, resp, err)
+			}
+		})
+	}
+
+	t.Run("Should return not found for unknown services", func(t *testing.T) {
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("Expected %v, Got: %v", codes.NotFound, err)
+		}
+	})
+}
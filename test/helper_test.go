@@ -6,13 +6,13 @@ import (
 	"context"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
 	"time"
 
-	"github.com/tdevsin/keyforge/internal/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/protobuf/types/known/emptypb"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var appBinary = "./keyforge"
@@ -34,9 +34,10 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-// runApp start and stop the server for each test
-func runApp(t *testing.T) (*exec.Cmd, func()) {
-	cmd := exec.Command(appBinary, "start", "--address", "localhost:8080", "--env", "dev")
+// runApp start and stop the server for each test. env is added to the environment of the server.
+func runApp(t *testing.T, env ...string) (*exec.Cmd, func()) {
+	cmd := exec.Command(appBinary, "start", "--advertise", "localhost:8080", "--env", "dev")
+	cmd.Env = append(os.Environ(), env...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -46,13 +47,13 @@ func runApp(t *testing.T) (*exec.Cmd, func()) {
 		t.Fatalf("Failed to start the application: %v", err)
 	}
 
-	// Wait for the server to start with 10 retries by using health endpoint
+	// Wait for the server to be ready with 10 retries by using the standard health endpoint
 	for i := 0; i < 10; i++ {
-		// We call the health endpoint to check if the server is running
+		// We call the health endpoint to check if the server has joined the cluster
 		conn, _ := grpc.NewClient("localhost:8080", grpc.WithTransportCredentials(insecure.NewCredentials()))
-		client := proto.NewHealthServiceClient(conn)
-		_, e := client.CheckHealth(context.Background(), &emptypb.Empty{})
-		if e != nil {
+		client := healthpb.NewHealthClient(conn)
+		resp, e := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		if e != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
 			// If the server is not running, wait for 2 seconds and try again
 			t.Log("Waiting for the application to start")
 			conn.Close()
@@ -75,6 +76,17 @@ func runApp(t *testing.T) (*exec.Cmd, func()) {
 	return cmd, cleanup
 }
 
+// runAuthenticatedApp starts the server authenticating nodes with a cluster secret, and returns the
+// client flags sending the secret
+func runAuthenticatedApp(t *testing.T) ([]string, func()) {
+	secretFile := filepath.Join(t.TempDir(), "cluster.secret")
+	if err := os.WriteFile(secretFile, []byte(clusterSecret), 0600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+	_, cleanup := runApp(t, "KEYFORGE_INTERNAL_AUTH=secret", "KEYFORGE_INTERNAL_PLAINTEXT_SECRET=true", "KEYFORGE_INTERNAL_SECRET_FILE="+secretFile)
+	return []string{"--cluster-secret-file", secretFile, "--cluster-secret-plaintext"}, cleanup
+}
+
 // getGrpcConnection returns a new GRPC connection with a local instance of the server
 func getGrpcConnection() (conn *grpc.ClientConn) {
 	conn, err := grpc.NewClient("localhost:8080", grpc.WithTransportCredentials(insecure.NewCredentials()))
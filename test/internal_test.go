@@ -0,0 +1,110 @@
+package test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const clusterSecret = "integration-test-cluster-secret"
+
+// startInternalNode starts a node serving cluster traffic on a separate port authenticated with a shared secret
+func startInternalNode(t *testing.T, secretFile, advertise, internal string, extraArgs ...string) {
+	args := append([]string{"start",
+		"--advertise", advertise,
+		"--internal-advertise", internal,
+		"--data-dir", t.TempDir(),
+		"--gossip-interval", "1s",
+	}, extraArgs...)
+	cmd := exec.Command(appBinary, args...)
+	cmd.Env = append(os.Environ(), "KEYFORGE_INTERNAL_AUTH=secret", "KEYFORGE_INTERNAL_PLAINTEXT_SECRET=true", "KEYFORGE_INTERNAL_SECRET_FILE="+secretFile)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start the application: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+}
+
+// clusterState calls GetClusterState, sending the secret if it is not empty
+func clusterState(t *testing.T, addr, secret string) (*proto.ClusterState, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if secret != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "keyforge-cluster-secret", secret)
+	}
+	return proto.NewClusterServiceClient(conn).GetClusterState(ctx, &emptypb.Empty{})
+}
+
+// TestInternalPort tests that nodes use a separate authenticated port for cluster traffic
+func TestInternalPort(t *testing.T) {
+	secretFile := t.TempDir() + "/cluster.secret"
+	if err := os.WriteFile(secretFile, []byte(clusterSecret), 0600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+
+	startInternalNode(t, secretFile, "localhost:8092", "localhost:9092")
+	waitFor(t, func() bool {
+		_, err := clusterState(t, "localhost:9092", clusterSecret)
+		return err == nil
+	})
+	startInternalNode(t, secretFile, "localhost:8093", "localhost:9093", "--bootstrap", "localhost:9092")
+
+	t.Run("Should form a cluster over the internal port", func(t *testing.T) {
+		waitFor(t, func() bool {
+			state, err := clusterState(t, "localhost:9093", clusterSecret)
+			return err == nil && len(state.Nodes) == 2
+		})
+		state, err := clusterState(t, "localhost:9092", clusterSecret)
+		if err != nil || len(state.Nodes) != 2 {
+			t.Errorf("Expected %v, Got: %v, %v", 2, state, err)
+		}
+	})
+
+	t.Run("Should reject cluster calls without the secret", func(t *testing.T) {
+		_, err := clusterState(t, "localhost:9092", "")
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Expected %v, Got: %v", codes.Unauthenticated, err)
+		}
+	})
+
+	t.Run("Should not serve ClusterService on the client port", func(t *testing.T) {
+		_, err := clusterState(t, "localhost:8092", clusterSecret)
+		if status.Code(err) != codes.Unimplemented {
+			t.Errorf("Expected %v, Got: %v", codes.Unimplemented, err)
+		}
+	})
+
+	t.Run("Should serve keys on the client port of every node", func(t *testing.T) {
+		for _, addr := range []string{"localhost:8092", "localhost:8093"} {
+			conn, _ := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			client := proto.NewKeyServiceClient(conn)
+			for _, key := range []string{"a", "b", "c", "d", "e"} {
+				// Connections to the other node may still be backing off from attempts made before it listened
+				waitFor(t, func() bool {
+					_, err := client.SetKey(context.Background(), &proto.SetKeyRequest{Key: key, Value: []byte(addr)})
+					return err == nil
+				})
+			}
+			conn.Close()
+		}
+	})
+}
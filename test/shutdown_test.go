@@ -0,0 +1,46 @@
+package test
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// TestGracefulShutdown tests that the server exits cleanly on SIGTERM
+func TestGracefulShutdown(t *testing.T) {
+	t.Run("Should stop the server and exit successfully on SIGTERM", func(t *testing.T) {
+		cmd, cleanup := runApp(t)
+		defer cleanup()
+
+		err := cmd.Process.Signal(syscall.SIGTERM)
+		if err != nil {
+			t.Fatalf("Failed to send SIGTERM: %v", err)
+		}
+
+		exited := make(chan error, 1)
+		go func() {
+			exited <- cmd.Wait()
+		}()
+
+		select {
+		case err := <-exited:
+			if err != nil {
+				t.Errorf("Expected clean exit, Got: %v", err)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatalf("Server did not exit after SIGTERM")
+		}
+
+		conn := getGrpcConnection()
+		defer conn.Close()
+		client := proto.NewHealthServiceClient(conn)
+		_, err = client.CheckHealth(context.Background(), &emptypb.Empty{})
+		if err == nil {
+			t.Errorf("Expected %v, Got: %v", "Error", err)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/tdevsin/keyforge/internal/proto"
+	"github.com/tdevsin/keyforge/internal/security/securitytest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// startTLSNode starts a node that requires client certificates and stops it when the test ends
+func startTLSNode(t *testing.T, ca *securitytest.CA, name, advertise string, extraArgs ...string) {
+	dir := t.TempDir()
+	files := ca.Issue(t, dir, name)
+	args := append([]string{"start",
+		"--advertise", advertise,
+		"--data-dir", dir + "/data",
+		"--tls-cert", files.CertFile,
+		"--tls-key", files.KeyFile,
+		"--tls-ca", ca.CAFile,
+		"--gossip-interval", "1s",
+	}, extraArgs...)
+	cmd := exec.Command(appBinary, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start the application: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+}
+
+// tlsClusterState calls GetClusterState over mutual TLS
+func tlsClusterState(t *testing.T, addr string, tlsConfig *tls.Config) (*proto.ClusterState, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return proto.NewClusterServiceClient(conn).GetClusterState(ctx, &emptypb.Empty{})
+}
+
+// TestMutualTLS tests that nodes form a cluster over mutual TLS and reject clients without a certificate
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := securitytest.NewCA(t, dir, "ca")
+	clientFiles := ca.Issue(t, dir, "client")
+	clientCert, err := tls.LoadX509KeyPair(clientFiles.CertFile, clientFiles.KeyFile)
+	if err != nil {
+		t.Fatalf("Failed to load client certificate: %v", err)
+	}
+	roots := ca.CertPool()
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{clientCert}, RootCAs: roots}
+
+	startTLSNode(t, ca, "node1", "localhost:8090")
+	waitFor(t, func() bool {
+		_, err := tlsClusterState(t, "localhost:8090", tlsConfig)
+		return err == nil
+	})
+	startTLSNode(t, ca, "node2", "localhost:8091", "--bootstrap", "localhost:8090")
+
+	t.Run("Should form a cluster over mutual TLS", func(t *testing.T) {
+		waitFor(t, func() bool {
+			state, err := tlsClusterState(t, "localhost:8091", tlsConfig)
+			return err == nil && len(state.Nodes) == 2
+		})
+		state, err := tlsClusterState(t, "localhost:8090", tlsConfig)
+		if err != nil || len(state.Nodes) != 2 {
+			t.Errorf("Expected %v, Got: %v, %v", 2, state, err)
+		}
+	})
+
+	t.Run("Should reject clients without a certificate", func(t *testing.T) {
+		_, err := tlsClusterState(t, "localhost:8090", &tls.Config{RootCAs: roots})
+		if err == nil {
+			t.Errorf("Expected %v, Got: %v", "Error", err)
+		}
+	})
+
+	t.Run("Should reject plaintext clients", func(t *testing.T) {
+		conn, err := grpc.NewClient("localhost:8090", grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatalf("Failed to connect to server: %v", err)
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_, err = proto.NewHealthServiceClient(conn).CheckHealth(ctx, &emptypb.Empty{})
+		if err == nil {
+			t.Errorf("Expected %v, Got: %v", "Error", err)
+		}
+	})
+}
+
+// waitFor retries the condition for up to 20 seconds
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 40; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	t.Fatalf("Condition not met in time")
+}